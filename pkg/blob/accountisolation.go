@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	accountIsolationPerVolume    = "pervolume"
+	accountIsolationPerNamespace = "pernamespace"
+
+	// accountIsolationTag is the isolation mode of an account created by driver, only accounts with this tag are deleted by driver
+	accountIsolationTag = "k8s-azure-blob-account-isolation"
+	// accountIsolationOwnerTag is the volume name or PVC namespace which owns the account
+	accountIsolationOwnerTag = "k8s-azure-blob-account-owner"
+
+	// max number of remaining container names in log when an isolated account is kept
+	maxLoggedContainers = 10
+)
+
+var supportedAccountIsolationList = []string{accountIsolationPerVolume, accountIsolationPerNamespace}
+
+func isSupportedAccountIsolation(isolation string) bool {
+	for _, v := range supportedAccountIsolationList {
+		if isolation == v {
+			return true
+		}
+	}
+	return false
+}
+
+// getIsolatedAccountCacheKey returns the key of an isolated account in accountSearchCache,
+// it's keyed by account name so that the entry could be removed when the account is deleted
+func getIsolatedAccountCacheKey(accountName string) string {
+	return accountIsolationTag + "/" + accountName
+}
+
+// getIsolatedAccountName returns the dedicated account name and its owner, the account name is derived from
+// account settings and the owner so that the same account is used on CreateVolume retry without volMap
+func (d *Driver) getIsolatedAccountName(isolation string, accountOptions *azure.AccountOptions, protocol, lockKey, namespace, volName string) (string, string) {
+	subsID, resourceGroup := accountOptions.SubscriptionID, accountOptions.ResourceGroup
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	if resourceGroup == "" {
+		resourceGroup = d.cloud.ResourceGroup
+	}
+	owner := volName
+	if isolation == accountIsolationPerNamespace {
+		owner = namespace
+	}
+	return getPoolAccountName(strings.ToLower(protocol), subsID, resourceGroup, lockKey+"/"+isolation, owner), owner
+}
+
+// ensureIsolatedAccount creates a dedicated account for the volume or PVC namespace, caller should hold the lock of
+// getIsolatedAccountCacheKey until the container is created, otherwise the account could be deleted by
+// deleteIsolatedAccount with the last volume of the namespace before the new container is created
+func (d *Driver) ensureIsolatedAccount(ctx context.Context, isolation string, accountOptions *azure.AccountOptions, protocol, lockKey, namespace, volName string) (string, string, error) {
+	accountName, owner := d.getIsolatedAccountName(isolation, accountOptions, protocol, lockKey, namespace, volName)
+	prefix := strings.ToLower(protocol)
+	cacheKey := getIsolatedAccountCacheKey(accountName)
+
+	cache, err := d.accountSearchCache.Get(cacheKey, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "%v", err)
+	}
+	if cache != nil {
+		return accountName, "", nil
+	}
+
+	options := *accountOptions
+	options.Name = accountName
+	options.CreateAccount = true
+	options.Tags = map[string]string{}
+	for k, v := range accountOptions.Tags {
+		options.Tags[k] = v
+	}
+	options.Tags[accountIsolationTag] = isolation
+	options.Tags[accountIsolationOwnerTag] = owner
+	options.Tags[azure.SkipMatchingTag] = ""
+	var accountKey string
+	err = wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+		var retErr error
+		_, accountKey, retErr = d.ensureStorageAccount(ctx, &options, prefix)
+		if isRetriableError(retErr) {
+			klog.Warningf("EnsureStorageAccount(%s) failed with error(%v), waiting for retrying", accountName, retErr)
+			return false, nil
+		}
+		return true, retErr
+	})
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "ensure dedicated storage account(%s) for %s(%s) failed with %v", accountName, isolation, owner, err)
+	}
+	if isolation == accountIsolationPerNamespace {
+		d.accountSearchCache.Set(cacheKey, accountName)
+	}
+	klog.V(2).Infof("use dedicated storage account(%s) for %s(%s)", accountName, isolation, owner)
+	return accountName, accountKey, nil
+}
+
+// deleteIsolatedAccount deletes the dedicated account after its last volume is deleted, the account is kept if it's
+// not tagged by driver with the same isolation mode, or if there is any container left, e.g. created out of driver
+func (d *Driver) deleteIsolatedAccount(ctx context.Context, isolation, subsID, resourceGroup, accountName string) error {
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	cacheKey := getIsolatedAccountCacheKey(accountName)
+	d.volLockMap.LockEntry(cacheKey)
+	defer d.volLockMap.UnlockEntry(cacheKey)
+
+	account, rerr := d.cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+	if rerr != nil {
+		if isNotFoundError(rerr.Error()) {
+			klog.V(2).Infof("dedicated storage account(%s) rg(%s) is already deleted", accountName, resourceGroup)
+			d.accountSearchCache.Delete(cacheKey)
+			return nil
+		}
+		return fmt.Errorf("failed to get storage account(%s) rg(%s): %w", accountName, resourceGroup, rerr.Error())
+	}
+	if v := ptr.Deref(account.Tags[accountIsolationTag], ""); v != isolation {
+		klog.Warningf("storage account(%s) rg(%s) is not deleted since it's not created by driver with %s(%s), tag %s: %q", accountName, resourceGroup, accountIsolationField, isolation, accountIsolationTag, v)
+		return nil
+	}
+
+	containers, err := d.listContainers(ctx, subsID, resourceGroup, accountName)
+	if err != nil {
+		return fmt.Errorf("failed to list containers of storage account(%s) rg(%s): %w", accountName, resourceGroup, err)
+	}
+	var names []string
+	for _, c := range containers {
+		if c == nil || c.Name == nil || strings.HasPrefix(*c.Name, "$") {
+			// skip system containers, e.g. $logs
+			continue
+		}
+		names = append(names, *c.Name)
+	}
+	if len(names) > 0 {
+		if len(names) > maxLoggedContainers {
+			names = append(names[:maxLoggedContainers], "...")
+		}
+		if isolation == accountIsolationPerVolume {
+			klog.Warningf("dedicated storage account(%s) rg(%s) is kept since it still has containers not created for the volume: %v", accountName, resourceGroup, names)
+		} else {
+			klog.V(2).Infof("dedicated storage account(%s) rg(%s) is kept since it still has containers: %v", accountName, resourceGroup, names)
+		}
+		return nil
+	}
+
+	klog.V(2).Infof("deleting dedicated storage account(%s) rg(%s) after its last volume is deleted", accountName, resourceGroup)
+	if rerr := d.cloud.StorageAccountClient.Delete(ctx, subsID, resourceGroup, accountName); rerr != nil {
+		return fmt.Errorf("failed to delete storage account(%s) rg(%s): %w", accountName, resourceGroup, rerr.Error())
+	}
+	d.accountSearchCache.Delete(cacheKey)
+	return nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/blobcontainerclient/mock_blobcontainerclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestEnsureIsolatedAccount(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.SubscriptionID = "sub"
+	d.cloud.ResourceGroup = "rg"
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	keys := []storage.AccountKey{{KeyName: ptr.To("key1"), Value: ptr.To("value")}}
+	d.cloud.StorageAccountClient = NewMockSAClient(context.Background(), ctrl, "sub", "rg", "", &keys)
+
+	account1, key, err := d.ensureIsolatedAccount(context.Background(), accountIsolationPerVolume, &azure.AccountOptions{}, Fuse2, "key", "ns", "vol1")
+	require.NoError(t, err)
+	assert.Equal(t, "value", key)
+	assert.Len(t, account1, accountNameMaxLength)
+	account2, _, err := d.ensureIsolatedAccount(context.Background(), accountIsolationPerVolume, &azure.AccountOptions{}, Fuse2, "key", "ns", "vol2")
+	require.NoError(t, err)
+	assert.NotEqual(t, account1, account2)
+	// same account on retry
+	account, _, err := d.ensureIsolatedAccount(context.Background(), accountIsolationPerVolume, &azure.AccountOptions{}, Fuse2, "key", "ns", "vol1")
+	require.NoError(t, err)
+	assert.Equal(t, account1, account)
+
+	nsAccount, key, err := d.ensureIsolatedAccount(context.Background(), accountIsolationPerNamespace, &azure.AccountOptions{}, Fuse2, "key", "ns", "vol1")
+	require.NoError(t, err)
+	assert.Equal(t, "value", key)
+	assert.NotEqual(t, account1, nsAccount)
+	// account of namespace is cached
+	d.cloud.StorageAccountClient = nil
+	account, key, err = d.ensureIsolatedAccount(context.Background(), accountIsolationPerNamespace, &azure.AccountOptions{}, Fuse2, "key", "ns", "vol2")
+	require.NoError(t, err)
+	assert.Equal(t, nsAccount, account)
+	assert.Empty(t, key)
+}
+
+func TestDeleteIsolatedAccount(t *testing.T) {
+	notFound := &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("StorageAccountNotFound")}
+	tests := []struct {
+		desc          string
+		isolation     string
+		getErr        *retry.Error
+		tags          map[string]*string
+		containers    []string
+		deleteErr     *retry.Error
+		expectDelete  bool
+		expectedErr   string
+		expectCleared bool
+	}{
+		{
+			desc:          "delete account after last volume",
+			isolation:     accountIsolationPerVolume,
+			tags:          map[string]*string{accountIsolationTag: ptr.To(accountIsolationPerVolume)},
+			containers:    []string{"$logs"},
+			expectDelete:  true,
+			expectCleared: true,
+		},
+		{
+			desc:          "account already deleted",
+			isolation:     accountIsolationPerVolume,
+			getErr:        notFound,
+			expectCleared: true,
+		},
+		{
+			desc:        "get account failure",
+			isolation:   accountIsolationPerVolume,
+			getErr:      &retry.Error{HTTPStatusCode: http.StatusInternalServerError, RawError: fmt.Errorf("server error")},
+			expectedErr: "server error",
+		},
+		{
+			desc:      "account without isolation tag is kept",
+			isolation: accountIsolationPerVolume,
+			tags:      map[string]*string{"foo": ptr.To("bar")},
+		},
+		{
+			desc:      "account with other isolation mode is kept",
+			isolation: accountIsolationPerNamespace,
+			tags:      map[string]*string{accountIsolationTag: ptr.To(accountIsolationPerVolume)},
+		},
+		{
+			desc:       "account with remaining volumes is kept",
+			isolation:  accountIsolationPerNamespace,
+			tags:       map[string]*string{accountIsolationTag: ptr.To(accountIsolationPerNamespace)},
+			containers: []string{"pvc-1"},
+		},
+		{
+			desc:       "account with foreign container is kept",
+			isolation:  accountIsolationPerVolume,
+			tags:       map[string]*string{accountIsolationTag: ptr.To(accountIsolationPerVolume)},
+			containers: []string{"foreign"},
+		},
+		{
+			desc:         "delete account failure",
+			isolation:    accountIsolationPerVolume,
+			tags:         map[string]*string{accountIsolationTag: ptr.To(accountIsolationPerVolume)},
+			deleteErr:    &retry.Error{HTTPStatusCode: http.StatusConflict, RawError: fmt.Errorf("conflict")},
+			expectDelete: true,
+			expectedErr:  "conflict",
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{}
+		d.cloud.SubscriptionID = "sub"
+		ctrl := gomock.NewController(t)
+		saClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		saClient.EXPECT().GetProperties(gomock.Any(), "sub", "rg", "account").Return(storage.Account{Tags: test.tags}, test.getErr)
+		if test.expectDelete {
+			saClient.EXPECT().Delete(gomock.Any(), "sub", "rg", "account").Return(test.deleteErr)
+		}
+		d.cloud.StorageAccountClient = saClient
+		blobClient := mock_blobcontainerclient.NewMockInterface(ctrl)
+		var items []*armstorage.ListContainerItem
+		for _, name := range test.containers {
+			items = append(items, &armstorage.ListContainerItem{Name: ptr.To(name)})
+		}
+		blobClient.EXPECT().List(gomock.Any(), "rg", "account").Return(items, nil).AnyTimes()
+		clientFactory := mock_azclient.NewMockClientFactory(ctrl)
+		clientFactory.EXPECT().GetBlobContainerClientForSub("sub").Return(blobClient, nil).AnyTimes()
+		d.clientFactory = clientFactory
+		d.accountSearchCache.Set(getIsolatedAccountCacheKey("account"), "account")
+
+		err := d.deleteIsolatedAccount(context.Background(), test.isolation, "", "rg", "account")
+		if test.expectedErr != "" {
+			assert.ErrorContains(t, err, test.expectedErr, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+		cache, err := d.accountSearchCache.Get(getIsolatedAccountCacheKey("account"), azcache.CacheReadTypeDefault)
+		require.NoError(t, err)
+		assert.Equal(t, test.expectCleared, cache == nil, test.desc)
+		ctrl.Finish()
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// accountPoolTag is the shard of the account in the pool, pool accounts are also tagged with azure.SkipMatchingTag
+	// so that they are not picked by storage account search of storage classes without account pool
+	accountPoolTag = "k8s-azure-blob-account-pool"
+
+	accountPoolStrategyHash      = "hash"
+	accountPoolStrategyLeastLoad = "leastload"
+
+	// max number of accounts of a pool with only maxVolumesPerAccount
+	maxAccountPoolSize = 100
+	// max length of storage account name
+	accountNameMaxLength = 24
+
+	accountPoolResultPlaced    = "placed"
+	accountPoolResultExhausted = "exhausted"
+)
+
+var (
+	accountPoolPlacementsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      "blob_csi_driver",
+			Name:           "account_pool_placements_total",
+			Help:           "Number of volume placements in account pools, partitioned by strategy and result (placed or exhausted)",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"strategy", "result"},
+	)
+	accountPoolVolumes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      "blob_csi_driver",
+			Name:           "account_pool_volumes",
+			Help:           "Number of containers in storage accounts of account pools when a volume is placed",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"account"},
+	)
+	registerAccountPoolMetricsOnce sync.Once
+)
+
+func registerAccountPoolMetrics() {
+	registerAccountPoolMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(accountPoolPlacementsTotal, accountPoolVolumes)
+	})
+}
+
+// accountPool spreads volumes of a storage class across multiple storage accounts
+type accountPool struct {
+	// size is the number of accounts in the pool, 0 means the pool grows with maxVolumes
+	size     int
+	strategy string
+	// maxVolumes is the max number of volumes per account, 0 means no limit
+	maxVolumes int
+	// perNamespace uses a dedicated pool of accounts for every PVC namespace
+	perNamespace bool
+}
+
+func (p *accountPool) isEmpty() bool {
+	return p.size == 0 && p.strategy == "" && p.maxVolumes == 0 && !p.perNamespace
+}
+
+// shardCount returns the number of shards which could be used by the pool
+func (p *accountPool) shardCount() int {
+	switch {
+	case p.size > 0:
+		return p.size
+	case p.maxVolumes > 0:
+		return maxAccountPoolSize
+	default:
+		return 1
+	}
+}
+
+// shard returns the tag value of the shard, it's prefixed with namespace for a per namespace pool
+func (p *accountPool) shard(namespace string, index int) string {
+	if p.perNamespace {
+		return fmt.Sprintf("%s-%d", namespace, index)
+	}
+	return strconv.Itoa(index)
+}
+
+// validate checks pool parameters, strategy is normalized to lower case
+func (p *accountPool) validate() error {
+	switch strings.ToLower(p.strategy) {
+	case "":
+		p.strategy = accountPoolStrategyLeastLoad
+	case accountPoolStrategyHash, accountPoolStrategyLeastLoad:
+		p.strategy = strings.ToLower(p.strategy)
+	default:
+		return fmt.Errorf("%s(%s) is not supported, supported %s list: [%s %s]", accountPoolStrategyField, p.strategy, accountPoolStrategyField, accountPoolStrategyHash, accountPoolStrategyLeastLoad)
+	}
+	return nil
+}
+
+// getPoolAccountName returns a deterministic account name of the shard, so that the account of a shard
+// could be found without listing accounts, prefix should only contain lowercase letters and numbers
+func getPoolAccountName(prefix, subsID, resourceGroup, lockKey, shard string) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{subsID, resourceGroup, lockKey, shard}, "/")))
+	return prefix + hex.EncodeToString(hash[:])[:accountNameMaxLength-len(prefix)]
+}
+
+// ensurePoolAccount places the volume in an account of the pool and creates the account if it does not exist
+func (d *Driver) ensurePoolAccount(ctx context.Context, pool *accountPool, accountOptions *azure.AccountOptions, protocol, lockKey, namespace, volName string) (string, string, error) {
+	registerAccountPoolMetrics()
+	subsID, resourceGroup := accountOptions.SubscriptionID, accountOptions.ResourceGroup
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	if resourceGroup == "" {
+		resourceGroup = d.cloud.ResourceGroup
+	}
+	prefix := strings.ToLower(protocol)
+	if pool.perNamespace {
+		lockKey += "/" + namespace
+	}
+	// placement decisions of the pool are serialized so that loads are counted correctly
+	d.volLockMap.LockEntry(lockKey)
+	defer d.volLockMap.UnlockEntry(lockKey)
+
+	shards := pool.shardCount()
+	var order []int
+	if pool.strategy == accountPoolStrategyHash {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(volName))
+		start := int(h.Sum32() % uint32(shards))
+		for i := 0; i < shards; i++ {
+			order = append(order, (start+i)%shards)
+		}
+	} else {
+		for i := 0; i < shards; i++ {
+			order = append(order, i)
+		}
+	}
+
+	selected, selectedLoad := -1, int64(0)
+	var selectedCounter *atomic.Int64
+	for _, i := range order {
+		accountName := getPoolAccountName(prefix, subsID, resourceGroup, lockKey, pool.shard(namespace, i))
+		counter, err := d.getPoolAccountLoad(ctx, subsID, resourceGroup, accountName)
+		if err != nil {
+			return "", "", status.Errorf(codes.Internal, "failed to get load of account(%s) in pool: %v", accountName, err)
+		}
+		load := counter.Load()
+		if pool.maxVolumes > 0 && load >= int64(pool.maxVolumes) {
+			continue
+		}
+		if selected < 0 || load < selectedLoad {
+			selected, selectedLoad, selectedCounter = i, load, counter
+		}
+		// hash strategy takes the first shard with capacity, and the pool only grows when accounts are full without pool size
+		if pool.strategy == accountPoolStrategyHash || pool.size == 0 || load == 0 {
+			break
+		}
+	}
+	if selected < 0 {
+		accountPoolPlacementsTotal.WithLabelValues(pool.strategy, accountPoolResultExhausted).Inc()
+		return "", "", status.Errorf(codes.ResourceExhausted, "all %d accounts in pool reached %s(%d)", shards, maxVolumesPerAccountField, pool.maxVolumes)
+	}
+
+	shard := pool.shard(namespace, selected)
+	accountName := getPoolAccountName(prefix, subsID, resourceGroup, lockKey, shard)
+	var accountKey string
+	cacheKey := lockKey + "/" + shard
+	cache, err := d.accountSearchCache.Get(cacheKey, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "%v", err)
+	}
+	if cache == nil {
+		options := *accountOptions
+		options.Name = accountName
+		options.CreateAccount = true
+		options.Tags = map[string]string{}
+		for k, v := range accountOptions.Tags {
+			options.Tags[k] = v
+		}
+		options.Tags[accountPoolTag] = shard
+		options.Tags[azure.SkipMatchingTag] = ""
+		err = wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+			var retErr error
+			_, accountKey, retErr = d.ensureStorageAccount(ctx, &options, prefix)
+			if isRetriableError(retErr) {
+				klog.Warningf("EnsureStorageAccount(%s) failed with error(%v), waiting for retrying", accountName, retErr)
+				return false, nil
+			}
+			return true, retErr
+		})
+		if err != nil {
+			return "", "", status.Errorf(codes.Internal, "ensure storage account(%s) in pool failed with %v", accountName, err)
+		}
+		d.accountSearchCache.Set(cacheKey, accountName)
+	}
+
+	load := selectedCounter.Add(1)
+	accountPoolVolumes.WithLabelValues(accountName).Set(float64(load))
+	accountPoolPlacementsTotal.WithLabelValues(pool.strategy, accountPoolResultPlaced).Inc()
+	klog.V(2).Infof("place volume(%s) in account(%s) shard(%s) of pool with strategy(%s), volumes in account: %d", volName, accountName, shard, pool.strategy, load)
+	return accountName, accountKey, nil
+}
+
+// getPoolAccountLoad returns the number of containers in the account, it's cached and increased on every placement
+// so that concurrent placements are counted before the containers are created, 0 is returned if the account does not exist
+func (d *Driver) getPoolAccountLoad(ctx context.Context, subsID, resourceGroup, accountName string) (*atomic.Int64, error) {
+	cache, err := d.accountLoadCache.Get(accountName, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		return cache.(*atomic.Int64), nil
+	}
+	counter := &atomic.Int64{}
+	containers, err := d.listContainers(ctx, subsID, resourceGroup, accountName)
+	if err != nil {
+		if !isNotFoundError(err) {
+			return nil, err
+		}
+		containers = nil
+	}
+	counter.Store(int64(len(containers)))
+	d.accountLoadCache.Set(accountName, counter)
+	return counter, nil
+}
+
+func isNotFoundError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), statusCodeNotFound) || strings.Contains(err.Error(), httpCodeNotFound) ||
+		strings.Contains(err.Error(), "ResourceNotFound") || strings.Contains(err.Error(), "StorageAccountNotFound"))
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/blobcontainerclient/mock_blobcontainerclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestGetPoolAccountName(t *testing.T) {
+	name := getPoolAccountName("fuse", "sub", "rg", "key", "0")
+	assert.Len(t, name, accountNameMaxLength)
+	assert.True(t, strings.HasPrefix(name, "fuse"))
+	assert.Equal(t, name, getPoolAccountName("fuse", "sub", "rg", "key", "0"))
+	assert.NotEqual(t, name, getPoolAccountName("fuse", "sub", "rg", "key", "1"))
+	assert.NotEqual(t, name, getPoolAccountName("fuse", "sub", "rg2", "key", "0"))
+}
+
+func TestAccountPool(t *testing.T) {
+	pool := &accountPool{}
+	assert.True(t, pool.isEmpty())
+	assert.Equal(t, 1, pool.shardCount())
+	assert.NoError(t, pool.validate())
+	assert.Equal(t, accountPoolStrategyLeastLoad, pool.strategy)
+
+	pool = &accountPool{maxVolumes: 10, strategy: "Hash"}
+	assert.False(t, pool.isEmpty())
+	assert.Equal(t, maxAccountPoolSize, pool.shardCount())
+	assert.NoError(t, pool.validate())
+	assert.Equal(t, accountPoolStrategyHash, pool.strategy)
+	assert.Equal(t, "1", pool.shard("ns", 1))
+
+	pool = &accountPool{size: 3, perNamespace: true, strategy: "random"}
+	assert.Equal(t, 3, pool.shardCount())
+	assert.Equal(t, "ns-1", pool.shard("ns", 1))
+	assert.ErrorContains(t, pool.validate(), "accountpoolstrategy(random) is not supported")
+}
+
+func TestEnsurePoolAccount(t *testing.T) {
+	const lockKey = "Standard_LRSStorageV2fusefalse"
+	notFound := fmt.Errorf("HTTPStatusCode: 404, ResourceNotFound")
+	hashShard := func(volName string, shards int) int {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(volName))
+		return int(h.Sum32() % uint32(shards))
+	}
+	tests := []struct {
+		desc          string
+		pool          accountPool
+		namespace     string
+		loads         map[int]int
+		expectedShard int
+		expectedLoad  int64
+		expectedCode  codes.Code
+	}{
+		{
+			desc:          "least load picks an account without volumes",
+			pool:          accountPool{size: 3, strategy: accountPoolStrategyLeastLoad},
+			loads:         map[int]int{0: 5, 1: 2, 2: -1},
+			expectedShard: 2,
+			expectedLoad:  1,
+		},
+		{
+			desc:          "least load",
+			pool:          accountPool{size: 2, strategy: accountPoolStrategyLeastLoad},
+			loads:         map[int]int{0: 5, 1: 2},
+			expectedShard: 1,
+			expectedLoad:  3,
+		},
+		{
+			desc:          "pool grows when accounts are full",
+			pool:          accountPool{maxVolumes: 3, strategy: accountPoolStrategyLeastLoad},
+			loads:         map[int]int{0: 3, 1: 1},
+			expectedShard: 1,
+			expectedLoad:  2,
+		},
+		{
+			desc:         "pool is exhausted",
+			pool:         accountPool{size: 2, maxVolumes: 2, strategy: accountPoolStrategyLeastLoad},
+			loads:        map[int]int{0: 2, 1: 2},
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			desc:          "hash",
+			pool:          accountPool{size: 3, strategy: accountPoolStrategyHash},
+			loads:         map[int]int{hashShard("vol", 3): 4},
+			expectedShard: hashShard("vol", 3),
+			expectedLoad:  5,
+		},
+		{
+			desc:          "hash skips full account",
+			pool:          accountPool{size: 3, maxVolumes: 4, strategy: accountPoolStrategyHash},
+			loads:         map[int]int{hashShard("vol", 3): 4, (hashShard("vol", 3) + 1) % 3: 1},
+			expectedShard: (hashShard("vol", 3) + 1) % 3,
+			expectedLoad:  2,
+		},
+		{
+			desc:          "dedicated account per namespace",
+			pool:          accountPool{perNamespace: true, strategy: accountPoolStrategyLeastLoad},
+			namespace:     "ns",
+			loads:         map[int]int{0: -1},
+			expectedShard: 0,
+			expectedLoad:  1,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{}
+		d.cloud.SubscriptionID = "sub"
+		d.cloud.ResourceGroup = "rg"
+		ctrl := gomock.NewController(t)
+		keys := []storage.AccountKey{{KeyName: ptr.To("key1"), Value: ptr.To("value")}}
+		d.cloud.StorageAccountClient = NewMockSAClient(context.Background(), ctrl, "sub", "rg", "", &keys)
+		blobClient := mock_blobcontainerclient.NewMockInterface(ctrl)
+		clientFactory := mock_azclient.NewMockClientFactory(ctrl)
+		clientFactory.EXPECT().GetBlobContainerClientForSub("sub").Return(blobClient, nil).AnyTimes()
+		d.clientFactory = clientFactory
+
+		poolKey := lockKey
+		if test.pool.perNamespace {
+			poolKey += "/" + test.namespace
+		}
+		for shard, load := range test.loads {
+			accountName := getPoolAccountName("fuse", "sub", "rg", poolKey, test.pool.shard(test.namespace, shard))
+			if load < 0 {
+				blobClient.EXPECT().List(gomock.Any(), "rg", accountName).Return(nil, notFound)
+			} else {
+				blobClient.EXPECT().List(gomock.Any(), "rg", accountName).Return(make([]*armstorage.ListContainerItem, load), nil)
+			}
+		}
+		blobClient.EXPECT().List(gomock.Any(), "rg", gomock.Any()).Return(nil, nil).AnyTimes()
+
+		accountName, accountKey, err := d.ensurePoolAccount(context.Background(), &test.pool, &azure.AccountOptions{}, "fuse", lockKey, test.namespace, "vol")
+		if test.expectedCode != codes.OK {
+			assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+			ctrl.Finish()
+			continue
+		}
+		require.NoError(t, err, test.desc)
+		assert.Equal(t, getPoolAccountName("fuse", "sub", "rg", poolKey, test.pool.shard(test.namespace, test.expectedShard)), accountName, test.desc)
+		assert.Equal(t, "value", accountKey, test.desc)
+		counter, err := d.getPoolAccountLoad(context.Background(), "sub", "rg", accountName)
+		require.NoError(t, err, test.desc)
+		assert.Equal(t, test.expectedLoad, counter.Load(), test.desc)
+
+		// the account is not ensured again in cache TTL
+		d.cloud.StorageAccountClient = nil
+		accountName2, _, err := d.ensurePoolAccount(context.Background(), &test.pool, &azure.AccountOptions{}, "fuse", lockKey, test.namespace, "vol")
+		require.NoError(t, err, test.desc)
+		if test.pool.size == 0 && !test.pool.perNamespace {
+			assert.Equal(t, accountName, accountName2, test.desc)
+		}
+		ctrl.Finish()
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// storage account search with the same lockKey fails fast within the backoff after a failure,
+	// the backoff doubles on every consecutive failure
+	accountSearchFailureBackoff    = 5 * time.Second
+	maxAccountSearchFailureBackoff = 5 * time.Minute
+)
+
+type accountSearchResult struct {
+	accountName string
+	accountKey  string
+}
+
+type accountSearchFailure struct {
+	err      error
+	failures int
+	retryAt  time.Time
+}
+
+// searchStorageAccount finds or creates a storage account with accountOptions. Concurrent calls with the same lockKey are
+// coalesced into one EnsureStorageAccount call and share its result, as the account found by the first call is shared
+// through accountSearchCache afterwards. The caller returns on context cancellation while the search continues.
+func (d *Driver) searchStorageAccount(ctx context.Context, lockKey string, accountOptions *azure.AccountOptions, protocol string) (string, string, error) {
+	ch := d.accountSearchGroup.DoChan(lockKey, func() (interface{}, error) {
+		// the search should not be canceled with the first caller
+		return d.resolveStorageAccount(context.WithoutCancel(ctx), lockKey, accountOptions, protocol)
+	})
+	select {
+	case <-ctx.Done():
+		return "", "", status.FromContextError(ctx.Err()).Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return "", "", res.Err
+		}
+		result := res.Val.(accountSearchResult)
+		if res.Shared {
+			klog.V(4).Infof("share storage account(%s) search result of %s", result.accountName, lockKey)
+		}
+		return result.accountName, result.accountKey, nil
+	}
+}
+
+func (d *Driver) resolveStorageAccount(ctx context.Context, lockKey string, accountOptions *azure.AccountOptions, protocol string) (interface{}, error) {
+	// the previous search may complete after the caller checks the cache
+	if cache, err := d.accountSearchCache.Get(lockKey, azcache.CacheReadTypeDefault); err == nil && cache != nil {
+		return accountSearchResult{accountName: cache.(string)}, nil
+	}
+	var lastFailure *accountSearchFailure
+	if v, ok := d.accountSearchFailures.Load(lockKey); ok {
+		lastFailure = v.(*accountSearchFailure)
+		if remaining := time.Until(lastFailure.retryAt); remaining > 0 {
+			return nil, status.Errorf(codes.Unavailable, "ensure storage account failed with %v, retry after %v", lastFailure.err, remaining.Round(time.Second))
+		}
+	}
+
+	var accountName, accountKey string
+	err := wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
+		var retErr error
+		accountName, accountKey, retErr = d.ensureStorageAccount(ctx, accountOptions, protocol)
+		if isRetriableError(retErr) {
+			klog.Warningf("EnsureStorageAccount(%s) failed with error(%v), waiting for retrying", accountOptions.Name, retErr)
+			return false, nil
+		}
+		return true, retErr
+	})
+	if err != nil {
+		failure := &accountSearchFailure{err: err, failures: 1}
+		if lastFailure != nil {
+			failure.failures = lastFailure.failures + 1
+		}
+		backoff := accountSearchFailureBackoff
+		for i := 1; i < failure.failures && backoff < maxAccountSearchFailureBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > maxAccountSearchFailureBackoff {
+			backoff = maxAccountSearchFailureBackoff
+		}
+		failure.retryAt = time.Now().Add(backoff)
+		d.accountSearchFailures.Store(lockKey, failure)
+		return nil, status.Errorf(codes.Internal, "ensure storage account failed with %v", err)
+	}
+	d.accountSearchFailures.Delete(lockKey)
+	d.accountSearchCache.Set(lockKey, accountName)
+	d.setState(ctx, stateKindAccountSearch, lockKey, accountName)
+	return accountSearchResult{accountName: accountName, accountKey: accountKey}, nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+// fakeAccountSearchCloud is a fake storage account client which counts ARM calls,
+// every call takes latency to simulate concurrent CreateVolume calls waiting on ARM
+type fakeAccountSearchCloud struct {
+	calls   atomic.Int64
+	latency time.Duration
+	err     *retry.Error
+}
+
+func (f *fakeAccountSearchCloud) newDriver(ctrl *gomock.Controller) *Driver {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.SubscriptionID = "sub"
+	d.cloud.Location = "eastus"
+	client := mockstorageaccountclient.NewMockInterface(ctrl)
+	client.EXPECT().ListByResourceGroup(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ string) ([]storage.Account, *retry.Error) {
+			f.calls.Add(1)
+			time.Sleep(f.latency)
+			if f.err != nil {
+				return nil, f.err
+			}
+			return []storage.Account{{
+				Name:     ptr.To("account"),
+				Location: ptr.To("eastus"),
+				Kind:     storage.KindStorageV2,
+				Sku:      &storage.Sku{Name: storage.SkuNameStandardLRS},
+				AccountProperties: &storage.AccountProperties{
+					EnableHTTPSTrafficOnly: ptr.To(true),
+				},
+			}}, nil
+		}).AnyTimes()
+	client.EXPECT().ListKeys(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _, _ string) (storage.AccountListKeysResult, *retry.Error) {
+			f.calls.Add(1)
+			time.Sleep(f.latency)
+			return storage.AccountListKeysResult{Keys: &[]storage.AccountKey{{KeyName: ptr.To("key1"), Value: ptr.To("value")}}}, nil
+		}).AnyTimes()
+	d.cloud.StorageAccountClient = client
+	return d
+}
+
+func newAccountSearchOptions() *azure.AccountOptions {
+	return &azure.AccountOptions{
+		Type:                   string(storage.SkuNameStandardLRS),
+		Kind:                   string(storage.KindStorageV2),
+		ResourceGroup:          "rg",
+		Location:               "eastus",
+		EnableHTTPSTrafficOnly: true,
+	}
+}
+
+func TestSearchStorageAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cloud := &fakeAccountSearchCloud{latency: 20 * time.Millisecond}
+	d := cloud.newDriver(ctrl)
+
+	var wg sync.WaitGroup
+	var shared atomic.Int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			accountName, accountKey, err := d.searchStorageAccount(context.Background(), "key", newAccountSearchOptions(), Fuse)
+			assert.NoError(t, err)
+			assert.Equal(t, "account", accountName)
+			if accountKey == "value" {
+				shared.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	// one account list and one key list are shared by all calls
+	assert.Equal(t, int64(2), cloud.calls.Load())
+	assert.Positive(t, shared.Load())
+
+	// result is cached afterwards
+	accountName, _, err := d.searchStorageAccount(context.Background(), "key", newAccountSearchOptions(), Fuse)
+	require.NoError(t, err)
+	assert.Equal(t, "account", accountName)
+	assert.Equal(t, int64(2), cloud.calls.Load())
+}
+
+func TestSearchStorageAccountCanceled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cloud := &fakeAccountSearchCloud{latency: 50 * time.Millisecond}
+	d := cloud.newDriver(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := d.searchStorageAccount(ctx, "key", newAccountSearchOptions(), Fuse)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	// the search started by the canceled call continues
+	accountName, _, err := d.searchStorageAccount(context.Background(), "key", newAccountSearchOptions(), Fuse)
+	require.NoError(t, err)
+	assert.Equal(t, "account", accountName)
+	assert.Equal(t, int64(2), cloud.calls.Load())
+}
+
+func TestSearchStorageAccountFailureBackoff(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cloud := &fakeAccountSearchCloud{err: &retry.Error{RawError: fmt.Errorf("AuthorizationFailed")}}
+	d := cloud.newDriver(ctrl)
+
+	_, _, err := d.searchStorageAccount(context.Background(), "key", newAccountSearchOptions(), Fuse)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.ErrorContains(t, err, "AuthorizationFailed")
+	assert.Equal(t, int64(1), cloud.calls.Load())
+
+	// fail fast within backoff
+	_, _, err = d.searchStorageAccount(context.Background(), "key", newAccountSearchOptions(), Fuse)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.ErrorContains(t, err, "AuthorizationFailed")
+	assert.Equal(t, int64(1), cloud.calls.Load())
+
+	// backoff doubles on consecutive failure
+	v, ok := d.accountSearchFailures.Load("key")
+	require.True(t, ok)
+	v.(*accountSearchFailure).retryAt = time.Now()
+	_, _, err = d.searchStorageAccount(context.Background(), "key", newAccountSearchOptions(), Fuse)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, int64(2), cloud.calls.Load())
+	v, _ = d.accountSearchFailures.Load("key")
+	failure := v.(*accountSearchFailure)
+	assert.Equal(t, 2, failure.failures)
+	assert.WithinDuration(t, time.Now().Add(2*accountSearchFailureBackoff), failure.retryAt, time.Second)
+
+	// failure is cleared on success
+	cloud.err = nil
+	failure.retryAt = time.Now()
+	accountName, _, err := d.searchStorageAccount(context.Background(), "key", newAccountSearchOptions(), Fuse)
+	require.NoError(t, err)
+	assert.Equal(t, "account", accountName)
+	_, ok = d.accountSearchFailures.Load("key")
+	assert.False(t, ok)
+}
+
+// BenchmarkAccountSearch compares ARM calls of a burst of concurrent CreateVolume calls with the same account search,
+// "lock" is the behavior before coalescing: every call waits on volLockMap and then calls EnsureStorageAccount in turn
+func BenchmarkAccountSearch(b *testing.B) {
+	const burst = 50
+	searches := map[string]func(d *Driver, lockKey string) error{
+		"lock": func(d *Driver, lockKey string) error {
+			d.volLockMap.LockEntry(lockKey)
+			defer d.volLockMap.UnlockEntry(lockKey)
+			_, _, err := d.ensureStorageAccount(context.Background(), newAccountSearchOptions(), Fuse)
+			return err
+		},
+		"singleflight": func(d *Driver, lockKey string) error {
+			_, _, err := d.searchStorageAccount(context.Background(), lockKey, newAccountSearchOptions(), Fuse)
+			return err
+		},
+	}
+	for _, name := range []string{"lock", "singleflight"} {
+		search := searches[name]
+		b.Run(name, func(b *testing.B) {
+			ctrl := gomock.NewController(b)
+			defer ctrl.Finish()
+			cloud := &fakeAccountSearchCloud{latency: time.Millisecond}
+			d := cloud.newDriver(ctrl)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				lockKey := fmt.Sprintf("key-%d", i)
+				var wg sync.WaitGroup
+				for j := 0; j < burst; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if err := search(d, lockKey); err != nil {
+							b.Error(err)
+						}
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(cloud.calls.Load())/float64(b.N), "armcalls/op")
+		})
+	}
+}
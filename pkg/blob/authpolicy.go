@@ -0,0 +1,249 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// namespacePolicyDataKey is the key of the policy in the namespace policy configmap
+	namespacePolicyDataKey = "policy.yaml"
+	namespacePolicyTTL     = time.Minute
+	// wildcard matches any value, it could also be used as suffix for prefix matching, e.g. team-a-*
+	wildcard = "*"
+)
+
+// namespacePolicy maps namespaces to the storage accounts, containers, secret namespaces,
+// resource groups and subscriptions they could use in volume attributes or storage class parameters
+type namespacePolicy struct {
+	Rules []namespacePolicyRule `json:"rules"`
+}
+
+// namespacePolicyRule applies to the namespaces it matches, the first matched rule takes effect.
+// an empty list means the corresponding field could not be overridden, the own namespace is always allowed as secret namespace
+type namespacePolicyRule struct {
+	Namespaces       []string `json:"namespaces"`
+	StorageAccounts  []string `json:"storageAccounts,omitempty"`
+	Containers       []string `json:"containers,omitempty"`
+	SecretNamespaces []string `json:"secretNamespaces,omitempty"`
+	ResourceGroups   []string `json:"resourceGroups,omitempty"`
+	SubscriptionIDs  []string `json:"subscriptionIDs,omitempty"`
+	// AllowKeyAccessWithIdentity allows inline volume to get account key using cluster identity,
+	// the driver flag --allow-inline-volume-key-access-with-idenitity is used if not set
+	AllowKeyAccessWithIdentity *bool `json:"allowKeyAccessWithIdentity,omitempty"`
+}
+
+// volumeAccessRequest contains the values a namespace requests to use, empty value means no override
+type volumeAccessRequest struct {
+	Namespace       string
+	StorageAccount  string
+	Container       string
+	SecretNamespace string
+	ResourceGroup   string
+	SubscriptionID  string
+}
+
+// hasOverride returns whether the request overrides any value which is checked by namespace policy
+func (req *volumeAccessRequest) hasOverride() bool {
+	return req.StorageAccount != "" || req.Container != "" || req.SecretNamespace != "" || req.ResourceGroup != "" || req.SubscriptionID != ""
+}
+
+// getVolumeNamespace returns the PVC namespace of the volume, or the pod namespace of inline volume.
+// volume attributes of inline volume are written by pod author, so only the pod namespace set by kubelet is trusted,
+// PVC namespace is only trusted from PV which is created by external-provisioner or cluster admin
+func getVolumeNamespace(attrib map[string]string) string {
+	if isEphemeralVolume(attrib) {
+		return attrib[podNamespaceField]
+	}
+	if namespace := attrib[pvcNamespaceKey]; namespace != "" {
+		return namespace
+	}
+	return attrib[podNamespaceField]
+}
+
+// isEphemeralVolume returns whether the volume is an inline volume in pod spec, the field is set by kubelet
+func isEphemeralVolume(attrib map[string]string) bool {
+	return strings.EqualFold(attrib[ephemeralField], trueValue)
+}
+
+// newVolumeAccessRequest gets the overridable values from volume attributes, secret namespace in volumeID is also checked
+func newVolumeAccessRequest(namespace, volumeID string, attrib map[string]string) *volumeAccessRequest {
+	req := &volumeAccessRequest{Namespace: namespace}
+	if _, _, _, secretNamespace, _, err := GetContainerInfo(volumeID); err == nil {
+		req.SecretNamespace = secretNamespace
+	}
+	for k, v := range attrib {
+		switch strings.ToLower(k) {
+		case storageAccountField, storageAccountNameField:
+			req.StorageAccount = v
+		case containerNameField:
+			req.Container = v
+		case secretNamespaceField:
+			req.SecretNamespace = v
+		case resourceGroupField:
+			req.ResourceGroup = v
+		case subscriptionIDField:
+			req.SubscriptionID = v
+		}
+	}
+	return req
+}
+
+func parseNamespacePolicy(data string) (*namespacePolicy, error) {
+	policy := &namespacePolicy{}
+	if err := yaml.UnmarshalStrict([]byte(data), policy); err != nil {
+		return nil, fmt.Errorf("failed to parse namespace policy: %w", err)
+	}
+	for i, rule := range policy.Rules {
+		if len(rule.Namespaces) == 0 {
+			return nil, fmt.Errorf("namespaces of rule[%d] is empty in namespace policy", i)
+		}
+	}
+	return policy, nil
+}
+
+// matchPolicyValue checks whether value matches any of the patterns, empty value always matches since there is no override
+func matchPolicyValue(patterns []string, value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == wildcard || strings.EqualFold(pattern, value) {
+			return true
+		}
+		if strings.HasSuffix(pattern, wildcard) && strings.HasPrefix(strings.ToLower(value), strings.ToLower(strings.TrimSuffix(pattern, wildcard))) {
+			return true
+		}
+	}
+	return false
+}
+
+// getRule returns the first rule which matches the namespace, return nil if there is no matched rule
+func (p *namespacePolicy) getRule(namespace string) *namespacePolicyRule {
+	for i := range p.Rules {
+		if matchPolicyValue(p.Rules[i].Namespaces, namespace) {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+// authorize checks the request against the rule of its namespace, all overrides are denied if there is no matched rule
+func (p *namespacePolicy) authorize(req *volumeAccessRequest) error {
+	rule := p.getRule(req.Namespace)
+	if rule == nil {
+		rule = &namespacePolicyRule{}
+	}
+	type policyCheck struct {
+		field    string
+		value    string
+		patterns []string
+	}
+	checks := []policyCheck{
+		{storageAccountField, req.StorageAccount, rule.StorageAccounts},
+		{containerNameField, req.Container, rule.Containers},
+		{resourceGroupField, req.ResourceGroup, rule.ResourceGroups},
+		{subscriptionIDField, req.SubscriptionID, rule.SubscriptionIDs},
+	}
+	if req.SecretNamespace != req.Namespace {
+		checks = append(checks, policyCheck{secretNamespaceField, req.SecretNamespace, rule.SecretNamespaces})
+	}
+	for _, check := range checks {
+		if !matchPolicyValue(check.patterns, check.value) {
+			return fmt.Errorf("namespace(%s) is not allowed to use %s(%s) by namespace policy", req.Namespace, check.field, check.value)
+		}
+	}
+	return nil
+}
+
+// getNamespacePolicy returns the namespace policy, return nil if namespace policy is not configured
+func (d *Driver) getNamespacePolicy() (*namespacePolicy, error) {
+	if d.namespacePolicyConfigMap == "" {
+		return nil, nil
+	}
+	policy, err := d.namespacePolicyCache.Get(d.namespacePolicyConfigMap, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return nil, err
+	}
+	return policy.(*namespacePolicy), nil
+}
+
+// getNamespacePolicyFromConfigMap is the getter of namespace policy cache, configmap is in the format of namespace/name
+func (d *Driver) getNamespacePolicyFromConfigMap(key string) (interface{}, error) {
+	namespace, name, found := strings.Cut(key, "/")
+	if !found || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid namespace policy configmap(%s), expected format: namespace/name", key)
+	}
+	if d.KubeClient == nil {
+		return nil, fmt.Errorf("could not get namespace policy configmap(%s): KubeClient is nil", key)
+	}
+	cm, err := d.KubeClient.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get namespace policy configmap(%s): %w", key, err)
+	}
+	return parseNamespacePolicy(cm.Data[namespacePolicyDataKey])
+}
+
+// authorizeVolumeAccess checks whether the namespace could use the values in the request,
+// the check is skipped if namespace policy is not configured, any override is denied if namespace is unknown
+func (d *Driver) authorizeVolumeAccess(req *volumeAccessRequest) error {
+	if d.namespacePolicyConfigMap == "" {
+		return nil
+	}
+	if req.Namespace == "" {
+		if req.hasOverride() {
+			return status.Errorf(codes.PermissionDenied, "namespace is unknown, which is required by namespace policy, set %s in volume attributes or enable --extra-create-metadata in external-provisioner", pvcNamespaceKey)
+		}
+		return nil
+	}
+	policy, err := d.getNamespacePolicy()
+	if err != nil {
+		// fail closed if namespace policy could not be loaded
+		return status.Errorf(codes.Unavailable, "failed to get namespace policy: %v", err)
+	}
+	if err := policy.authorize(req); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// allowKeyAccessWithIdentity returns whether inline volume in the namespace could get account key using cluster identity,
+// it's determined by the namespace policy rule, or the driver flag if the rule does not set it
+func (d *Driver) allowKeyAccessWithIdentity(namespace string) bool {
+	policy, err := d.getNamespacePolicy()
+	if err != nil {
+		klog.Errorf("failed to get namespace policy: %v", err)
+		return false
+	}
+	if policy != nil {
+		if rule := policy.getRule(namespace); rule != nil && rule.AllowKeyAccessWithIdentity != nil {
+			return *rule.AllowKeyAccessWithIdentity
+		}
+	}
+	return d.allowInlineVolumeKeyAccessWithIdentity
+}
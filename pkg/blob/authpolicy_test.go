@@ -0,0 +1,277 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+const testNamespacePolicy = `
+rules:
+- namespaces: ["team-a"]
+  storageAccounts: ["teamaaccount"]
+  containers: ["team-a-*"]
+  secretNamespaces: ["shared-secrets"]
+  allowKeyAccessWithIdentity: true
+- namespaces: ["team-b-*"]
+  storageAccounts: ["*"]
+  resourceGroups: ["team-b-rg"]
+  subscriptionIDs: ["team-b-sub"]
+`
+
+func newFakeDriverWithNamespacePolicy(t *testing.T, policy string) *Driver {
+	d := NewFakeDriver()
+	d.KubeClient = fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "blob-csi-namespace-policy", Namespace: "kube-system"},
+		Data:       map[string]string{namespacePolicyDataKey: policy},
+	})
+	d.namespacePolicyConfigMap = "kube-system/blob-csi-namespace-policy"
+	var err error
+	d.namespacePolicyCache, err = azcache.NewTimedCache(namespacePolicyTTL, d.getNamespacePolicyFromConfigMap, false)
+	assert.NoError(t, err)
+	return d
+}
+
+func TestParseNamespacePolicy(t *testing.T) {
+	tests := []struct {
+		desc      string
+		data      string
+		expectErr bool
+	}{
+		{
+			desc: "valid policy",
+			data: testNamespacePolicy,
+		},
+		{
+			desc: "empty policy",
+			data: "",
+		},
+		{
+			desc:      "unknown field",
+			data:      "rules:\n- namespaces: [\"a\"]\n  accounts: [\"b\"]",
+			expectErr: true,
+		},
+		{
+			desc:      "empty namespaces",
+			data:      "rules:\n- storageAccounts: [\"b\"]",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := parseNamespacePolicy(test.data)
+		assert.Equal(t, test.expectErr, err != nil, test.desc)
+	}
+}
+
+func TestAuthorizeVolumeAccess(t *testing.T) {
+	tests := []struct {
+		desc         string
+		req          *volumeAccessRequest
+		expectedCode codes.Code
+	}{
+		{
+			desc: "no override",
+			req:  &volumeAccessRequest{Namespace: "team-a"},
+		},
+		{
+			desc: "unknown namespace without override",
+			req:  &volumeAccessRequest{},
+		},
+		{
+			desc:         "unknown namespace with override is denied",
+			req:          &volumeAccessRequest{StorageAccount: "otheraccount"},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			desc: "allowed account, container and secret namespace",
+			req:  &volumeAccessRequest{Namespace: "team-a", StorageAccount: "TeamAAccount", Container: "team-a-data", SecretNamespace: "shared-secrets"},
+		},
+		{
+			desc: "own namespace is always allowed as secret namespace",
+			req:  &volumeAccessRequest{Namespace: "team-c", SecretNamespace: "team-c"},
+		},
+		{
+			desc:         "account not allowed",
+			req:          &volumeAccessRequest{Namespace: "team-a", StorageAccount: "otheraccount"},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			desc:         "container not allowed",
+			req:          &volumeAccessRequest{Namespace: "team-a", Container: "team-b-data"},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			desc:         "secret namespace not allowed",
+			req:          &volumeAccessRequest{Namespace: "team-a", SecretNamespace: "kube-system"},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			desc: "wildcard namespace rule",
+			req:  &volumeAccessRequest{Namespace: "team-b-dev", StorageAccount: "anyaccount", ResourceGroup: "team-b-rg", SubscriptionID: "team-b-sub"},
+		},
+		{
+			desc:         "resource group not allowed",
+			req:          &volumeAccessRequest{Namespace: "team-b-dev", ResourceGroup: "other-rg"},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			desc:         "no matched rule, override is denied",
+			req:          &volumeAccessRequest{Namespace: "team-c", StorageAccount: "teamaaccount"},
+			expectedCode: codes.PermissionDenied,
+		},
+	}
+
+	d := newFakeDriverWithNamespacePolicy(t, testNamespacePolicy)
+	for _, test := range tests {
+		err := d.authorizeVolumeAccess(test.req)
+		assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+	}
+
+	// namespace policy is not configured
+	d = NewFakeDriver()
+	assert.NoError(t, d.authorizeVolumeAccess(&volumeAccessRequest{Namespace: "team-c", StorageAccount: "teamaaccount"}))
+
+	// namespace policy could not be loaded
+	d = newFakeDriverWithNamespacePolicy(t, testNamespacePolicy)
+	d.namespacePolicyConfigMap = "kube-system/notfound"
+	assert.Equal(t, codes.Unavailable, status.Code(d.authorizeVolumeAccess(&volumeAccessRequest{Namespace: "team-a"})))
+}
+
+func TestNewVolumeAccessRequest(t *testing.T) {
+	attrib := map[string]string{
+		"StorageAccountName": "account",
+		containerNameField:   "container",
+		resourceGroupField:   "rg",
+		subscriptionIDField:  "sub",
+	}
+	req := newVolumeAccessRequest("ns", "rg#account#container#uuid#secretns", attrib)
+	assert.Equal(t, &volumeAccessRequest{
+		Namespace:       "ns",
+		StorageAccount:  "account",
+		Container:       "container",
+		SecretNamespace: "secretns",
+		ResourceGroup:   "rg",
+		SubscriptionID:  "sub",
+	}, req)
+
+	attrib[secretNamespaceField] = "ns"
+	req = newVolumeAccessRequest("ns", "invalid", attrib)
+	assert.Equal(t, "ns", req.SecretNamespace)
+}
+
+func TestAllowKeyAccessWithIdentity(t *testing.T) {
+	d := newFakeDriverWithNamespacePolicy(t, testNamespacePolicy)
+	assert.True(t, d.allowKeyAccessWithIdentity("team-a"))
+	// rule does not set allowKeyAccessWithIdentity, use driver flag
+	assert.False(t, d.allowKeyAccessWithIdentity("team-b-dev"))
+	d.allowInlineVolumeKeyAccessWithIdentity = true
+	assert.True(t, d.allowKeyAccessWithIdentity("team-b-dev"))
+	assert.True(t, d.allowKeyAccessWithIdentity("team-c"))
+
+	// fail closed if namespace policy could not be loaded
+	d.namespacePolicyConfigMap = "invalid"
+	assert.False(t, d.allowKeyAccessWithIdentity("team-a"))
+}
+
+func TestNodePublishVolumeWithNamespacePolicy(t *testing.T) {
+	d := newFakeDriverWithNamespacePolicy(t, testNamespacePolicy)
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         "rg#account#container",
+		TargetPath:       "/tmp/target",
+		VolumeCapability: &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{}},
+		VolumeContext: map[string]string{
+			podNamespaceField:    "team-a",
+			secretNamespaceField: "kube-system",
+		},
+	}
+	_, err := d.NodePublishVolume(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestNodePublishVolumeWithSpoofedPVCNamespace(t *testing.T) {
+	d := newFakeDriverWithNamespacePolicy(t, testNamespacePolicy)
+	// pvc namespace in attributes of inline volume is written by pod author, team-b-* could use any storage account
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         "rg#account#container",
+		TargetPath:       "/tmp/target",
+		VolumeCapability: &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{}},
+		VolumeContext: map[string]string{
+			ephemeralField:      trueValue,
+			podNamespaceField:   "team-a",
+			pvcNamespaceKey:     "team-b-dev",
+			storageAccountField: "otheraccount",
+		},
+	}
+	_, err := d.NodePublishVolume(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGetVolumeNamespace(t *testing.T) {
+	assert.Equal(t, "pvc-ns", getVolumeNamespace(map[string]string{pvcNamespaceKey: "pvc-ns", podNamespaceField: "pod-ns"}))
+	assert.Equal(t, "pod-ns", getVolumeNamespace(map[string]string{podNamespaceField: "pod-ns"}))
+	// pvc namespace of inline volume is ignored
+	assert.Equal(t, "pod-ns", getVolumeNamespace(map[string]string{ephemeralField: "True", pvcNamespaceKey: "pvc-ns", podNamespaceField: "pod-ns"}))
+	assert.Equal(t, "", getVolumeNamespace(map[string]string{ephemeralField: trueValue, pvcNamespaceKey: "pvc-ns"}))
+}
+
+func TestNodeStageVolumeWithNamespacePolicy(t *testing.T) {
+	d := newFakeDriverWithNamespacePolicy(t, testNamespacePolicy)
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "rg#account#container",
+		StagingTargetPath: "/tmp/staging",
+		VolumeCapability:  &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{}},
+		VolumeContext: map[string]string{
+			pvcNamespaceKey:      "team-a",
+			secretNamespaceField: "kube-system",
+		},
+	}
+	_, err := d.NodeStageVolume(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// namespace is unknown
+	delete(req.VolumeContext, pvcNamespaceKey)
+	_, err = d.NodeStageVolume(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestCreateVolumeWithNamespacePolicy(t *testing.T) {
+	d := newFakeDriverWithNamespacePolicy(t, testNamespacePolicy)
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	req := &csi.CreateVolumeRequest{
+		Name: "unit-test",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER}},
+		},
+		Parameters: map[string]string{
+			pvcNamespaceKey:     "team-a",
+			storageAccountField: "otheraccount",
+		},
+	}
+	_, err := d.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
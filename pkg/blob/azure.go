@@ -23,6 +23,7 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	network "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 	"github.com/Azure/azure-sdk-for-go/storage"
 	azure2 "github.com/Azure/go-autorest/autorest/azure"
@@ -146,22 +147,36 @@ func GetCloudProvider(ctx context.Context, kubeClient kubernetes.Interface, node
 
 // getKeyVaultSecretContent get content of the keyvault secret
 func (d *Driver) getKeyVaultSecretContent(ctx context.Context, vaultURL string, secretName string, secretVersion string) (content string, err error) {
-	var authProvider *azclient.AuthProvider
-	authProvider, err = azclient.NewAuthProvider(&d.cloud.AzureAuthConfig.ARMClientConfig, &d.cloud.AzureAuthConfig.AzureAuthConfig)
+	secret, err := d.getKeyVaultSecret(ctx, vaultURL, secretName, secretVersion)
 	if err != nil {
 		return "", err
 	}
+	return secret.content, nil
+}
+
+// keyVaultSecret is the content and content type of a keyvault secret
+type keyVaultSecret struct {
+	content     string
+	contentType string
+}
+
+// getKeyVaultSecret get content and content type of the keyvault secret
+func (d *Driver) getKeyVaultSecret(ctx context.Context, vaultURL string, secretName string, secretVersion string) (*keyVaultSecret, error) {
+	authProvider, err := azclient.NewAuthProvider(&d.cloud.AzureAuthConfig.ARMClientConfig, &d.cloud.AzureAuthConfig.AzureAuthConfig)
+	if err != nil {
+		return nil, err
+	}
 	kvClient, err := azsecrets.NewClient(vaultURL, authProvider.GetAzIdentity(), nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to get keyvaultClient: %w", err)
+		return nil, fmt.Errorf("failed to get keyvaultClient: %w", err)
 	}
 
 	klog.V(2).Infof("get secret from vaultURL(%v), sercretName(%v), secretVersion(%v)", vaultURL, secretName, secretVersion)
 	secret, err := kvClient.GetSecret(ctx, secretName, secretVersion, nil)
 	if err != nil {
-		return "", fmt.Errorf("get secret from vaultURL(%v), sercretName(%v), secretVersion(%v) failed with error: %w", vaultURL, secretName, secretVersion, err)
+		return nil, fmt.Errorf("get secret from vaultURL(%v), sercretName(%v), secretVersion(%v) failed with error: %w", vaultURL, secretName, secretVersion, err)
 	}
-	return *secret.Value, nil
+	return &keyVaultSecret{content: ptr.Deref(secret.Value, ""), contentType: ptr.Deref(secret.ContentType, "")}, nil
 }
 
 func (d *Driver) updateSubnetServiceEndpoints(ctx context.Context, vnetResourceGroup, vnetName, subnetName string) ([]string, error) {
@@ -207,15 +222,26 @@ func (d *Driver) updateSubnetServiceEndpoints(ctx context.Context, vnetResourceG
 		subnetNames := strings.Split(subnetName, ",")
 		for _, sn := range subnetNames {
 			sn = strings.TrimSpace(sn)
-			subnet, rerr := d.networkClientFactory.GetSubnetClient().Get(ctx, vnetResourceGroup, vnetName, sn, nil)
+			if sn == "" {
+				continue
+			}
+			var subnet *network.Subnet
+			rerr := d.armRateLimiter.do(ctx, "GetSubnet", func() error {
+				var getErr error
+				subnet, getErr = d.networkClientFactory.GetSubnetClient().Get(ctx, vnetResourceGroup, vnetName, sn, nil)
+				return getErr
+			})
 			if rerr != nil {
-				return vnetResourceIDs, fmt.Errorf("failed to get the subnet %s under rg %s vnet %s: %v", subnetName, vnetResourceGroup, vnetName, rerr.Error())
+				return vnetResourceIDs, fmt.Errorf("failed to get the subnet %s under rg %s vnet %s: %v", sn, vnetResourceGroup, vnetName, rerr.Error())
 			}
 			subnets = append(subnets, subnet)
 		}
 	} else {
-		var rerr error
-		subnets, rerr = d.networkClientFactory.GetSubnetClient().List(ctx, vnetResourceGroup, vnetName)
+		rerr := d.armRateLimiter.do(ctx, "ListSubnets", func() error {
+			var listErr error
+			subnets, listErr = d.networkClientFactory.GetSubnetClient().List(ctx, vnetResourceGroup, vnetName)
+			return listErr
+		})
 		if rerr != nil {
 			return vnetResourceIDs, fmt.Errorf("failed to list the subnets under rg %s vnet %s: %v", vnetResourceGroup, vnetName, rerr.Error())
 		}
@@ -256,7 +282,10 @@ func (d *Driver) updateSubnetServiceEndpoints(ctx context.Context, vnetResourceG
 			subnet.Properties.ServiceEndpoints = serviceEndpoints
 
 			klog.V(2).Infof("begin to update the subnet %s under vnet %s in rg %s", sn, vnetName, vnetResourceGroup)
-			if _, err := d.networkClientFactory.GetSubnetClient().CreateOrUpdate(ctx, vnetResourceGroup, vnetName, sn, *subnet); err != nil {
+			if err := d.armRateLimiter.do(ctx, "UpdateSubnet", func() error {
+				_, updateErr := d.networkClientFactory.GetSubnetClient().CreateOrUpdate(ctx, vnetResourceGroup, vnetName, sn, *subnet)
+				return updateErr
+			}); err != nil {
 				return vnetResourceIDs, fmt.Errorf("failed to update the subnet %s under vnet %s: %v", sn, vnetName, err)
 			}
 		}
@@ -279,3 +308,70 @@ func (d *Driver) getCloudEnvironment() azure2.Environment {
 	}
 	return d.cloud.Environment
 }
+
+// ensureStorageAccount calls EnsureStorageAccount of cloud provider under ARM rate limiter
+func (d *Driver) ensureStorageAccount(ctx context.Context, accountOptions *azure.AccountOptions, genAccountNamePrefix string) (accountName, accountKey string, err error) {
+	err = d.armRateLimiter.do(ctx, "EnsureStorageAccount", func() error {
+		var retErr error
+		accountName, accountKey, retErr = d.cloud.EnsureStorageAccount(ctx, accountOptions, genAccountNamePrefix)
+		return retErr
+	})
+	return accountName, accountKey, err
+}
+
+// getStorageAccesskey lists account key with cluster identity under ARM rate limiter
+func (d *Driver) getStorageAccesskey(ctx context.Context, subsID, accountName, resourceGroup string, getLatestAccountKey bool) (accountKey string, err error) {
+	err = d.armRateLimiter.do(ctx, "ListKeys", func() error {
+		var retErr error
+		accountKey, retErr = d.cloud.GetStorageAccesskey(ctx, subsID, accountName, resourceGroup, getLatestAccountKey)
+		return retErr
+	})
+	return accountKey, err
+}
+
+// getContainerMetadata gets metadata of the container with management API under ARM rate limiter, metadata keys are in lower case
+func (d *Driver) getContainerMetadata(ctx context.Context, subsID, resourceGroup, accountName, containerName string) (map[string]string, error) {
+	if d.clientFactory == nil {
+		return nil, fmt.Errorf("client factory is nil")
+	}
+	if subsID == "" {
+		subsID = d.cloud.SubscriptionID
+	}
+	client, err := d.clientFactory.GetBlobContainerClientForSub(subsID)
+	if err != nil {
+		return nil, err
+	}
+	var container *armstorage.BlobContainer
+	err = d.armRateLimiter.do(ctx, "GetContainer", func() error {
+		var getErr error
+		container, getErr = client.Get(ctx, resourceGroup, accountName, containerName)
+		return getErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]string{}
+	if container != nil && container.ContainerProperties != nil {
+		for k, v := range container.ContainerProperties.Metadata {
+			metadata[strings.ToLower(k)] = ptr.Deref(v, "")
+		}
+	}
+	return metadata, nil
+}
+
+// listContainers lists containers of the account with management API under ARM rate limiter
+func (d *Driver) listContainers(ctx context.Context, subsID, resourceGroup, accountName string) (containers []*armstorage.ListContainerItem, err error) {
+	if d.clientFactory == nil {
+		return nil, fmt.Errorf("client factory is nil")
+	}
+	client, err := d.clientFactory.GetBlobContainerClientForSub(subsID)
+	if err != nil {
+		return nil, err
+	}
+	err = d.armRateLimiter.do(ctx, "ListContainers", func() error {
+		var listErr error
+		containers, listErr = client.List(ctx, resourceGroup, accountName)
+		return listErr
+	})
+	return containers, err
+}
@@ -0,0 +1,391 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// PVC annotations which override backup parameters of storage class, backup is disabled if schedule is "none"
+	backupScheduleAnnotation       = "blob.csi.azure.com/backup-schedule"
+	backupStorageAccountAnnotation = "blob.csi.azure.com/backup-storage-account"
+	backupRetentionAnnotation      = "blob.csi.azure.com/backup-retention"
+	// restorePointsAnnotation lists completed restore points of the PVC, newest first
+	restorePointsAnnotation = "blob.csi.azure.com/restore-points"
+
+	backupScheduleDisabled = "none"
+	defaultBackupRetention = 7
+
+	// restore point container name is restorePointPrefix + hash of the source + time
+	restorePointPrefix     = "bkp-"
+	restorePointTimeFormat = "200601021504"
+
+	// restore point metadata, the copy checkpoint is also stored in restore point metadata
+	backupPVCNamespaceMetadata = "backuppvcnamespace"
+	backupPVCNameMetadata      = "backuppvcname"
+
+	backupSucceededReason = "BackupSucceeded"
+	backupFailedReason    = "BackupFailed"
+)
+
+// interval of checking whether backup of volumes is due
+var backupSchedulerInterval = time.Minute
+
+// backupConfig is the backup configuration of a volume
+type backupConfig struct {
+	schedule       *cronSchedule
+	accountName    string
+	resourceGroup  string
+	subscriptionID string
+	retention      int
+}
+
+// restorePoint is a container in backup account which holds a copy of the volume
+type restorePoint struct {
+	name      string
+	time      time.Time
+	completed bool
+}
+
+// parseBackupConfig parses backup parameters in volume attributes and PVC annotations, nil is returned if backup is not configured
+func parseBackupConfig(attrib, annotations map[string]string) (*backupConfig, error) {
+	var scheduleSpec, retention string
+	cfg := &backupConfig{retention: defaultBackupRetention}
+	for k, v := range attrib {
+		switch strings.ToLower(k) {
+		case backupScheduleField:
+			scheduleSpec = v
+		case backupStorageAccountField:
+			cfg.accountName = v
+		case backupResourceGroupField:
+			cfg.resourceGroup = v
+		case backupSubscriptionIDField:
+			cfg.subscriptionID = v
+		case backupRetentionField:
+			retention = v
+		}
+	}
+	if v, ok := annotations[backupScheduleAnnotation]; ok {
+		scheduleSpec = v
+	}
+	if v, ok := annotations[backupStorageAccountAnnotation]; ok {
+		cfg.accountName = v
+	}
+	if v, ok := annotations[backupRetentionAnnotation]; ok {
+		retention = v
+	}
+
+	if scheduleSpec == "" || strings.EqualFold(scheduleSpec, backupScheduleDisabled) {
+		return nil, nil
+	}
+	var err error
+	if cfg.schedule, err = parseCronSchedule(scheduleSpec); err != nil {
+		return nil, err
+	}
+	if cfg.accountName == "" {
+		return nil, fmt.Errorf("%s is required with %s", backupStorageAccountField, backupScheduleField)
+	}
+	if retention != "" {
+		if cfg.retention, err = strconv.Atoi(retention); err != nil || cfg.retention < 1 {
+			return nil, fmt.Errorf("invalid %s(%s): should be a positive integer", backupRetentionField, retention)
+		}
+	}
+	return cfg, nil
+}
+
+// formatRestorePoint returns reference of the restore point in the format of subscriptionID/resourceGroup/account/container,
+// resource group and subscription are carried so that the restore point could be restored with account key in another subscription
+func formatRestorePoint(subsID, resourceGroup, accountName, containerName string) string {
+	return strings.Join([]string{subsID, resourceGroup, accountName, containerName}, "/")
+}
+
+// getRestorePointPrefix returns the name prefix of restore points of the source container
+func getRestorePointPrefix(source string) string {
+	hash := sha256.Sum256([]byte(source))
+	return restorePointPrefix + hex.EncodeToString(hash[:])[:10] + "-"
+}
+
+// runBackupScheduler backs up volumes with backup schedule periodically until ctx is done
+func (d *Driver) runBackupScheduler(ctx context.Context) {
+	if !d.enableBackupScheduler || d.KubeClient == nil {
+		return
+	}
+	if !d.waitForControllerInformers(ctx) {
+		return
+	}
+	wait.UntilWithContext(ctx, d.syncBackups, backupSchedulerInterval)
+}
+
+// syncBackups starts backup of bound volumes whose backup is due in background, a volume is only backed up by one goroutine
+func (d *Driver) syncBackups(ctx context.Context) {
+	pvs, err := d.pvLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list pv: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.Status.Phase != v1.VolumeBound || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		pvc, err := d.pvcLister.PersistentVolumeClaims(pv.Spec.ClaimRef.Namespace).Get(pv.Spec.ClaimRef.Name)
+		if err != nil {
+			continue
+		}
+		cfg, err := parseBackupConfig(pv.Spec.CSI.VolumeAttributes, pvc.Annotations)
+		if err != nil {
+			klog.Warningf("invalid backup configuration of pvc(%s/%s): %v", pvc.Namespace, pvc.Name, err)
+			continue
+		}
+		if cfg == nil {
+			continue
+		}
+		volumeID := pv.Spec.CSI.VolumeHandle
+		if info, err := parseVolumeID(volumeID); err == nil && info.subDir != "" {
+			// restore point is a copy of the whole container, which would include other volumes sharing the container
+			klog.Warningf("backup of pvc(%s/%s) is skipped since volume with %s is not supported", pvc.Namespace, pvc.Name, subDirField)
+			continue
+		}
+		if last, ok := d.lastBackupTimes.Load(volumeID); ok {
+			if next := cfg.schedule.next(last.(time.Time)); next.IsZero() || next.After(now) {
+				continue
+			}
+		}
+		if err := d.authorizeVolumeAccess(&volumeAccessRequest{Namespace: pvc.Namespace, StorageAccount: pvc.Annotations[backupStorageAccountAnnotation]}); err != nil {
+			klog.Warningf("backup of pvc(%s/%s) is not allowed: %v", pvc.Namespace, pvc.Name, err)
+			continue
+		}
+		if _, loaded := d.backupJobs.LoadOrStore(volumeID, struct{}{}); loaded {
+			continue
+		}
+		go func() {
+			defer d.backupJobs.Delete(volumeID)
+			if err := d.backupVolume(ctx, pv, pvc, cfg, now); err != nil {
+				klog.Errorf("failed to backup pvc(%s/%s): %v", pvc.Namespace, pvc.Name, err)
+			}
+		}()
+	}
+}
+
+// backupVolume copies the volume into a new restore point if the backup is due, an unfinished restore point is resumed,
+// and the oldest restore points beyond retention are deleted after the copy
+func (d *Driver) backupVolume(ctx context.Context, pv *v1.PersistentVolume, pvc *v1.PersistentVolumeClaim, cfg *backupConfig, now time.Time) error {
+	volumeID := pv.Spec.CSI.VolumeHandle
+	resourceGroup, accountName, containerName, secretNamespace, subsID, err := GetContainerInfo(volumeID)
+	if err != nil {
+		return err
+	}
+	storageEndpointSuffix := d.getStorageEndPointSuffix()
+	if info, err := parseVolumeID(volumeID); err == nil && info.storageEndpointSuffix != "" {
+		storageEndpointSuffix = info.storageEndpointSuffix
+	}
+	if cfg.resourceGroup == "" {
+		cfg.resourceGroup = d.cloud.ResourceGroup
+	}
+	source := accountName + "/" + containerName
+	prefix := getRestorePointPrefix(source)
+
+	points, err := d.listRestorePoints(ctx, cfg, prefix, source)
+	if err != nil {
+		return err
+	}
+	last := pv.CreationTimestamp.Time
+	var target *restorePoint
+	if n := len(points); n > 0 {
+		last = points[n-1].time
+		if !points[n-1].completed {
+			target = &points[n-1]
+		}
+	}
+	if target == nil {
+		if next := cfg.schedule.next(last); next.IsZero() || next.After(now) {
+			d.lastBackupTimes.Store(volumeID, last)
+			return nil
+		}
+		target = &restorePoint{name: prefix + now.UTC().Format(restorePointTimeFormat), time: now.UTC().Truncate(time.Minute)}
+		metadata := map[string]string{
+			backupPVCNamespaceMetadata: pvc.Namespace,
+			backupPVCNameMetadata:      pvc.Name,
+		}
+		if err := d.createBlobContainer(ctx, cfg.subscriptionID, cfg.resourceGroup, cfg.accountName, target.name, nil, &blobContainerOptions{metadata: metadata}); err != nil {
+			err = fmt.Errorf("failed to create restore point(%s) in account(%s): %w", target.name, cfg.accountName, err)
+			d.recordBackupEvent(ctx, pvc, v1.EventTypeWarning, backupFailedReason, err.Error())
+			return err
+		}
+		points = append(points, *target)
+	}
+	// retry an unfinished restore point on next schedule
+	d.lastBackupTimes.Store(volumeID, target.time)
+
+	var previous *restorePoint
+	for i := range points {
+		if points[i].completed && points[i].name != target.name {
+			previous = &points[i]
+		}
+	}
+	srcAccountOptions := &azure.AccountOptions{Name: accountName, ResourceGroup: resourceGroup, SubscriptionID: subsID}
+	klog.V(2).Infof("backup pvc(%s/%s) from %s to restore point(%s) in account(%s)", pvc.Namespace, pvc.Name, source, target.name, cfg.accountName)
+	progress, err := d.copyToRestorePoint(ctx, srcAccountOptions, containerName, secretNamespace, storageEndpointSuffix, cfg, target.name, previous)
+	if err != nil {
+		err = fmt.Errorf("failed to copy %s to restore point(%s) in account(%s): %w", source, target.name, cfg.accountName, err)
+		d.recordBackupEvent(ctx, pvc, v1.EventTypeWarning, backupFailedReason, err.Error())
+		return err
+	}
+	for i := range points {
+		if points[i].name == target.name {
+			points[i].completed = true
+		}
+	}
+
+	kept := d.pruneRestorePoints(ctx, cfg, points)
+	backupSubsID := cfg.subscriptionID
+	if backupSubsID == "" {
+		backupSubsID = d.cloud.SubscriptionID
+	}
+	restorePoints := make([]string, 0, len(kept))
+	for _, p := range kept {
+		restorePoints = append(restorePoints, formatRestorePoint(backupSubsID, cfg.resourceGroup, cfg.accountName, p.name))
+	}
+	d.patchPVCAnnotations(ctx, pvc.Namespace, pvc.Name, map[string]string{restorePointsAnnotation: strings.Join(restorePoints, ",")})
+	d.recordBackupEvent(ctx, pvc, v1.EventTypeNormal, backupSucceededReason, fmt.Sprintf("restore point %s/%s is created, %s", cfg.accountName, target.name, progress))
+	return nil
+}
+
+// listRestorePoints lists restore points of the source in backup account, sorted by time
+func (d *Driver) listRestorePoints(ctx context.Context, cfg *backupConfig, prefix, source string) ([]restorePoint, error) {
+	items, err := d.listContainers(ctx, cfg.subscriptionID, cfg.resourceGroup, cfg.accountName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers in account(%s) rg(%s): %w", cfg.accountName, cfg.resourceGroup, err)
+	}
+	var points []restorePoint
+	for _, item := range items {
+		name := ptr.Deref(item.Name, "")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		t, err := time.Parse(restorePointTimeFormat, strings.TrimPrefix(name, prefix))
+		if err != nil {
+			continue
+		}
+		p := restorePoint{name: name, time: t}
+		if item.Properties != nil {
+			metadata := map[string]string{}
+			for k, v := range item.Properties.Metadata {
+				metadata[strings.ToLower(k)] = ptr.Deref(v, "")
+			}
+			p.completed = parseCloneProgress(metadata, source).completed
+		}
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].time.Before(points[j].time) })
+	return points, nil
+}
+
+// copyToRestorePoint copies the source container into the restore point with native copy engine,
+// unchanged blobs are copied from the previous restore point in the same account
+func (d *Driver) copyToRestorePoint(ctx context.Context, srcAccountOptions *azure.AccountOptions, srcContainerName, secretNamespace, storageEndpointSuffix string, cfg *backupConfig, target string, previous *restorePoint) (cloneProgress, error) {
+	backupAccountOptions := &azure.AccountOptions{Name: cfg.accountName, ResourceGroup: cfg.resourceGroup, SubscriptionID: cfg.subscriptionID}
+	dstSasToken, _, err := d.getAzcopyAuth(ctx, cfg.accountName, "", storageEndpointSuffix, backupAccountOptions, nil, "", secretNamespace, false)
+	if err != nil {
+		return cloneProgress{}, err
+	}
+	srcSasToken, err := d.getCloneSourceSASToken(ctx, srcAccountOptions.Name, srcContainerName, storageEndpointSuffix, srcAccountOptions, secretNamespace)
+	if err != nil {
+		return cloneProgress{}, err
+	}
+	srcClient, err := d.newNativeCopyClient(ctx, srcAccountOptions.Name, srcContainerName, srcSasToken, cfg.accountName, target, dstSasToken, storageEndpointSuffix)
+	if err != nil {
+		return cloneProgress{}, err
+	}
+	client := &incrementalCopyClient{cloneCopyClient: srcClient}
+	if previous != nil {
+		prevSasToken, err := d.getCloneSourceSASToken(ctx, cfg.accountName, previous.name, storageEndpointSuffix, backupAccountOptions, secretNamespace)
+		if err != nil {
+			return cloneProgress{}, err
+		}
+		if client.previous, err = d.newNativeCopyClient(ctx, cfg.accountName, previous.name, prevSasToken, cfg.accountName, target, dstSasToken, storageEndpointSuffix); err != nil {
+			return cloneProgress{}, err
+		}
+		client.since = previous.time
+	}
+
+	job := newNativeCopyJob()
+	err = copyContainer(ctx, client, srcAccountOptions.Name+"/"+srcContainerName, d.cloneCopyParallelism, job)
+	progress := job.progress()
+	progress.completed = err == nil
+	return progress, err
+}
+
+// pruneRestorePoints deletes the oldest completed restore points beyond retention and returns the kept ones, newest first
+func (d *Driver) pruneRestorePoints(ctx context.Context, cfg *backupConfig, points []restorePoint) []restorePoint {
+	var kept []restorePoint
+	for i := len(points) - 1; i >= 0; i-- {
+		p := points[i]
+		if !p.completed {
+			continue
+		}
+		if len(kept) < cfg.retention {
+			kept = append(kept, p)
+			continue
+		}
+		klog.V(2).Infof("delete restore point(%s) in account(%s) beyond retention(%d)", p.name, cfg.accountName, cfg.retention)
+		if err := d.DeleteBlobContainer(ctx, cfg.subscriptionID, cfg.resourceGroup, cfg.accountName, p.name, nil); err != nil {
+			klog.Warningf("failed to delete restore point(%s) in account(%s): %v", p.name, cfg.accountName, err)
+		}
+	}
+	return kept
+}
+
+func (d *Driver) recordBackupEvent(ctx context.Context, pvc *v1.PersistentVolumeClaim, eventType, reason, message string) {
+	d.recordVolumeEvent(ctx, map[string]string{pvcNameKey: pvc.Name, pvcNamespaceKey: pvc.Namespace}, eventType, reason, "%s", message)
+}
+
+// incrementalCopyClient copies blobs not modified since the previous restore point from that restore point,
+// which is a copy within the backup account instead of across regions, other blobs are copied from the source volume
+type incrementalCopyClient struct {
+	cloneCopyClient
+	previous cloneCopyClient
+	since    time.Time
+}
+
+func (c *incrementalCopyClient) CopyBlob(ctx context.Context, b copySourceBlob) error {
+	if c.previous != nil && !b.lastModified.IsZero() && b.lastModified.Before(c.since) {
+		err := c.previous.CopyBlob(ctx, b)
+		if err == nil {
+			return nil
+		}
+		// e.g. the blob is renamed after previous backup
+		klog.V(4).Infof("failed to copy blob(%s) from previous restore point, copy from source: %v", b.name, err)
+	}
+	return c.cloneCopyClient.CopyBlob(ctx, b)
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/blobcontainerclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/blobcontainerclient/mock_blobcontainerclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+)
+
+func TestParseBackupConfig(t *testing.T) {
+	attrib := map[string]string{
+		"backupSchedule":       "@daily",
+		"backupStorageAccount": "backup",
+		"backupResourceGroup":  "rg",
+		"backupSubscriptionID": "sub",
+	}
+	tests := []struct {
+		desc              string
+		attrib            map[string]string
+		annotations       map[string]string
+		expectedNil       bool
+		expectedAccount   string
+		expectedRetention int
+		expectedErr       string
+	}{
+		{
+			desc:        "backup is not configured",
+			attrib:      map[string]string{storageAccountField: "account"},
+			expectedNil: true,
+		},
+		{
+			desc:              "storage class parameters",
+			attrib:            attrib,
+			expectedAccount:   "backup",
+			expectedRetention: defaultBackupRetention,
+		},
+		{
+			desc:   "pvc annotations override storage class parameters",
+			attrib: attrib,
+			annotations: map[string]string{
+				backupStorageAccountAnnotation: "other",
+				backupRetentionAnnotation:      "3",
+			},
+			expectedAccount:   "other",
+			expectedRetention: 3,
+		},
+		{
+			desc:        "backup is disabled by pvc annotation",
+			attrib:      attrib,
+			annotations: map[string]string{backupScheduleAnnotation: "None"},
+			expectedNil: true,
+		},
+		{
+			desc:              "backup is enabled by pvc annotation",
+			annotations:       map[string]string{backupScheduleAnnotation: "0 * * * *", backupStorageAccountAnnotation: "backup"},
+			expectedAccount:   "backup",
+			expectedRetention: defaultBackupRetention,
+		},
+		{
+			desc:        "backup storage account is not set",
+			attrib:      map[string]string{backupScheduleField: "@daily"},
+			expectedErr: "backupstorageaccount is required with backupschedule",
+		},
+		{
+			desc:        "invalid schedule",
+			attrib:      map[string]string{backupScheduleField: "daily", backupStorageAccountField: "backup"},
+			expectedErr: "invalid cron schedule(daily)",
+		},
+		{
+			desc:        "invalid retention",
+			attrib:      map[string]string{backupScheduleField: "@daily", backupStorageAccountField: "backup", backupRetentionField: "0"},
+			expectedErr: "invalid backupretention(0)",
+		},
+	}
+
+	for _, test := range tests {
+		cfg, err := parseBackupConfig(test.attrib, test.annotations)
+		if test.expectedErr != "" {
+			assert.ErrorContains(t, err, test.expectedErr, test.desc)
+			continue
+		}
+		require.NoError(t, err, test.desc)
+		if test.expectedNil {
+			assert.Nil(t, cfg, test.desc)
+			continue
+		}
+		require.NotNil(t, cfg, test.desc)
+		assert.Equal(t, test.expectedAccount, cfg.accountName, test.desc)
+		assert.Equal(t, test.expectedRetention, cfg.retention, test.desc)
+	}
+}
+
+func TestGetRestorePointPrefix(t *testing.T) {
+	prefix := getRestorePointPrefix("account/container")
+	assert.Equal(t, prefix, getRestorePointPrefix("account/container"))
+	assert.NotEqual(t, prefix, getRestorePointPrefix("account/other"))
+	assert.True(t, strings.HasPrefix(prefix, restorePointPrefix))
+	// container name length should not exceed the limit
+	assert.LessOrEqual(t, len(prefix+restorePointTimeFormat), containerNameMaxLength)
+}
+
+func TestIncrementalCopyClient(t *testing.T) {
+	since := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	source := newFakeCloneCopyClient(0)
+	previous := newFakeCloneCopyClient(0)
+	previous.failBlob = "renamed"
+	client := &incrementalCopyClient{cloneCopyClient: source, previous: previous, since: since}
+
+	for _, b := range []copySourceBlob{
+		{name: "unchanged", lastModified: since.Add(-time.Hour)},
+		{name: "changed", lastModified: since.Add(time.Minute)},
+		{name: "renamed", lastModified: since.Add(-time.Hour)},
+		{name: "unknown"},
+	} {
+		require.NoError(t, client.CopyBlob(context.Background(), b))
+	}
+	assert.Equal(t, []string{"unchanged"}, previous.copied)
+	assert.Equal(t, []string{"changed", "renamed", "unknown"}, source.copied)
+
+	// all blobs are copied from source without previous restore point
+	client = &incrementalCopyClient{cloneCopyClient: source}
+	require.NoError(t, client.CopyBlob(context.Background(), copySourceBlob{name: "first", lastModified: since.Add(-time.Hour)}))
+	assert.Contains(t, source.copied, "first")
+}
+
+func newTestRestorePoint(name string, state string) *armstorage.ListContainerItem {
+	item := &armstorage.ListContainerItem{Name: ptr.To(name), Properties: &armstorage.ContainerProperties{}}
+	if state != "" {
+		item.Properties.Metadata = map[string]*string{
+			"Clonesource": ptr.To("account/container"),
+			"Clonestate":  ptr.To(state),
+		}
+	}
+	return item
+}
+
+func TestBackupVolume(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	prefix := getRestorePointPrefix("account/container")
+	newName := prefix + "202401021030"
+	tests := []struct {
+		desc                  string
+		items                 []*armstorage.ListContainerItem
+		retention             int
+		failBlob              string
+		expectedCreate        bool
+		expectedDeleted       []string
+		expectedCopied        bool
+		expectedRestorePoints string
+		expectedLastBackup    time.Time
+		expectedReason        string
+		expectedErr           string
+	}{
+		{
+			desc: "backup is not due",
+			items: []*armstorage.ListContainerItem{
+				newTestRestorePoint(prefix+"202401021000", cloneStateCompleted),
+				newTestRestorePoint("other", ""),
+			},
+			retention:          7,
+			expectedLastBackup: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			desc:                  "first backup after volume creation",
+			retention:             7,
+			expectedCreate:        true,
+			expectedCopied:        true,
+			expectedRestorePoints: "sub/rg/backup/" + newName,
+			expectedLastBackup:    now,
+			expectedReason:        backupSucceededReason,
+		},
+		{
+			desc: "backup with retention",
+			items: []*armstorage.ListContainerItem{
+				newTestRestorePoint(prefix+"202401020800", cloneStateCompleted),
+				newTestRestorePoint(prefix+"202401020900", cloneStateCompleted),
+				newTestRestorePoint(prefix+"202401020700", cloneStateCompleted),
+			},
+			retention:             2,
+			expectedCreate:        true,
+			expectedCopied:        true,
+			expectedDeleted:       []string{prefix + "202401020800", prefix + "202401020700"},
+			expectedRestorePoints: fmt.Sprintf("sub/rg/backup/%s,sub/rg/backup/%s", newName, prefix+"202401020900"),
+			expectedLastBackup:    now,
+			expectedReason:        backupSucceededReason,
+		},
+		{
+			desc: "unfinished restore point is resumed",
+			items: []*armstorage.ListContainerItem{
+				newTestRestorePoint(prefix+"202401020900", cloneStateCompleted),
+				newTestRestorePoint(prefix+"202401021000", cloneStateInProgress),
+			},
+			retention:             7,
+			expectedCopied:        true,
+			expectedRestorePoints: fmt.Sprintf("sub/rg/backup/%s,sub/rg/backup/%s", prefix+"202401021000", prefix+"202401020900"),
+			expectedLastBackup:    time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+			expectedReason:        backupSucceededReason,
+		},
+		{
+			desc:               "copy failed",
+			retention:          7,
+			failBlob:           "blob0",
+			expectedCreate:     true,
+			expectedLastBackup: now,
+			expectedReason:     backupFailedReason,
+			expectedErr:        "failed to copy account/container to restore point(" + newName + ")",
+		},
+	}
+
+	for _, test := range tests {
+		d, recorder := newFakeDriverWithCloneJob(t)
+		ctrl := gomock.NewController(t)
+		blobClient := mock_blobcontainerclient.NewMockInterface(ctrl)
+		clientFactory := mock_azclient.NewMockClientFactory(ctrl)
+		clientFactory.EXPECT().GetBlobContainerClientForSub("sub").Return(blobClient, nil).AnyTimes()
+		d.clientFactory = clientFactory
+		blobClient.EXPECT().List(gomock.Any(), "rg", "backup").Return(test.items, nil)
+		if test.expectedCreate {
+			blobClient.EXPECT().CreateContainer(gomock.Any(), "rg", "backup", newName, gomock.Any()).DoAndReturn(
+				func(_ context.Context, _, _, _ string, c armstorage.BlobContainer) (*armstorage.BlobContainer, error) {
+					assert.Equal(t, "ns", ptr.Deref(c.ContainerProperties.Metadata[backupPVCNamespaceMetadata], ""), test.desc)
+					assert.Equal(t, "pvc", ptr.Deref(c.ContainerProperties.Metadata[backupPVCNameMetadata], ""), test.desc)
+					return &c, nil
+				})
+		}
+		for _, name := range test.expectedDeleted {
+			blobClient.EXPECT().DeleteContainer(gomock.Any(), "rg", "backup", name).Return(nil)
+		}
+		copyClient := newFakeCloneCopyClient(3)
+		copyClient.failBlob = test.failBlob
+		d.cloneCopyClientFactory = copyClient
+		d.userDelegationSASFactory = &fakeUserDelegationSASFactory{sasToken: "?sig=src"}
+		d.azcopySasTokenCache.Set("backup", "?sig=dst")
+
+		pv := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv", CreationTimestamp: metav1.NewTime(time.Date(2024, 1, 2, 8, 30, 0, 0, time.UTC))},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{VolumeHandle: "rg#account#container"}},
+			},
+		}
+		pvc := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns"}}
+		schedule, err := parseCronSchedule("0 * * * *")
+		require.NoError(t, err, test.desc)
+		cfg := &backupConfig{schedule: schedule, accountName: "backup", resourceGroup: "rg", subscriptionID: "sub", retention: test.retention}
+
+		err = d.backupVolume(context.Background(), pv, pvc, cfg, now)
+		if test.expectedErr != "" {
+			assert.ErrorContains(t, err, test.expectedErr, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+		if test.expectedCopied {
+			assert.ElementsMatch(t, []string{"blob0", "blob1", "blob2"}, copyClient.copied, test.desc)
+			// the last client copies from previous restore point if there is one
+			assert.True(t, strings.HasSuffix(copyClient.srcURL, "?sig=src"), test.desc)
+			assert.True(t, strings.HasPrefix(copyClient.dstURL, "https://backup.blob.core.windows.net/"+prefix), test.desc)
+			assert.Equal(t, cloneStateCompleted, copyClient.metadata[cloneStateMetadata], test.desc)
+		} else if test.failBlob == "" {
+			assert.Empty(t, copyClient.copied, test.desc)
+		}
+		last, ok := d.lastBackupTimes.Load("rg#account#container")
+		assert.True(t, ok, test.desc)
+		assert.Equal(t, test.expectedLastBackup, last, test.desc)
+
+		updated, err := d.KubeClient.CoreV1().PersistentVolumeClaims("ns").Get(context.Background(), "pvc", metav1.GetOptions{})
+		require.NoError(t, err, test.desc)
+		assert.Equal(t, test.expectedRestorePoints, updated.Annotations[restorePointsAnnotation], test.desc)
+		reasons := getCloneEventReasons(recorder)
+		if test.expectedReason == "" {
+			assert.Empty(t, reasons, test.desc)
+		} else {
+			assert.Equal(t, []string{test.expectedReason}, reasons, test.desc)
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestSyncBackups(t *testing.T) {
+	newPV := func(name, driver string, phase v1.PersistentVolumePhase, attrib map[string]string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: "rg#account#" + name, VolumeAttributes: attrib}},
+				ClaimRef:               &v1.ObjectReference{Namespace: "ns", Name: "pvc-" + name},
+			},
+			Status: v1.PersistentVolumeStatus{Phase: phase},
+		}
+	}
+	newPVC := func(name string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc-" + name, Namespace: "ns"}}
+	}
+	backup := map[string]string{backupScheduleField: "0 * * * *", backupStorageAccountField: "backup", backupResourceGroupField: "rg"}
+
+	d := NewFakeDriver()
+	d.enableBackupScheduler = true
+	d.KubeClient = fake.NewSimpleClientset(
+		newPV("due", fakeDriverName, v1.VolumeBound, backup), newPVC("due"),
+		newPV("notdue", fakeDriverName, v1.VolumeBound, backup), newPVC("notdue"),
+		newPV("nobackup", fakeDriverName, v1.VolumeBound, nil), newPVC("nobackup"),
+		newPV("otherdriver", "other", v1.VolumeBound, backup), newPVC("otherdriver"),
+		newPV("released", fakeDriverName, v1.VolumeReleased, backup),
+		newPV("invalid", fakeDriverName, v1.VolumeBound, map[string]string{backupScheduleField: "invalid"}), newPVC("invalid"),
+	)
+	subDirPV := newPV("subdir", fakeDriverName, v1.VolumeBound, backup)
+	subDirPV.Spec.CSI.VolumeHandle = "blob:v2#rg#account#subdir####subdir=dir"
+	_, err := d.KubeClient.CoreV1().PersistentVolumes().Create(context.Background(), subDirPV, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = d.KubeClient.CoreV1().PersistentVolumeClaims("ns").Create(context.Background(), newPVC("subdir"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.startControllerInformers(ctx)
+	require.True(t, d.waitForControllerInformers(ctx))
+	d.lastBackupTimes.Store("rg#account#notdue", time.Now())
+
+	// only the due volume lists its restore points, volume with subDir is skipped
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	clientFactory := mock_azclient.NewMockClientFactory(ctrl)
+	listed := make(chan struct{})
+	clientFactory.EXPECT().GetBlobContainerClientForSub(gomock.Any()).DoAndReturn(func(string) (blobcontainerclient.Interface, error) {
+		close(listed)
+		return nil, fmt.Errorf("test error")
+	})
+	d.clientFactory = clientFactory
+
+	d.syncBackups(ctx)
+	select {
+	case <-listed:
+	case <-time.After(time.Minute):
+		t.Fatalf("timeout waiting for backup of due volume")
+	}
+	assert.Eventually(t, func() bool {
+		_, running := d.backupJobs.Load("rg#account#due")
+		return !running
+	}, time.Minute, 10*time.Millisecond)
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -33,11 +34,16 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	"github.com/pborman/uuid"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/grpc"
 	v1 "k8s.io/api/core/v1"
 	apierror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	k8sutil "k8s.io/kubernetes/pkg/volume/util"
 	mount "k8s.io/mount-utils"
@@ -75,6 +81,9 @@ const (
 	secretNamespaceField           = "secretnamespace"
 	containerNameField             = "containername"
 	containerNamePrefixField       = "containernameprefix"
+	subDirField                    = "subdir"
+	onDeleteField                  = "ondelete"
+	backupContainerNameField       = "backupcontainername"
 	storeAccountKeyField           = "storeaccountkey"
 	getLatestAccountKeyField       = "getlatestaccountkey"
 	isHnsEnabledField              = "ishnsenabled"
@@ -100,6 +109,7 @@ const (
 	ephemeralField                 = "csi.storage.k8s.io/ephemeral"
 	podNamespaceField              = "csi.storage.k8s.io/pod.namespace"
 	serviceAccountTokenField       = "csi.storage.k8s.io/serviceAccount.tokens"
+	serviceAccountNameField        = "csi.storage.k8s.io/serviceAccount.name"
 	clientIDField                  = "clientID"
 	tenantIDField                  = "tenantID"
 	mountOptionsField              = "mountoptions"
@@ -110,6 +120,8 @@ const (
 	accountSasTokenField           = "azurestorageaccountsastoken"
 	msiSecretField                 = "msisecret"
 	storageSPNClientSecretField    = "azurestoragespnclientsecret"
+	storageSPNClientCertField      = "azurestoragespnclientcertificate"
+	storageSPNClientCertPwdField   = "azurestoragespnclientcertificatepassword"
 	Fuse                           = "fuse"
 	Fuse2                          = "fuse2"
 	NFS                            = "nfs"
@@ -120,6 +132,7 @@ const (
 	subnetNameField                = "subnetname"
 	accessTierField                = "accesstier"
 	networkEndpointTypeField       = "networkendpointtype"
+	privateDNSZoneLinkField        = "privatednszonelink"
 	mountPermissionsField          = "mountpermissions"
 	fsGroupChangePolicyField       = "fsgroupchangepolicy"
 	useDataPlaneAPIField           = "usedataplaneapi"
@@ -157,6 +170,32 @@ const (
 	FSGroupChangeNone = "None"
 	// define tag value delimiter and default is comma
 	tagValueDelimiterField = "tagValueDelimiter"
+
+	externalSecretSourceTimeout = 30 * time.Second
+)
+
+// StorageClass parameters of data protection, lifecycle management, cloning, backup and account sharing
+const (
+	immutabilityPeriodInDaysField    = "immutabilityperiodindays"
+	allowProtectedAppendWritesField  = "allowprotectedappendwrites"
+	legalHoldTagsField               = "legalholdtags"
+	tierToCoolAfterDaysField         = "tiertocoolafterdays"
+	tierToArchiveAfterDaysField      = "tiertoarchiveafterdays"
+	deleteAfterDaysField             = "deleteafterdays"
+	encryptionScopeField             = "encryptionscope"
+	denyEncryptionScopeOverrideField = "denyencryptionscopeoverride"
+	waitForCloneCompletionField      = "waitforclonecompletion"
+	cloneSourceField                 = "clonesource"
+	backupScheduleField              = "backupschedule"
+	backupStorageAccountField        = "backupstorageaccount"
+	backupResourceGroupField         = "backupresourcegroup"
+	backupSubscriptionIDField        = "backupsubscriptionid"
+	backupRetentionField             = "backupretention"
+	accountPoolSizeField             = "accountpoolsize"
+	accountPoolStrategyField         = "accountpoolstrategy"
+	maxVolumesPerAccountField        = "maxvolumesperaccount"
+	accountPerNamespaceField         = "accountpernamespace"
+	accountIsolationField            = "accountisolation"
 )
 
 var (
@@ -186,8 +225,31 @@ type DriverOptions struct {
 	VolStatsCacheExpireInMinutes           int
 	SasTokenExpirationMinutes              int
 	WaitForAzCopyTimeoutMinutes            int
+	UseAzcopyForCloning                    bool
+	CloneCopyParallelism                   int
+	EnableAsyncClone                       bool
+	EnableVolumePopulator                  bool
+	EnableBackupScheduler                  bool
+	EnableNetworkRuleReconciler            bool
+	EnableTopology                         bool
+	CrossRegionMountPolicy                 string
+	ARMRateLimitQPS                        float64
+	ARMRateLimitBurst                      int
+	DataPlaneRateLimitQPS                  float64
+	DataPlaneRateLimitBurst                int
 	EnableVolumeMountGroup                 bool
 	FSGroupChangePolicy                    string
+	ExternalSecretSourceEndpoint           string
+	ExternalSecretSourceCacheTTLMinutes    int
+	EnableCredentialCache                  bool
+	CredentialCacheTTLMinutes              int
+	NamespacePolicyConfigMap               string
+	CloudCapabilitiesConfigMap             string
+	VolumeIDFormatVersion                  int
+	EnableStateStore                       bool
+	StateStoreNamespace                    string
+	EnableLeaderElection                   bool
+	LeaderElectionNamespace                string
 }
 
 func (option *DriverOptions) AddFlags() {
@@ -205,9 +267,32 @@ func (option *DriverOptions) AddFlags() {
 	flag.BoolVar(&option.EnableAznfsMount, "enable-aznfs-mount", false, "replace nfs mount with aznfs mount")
 	flag.IntVar(&option.VolStatsCacheExpireInMinutes, "vol-stats-cache-expire-in-minutes", 10, "The cache expire time in minutes for volume stats cache")
 	flag.IntVar(&option.SasTokenExpirationMinutes, "sas-token-expiration-minutes", 1440, "sas token expiration minutes during volume cloning")
-	flag.IntVar(&option.WaitForAzCopyTimeoutMinutes, "wait-for-azcopy-timeout-minutes", 18, "timeout in minutes for waiting for azcopy to finish")
+	flag.IntVar(&option.WaitForAzCopyTimeoutMinutes, "wait-for-azcopy-timeout-minutes", 18, "timeout in minutes for waiting for azcopy or native copy to finish")
+	flag.BoolVar(&option.UseAzcopyForCloning, "use-azcopy-for-cloning", false, "use azcopy binary instead of native server side copy for volume cloning")
+	flag.IntVar(&option.CloneCopyParallelism, "clone-copy-parallelism", defaultCloneCopyParallelism, "number of blobs copied in parallel by native copy in volume cloning, also used by archive and copyToBackupContainer onDelete policies")
+	flag.BoolVar(&option.EnableAsyncClone, "enable-async-clone", false, "return CreateVolume once the native copy job of volume cloning is started, and track the copy in background")
+	flag.BoolVar(&option.EnableVolumePopulator, "enable-volume-populator", false, "populate PVCs whose dataSourceRef is a BlobDataSource custom resource in controller")
+	flag.BoolVar(&option.EnableBackupScheduler, "enable-backup-scheduler", false, "back up volumes with backup schedule to restore points in backup storage account in controller")
+	flag.BoolVar(&option.EnableNetworkRuleReconciler, "enable-network-rule-reconciler", false, "keep virtual network rules of NFS storage accounts in sync with subnets of nodes in controller")
+	flag.BoolVar(&option.EnableTopology, "enable-topology", false, "report region and zone of node as topology, and create storage account in the region of requested topology")
+	flag.StringVar(&option.CrossRegionMountPolicy, "cross-region-mount-policy", crossRegionMountWarn, "policy of mounting volume whose location is not the region of node, supported values: allow, warn, deny")
+	flag.Float64Var(&option.ARMRateLimitQPS, "arm-rate-limit-qps", 10, "max qps of Azure Resource Manager calls shared by all volume operations, the rate is lowered on throttling, 0 disables the rate limiter")
+	flag.IntVar(&option.ARMRateLimitBurst, "arm-rate-limit-burst", 50, "burst of Azure Resource Manager calls")
+	flag.Float64Var(&option.DataPlaneRateLimitQPS, "dataplane-rate-limit-qps", 0, "max qps of storage data plane calls shared by all volume operations, the rate is lowered on throttling, 0 disables the rate limiter")
+	flag.IntVar(&option.DataPlaneRateLimitBurst, "dataplane-rate-limit-burst", 100, "burst of storage data plane calls")
 	flag.BoolVar(&option.EnableVolumeMountGroup, "enable-volume-mount-group", true, "indicates whether enabling VOLUME_MOUNT_GROUP")
 	flag.StringVar(&option.FSGroupChangePolicy, "fsgroup-change-policy", "", "indicates how the volume's ownership will be changed by the driver, OnRootMismatch is the default value")
+	flag.StringVar(&option.ExternalSecretSourceEndpoint, "external-secret-source-endpoint", "", "http(s) or unix socket endpoint of external secret source plugin which provides storage account credentials, e.g. unix:///var/run/blob-secret-source.sock")
+	flag.IntVar(&option.ExternalSecretSourceCacheTTLMinutes, "external-secret-source-cache-ttl-minutes", 10, "default cache TTL in minutes for credentials returned by external secret source, set as 0 to disable cache")
+	flag.BoolVar(&option.EnableCredentialCache, "enable-credential-cache", false, "cache kubernetes secrets and key vault secrets on the node")
+	flag.StringVar(&option.NamespacePolicyConfigMap, "namespace-policy-configmap", "", "configmap(in the format of namespace/name) which stores the namespace authorization policy of storage accounts, containers and secret namespaces")
+	flag.IntVar(&option.CredentialCacheTTLMinutes, "credential-cache-ttl-minutes", 10, "cache TTL in minutes for kubernetes secrets and key vault secrets when credential cache is enabled")
+	flag.StringVar(&option.CloudCapabilitiesConfigMap, "cloud-capabilities-configmap", "", "configmap(in the format of namespace/name) which overrides the built-in capabilities of the cloud, e.g. supported skus and features on Azure Stack Hub")
+	flag.BoolVar(&option.EnableStateStore, "enable-state-store", false, "persist driver-managed state(e.g. storage account picked for volume) in BlobDriverState custom resources so it survives controller restarts")
+	flag.StringVar(&option.StateStoreNamespace, "state-store-namespace", "kube-system", "namespace of BlobDriverState custom resources when state store is enabled")
+	flag.BoolVar(&option.EnableLeaderElection, "leader-election", false, "only run background controllers(e.g. backup scheduler) on the leader of controller replicas, elected by Lease")
+	flag.StringVar(&option.LeaderElectionNamespace, "leader-election-namespace", "kube-system", "namespace of the Lease used in leader election of controller replicas")
+	flag.IntVar(&option.VolumeIDFormatVersion, "volume-id-format-version", volumeIDFormatV1, "format version of volume ID created by the driver, supported values: 1, 2. Set as 2 only after all nodes are upgraded since older drivers could not parse v2 volume ID")
 }
 
 // Driver implements all interfaces of CSI drivers
@@ -238,24 +323,102 @@ type Driver struct {
 	volumeLocks *volumeLocks
 	// only for nfs feature
 	subnetLockMap *util.LockMap
+	// a map storing all account names which are updating management policy
+	managementPolicyLockMap       *util.LockMap
+	managementPolicyClientFactory managementPolicyClientFactory
 	// a map storing all volumes created by this driver <volumeName, accountName>
 	volMap sync.Map
 	// a timed cache storing all volumeIDs and storage accounts that are using data plane API
 	dataPlaneAPIVolCache azcache.Resource
+	// a timed cache storing volumeIDs and storage accounts not found in state store as data plane API entries
+	dataPlaneAPIStateMissCache azcache.Resource
 	// a timed cache storing account search history (solve account list throttling issue)
 	accountSearchCache azcache.Resource
+	// a timed cache storing number of volumes in accounts of account pools <accountName, *atomic.Int64>
+	accountLoadCache azcache.Resource
 	// a timed cache storing volume stats <volumeID, volumeStats>
 	volStatsCache azcache.Resource
 	// a timed cache storing account which should use sastoken for azcopy based volume cloning
 	azcopySasTokenCache azcache.Resource
 	// a timed cache storing subnet operations
 	subnetCache azcache.Resource
+	// a timed cache storing verified private DNS zone links
+	privateDNSZoneLinkCache azcache.Resource
+	// ipResolver resolves blob endpoint on the node to check private endpoint
+	ipResolver ipResolver
 	// sas expiry time for azcopy in volume clone
 	sasTokenExpirationMinutes int
 	// timeout in minutes for waiting for azcopy to finish
 	waitForAzCopyTimeoutMinutes int
 	// azcopy for provide exec mock for ut
 	azcopy *util.Azcopy
+	// use azcopy instead of native copy engine for volume cloning
+	useAzcopyForCloning  bool
+	cloneCopyParallelism int
+	// run native copy of volume cloning in background, not supported with azcopy
+	enableAsyncClone bool
+	// cloneCopyClientFactory creates server side copy client for native copy engine
+	cloneCopyClientFactory cloneCopyClientFactory
+	// a map storing running native copy jobs <dstAccount/dstContainer, *nativeCopyJob>
+	nativeCopyJobs sync.Map
+	// blobDataSourceClient gets BlobDataSource custom resources, nil if volume populator is disabled
+	blobDataSourceClient  dynamic.Interface
+	populateClientFactory populateClientFactory
+	// a map storing PVCs being populated <pvc uid, struct{}>
+	populateJobs sync.Map
+	// back up volumes with backup schedule in controller
+	enableBackupScheduler bool
+	// a map storing volumes being backed up <volumeID, struct{}>
+	backupJobs sync.Map
+	// a map storing time of the latest restore point, restore points are not listed until next schedule <volumeID, time.Time>
+	lastBackupTimes sync.Map
+	// informers of objects watched by background controllers, listers are only set when the controller is enabled
+	controllerInformerFactory informers.SharedInformerFactory
+	pvLister                  corev1listers.PersistentVolumeLister
+	pvcLister                 corev1listers.PersistentVolumeClaimLister
+	nodeLister                corev1listers.NodeLister
+	// sync network rules of NFS storage accounts with subnets of nodes
+	enableNetworkRuleReconciler bool
+	// report node topology and provision storage account in the region of requested topology
+	enableTopology bool
+	// policy of mounting volume whose location is not the region of node
+	crossRegionMountPolicy string
+	// region and zone of the node, set on first use
+	nodeTopologyLock sync.Mutex
+	nodeTopology     *nodeTopology
+	// accountSearchGroup coalesces concurrent storage account searches with the same lockKey
+	accountSearchGroup singleflight.Group
+	// a map storing the last failure of storage account search <lockKey, *accountSearchFailure>
+	accountSearchFailures sync.Map
+	// external secret source which provides storage account credentials, nil if not configured
+	externalSecretSource externalSecretSource
+	eventRecorder        record.EventRecorder
+	// timed caches storing kubernetes secrets and key vault secret content, nil if credential cache is disabled
+	secretCache         azcache.Resource
+	keyVaultSecretCache azcache.Resource
+	// configmap which stores the namespace authorization policy, in the format of namespace/name
+	namespacePolicyConfigMap string
+	// a timed cache storing parsed namespace policy
+	namespacePolicyCache azcache.Resource
+	// configmap which overrides the built-in cloud capabilities, in the format of namespace/name
+	cloudCapabilitiesConfigMap string
+	// a timed cache storing parsed cloud capabilities of the configmap
+	cloudCapabilitiesCache azcache.Resource
+	// format version of volume ID created by the driver
+	volumeIDFormatVersion int
+	// stateStore persists driver-managed state, nil if state store is disabled
+	stateStore          stateStore
+	stateStoreNamespace string
+	// run background controllers only on the leader elected by Lease in leaderElectionNamespace
+	enableLeaderElection    bool
+	leaderElectionNamespace string
+	// containerDataClientFactory creates data plane client of a container, e.g. to create subDir
+	containerDataClientFactory containerDataClientFactory
+	// rate limiters shared by all Azure Resource Manager and storage data plane calls, nil if disabled
+	armRateLimiter       *adaptiveRateLimiter
+	dataPlaneRateLimiter *adaptiveRateLimiter
+	// userDelegationSASFactory generates SAS token with cluster identity for cross account volume cloning
+	userDelegationSASFactory userDelegationSASFactory
 }
 
 // NewDriver Creates a NewCSIDriver object. Assumes vendor version is equal to driver version &
@@ -264,6 +427,7 @@ func NewDriver(options *DriverOptions, kubeClient kubernetes.Interface, cloud *p
 	d := Driver{
 		volLockMap:                             util.NewLockMap(),
 		subnetLockMap:                          util.NewLockMap(),
+		managementPolicyLockMap:                util.NewLockMap(),
 		volumeLocks:                            newVolumeLocks(),
 		blobfuseProxyEndpoint:                  options.BlobfuseProxyEndpoint,
 		enableBlobfuseProxy:                    options.EnableBlobfuseProxy,
@@ -277,20 +441,63 @@ func NewDriver(options *DriverOptions, kubeClient kubernetes.Interface, cloud *p
 		enableAznfsMount:                       options.EnableAznfsMount,
 		sasTokenExpirationMinutes:              options.SasTokenExpirationMinutes,
 		waitForAzCopyTimeoutMinutes:            options.WaitForAzCopyTimeoutMinutes,
+		useAzcopyForCloning:                    options.UseAzcopyForCloning,
+		cloneCopyParallelism:                   options.CloneCopyParallelism,
+		enableAsyncClone:                       options.EnableAsyncClone,
+		enableBackupScheduler:                  options.EnableBackupScheduler,
+		enableNetworkRuleReconciler:            options.EnableNetworkRuleReconciler,
+		enableTopology:                         options.EnableTopology,
+		crossRegionMountPolicy:                 options.CrossRegionMountPolicy,
+		armRateLimiter:                         newAdaptiveRateLimiter(armRateLimiterName, options.ARMRateLimitQPS, options.ARMRateLimitBurst),
+		dataPlaneRateLimiter:                   newAdaptiveRateLimiter(dataPlaneRateLimiterName, options.DataPlaneRateLimitQPS, options.DataPlaneRateLimitBurst),
 		fsGroupChangePolicy:                    options.FSGroupChangePolicy,
 		azcopy:                                 &util.Azcopy{},
 		KubeClient:                             kubeClient,
 		cloud:                                  cloud,
+		namespacePolicyConfigMap:               options.NamespacePolicyConfigMap,
+		cloudCapabilitiesConfigMap:             options.CloudCapabilitiesConfigMap,
+		volumeIDFormatVersion:                  options.VolumeIDFormatVersion,
+		stateStoreNamespace:                    options.StateStoreNamespace,
+		enableLeaderElection:                   options.EnableLeaderElection,
+		leaderElectionNamespace:                options.LeaderElectionNamespace,
+		eventRecorder:                          newEventRecorder(kubeClient, options.DriverName, options.NodeID),
+		ipResolver:                             net.DefaultResolver,
 	}
 	d.Name = options.DriverName
 	d.Version = driverVersion
 	d.NodeID = options.NodeID
+	d.cloneCopyClientFactory = &azblobCloneCopyClientFactory{limiter: d.dataPlaneRateLimiter}
+	d.populateClientFactory = &azblobCloneCopyClientFactory{limiter: d.dataPlaneRateLimiter}
+	d.containerDataClientFactory = &azblobContainerDataClientFactory{limiter: d.dataPlaneRateLimiter}
+	if !isSupportedVolumeIDFormatVersion(d.volumeIDFormatVersion) {
+		if d.volumeIDFormatVersion != 0 {
+			klog.Warningf("volume id format version(%d) is not supported, use version %d instead", d.volumeIDFormatVersion, volumeIDFormatV1)
+		}
+		d.volumeIDFormatVersion = volumeIDFormatV1
+	}
+	if !isSupportedCrossRegionMountPolicy(d.crossRegionMountPolicy) {
+		if d.crossRegionMountPolicy != "" {
+			klog.Warningf("cross region mount policy(%s) is not supported, use %s instead", d.crossRegionMountPolicy, crossRegionMountWarn)
+		}
+		d.crossRegionMountPolicy = crossRegionMountWarn
+	}
+	if d.enableAsyncClone && d.useAzcopyForCloning {
+		klog.Warningf("async clone is not supported with azcopy, volume cloning runs synchronously")
+		d.enableAsyncClone = false
+	}
+	if d.enableAsyncClone && !options.EnableStateStore {
+		// running clone jobs are only resumed from state store after controller restart
+		klog.Warningf("async clone requires state store(--enable-state-store), volume cloning runs synchronously")
+		d.enableAsyncClone = false
+	}
 	if d.cloud != nil {
 		d.clientFactory = d.cloud.ComputeClientFactory
 		d.networkClientFactory = d.cloud.NetworkClientFactory
 		if d.networkClientFactory == nil {
 			d.networkClientFactory = d.cloud.ComputeClientFactory
 		}
+		d.managementPolicyClientFactory = &armManagementPolicyClientFactory{cloud: d.cloud}
+		d.userDelegationSASFactory = &azblobUserDelegationSASFactory{cloud: d.cloud, limiter: d.dataPlaneRateLimiter}
 	}
 
 	var err error
@@ -298,9 +505,15 @@ func NewDriver(options *DriverOptions, kubeClient kubernetes.Interface, cloud *p
 	if d.accountSearchCache, err = azcache.NewTimedCache(time.Minute, getter, false); err != nil {
 		klog.Fatalf("%v", err)
 	}
+	if d.accountLoadCache, err = azcache.NewTimedCache(time.Minute, getter, false); err != nil {
+		klog.Fatalf("%v", err)
+	}
 	if d.dataPlaneAPIVolCache, err = azcache.NewTimedCache(24*30*time.Hour, getter, false); err != nil {
 		klog.Fatalf("%v", err)
 	}
+	if d.dataPlaneAPIStateMissCache, err = azcache.NewTimedCache(10*time.Minute, getter, false); err != nil {
+		klog.Fatalf("%v", err)
+	}
 	if d.azcopySasTokenCache, err = azcache.NewTimedCache(15*time.Minute, getter, false); err != nil {
 		klog.Fatalf("%v", err)
 	}
@@ -314,6 +527,43 @@ func NewDriver(options *DriverOptions, kubeClient kubernetes.Interface, cloud *p
 	if d.subnetCache, err = azcache.NewTimedCache(10*time.Minute, getter, false); err != nil {
 		klog.Fatalf("%v", err)
 	}
+	if d.privateDNSZoneLinkCache, err = azcache.NewTimedCache(10*time.Minute, getter, false); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
+	if options.ExternalSecretSourceEndpoint != "" {
+		klog.V(2).Infof("use external secret source(%s), cache TTL: %d minutes", options.ExternalSecretSourceEndpoint, options.ExternalSecretSourceCacheTTLMinutes)
+		d.externalSecretSource = newCachedSecretSource(newHTTPSecretSource(options.ExternalSecretSourceEndpoint, externalSecretSourceTimeout),
+			time.Duration(options.ExternalSecretSourceCacheTTLMinutes)*time.Minute)
+	}
+
+	if d.namespacePolicyConfigMap != "" {
+		klog.V(2).Infof("namespace policy is enabled, configmap: %s", d.namespacePolicyConfigMap)
+		if d.namespacePolicyCache, err = azcache.NewTimedCache(namespacePolicyTTL, d.getNamespacePolicyFromConfigMap, false); err != nil {
+			klog.Fatalf("%v", err)
+		}
+	}
+
+	if d.cloudCapabilitiesConfigMap != "" {
+		klog.V(2).Infof("cloud capabilities are overridden by configmap: %s", d.cloudCapabilitiesConfigMap)
+		// fail fast on invalid configmap instead of falling back to built-in capabilities on every CreateVolume
+		if _, err := d.getCloudCapabilitiesFromConfigMap(d.cloudCapabilitiesConfigMap); err != nil {
+			klog.Fatalf("%v", err)
+		}
+		if d.cloudCapabilitiesCache, err = azcache.NewTimedCache(cloudCapabilitiesTTL, d.loadCloudCapabilities, false); err != nil {
+			klog.Fatalf("%v", err)
+		}
+	}
+
+	if options.EnableCredentialCache {
+		if options.CredentialCacheTTLMinutes <= 0 {
+			options.CredentialCacheTTLMinutes = 10 // default expire in 10 minutes
+		}
+		klog.V(2).Infof("credential cache is enabled, cache TTL: %d minutes", options.CredentialCacheTTLMinutes)
+		if err := d.setupCredentialCache(time.Duration(options.CredentialCacheTTLMinutes) * time.Minute); err != nil {
+			klog.Fatalf("%v", err)
+		}
+	}
 
 	d.mounter = &mount.SafeFormatAndMount{
 		Interface: mount.New(""),
@@ -373,6 +623,13 @@ func (d *Driver) Run(ctx context.Context, endpoint string) error {
 	csi.RegisterControllerServer(s, d)
 	csi.RegisterNodeServer(s, d)
 
+	d.startControllerInformers(ctx)
+	d.startCredentialCacheInformer(ctx)
+	if err := d.loadState(ctx); err != nil {
+		klog.Errorf("failed to load state from state store: %v", err)
+	}
+	d.runBackgroundControllers(ctx)
+
 	go func() {
 		//graceful shutdown
 		<-ctx.Done()
@@ -401,19 +658,14 @@ func (d *Driver) Run(ctx context.Context, endpoint string) error {
 // output: rg, f5713de20cde511e8ba4900, containerName, namespace, ""
 // input: "rg#f5713de20cde511e8ba4900#containerName#uuid#namespace#subsID"
 // output: rg, f5713de20cde511e8ba4900, containerName, namespace, subsID
+// input: "blob:v2#rg#f5713de20cde511e8ba4900#containerName#uuid#namespace#subsID#protocol=nfs"
+// output: rg, f5713de20cde511e8ba4900, containerName, namespace, subsID
 func GetContainerInfo(id string) (string, string, string, string, string, error) {
-	segments := strings.Split(id, separator)
-	if len(segments) < 3 {
-		return "", "", "", "", "", fmt.Errorf("error parsing volume id: %q, should at least contain two #", id)
-	}
-	var secretNamespace, subsID string
-	if len(segments) > 4 {
-		secretNamespace = segments[4]
-	}
-	if len(segments) > 5 {
-		subsID = segments[5]
+	info, err := parseVolumeID(id)
+	if err != nil {
+		return "", "", "", "", "", err
 	}
-	return segments[0], segments[1], segments[2], secretNamespace, subsID, nil
+	return info.resourceGroup, info.accountName, info.containerName, info.secretNamespace, info.subsID, nil
 }
 
 // A container name must be a valid DNS name, conforming to the following naming rules:
@@ -457,6 +709,12 @@ func isSASToken(key string) bool {
 
 // GetAuthEnv return <accountName, containerName, authEnv, error>
 func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attrib, secrets map[string]string) (string, string, string, string, []string, error) {
+	return d.getAuthEnv(ctx, volumeID, protocol, "", attrib, secrets)
+}
+
+// getAuthEnv is the same as GetAuthEnv, spn client certificate is only written next to stagingPath for blobfuse,
+// it's skipped if stagingPath is empty, e.g. in controller which does not mount the volume
+func (d *Driver) getAuthEnv(ctx context.Context, volumeID, protocol, stagingPath string, attrib, secrets map[string]string) (string, string, string, string, []string, error) {
 	rgName, accountName, containerName, secretNamespace, _, err := GetContainerInfo(volumeID)
 	if err != nil {
 		// ignore volumeID parsing error
@@ -470,10 +728,13 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 		accountSasToken         string
 		msiSecret               string
 		storageSPNClientSecret  string
+		storageSPNClientCert    string
+		storageSPNClientCertPwd string
 		storageSPNClientID      string
 		storageSPNTenantID      string
 		secretName              string
 		pvcNamespace            string
+		serviceAccountName      string
 		keyVaultURL             string
 		keyVaultSecretName      string
 		keyVaultSecretVersion   string
@@ -510,6 +771,8 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 			secretNamespace = v
 		case pvcNamespaceKey:
 			pvcNamespace = v
+		case strings.ToLower(serviceAccountNameField):
+			serviceAccountName = v
 		case getAccountKeyFromSecretField:
 			getAccountKeyFromSecret = strings.EqualFold(v, trueValue)
 		case storageAuthTypeField:
@@ -575,19 +838,59 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 		return rgName, accountName, accountKey, containerName, authEnv, err
 	}
 
+	var externalCred *externalCredential
+	if keyVaultURL == "" && len(secrets) == 0 {
+		// secretNamespace and pvc namespace of inline volume could be set by pod author, so only the trusted namespace of volume
+		// is sent to external secret source: pod namespace set by kubelet for inline volume, or pvc namespace in PV
+		externalCred, err = d.getExternalCredential(ctx, &externalSecretRequest{
+			VolumeID:       volumeID,
+			Namespace:      getVolumeNamespace(attrib),
+			ServiceAccount: serviceAccountName,
+			AccountName:    accountName,
+			ContainerName:  containerName,
+		})
+		if err != nil {
+			return rgName, accountName, accountKey, containerName, authEnv, err
+		}
+	}
+
 	// 1. If keyVaultURL is not nil, preferentially use the key stored in key vault.
-	// 2. Then if secrets map is not nil, use the key stored in the secrets map.
-	// 3. Finally if both keyVaultURL and secrets map are nil, get the key from Azure.
+	// 2. Then if external secret source returns credential, use the credential from external secret source.
+	// 3. Then if secrets map is not nil, use the key stored in the secrets map.
+	// 4. Finally if both keyVaultURL and secrets map are nil, get the key from Azure.
 	if keyVaultURL != "" {
-		key, err := d.getKeyVaultSecretContent(ctx, keyVaultURL, keyVaultSecretName, keyVaultSecretVersion)
+		secret, err := d.getKeyVaultSecretWithCache(ctx, keyVaultURL, keyVaultSecretName, keyVaultSecretVersion)
 		if err != nil {
 			return rgName, accountName, accountKey, containerName, authEnv, err
 		}
-		if isSASToken(key) {
+		key := secret.content
+		if strings.EqualFold(azureStorageAuthType, SPN) {
+			// spn client certificate stored in key vault
+			if !isSPNCertContentType(secret.contentType) {
+				return rgName, accountName, accountKey, containerName, authEnv, fmt.Errorf("content type(%s) of secret(%s) in key vault(%s) is not a certificate, supported content types: %v", secret.contentType, keyVaultSecretName, keyVaultURL, spnCertContentTypes)
+			}
+			storageSPNClientCert = key
+		} else if isSASToken(key) {
 			accountSasToken = key
 		} else {
 			accountKey = key
 		}
+	} else if externalCred != nil {
+		klog.V(2).Infof("use credential from external secret source to access storage account(%s), container(%s)", accountName, containerName)
+		if externalCred.AccountName != "" {
+			accountName = externalCred.AccountName
+		}
+		accountKey = externalCred.AccountKey
+		accountSasToken = externalCred.SASToken
+		storageSPNClientSecret = externalCred.SPNClientSecret
+		storageSPNClientCert = externalCred.SPNClientCert
+		storageSPNClientCertPwd = externalCred.SPNClientCertPassword
+		if externalCred.SPNClientID != "" {
+			storageSPNClientID = externalCred.SPNClientID
+		}
+		if externalCred.SPNTenantID != "" {
+			storageSPNTenantID = externalCred.SPNTenantID
+		}
 	} else {
 		if len(secrets) == 0 {
 			if secretName == "" && accountName != "" {
@@ -596,7 +899,7 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 			if secretName != "" {
 				// read from k8s secret first
 				var name, spnClientID, spnTenantID string
-				name, accountKey, accountSasToken, msiSecret, storageSPNClientSecret, spnClientID, spnTenantID, err = d.GetInfoFromSecret(ctx, secretName, secretNamespace)
+				name, accountKey, accountSasToken, msiSecret, storageSPNClientSecret, spnClientID, spnTenantID, storageSPNClientCert, storageSPNClientCertPwd, err = d.GetInfoFromSecret(ctx, secretName, secretNamespace)
 				if name != "" {
 					accountName = name
 				}
@@ -613,7 +916,7 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 				if err != nil && !getAccountKeyFromSecret && (azureStorageAuthType == "" || strings.EqualFold(azureStorageAuthType, "key")) {
 					klog.V(2).Infof("get account(%s) key from secret(%s, %s) failed with error: %v, use cluster identity to get account key instead",
 						accountName, secretNamespace, secretName, err)
-					accountKey, err = d.cloud.GetStorageAccesskey(ctx, subsID, accountName, rgName, getLatestAccountKey)
+					accountKey, err = d.getStorageAccesskey(ctx, subsID, accountName, rgName, getLatestAccountKey)
 					if err != nil {
 						return rgName, accountName, accountKey, containerName, authEnv, fmt.Errorf("no key for storage account(%s) under resource group(%s), err %w", accountName, rgName, err)
 					}
@@ -637,6 +940,10 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 					msiSecret = v
 				case storageSPNClientSecretField:
 					storageSPNClientSecret = v
+				case storageSPNClientCertField:
+					storageSPNClientCert = v
+				case storageSPNClientCertPwdField:
+					storageSPNClientCertPwd = v
 				case storageSPNClientIDField:
 					storageSPNClientID = v
 				case storageSPNTenantIDField:
@@ -669,6 +976,15 @@ func (d *Driver) GetAuthEnv(ctx context.Context, volumeID, protocol string, attr
 		authEnv = append(authEnv, "AZURE_STORAGE_SPN_CLIENT_SECRET="+storageSPNClientSecret)
 	}
 
+	if storageSPNClientCert != "" && stagingPath != "" && err == nil {
+		klog.V(2).Infof("storageSPNClientCert is not empty, use it to access storage account(%s), container(%s)", accountName, containerName)
+		var certAuthEnv []string
+		if certAuthEnv, err = d.setupSPNClientCertificate(ctx, volumeID, stagingPath, attrib, []byte(storageSPNClientCert), storageSPNClientCertPwd); err != nil {
+			return rgName, accountName, accountKey, containerName, authEnv, err
+		}
+		authEnv = append(authEnv, certAuthEnv...)
+	}
+
 	if storageSPNClientID != "" {
 		klog.V(2).Infof("storageSPNClientID(%s) is not empty, use it to access storage account(%s), container(%s)", storageSPNClientID, accountName, containerName)
 		authEnv = append(authEnv, "AZURE_STORAGE_SPN_CLIENT_ID="+storageSPNClientID)
@@ -726,10 +1042,11 @@ func (d *Driver) GetStorageAccountAndContainer(ctx context.Context, volumeID str
 	// 2. Then if secrets map is not nil, use the key stored in the secrets map.
 	// 3. Finally if both keyVaultURL and secrets map are nil, get the key from Azure.
 	if keyVaultURL != "" {
-		key, err := d.getKeyVaultSecretContent(ctx, keyVaultURL, keyVaultSecretName, keyVaultSecretVersion)
+		secret, err := d.getKeyVaultSecretWithCache(ctx, keyVaultURL, keyVaultSecretName, keyVaultSecretVersion)
 		if err != nil {
 			return "", "", "", "", err
 		}
+		key := secret.content
 		if isSASToken(key) {
 			accountSasToken = key
 		} else {
@@ -747,7 +1064,7 @@ func (d *Driver) GetStorageAccountAndContainer(ctx context.Context, volumeID str
 				rgName = d.cloud.ResourceGroup
 			}
 
-			accountKey, err = d.cloud.GetStorageAccesskey(ctx, subsID, accountName, rgName, getLatestAccountKey)
+			accountKey, err = d.getStorageAccesskey(ctx, subsID, accountName, rgName, getLatestAccountKey)
 			if err != nil {
 				return "", "", "", "", fmt.Errorf("no key for storage account(%s) under resource group(%s), err %w", accountName, rgName, err)
 			}
@@ -920,24 +1237,24 @@ func (d *Driver) GetStorageAccesskey(ctx context.Context, accountOptions *azure.
 	if secretName == "" {
 		secretName = fmt.Sprintf(secretNameTemplate, accountOptions.Name)
 	}
-	_, accountKey, _, _, _, _, _, err := d.GetInfoFromSecret(ctx, secretName, secretNamespace) //nolint
+	_, accountKey, _, _, _, _, _, _, _, err := d.GetInfoFromSecret(ctx, secretName, secretNamespace) //nolint
 	if err != nil {
 		klog.V(2).Infof("could not get account(%s) key from secret(%s) namespace(%s), error: %v, use cluster identity to get account key instead", accountOptions.Name, secretName, secretNamespace, err)
-		accountKey, err = d.cloud.GetStorageAccesskey(ctx, accountOptions.SubscriptionID, accountOptions.Name, accountOptions.ResourceGroup, accountOptions.GetLatestAccountKey)
+		accountKey, err = d.getStorageAccesskey(ctx, accountOptions.SubscriptionID, accountOptions.Name, accountOptions.ResourceGroup, accountOptions.GetLatestAccountKey)
 	}
 	return accountOptions.Name, accountKey, err
 }
 
 // GetInfoFromSecret get info from k8s secret
-// return <accountName, accountKey, accountSasToken, msiSecret, spnClientSecret, spnClientID, spnTenantID, error>
-func (d *Driver) GetInfoFromSecret(ctx context.Context, secretName, secretNamespace string) (string, string, string, string, string, string, string, error) {
+// return <accountName, accountKey, accountSasToken, msiSecret, spnClientSecret, spnClientID, spnTenantID, spnClientCert, spnClientCertPassword, error>
+func (d *Driver) GetInfoFromSecret(ctx context.Context, secretName, secretNamespace string) (string, string, string, string, string, string, string, string, string, error) {
 	if d.KubeClient == nil {
-		return "", "", "", "", "", "", "", fmt.Errorf("could not get account key from secret(%s): KubeClient is nil", secretName)
+		return "", "", "", "", "", "", "", "", "", fmt.Errorf("could not get account key from secret(%s): KubeClient is nil", secretName)
 	}
 
-	secret, err := d.KubeClient.CoreV1().Secrets(secretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	secret, err := d.getSecret(ctx, secretName, secretNamespace)
 	if err != nil {
-		return "", "", "", "", "", "", "", fmt.Errorf("could not get secret(%v): %w", secretName, err)
+		return "", "", "", "", "", "", "", "", "", fmt.Errorf("could not get secret(%v): %w", secretName, err)
 	}
 
 	accountName := strings.TrimSpace(string(secret.Data[defaultSecretAccountName][:]))
@@ -947,17 +1264,25 @@ func (d *Driver) GetInfoFromSecret(ctx context.Context, secretName, secretNamesp
 	spnClientSecret := strings.TrimSpace(string(secret.Data[storageSPNClientSecretField][:]))
 	spnClientID := strings.TrimSpace(string(secret.Data[storageSPNClientIDField][:]))
 	spnTenantID := strings.TrimSpace(string(secret.Data[storageSPNTenantIDField][:]))
+	// do not trim spn client certificate since it could be in binary PFX format
+	spnClientCert := string(secret.Data[storageSPNClientCertField][:])
+	spnClientCertPassword := strings.TrimSpace(string(secret.Data[storageSPNClientCertPwdField][:]))
 
 	klog.V(4).Infof("got storage account(%s) from secret(%s) namespace(%s)", accountName, secretName, secretNamespace)
-	return accountName, accountKey, accountSasToken, msiSecret, spnClientSecret, spnClientID, spnTenantID, nil
+	return accountName, accountKey, accountSasToken, msiSecret, spnClientSecret, spnClientID, spnTenantID, spnClientCert, spnClientCertPassword, nil
 }
 
-// getSubnetResourceID get default subnet resource ID from cloud provider config
-func (d *Driver) getSubnetResourceID(vnetResourceGroup, vnetName, subnetName string) string {
-	subsID := d.cloud.SubscriptionID
+// getNetworkResourceSubscriptionID returns the subscription of network resources from cloud provider config
+func (d *Driver) getNetworkResourceSubscriptionID() string {
 	if len(d.cloud.NetworkResourceSubscriptionID) > 0 {
-		subsID = d.cloud.NetworkResourceSubscriptionID
+		return d.cloud.NetworkResourceSubscriptionID
 	}
+	return d.cloud.SubscriptionID
+}
+
+// getSubnetResourceID get default subnet resource ID from cloud provider config
+func (d *Driver) getSubnetResourceID(vnetResourceGroup, vnetName, subnetName string) string {
+	subsID := d.getNetworkResourceSubscriptionID()
 
 	if len(vnetResourceGroup) == 0 {
 		vnetResourceGroup = d.cloud.ResourceGroup
@@ -977,6 +1302,9 @@ func (d *Driver) getSubnetResourceID(vnetResourceGroup, vnetName, subnetName str
 }
 
 func (d *Driver) useDataPlaneAPI(volumeID, accountName string) bool {
+	if info, err := parseVolumeID(volumeID); err == nil && info.useDataPlaneAPI {
+		return true
+	}
 	cache, err := d.dataPlaneAPIVolCache.Get(volumeID, azcache.CacheReadTypeDefault)
 	if err != nil {
 		klog.Errorf("get(%s) from dataPlaneAPIVolCache failed with error: %v", volumeID, err)
@@ -991,6 +1319,28 @@ func (d *Driver) useDataPlaneAPI(volumeID, accountName string) bool {
 	if cache != nil {
 		return true
 	}
+	if d.stateStore == nil {
+		return false
+	}
+	for _, key := range []string{volumeID, accountName} {
+		if key == "" {
+			continue
+		}
+		// avoid reading state store on every call of volumes not using data plane API
+		if cache, _ := d.dataPlaneAPIStateMissCache.Get(key, azcache.CacheReadTypeDefault); cache != nil {
+			continue
+		}
+		_, ok, err := d.lookupState(context.Background(), stateKindDataPlaneAPI, key)
+		if err != nil {
+			klog.Warningf("failed to get %s(%s) from state store: %v", stateKindDataPlaneAPI, key, err)
+			continue
+		}
+		if ok {
+			d.dataPlaneAPIVolCache.Set(key, "")
+			return true
+		}
+		d.dataPlaneAPIStateMissCache.Set(key, "")
+	}
 	return false
 }
 
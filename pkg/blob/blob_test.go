@@ -94,10 +94,13 @@ func TestNewDriver(t *testing.T) {
 	fakedriver.Name = DefaultDriverName
 	fakedriver.Version = driverVersion
 	fakedriver.accountSearchCache = driver.accountSearchCache
+	fakedriver.accountLoadCache = driver.accountLoadCache
 	fakedriver.dataPlaneAPIVolCache = driver.dataPlaneAPIVolCache
+	fakedriver.dataPlaneAPIStateMissCache = driver.dataPlaneAPIStateMissCache
 	fakedriver.azcopySasTokenCache = driver.azcopySasTokenCache
 	fakedriver.volStatsCache = driver.volStatsCache
 	fakedriver.subnetCache = driver.subnetCache
+	fakedriver.privateDNSZoneLinkCache = driver.privateDNSZoneLinkCache
 	fakedriver.cloud = driver.cloud
 	assert.Equal(t, driver, fakedriver)
 }
@@ -1126,7 +1129,7 @@ func TestGetInfoFromSecret(t *testing.T) {
 				d.KubeClient = nil
 				secretName := "foo"
 				secretNamespace := "bar"
-				_, _, _, _, _, _, _, err := d.GetInfoFromSecret(context.TODO(), secretName, secretNamespace)
+				_, _, _, _, _, _, _, _, _, err := d.GetInfoFromSecret(context.TODO(), secretName, secretNamespace)
 				expectedErr := fmt.Errorf("could not get account key from secret(%s): KubeClient is nil", secretName)
 				if assert.Error(t, err) {
 					assert.Equal(t, expectedErr, err)
@@ -1140,7 +1143,7 @@ func TestGetInfoFromSecret(t *testing.T) {
 				d.KubeClient = fakeClient
 				secretName := ""
 				secretNamespace := ""
-				_, _, _, _, _, _, _, err := d.GetInfoFromSecret(context.TODO(), secretName, secretNamespace)
+				_, _, _, _, _, _, _, _, _, err := d.GetInfoFromSecret(context.TODO(), secretName, secretNamespace)
 				// expectedErr := fmt.Errorf("could not get secret(%v): %w", secretName, err)
 				assert.Error(t, err) // could not check what type of error, needs fix
 				/*if assert.Error(t, err) {
@@ -1172,7 +1175,7 @@ func TestGetInfoFromSecret(t *testing.T) {
 				if secretCreateErr != nil {
 					t.Error("failed to create secret")
 				}
-				an, ak, accountSasToken, msiSecret, storageSPNClientSecret, storageSPNClientID, storageSPNTenantID, err := d.GetInfoFromSecret(context.TODO(), secretName, secretNamespace)
+				an, ak, accountSasToken, msiSecret, storageSPNClientSecret, storageSPNClientID, storageSPNTenantID, _, _, err := d.GetInfoFromSecret(context.TODO(), secretName, secretNamespace)
 				assert.Equal(t, accountName, an, "accountName should match")
 				assert.Equal(t, accountKey, ak, "accountKey should match")
 				assert.Equal(t, "", accountSasToken, "accountSasToken should be empty")
@@ -1216,7 +1219,7 @@ func TestGetInfoFromSecret(t *testing.T) {
 				if secretCreateErr != nil {
 					t.Error("failed to create secret")
 				}
-				an, ak, accountSasToken, msiSecret, storageSPNClientSecret, storageSPNClientID, storageSPNTenantID, err := d.GetInfoFromSecret(context.TODO(), secretName, secretNamespace)
+				an, ak, accountSasToken, msiSecret, storageSPNClientSecret, storageSPNClientID, storageSPNTenantID, _, _, err := d.GetInfoFromSecret(context.TODO(), secretName, secretNamespace)
 				assert.Equal(t, accountName, an, "accountName should match")
 				assert.Equal(t, "", ak, "accountKey should be empty")
 				assert.Equal(t, accountSasTokenValue, accountSasToken, "sasToken should match")
@@ -1343,6 +1346,16 @@ func TestUseDataPlaneAPI(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "dataplane option in v2 volumeID",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				output := d.useDataPlaneAPI("blob:v2#rg#account#container####dataplane=true", "")
+				if !output {
+					t.Errorf("Actual Output: %t, Expected Output: %t", output, true)
+				}
+			},
+		},
 		{
 			name: "invalid volumeID and account",
 			testFunc: func(t *testing.T) {
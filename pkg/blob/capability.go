@@ -0,0 +1,235 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	featureHNS                      = "hns"
+	featureNFS                      = "nfs"
+	featureBlobVersioning           = "blobVersioning"
+	featureSoftDelete               = "softDelete"
+	featurePrivateEndpoint          = "privateEndpoint"
+	featureLifecycleManagement      = "lifecycleManagement"
+	featureImmutability             = "immutability"
+	featureEncryptionScope          = "encryptionScope"
+	featureAccessTier               = "accessTier"
+	featureInfrastructureEncryption = "infrastructureEncryption"
+
+	// cloudCapabilitiesDataKey is the key of the capabilities in the cloud capabilities configmap
+	cloudCapabilitiesDataKey = "capabilities.yaml"
+	cloudCapabilitiesTTL     = time.Minute
+
+	// storage API version of Azure Stack Hub profile used by cloud provider
+	azureStackStorageAPIVersion = "2019-06-01"
+)
+
+// cloudCapabilities describes what the target cloud supports, empty value means no restriction
+type cloudCapabilities struct {
+	// StorageAPIVersion is the storage resource provider API version of the cloud, it's reported in errors
+	StorageAPIVersion string `json:"storageAPIVersion,omitempty"`
+	// SKUs lists the supported storage account types, all types are supported if empty
+	SKUs []string `json:"skus,omitempty"`
+	// AccountKind is the kind of storage accounts created by the driver, the kind is picked by sku if empty
+	AccountKind string `json:"accountKind,omitempty"`
+	// UnsupportedFeatures lists the features which are not supported on the cloud
+	UnsupportedFeatures []string `json:"unsupportedFeatures,omitempty"`
+
+	cloudName string
+}
+
+// featureCheck maps a feature to the storage class parameter which requests it
+type featureCheck struct {
+	feature   string
+	field     string
+	requested func(p *storageClassParameters) bool
+}
+
+var featureChecks = []featureCheck{
+	// nfs is checked before hns since NFS protocol enables hns implicitly
+	{featureNFS, protocolField, func(p *storageClassParameters) bool { return isNFSProtocol(p.protocol) }},
+	{featureHNS, isHnsEnabledField, func(p *storageClassParameters) bool { return ptr.Deref(p.isHnsEnabled, false) }},
+	{featureBlobVersioning, enableBlobVersioningField, func(p *storageClassParameters) bool { return ptr.Deref(p.enableBlobVersioning, false) }},
+	{featureSoftDelete, softDeleteBlobsField + "/" + softDeleteContainersField, func(p *storageClassParameters) bool {
+		return p.softDeleteBlobs > 0 || p.softDeleteContainers > 0
+	}},
+	{featurePrivateEndpoint, networkEndpointTypeField, func(p *storageClassParameters) bool { return ptr.Deref(p.createPrivateEndpoint, false) }},
+	{featureLifecycleManagement, tierToCoolAfterDaysField + "/" + tierToArchiveAfterDaysField + "/" + deleteAfterDaysField, func(p *storageClassParameters) bool {
+		return !p.lifecycleRule.isEmpty()
+	}},
+	{featureImmutability, immutabilityPeriodInDaysField + "/" + legalHoldTagsField, func(p *storageClassParameters) bool { return p.hasImmutability() }},
+	{featureEncryptionScope, encryptionScopeField, func(p *storageClassParameters) bool { return p.encryptionScope != "" }},
+	{featureAccessTier, accessTierField, func(p *storageClassParameters) bool { return p.accessTier != "" }},
+	{featureInfrastructureEncryption, requireInfraEncryptionField, func(p *storageClassParameters) bool { return ptr.Deref(p.requireInfraEncryption, false) }},
+}
+
+// getDefaultCloudCapabilities returns the built-in capabilities of the cloud, there is no restriction on Azure public and sovereign clouds
+func getDefaultCloudCapabilities(cloud *azure.Cloud) *cloudCapabilities {
+	if cloud == nil {
+		return &cloudCapabilities{}
+	}
+	if IsAzureStackCloud(cloud) {
+		return &cloudCapabilities{
+			cloudName:         cloud.Cloud,
+			StorageAPIVersion: azureStackStorageAPIVersion,
+			SKUs:              []string{string(armstorage.SKUNameStandardLRS), string(armstorage.SKUNamePremiumLRS)},
+			AccountKind:       string(armstorage.KindStorage),
+			UnsupportedFeatures: []string{featureHNS, featureNFS, featureBlobVersioning, featureSoftDelete, featurePrivateEndpoint,
+				featureLifecycleManagement, featureImmutability, featureEncryptionScope, featureAccessTier, featureInfrastructureEncryption},
+		}
+	}
+	return &cloudCapabilities{cloudName: cloud.Cloud}
+}
+
+func parseCloudCapabilities(data string) (*cloudCapabilities, error) {
+	c := &cloudCapabilities{}
+	if err := yaml.UnmarshalStrict([]byte(data), c); err != nil {
+		return nil, fmt.Errorf("failed to parse cloud capabilities: %w", err)
+	}
+	supported := map[string]bool{}
+	for _, check := range featureChecks {
+		supported[strings.ToLower(check.feature)] = true
+	}
+	for _, feature := range c.UnsupportedFeatures {
+		if !supported[strings.ToLower(feature)] {
+			return nil, fmt.Errorf("unknown feature(%s) in cloud capabilities", feature)
+		}
+	}
+	return c, nil
+}
+
+// merge overrides the built-in capabilities with the configured ones
+func (c *cloudCapabilities) merge(override *cloudCapabilities) *cloudCapabilities {
+	merged := *c
+	if override.StorageAPIVersion != "" {
+		merged.StorageAPIVersion = override.StorageAPIVersion
+	}
+	if override.SKUs != nil {
+		merged.SKUs = override.SKUs
+	}
+	if override.AccountKind != "" {
+		merged.AccountKind = override.AccountKind
+	}
+	if override.UnsupportedFeatures != nil {
+		merged.UnsupportedFeatures = override.UnsupportedFeatures
+	}
+	return &merged
+}
+
+func (c *cloudCapabilities) supportsSKU(sku string) bool {
+	if len(c.SKUs) == 0 {
+		return true
+	}
+	for _, v := range c.SKUs {
+		if strings.EqualFold(v, sku) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cloudCapabilities) supportsFeature(feature string) bool {
+	for _, v := range c.UnsupportedFeatures {
+		if strings.EqualFold(v, feature) {
+			return false
+		}
+	}
+	return true
+}
+
+// description returns the cloud name and storage API version used in errors
+func (c *cloudCapabilities) description() string {
+	name := c.cloudName
+	if name == "" {
+		name = "AzurePublicCloud"
+	}
+	if c.StorageAPIVersion != "" {
+		return fmt.Sprintf("%s, storage API version %s", name, c.StorageAPIVersion)
+	}
+	return name
+}
+
+// validate checks the storage class parameters against the capabilities, so that unsupported features fail
+// before any storage account is created instead of failing later with cloud errors
+func (c *cloudCapabilities) validate(p *storageClassParameters) error {
+	if p.storageAccountType != "" && !c.supportsSKU(p.storageAccountType) {
+		return status.Errorf(codes.InvalidArgument, "feature skuName(%s) is not supported on this cloud(%s), supported skuName list: %v", p.storageAccountType, c.description(), c.SKUs)
+	}
+	for _, check := range featureChecks {
+		if check.requested(p) && !c.supportsFeature(check.feature) {
+			return status.Errorf(codes.InvalidArgument, "feature %s requested by %s is not supported on this cloud(%s)", check.feature, check.field, c.description())
+		}
+	}
+	return nil
+}
+
+// getCloudCapabilities returns the built-in capabilities of the cloud overridden by the cloud capabilities configmap,
+// the built-in capabilities are used if the configmap could not be loaded
+func (d *Driver) getCloudCapabilities() *cloudCapabilities {
+	capabilities := getDefaultCloudCapabilities(d.cloud)
+	if d.cloudCapabilitiesConfigMap == "" {
+		return capabilities
+	}
+	override, err := d.cloudCapabilitiesCache.Get(d.cloudCapabilitiesConfigMap, azcache.CacheReadTypeDefault)
+	if err != nil {
+		klog.Warningf("use built-in cloud capabilities since cloud capabilities configmap could not be loaded: %v", err)
+		return capabilities
+	}
+	return capabilities.merge(override.(*cloudCapabilities))
+}
+
+// loadCloudCapabilities is the getter of cloud capabilities cache, empty capabilities are cached on load failure
+// so that the built-in capabilities are used until the TTL expires instead of reading the configmap on every call
+func (d *Driver) loadCloudCapabilities(key string) (interface{}, error) {
+	c, err := d.getCloudCapabilitiesFromConfigMap(key)
+	if err != nil {
+		klog.Warningf("use built-in cloud capabilities in %v since cloud capabilities configmap could not be loaded: %v", cloudCapabilitiesTTL, err)
+		return &cloudCapabilities{}, nil
+	}
+	return c, nil
+}
+
+// getCloudCapabilitiesFromConfigMap reads and parses cloud capabilities configmap in the format of namespace/name
+func (d *Driver) getCloudCapabilitiesFromConfigMap(key string) (*cloudCapabilities, error) {
+	namespace, name, found := strings.Cut(key, "/")
+	if !found || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid cloud capabilities configmap(%s), expected format: namespace/name", key)
+	}
+	if d.KubeClient == nil {
+		return nil, fmt.Errorf("could not get cloud capabilities configmap(%s): KubeClient is nil", key)
+	}
+	cm, err := d.KubeClient.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get cloud capabilities configmap(%s): %w", key, err)
+	}
+	return parseCloudCapabilities(cm.Data[cloudCapabilitiesDataKey])
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func newAzureStackCloud() *azure.Cloud {
+	cloud := &azure.Cloud{}
+	cloud.Cloud = "AZURESTACKCLOUD"
+	return cloud
+}
+
+func TestValidateCloudCapabilities(t *testing.T) {
+	tests := []struct {
+		desc          string
+		cloud         *azure.Cloud
+		parameters    map[string]string
+		expectedError string
+	}{
+		{
+			desc:       "all features are supported on public cloud",
+			cloud:      &azure.Cloud{},
+			parameters: map[string]string{skuNameField: "Standard_ZRS", protocolField: NFS, networkEndpointTypeField: privateEndpoint},
+		},
+		{
+			desc:       "supported sku on Azure Stack",
+			cloud:      newAzureStackCloud(),
+			parameters: map[string]string{skuNameField: "Premium_LRS"},
+		},
+		{
+			desc:          "unsupported sku on Azure Stack",
+			cloud:         newAzureStackCloud(),
+			parameters:    map[string]string{skuNameField: "Standard_GRS"},
+			expectedError: "feature skuName(Standard_GRS) is not supported on this cloud(AZURESTACKCLOUD, storage API version 2019-06-01), supported skuName list: [Standard_LRS Premium_LRS]",
+		},
+		{
+			desc:          "nfs on Azure Stack",
+			cloud:         newAzureStackCloud(),
+			parameters:    map[string]string{protocolField: NFS},
+			expectedError: "feature nfs requested by protocol is not supported on this cloud(AZURESTACKCLOUD, storage API version 2019-06-01)",
+		},
+		{
+			desc:          "hns on Azure Stack",
+			cloud:         newAzureStackCloud(),
+			parameters:    map[string]string{isHnsEnabledField: trueValue},
+			expectedError: "feature hns requested by ishnsenabled is not supported on this cloud",
+		},
+		{
+			desc:          "lifecycle management on Azure Stack",
+			cloud:         newAzureStackCloud(),
+			parameters:    map[string]string{deleteAfterDaysField: "30"},
+			expectedError: "feature lifecycleManagement",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			p, err := parseStorageClassParameters(test.parameters)
+			require.NoError(t, err)
+			err = getDefaultCloudCapabilities(test.cloud).validate(p)
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, codes.InvalidArgument, status.Code(err))
+				assert.ErrorContains(t, err, test.expectedError)
+			}
+		})
+	}
+}
+
+func TestParseCloudCapabilities(t *testing.T) {
+	c, err := parseCloudCapabilities("skus: [Standard_LRS]\naccountKind: StorageV2\nunsupportedFeatures: [NFS]\n")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Standard_LRS"}, c.SKUs)
+	assert.False(t, c.supportsFeature(featureNFS))
+
+	_, err = parseCloudCapabilities("unsupportedFeatures: [unknown]\n")
+	assert.ErrorContains(t, err, "unknown feature(unknown)")
+
+	_, err = parseCloudCapabilities("unknownField: true\n")
+	assert.Error(t, err)
+}
+
+func TestGetCloudCapabilities(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = newAzureStackCloud()
+	assert.Equal(t, "Storage", d.getCloudCapabilities().AccountKind)
+
+	d.KubeClient = fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "blob-csi-cloud-capabilities", Namespace: "kube-system"},
+		Data:       map[string]string{cloudCapabilitiesDataKey: "accountKind: StorageV2\nunsupportedFeatures: [nfs]\n"},
+	})
+	d.cloudCapabilitiesConfigMap = "kube-system/blob-csi-cloud-capabilities"
+	var err error
+	d.cloudCapabilitiesCache, err = azcache.NewTimedCache(cloudCapabilitiesTTL, d.loadCloudCapabilities, false)
+	require.NoError(t, err)
+
+	// configured fields override built-in capabilities
+	c := d.getCloudCapabilities()
+	assert.Equal(t, "StorageV2", c.AccountKind)
+	assert.Equal(t, []string{"Standard_LRS", "Premium_LRS"}, c.SKUs)
+	assert.True(t, c.supportsFeature(featureHNS))
+	assert.False(t, c.supportsFeature(featureNFS))
+
+	// built-in capabilities are used if configmap could not be loaded, and the failure is cached
+	d.cloudCapabilitiesConfigMap = "kube-system/not-found"
+	assert.Equal(t, "Storage", d.getCloudCapabilities().AccountKind)
+	actions := len(d.KubeClient.(*fake.Clientset).Actions())
+	assert.Equal(t, "Storage", d.getCloudCapabilities().AccountKind)
+	assert.Equal(t, actions, len(d.KubeClient.(*fake.Clientset).Actions()))
+}
+
+func TestGetCloudCapabilitiesFromConfigMap(t *testing.T) {
+	d := NewFakeDriver()
+	d.KubeClient = fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: "kube-system"},
+		Data:       map[string]string{cloudCapabilitiesDataKey: "unsupportedFeatures: [unknown]\n"},
+	})
+
+	_, err := d.getCloudCapabilitiesFromConfigMap("invalid")
+	assert.ErrorContains(t, err, "expected format: namespace/name")
+
+	_, err = d.getCloudCapabilitiesFromConfigMap("kube-system/invalid")
+	assert.ErrorContains(t, err, "unknown feature(unknown)")
+
+	_, err = d.getCloudCapabilitiesFromConfigMap("kube-system/not-found")
+	assert.ErrorContains(t, err, "could not get cloud capabilities configmap(kube-system/not-found)")
+}
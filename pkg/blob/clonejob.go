@@ -0,0 +1,366 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// dstAccount/dstContainer -> async clone job
+	stateKindCloneJob = "clone-job"
+
+	cloneStateAnnotation    = "blob.csi.azure.com/clone-state"
+	cloneProgressAnnotation = "blob.csi.azure.com/clone-progress"
+
+	cloneStartedReason   = "CloneStarted"
+	cloneProgressReason  = "CloneProgress"
+	cloneSucceededReason = "CloneSucceeded"
+	cloneRetryReason     = "CloneRetry"
+	cloneFailedReason    = "CloneFailed"
+
+	cloneResultSucceeded = "succeeded"
+	cloneResultFailed    = "failed"
+	cloneResultCancelled = "cancelled"
+
+	maxCloneJobAttempts = 5
+)
+
+var (
+	// interval of publishing clone progress on PVC
+	cloneProgressInterval = 30 * time.Second
+	// initial interval of retrying a failed clone job, doubled on every retry
+	cloneJobRetryInterval = 30 * time.Second
+)
+
+var (
+	cloneJobsInProgress = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      "blob_csi_driver",
+			Name:           "clone_jobs_in_progress",
+			Help:           "Number of async clone jobs running in background",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	cloneJobsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      "blob_csi_driver",
+			Name:           "clone_jobs_total",
+			Help:           "Number of finished async clone jobs, partitioned by result (succeeded, failed or cancelled)",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"result"},
+	)
+	cloneCopiedBytesTotal = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      "blob_csi_driver",
+			Name:           "clone_copied_bytes_total",
+			Help:           "Number of bytes copied by async clone jobs",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	registerCloneMetricsOnce sync.Once
+)
+
+func registerCloneMetrics() {
+	registerCloneMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(cloneJobsInProgress, cloneJobsTotal, cloneCopiedBytesTotal)
+	})
+}
+
+// cloneJob is an async clone job persisted in state store, credentials are resolved again when the job is resumed
+type cloneJob struct {
+	SrcAccountName        string `json:"srcAccountName"`
+	SrcContainerName      string `json:"srcContainerName"`
+	SrcResourceGroup      string `json:"srcResourceGroup,omitempty"`
+	SrcSubscriptionID     string `json:"srcSubscriptionID,omitempty"`
+	DstAccountName        string `json:"dstAccountName"`
+	DstContainerName      string `json:"dstContainerName"`
+	DstResourceGroup      string `json:"dstResourceGroup,omitempty"`
+	DstSubscriptionID     string `json:"dstSubscriptionID,omitempty"`
+	StorageEndpointSuffix string `json:"storageEndpointSuffix"`
+	SecretNamespace       string `json:"secretNamespace,omitempty"`
+	PVCName               string `json:"pvcName,omitempty"`
+	PVCNamespace          string `json:"pvcNamespace,omitempty"`
+}
+
+func (j *cloneJob) key() string {
+	return j.DstAccountName + "/" + j.DstContainerName
+}
+
+func (j *cloneJob) source() string {
+	return j.SrcAccountName + "/" + j.SrcContainerName
+}
+
+// startCloneJob registers the clone job in state store and starts the copy in background
+func (d *Driver) startCloneJob(ctx context.Context, job *cloneJob, srcSasToken, dstSasToken string) error {
+	client, err := d.newNativeCopyClient(ctx, job.SrcAccountName, job.SrcContainerName, srcSasToken, job.DstAccountName, job.DstContainerName, dstSasToken, job.StorageEndpointSuffix)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	d.setState(ctx, stateKindCloneJob, job.key(), string(value))
+	d.runCloneJob(job, client)
+	return nil
+}
+
+// runCloneJob copies the container in background with retries and publishes the progress, it's a no-op if the job is already running
+func (d *Driver) runCloneJob(job *cloneJob, client cloneCopyClient) {
+	ctx, cancel := context.WithCancel(context.Background())
+	nativeJob := newNativeCopyJob()
+	nativeJob.cancel = cancel
+	if _, loaded := d.nativeCopyJobs.LoadOrStore(job.key(), nativeJob); loaded {
+		cancel()
+		klog.V(2).Infof("clone job of %s is already running", job.key())
+		return
+	}
+	registerCloneMetrics()
+	cloneJobsInProgress.Inc()
+	d.publishCloneProgress(ctx, job, v1.EventTypeNormal, cloneStartedReason, cloneStateInProgress, nativeJob.progress(), "")
+
+	go func() {
+		defer cancel()
+		stop := make(chan struct{})
+		reported := make(chan struct{})
+		go func() {
+			defer close(reported)
+			d.reportCloneProgress(ctx, job, nativeJob, stop)
+		}()
+
+		var err error
+		retryInterval := cloneJobRetryInterval
+		for attempt := 1; attempt <= maxCloneJobAttempts; attempt++ {
+			if err = copyContainer(ctx, client, job.source(), d.cloneCopyParallelism, nativeJob); err == nil || ctx.Err() != nil {
+				break
+			}
+			if attempt == maxCloneJobAttempts {
+				break
+			}
+			d.publishCloneProgress(ctx, job, v1.EventTypeWarning, cloneRetryReason, cloneStateInProgress, nativeJob.progress(), fmt.Sprintf("attempt %d failed, retry in %v: %v", attempt, retryInterval, err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryInterval):
+			}
+			retryInterval *= 2
+		}
+		close(stop)
+		<-reported
+
+		bgCtx := context.Background()
+		progress := nativeJob.progress()
+		switch {
+		case ctx.Err() != nil:
+			// volume is deleted
+			cloneJobsTotal.WithLabelValues(cloneResultCancelled).Inc()
+			klog.V(2).Infof("clone job of %s is cancelled, %s", job.key(), progress)
+		case err != nil:
+			cloneJobsTotal.WithLabelValues(cloneResultFailed).Inc()
+			if markErr := markCloneFailed(bgCtx, client, job.source()); markErr != nil {
+				klog.Warningf("failed to mark clone of %s as failed: %v", job.key(), markErr)
+			}
+			d.publishCloneProgress(bgCtx, job, v1.EventTypeWarning, cloneFailedReason, cloneStateFailed, progress, err.Error())
+		default:
+			cloneJobsTotal.WithLabelValues(cloneResultSucceeded).Inc()
+			progress.completed = true
+			d.publishCloneProgress(bgCtx, job, v1.EventTypeNormal, cloneSucceededReason, cloneStateCompleted, progress, "")
+		}
+		d.deleteState(bgCtx, stateKindCloneJob, job.key())
+		cloneJobsInProgress.Dec()
+		nativeJob.err = err
+		d.nativeCopyJobs.Delete(job.key())
+		close(nativeJob.done)
+	}()
+}
+
+// reportCloneProgress publishes progress of the running job periodically until stop is closed
+func (d *Driver) reportCloneProgress(ctx context.Context, job *cloneJob, nativeJob *nativeCopyJob, stop <-chan struct{}) {
+	ticker := time.NewTicker(cloneProgressInterval)
+	defer ticker.Stop()
+	var lastCopiedBytes int64
+	observe := func() cloneProgress {
+		progress := nativeJob.progress()
+		// copied bytes go back to the checkpoint if the job is retried
+		if delta := progress.copiedBytes - lastCopiedBytes; delta > 0 {
+			cloneCopiedBytesTotal.Add(float64(delta))
+		}
+		lastCopiedBytes = progress.copiedBytes
+		return progress
+	}
+	for {
+		select {
+		case <-stop:
+			observe()
+			return
+		case <-ticker.C:
+			d.publishCloneProgress(ctx, job, v1.EventTypeNormal, cloneProgressReason, cloneStateInProgress, observe(), "")
+		}
+	}
+}
+
+// publishCloneProgress records an event and updates clone annotations on the PVC, the event is only logged if there is no PVC
+func (d *Driver) publishCloneProgress(ctx context.Context, job *cloneJob, eventType, reason, state string, progress cloneProgress, detail string) {
+	message := fmt.Sprintf("clone from %s to %s, %s", job.source(), job.key(), progress)
+	if detail != "" {
+		message += ", " + detail
+	}
+	d.recordVolumeEvent(ctx, map[string]string{pvcNameKey: job.PVCName, pvcNamespaceKey: job.PVCNamespace}, eventType, reason, "%s", message)
+
+	d.patchPVCAnnotations(ctx, job.PVCNamespace, job.PVCName, map[string]string{
+		cloneStateAnnotation:    state,
+		cloneProgressAnnotation: progress.String(),
+	})
+}
+
+// patchPVCAnnotations merges annotations into the PVC, errors are only logged
+func (d *Driver) patchPVCAnnotations(ctx context.Context, namespace, name string, annotations map[string]string) {
+	if d.KubeClient == nil || name == "" || namespace == "" {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		klog.Warningf("failed to marshal annotations: %v", err)
+		return
+	}
+	if _, err := d.KubeClient.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		klog.Warningf("failed to update annotations of pvc(%s/%s): %v", namespace, name, err)
+	}
+}
+
+// getNativeCopyProgress returns progress of the running native copy job of the container
+func (d *Driver) getNativeCopyProgress(containerName string) (cloneProgress, bool) {
+	var progress cloneProgress
+	var found bool
+	d.nativeCopyJobs.Range(func(key, value interface{}) bool {
+		if strings.HasSuffix(key.(string), "/"+containerName) {
+			progress, found = value.(*nativeCopyJob).progress(), true
+			return false
+		}
+		return true
+	})
+	return progress, found
+}
+
+// cancelCloneJob stops the running async clone job of the container and removes it from state store
+func (d *Driver) cancelCloneJob(ctx context.Context, accountName, containerName string) {
+	key := accountName + "/" + containerName
+	if value, ok := d.nativeCopyJobs.Load(key); ok {
+		if job := value.(*nativeCopyJob); job.cancel != nil {
+			klog.V(2).Infof("cancel clone job of %s", key)
+			job.cancel()
+		}
+	}
+	if d.enableAsyncClone {
+		d.deleteState(ctx, stateKindCloneJob, key)
+	}
+}
+
+// resumeCloneJobs resumes async clone jobs in state store after restart, the copy continues from the checkpoint
+func (d *Driver) resumeCloneJobs(ctx context.Context) error {
+	if !d.enableAsyncClone || d.stateStore == nil {
+		return nil
+	}
+	entries, err := d.stateStore.List(ctx, stateKindCloneJob)
+	if err != nil {
+		return fmt.Errorf("failed to list %s from state store: %w", stateKindCloneJob, err)
+	}
+	for _, entry := range entries {
+		job := &cloneJob{}
+		if err := json.Unmarshal([]byte(entry.Value), job); err != nil {
+			klog.Warningf("failed to parse clone job(%s) in state store: %v", entry.Key, err)
+			continue
+		}
+		srcSasToken, dstSasToken, err := d.getCloneJobCredentials(ctx, job)
+		if err == nil {
+			err = d.startCloneJob(ctx, job, srcSasToken, dstSasToken)
+		}
+		if err != nil {
+			d.publishCloneProgress(ctx, job, v1.EventTypeWarning, cloneFailedReason, cloneStateFailed, cloneProgress{}, fmt.Sprintf("failed to resume clone job: %v", err))
+			continue
+		}
+		klog.V(2).Infof("resumed clone job of %s", job.key())
+	}
+	return nil
+}
+
+// getCloneJobCredentials resolves SAS tokens of source and destination of the job, empty SAS token means cluster identity is used
+func (d *Driver) getCloneJobCredentials(ctx context.Context, job *cloneJob) (string, string, error) {
+	dstAccountOptions := &azure.AccountOptions{Name: job.DstAccountName, ResourceGroup: job.DstResourceGroup, SubscriptionID: job.DstSubscriptionID}
+	dstSasToken, _, err := d.getAzcopyAuth(ctx, job.DstAccountName, "", job.StorageEndpointSuffix, dstAccountOptions, nil, "", job.SecretNamespace, false)
+	if err != nil {
+		return "", "", err
+	}
+	srcSasToken := dstSasToken
+	if job.SrcAccountName != job.DstAccountName {
+		srcAccountOptions := &azure.AccountOptions{Name: job.SrcAccountName, ResourceGroup: job.SrcResourceGroup, SubscriptionID: job.SrcSubscriptionID}
+		if srcSasToken, err = d.getCloneSourceSASToken(ctx, job.SrcAccountName, job.SrcContainerName, job.StorageEndpointSuffix, srcAccountOptions, job.SecretNamespace); err != nil {
+			return "", "", err
+		}
+	}
+	return srcSasToken, dstSasToken, nil
+}
+
+// checkCloneCompleted checks the clone checkpoint in container metadata before mount, metadata is read with account key,
+// or with management API using driver identity if account key is not available, e.g. volume is mounted with managed identity,
+// returns Unavailable if the copy is in progress so that NodeStageVolume is retried
+func (d *Driver) checkCloneCompleted(ctx context.Context, subsID, resourceGroup, accountName, accountKey, storageEndpointSuffix, containerName, source string) error {
+	var metadata map[string]string
+	if accountKey != "" {
+		client, err := d.containerDataClientFactory.NewContainerDataClient(accountName, accountKey, storageEndpointSuffix, containerName)
+		if err != nil {
+			return status.Errorf(codes.Internal, "%v", err)
+		}
+		if metadata, err = client.GetMetadata(ctx); err != nil {
+			return status.Errorf(codes.Internal, "failed to get metadata of container(%s) account(%s): %v", containerName, accountName, err)
+		}
+	} else {
+		var err error
+		if metadata, err = d.getContainerMetadata(ctx, subsID, resourceGroup, accountName, containerName); err != nil {
+			return status.Errorf(codes.Internal, "failed to get metadata of container(%s) account(%s) rg(%s) with driver identity to check clone from %s, set %s as false to skip the check: %v", containerName, accountName, resourceGroup, source, waitForCloneCompletionField, err)
+		}
+	}
+	progress := parseCloneProgress(metadata, source)
+	switch {
+	case progress.completed:
+		return nil
+	case metadata[cloneSourceMetadata] == source && metadata[cloneStateMetadata] == cloneStateFailed:
+		return status.Errorf(codes.FailedPrecondition, "clone from %s to container(%s) account(%s) failed, check events of the PVC", source, containerName, accountName)
+	default:
+		return status.Errorf(codes.Unavailable, "clone from %s to container(%s) account(%s) is in progress, %s", source, containerName, accountName, progress)
+	}
+}
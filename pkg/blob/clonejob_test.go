@@ -0,0 +1,262 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/blobcontainerclient/mock_blobcontainerclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+)
+
+func newFakeDriverWithCloneJob(t *testing.T) (*Driver, *record.FakeRecorder) {
+	interval, retryInterval := cloneProgressInterval, cloneJobRetryInterval
+	cloneProgressInterval, cloneJobRetryInterval = time.Millisecond, time.Millisecond
+	t.Cleanup(func() {
+		cloneProgressInterval, cloneJobRetryInterval = interval, retryInterval
+	})
+
+	d := NewFakeDriver()
+	d.enableAsyncClone = true
+	d.stateStore = newMemoryStateStore()
+	d.KubeClient = fake.NewSimpleClientset(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns"},
+	})
+	recorder := record.NewFakeRecorder(1000)
+	d.eventRecorder = recorder
+	return d, recorder
+}
+
+func newTestCloneJob() *cloneJob {
+	return &cloneJob{
+		SrcAccountName:        "account",
+		SrcContainerName:      "src",
+		DstAccountName:        "account",
+		DstContainerName:      "dst",
+		StorageEndpointSuffix: "core.windows.net",
+		PVCName:               "pvc",
+		PVCNamespace:          "ns",
+	}
+}
+
+// waitForCloneJob waits for the running clone job of key to finish
+func waitForCloneJob(t *testing.T, d *Driver, key string) {
+	value, ok := d.nativeCopyJobs.Load(key)
+	require.True(t, ok)
+	select {
+	case <-value.(*nativeCopyJob).done:
+	case <-time.After(time.Minute):
+		t.Fatalf("timeout waiting for clone job of %s", key)
+	}
+}
+
+func getCloneEventReasons(recorder *record.FakeRecorder) []string {
+	var reasons []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			reasons = append(reasons, strings.Fields(event)[1])
+		default:
+			return reasons
+		}
+	}
+}
+
+func TestStartCloneJob(t *testing.T) {
+	tests := []struct {
+		desc            string
+		failBlob        string
+		expectedReasons []string
+	}{
+		{
+			desc:            "clone succeeded",
+			expectedReasons: []string{cloneStartedReason, cloneSucceededReason},
+		},
+		{
+			desc:            "clone succeeded after retry",
+			failBlob:        "blob2",
+			expectedReasons: []string{cloneStartedReason, cloneRetryReason, cloneSucceededReason},
+		},
+	}
+
+	for _, test := range tests {
+		d, recorder := newFakeDriverWithCloneJob(t)
+		client := newFakeCloneCopyClient(3)
+		client.failBlob = test.failBlob
+		client.block = make(chan struct{})
+		d.cloneCopyClientFactory = client
+		job := newTestCloneJob()
+
+		require.NoError(t, d.startCloneJob(context.Background(), job, "?sig=src", "?sig=dst"), test.desc)
+		_, ok := d.getState(context.Background(), stateKindCloneJob, job.key())
+		assert.True(t, ok, test.desc)
+		progress, ok := d.getNativeCopyProgress("dst")
+		assert.True(t, ok, test.desc)
+		assert.False(t, progress.completed, test.desc)
+
+		// job is not started again
+		require.NoError(t, d.startCloneJob(context.Background(), job, "?sig=src", "?sig=dst"), test.desc)
+		close(client.block)
+		waitForCloneJob(t, d, job.key())
+
+		assert.ElementsMatch(t, []string{"blob0", "blob1", "blob2"}, client.copied, test.desc)
+		assert.Equal(t, cloneStateCompleted, client.metadata[cloneStateMetadata], test.desc)
+		_, ok = d.getState(context.Background(), stateKindCloneJob, job.key())
+		assert.False(t, ok, test.desc)
+
+		pvc, err := d.KubeClient.CoreV1().PersistentVolumeClaims("ns").Get(context.Background(), "pvc", metav1.GetOptions{})
+		require.NoError(t, err, test.desc)
+		assert.Equal(t, cloneStateCompleted, pvc.Annotations[cloneStateAnnotation], test.desc)
+		assert.Equal(t, "state: completed, copied blobs: 3, copied bytes: 30", pvc.Annotations[cloneProgressAnnotation], test.desc)
+
+		var reasons []string
+		for _, reason := range getCloneEventReasons(recorder) {
+			if reason != cloneProgressReason {
+				reasons = append(reasons, reason)
+			}
+		}
+		assert.Equal(t, test.expectedReasons, reasons, test.desc)
+	}
+}
+
+func TestCancelCloneJob(t *testing.T) {
+	d, recorder := newFakeDriverWithCloneJob(t)
+	client := newFakeCloneCopyClient(1)
+	client.block = make(chan struct{})
+	d.cloneCopyClientFactory = client
+	job := newTestCloneJob()
+
+	require.NoError(t, d.startCloneJob(context.Background(), job, "?sig=src", "?sig=dst"))
+	value, ok := d.nativeCopyJobs.Load(job.key())
+	require.True(t, ok)
+	d.cancelCloneJob(context.Background(), "account", "dst")
+	close(client.block)
+	<-value.(*nativeCopyJob).done
+
+	_, ok = d.getState(context.Background(), stateKindCloneJob, job.key())
+	assert.False(t, ok)
+	assert.NotContains(t, getCloneEventReasons(recorder), cloneSucceededReason)
+	pvc, err := d.KubeClient.CoreV1().PersistentVolumeClaims("ns").Get(context.Background(), "pvc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, cloneStateInProgress, pvc.Annotations[cloneStateAnnotation])
+
+	// no-op if there is no running job
+	d.cancelCloneJob(context.Background(), "account", "notfound")
+}
+
+func TestResumeCloneJobs(t *testing.T) {
+	d, _ := newFakeDriverWithCloneJob(t)
+	client := newFakeCloneCopyClient(3)
+	client.block = make(chan struct{})
+	d.cloneCopyClientFactory = client
+	// checkpoint saved before restart
+	cloneProgress{marker: "2", copiedBlobs: 2, copiedBytes: 20}.setCheckpoint(client.metadata, "account/src")
+	d.azcopySasTokenCache.Set("account", "?sig=cache")
+	d.setState(context.Background(), stateKindCloneJob, "account/dst", `{"srcAccountName":"account","srcContainerName":"src","dstAccountName":"account","dstContainerName":"dst","storageEndpointSuffix":"core.windows.net"}`)
+	d.setState(context.Background(), stateKindCloneJob, "account/invalid", "invalid")
+
+	require.NoError(t, d.resumeCloneJobs(context.Background()))
+	close(client.block)
+	waitForCloneJob(t, d, "account/dst")
+	assert.Equal(t, []string{"blob2"}, client.copied)
+	assert.Equal(t, "https://account.blob.core.windows.net/src?sig=cache", client.srcURL)
+	assert.Equal(t, "https://account.blob.core.windows.net/dst?sig=cache", client.dstURL)
+	_, ok := d.getState(context.Background(), stateKindCloneJob, "account/dst")
+	assert.False(t, ok)
+
+	// no-op if async clone is disabled
+	d.enableAsyncClone = false
+	assert.NoError(t, d.resumeCloneJobs(context.Background()))
+}
+
+func TestCheckCloneCompleted(t *testing.T) {
+	tests := []struct {
+		desc         string
+		metadata     map[string]string
+		expectedCode codes.Code
+	}{
+		{
+			desc:         "clone completed",
+			metadata:     map[string]string{cloneSourceMetadata: "account/src", cloneStateMetadata: cloneStateCompleted},
+			expectedCode: codes.OK,
+		},
+		{
+			desc:         "clone in progress",
+			metadata:     map[string]string{cloneSourceMetadata: "account/src", cloneStateMetadata: cloneStateInProgress, cloneCopiedBlobsMetadata: "2"},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			desc:         "clone not started",
+			metadata:     map[string]string{},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			desc:         "clone of another source completed",
+			metadata:     map[string]string{cloneSourceMetadata: "account/other", cloneStateMetadata: cloneStateCompleted},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			desc:         "clone failed",
+			metadata:     map[string]string{cloneSourceMetadata: "account/src", cloneStateMetadata: cloneStateFailed},
+			expectedCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.containerDataClientFactory = &fakeContainerDataClient{metadata: test.metadata}
+		err := d.checkCloneCompleted(context.Background(), "sub", "rg", "account", "key", "core.windows.net", "dst", "account/src")
+		assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+	}
+}
+
+func TestCheckCloneCompletedWithIdentity(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	blobClient := mock_blobcontainerclient.NewMockInterface(ctrl)
+	clientFactory := mock_azclient.NewMockClientFactory(ctrl)
+	clientFactory.EXPECT().GetBlobContainerClientForSub("sub").Return(blobClient, nil).AnyTimes()
+	blobClient.EXPECT().Get(gomock.Any(), "rg", "account", "dst").Return(&armstorage.BlobContainer{
+		ContainerProperties: &armstorage.ContainerProperties{
+			Metadata: map[string]*string{"CloneSource": ptr.To("account/src"), "CloneState": ptr.To(cloneStateInProgress)},
+		},
+	}, nil)
+	blobClient.EXPECT().Get(gomock.Any(), "rg", "account", "dst").Return(nil, fmt.Errorf("AuthorizationFailed"))
+
+	d := NewFakeDriver()
+	d.clientFactory = clientFactory
+	// account key is not available, metadata is read with driver identity
+	err := d.checkCloneCompleted(context.Background(), "sub", "rg", "account", "", "core.windows.net", "dst", "account/src")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	err = d.checkCloneCompleted(context.Background(), "sub", "rg", "account", "", "core.windows.net", "dst", "account/src")
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
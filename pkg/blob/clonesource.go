@@ -0,0 +1,122 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// user delegation SAS start time is set in the past to tolerate clock skew
+	userDelegationSASClockSkew = 5 * time.Minute
+	// user delegation key could be valid for at most 7 days
+	maxUserDelegationKeyExpiry = 7 * 24 * time.Hour
+
+	cloneSourceRole      = "Storage Blob Data Reader"
+	cloneDestinationRole = "Storage Blob Data Contributor"
+)
+
+// errIdentityNotAvailable is returned when cluster identity could not be used to access storage data plane
+var errIdentityNotAvailable = errors.New("cluster identity is not available")
+
+// userDelegationSASFactory generates SAS token signed by user delegation key of cluster identity,
+// so that shared key access is not required on the storage account
+type userDelegationSASFactory interface {
+	// GetContainerReadSAS returns a read and list SAS token of the container, starting with "?"
+	GetContainerReadSAS(ctx context.Context, accountName, storageEndpointSuffix, containerName string, expiry time.Duration) (string, error)
+}
+
+type azblobUserDelegationSASFactory struct {
+	cloud   *azure.Cloud
+	limiter *adaptiveRateLimiter
+}
+
+func (f *azblobUserDelegationSASFactory) GetContainerReadSAS(ctx context.Context, accountName, storageEndpointSuffix, containerName string, expiry time.Duration) (string, error) {
+	if f.cloud == nil || f.cloud.AuthProvider == nil {
+		return "", errIdentityNotAvailable
+	}
+	credential := f.cloud.AuthProvider.GetAzIdentity()
+	if credential == nil {
+		return "", errIdentityNotAvailable
+	}
+	serviceClient, err := service.NewClient(fmt.Sprintf("https://%s.blob.%s/", accountName, storageEndpointSuffix), credential, &service.ClientOptions{ClientOptions: f.limiter.clientOptions()})
+	if err != nil {
+		return "", err
+	}
+	if expiry > maxUserDelegationKeyExpiry {
+		expiry = maxUserDelegationKeyExpiry
+	}
+	now := time.Now().UTC()
+	start, end := now.Add(-userDelegationSASClockSkew), now.Add(expiry)
+	udc, err := serviceClient.GetUserDelegationCredential(ctx, service.KeyInfo{
+		Start:  to.Ptr(start.Format(sas.TimeFormat)),
+		Expiry: to.Ptr(end.Format(sas.TimeFormat)),
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user delegation key of account(%s): %w", accountName, err)
+	}
+	params, err := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		StartTime:     start,
+		ExpiryTime:    end,
+		Permissions:   to.Ptr(sas.ContainerPermissions{Read: true, List: true}).String(),
+		ContainerName: containerName,
+	}.SignWithUserDelegation(udc)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign user delegation sas of container(%s) account(%s): %w", containerName, accountName, err)
+	}
+	return "?" + params.Encode(), nil
+}
+
+// getCloneSourceSASToken returns a SAS token of the source container when the source account differs from the destination,
+// the source account could be in another subscription. User delegation SAS signed by cluster identity is preferred,
+// account key SAS is only used when cluster identity is not available or is not authorized on the source account.
+func (d *Driver) getCloneSourceSASToken(ctx context.Context, srcAccountName, srcContainerName, storageEndpointSuffix string, srcAccountOptions *azure.AccountOptions, secretNamespace string) (string, error) {
+	var delegationErr error
+	if d.userDelegationSASFactory != nil {
+		sasToken, err := d.userDelegationSASFactory.GetContainerReadSAS(ctx, srcAccountName, storageEndpointSuffix, srcContainerName, time.Duration(d.sasTokenExpirationMinutes)*time.Minute)
+		if err == nil {
+			klog.V(2).Infof("use user delegation sas token of source container(%s) account(%s)", srcContainerName, srcAccountName)
+			return sasToken, nil
+		}
+		if !errors.Is(err, errIdentityNotAvailable) {
+			delegationErr = err
+			klog.Warningf("failed to get user delegation sas token of source container(%s) account(%s), fall back to account key: %v", srcContainerName, srcAccountName, err)
+		}
+	}
+
+	sasToken, _, err := d.getAzcopyAuth(ctx, srcAccountName, "", storageEndpointSuffix, srcAccountOptions, nil, "", secretNamespace, true)
+	if err != nil {
+		if delegationErr != nil {
+			return "", status.Errorf(codes.PermissionDenied, "could not access source container(%s) account(%s) subscription(%s): assign %q role on the source account to controller identity or allow listing account key, user delegation error: %v, account key error: %v",
+				srcContainerName, srcAccountName, srcAccountOptions.SubscriptionID, cloneSourceRole, delegationErr, err)
+		}
+		return "", err
+	}
+	return sasToken, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/blob-csi-driver/pkg/util"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+type fakeUserDelegationSASFactory struct {
+	sasToken string
+	err      error
+	requests []string
+}
+
+func (f *fakeUserDelegationSASFactory) GetContainerReadSAS(_ context.Context, accountName, _, containerName string, _ time.Duration) (string, error) {
+	f.requests = append(f.requests, accountName+"/"+containerName)
+	return f.sasToken, f.err
+}
+
+func TestUserDelegationSASFactoryWithoutIdentity(t *testing.T) {
+	f := &azblobUserDelegationSASFactory{cloud: &azure.Cloud{}}
+	_, err := f.GetContainerReadSAS(context.Background(), "account", "core.windows.net", "container", time.Hour)
+	assert.ErrorIs(t, err, errIdentityNotAvailable)
+}
+
+func TestGetCloneSourceSASToken(t *testing.T) {
+	srcAccountOptions := &azure.AccountOptions{Name: "srcaccount", ResourceGroup: "srcrg", SubscriptionID: "srcsub"}
+	tests := []struct {
+		desc             string
+		factory          *fakeUserDelegationSASFactory
+		expectedSASToken string
+		expectedCode     codes.Code
+	}{
+		{
+			desc:             "user delegation sas",
+			factory:          &fakeUserDelegationSASFactory{sasToken: "?sig=delegation"},
+			expectedSASToken: "?sig=delegation",
+		},
+		{
+			desc:         "identity is not authorized and account key is not available",
+			factory:      &fakeUserDelegationSASFactory{err: fmt.Errorf("AuthorizationPermissionMismatch")},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			desc:         "identity is not available",
+			factory:      &fakeUserDelegationSASFactory{err: fmt.Errorf("wrapped: %w", errIdentityNotAvailable)},
+			expectedCode: codes.Unknown,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.userDelegationSASFactory = test.factory
+		sasToken, err := d.getCloneSourceSASToken(context.Background(), "srcaccount", "srccontainer", "core.windows.net", srcAccountOptions, "")
+		assert.Equal(t, test.expectedSASToken, sasToken, test.desc)
+		assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+		assert.Equal(t, []string{"srcaccount/srccontainer"}, test.factory.requests, test.desc)
+	}
+}
+
+func TestCopyVolumeFromAnotherSubscription(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	factory := &fakeUserDelegationSASFactory{sasToken: "?sig=delegation"}
+	d.userDelegationSASFactory = factory
+	m := util.NewMockEXEC(ctrl)
+	listStr := "JobId: ed1c3833-eaff-fe42-71d7-513fb065a9d9\nStart Time: Monday, 07-Aug-23 03:29:54 UTC\nStatus: Completed\nCommand: copy https://srcaccount.blob.core.windows.net/srccontainer https://dstaccount.blob.core.windows.net/dstContainer --recursive --check-length=false"
+	m.EXPECT().RunCommand(gomock.Eq("azcopy jobs list | grep dstContainer -B 3"), gomock.Any()).Return(listStr, nil)
+	d.azcopy.ExecCmd = m
+	d.useAzcopyForCloning = true
+
+	req := &csi.CreateVolumeRequest{
+		Name: "unit-test",
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "srcrg#srcaccount#srccontainer##srcsub"},
+			},
+		},
+	}
+	// destination is authorized with cluster identity, no shared key is used on both sides
+	err := d.copyVolume(context.Background(), req, "dstaccount", "", []string{"AZCOPY_AUTO_LOGIN_TYPE=MSI"}, "dstContainer", "", &azure.AccountOptions{Name: "dstaccount", ResourceGroup: "rg", SubscriptionID: "subsID"}, "core.windows.net")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"srcaccount/srccontainer"}, factory.requests)
+}
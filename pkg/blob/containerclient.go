@@ -0,0 +1,252 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// folder marker metadata recognized by blobfuse2, and directory is created on HNS enabled account with this metadata
+	folderMetadata = "hdi_isfolder"
+
+	copyBlobPollInterval = 2 * time.Second
+)
+
+// containerDataClient is the data plane operations on a container
+type containerDataClient interface {
+	// CreateDirectory creates dir and all parent directories, no error if dir already exists
+	CreateDirectory(ctx context.Context, dir string) error
+	// DeleteDirectory deletes dir and all blobs under dir, no error if dir does not exist
+	DeleteDirectory(ctx context.Context, dir string) error
+	// CreateContainer creates a container in the same account, no error if it already exists
+	CreateContainer(ctx context.Context, name string, options *blobContainerOptions) error
+	// GetEncryptionScope returns default encryption scope of the container and whether override is denied
+	GetEncryptionScope(ctx context.Context) (string, bool, error)
+	// GetMetadata returns container metadata
+	GetMetadata(ctx context.Context) (map[string]string, error)
+	// SetMetadata replaces container metadata
+	SetMetadata(ctx context.Context, metadata map[string]string) error
+	// ListBlobs returns names of all blobs except directories
+	ListBlobs(ctx context.Context) ([]string, error)
+	// SetBlobTier sets access tier of the blob
+	SetBlobTier(ctx context.Context, name string, tier blob.AccessTier) error
+	// CopyBlob copies the blob to dstContainer in the same account and waits for completion
+	CopyBlob(ctx context.Context, name, dstContainer, dstName string) error
+}
+
+// blobContainerOptions is the optional properties of a new container
+type blobContainerOptions struct {
+	metadata map[string]string
+	// encryptionScope is the default encryption scope of all blobs in the container
+	encryptionScope             string
+	denyEncryptionScopeOverride bool
+	// storageEndpointSuffix is used when the container is created by data plane client, default to the cloud environment
+	storageEndpointSuffix string
+}
+
+// containerDataClientFactory creates data plane client of a container with account key
+type containerDataClientFactory interface {
+	NewContainerDataClient(accountName, accountKey, storageEndpointSuffix, containerName string) (containerDataClient, error)
+}
+
+type azblobContainerDataClientFactory struct {
+	limiter *adaptiveRateLimiter
+}
+
+type azblobContainerDataClient struct {
+	service *service.Client
+	client  *container.Client
+}
+
+func (f *azblobContainerDataClientFactory) NewContainerDataClient(accountName, accountKey, storageEndpointSuffix, containerName string) (containerDataClient, error) {
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shared key credential of account(%s): %w", accountName, err)
+	}
+	serviceClient, err := service.NewClientWithSharedKeyCredential(fmt.Sprintf("https://%s.blob.%s/", accountName, storageEndpointSuffix), credential, &service.ClientOptions{ClientOptions: f.limiter.clientOptions()})
+	if err != nil {
+		return nil, err
+	}
+	return &azblobContainerDataClient{service: serviceClient, client: serviceClient.NewContainerClient(containerName)}, nil
+}
+
+func (c *azblobContainerDataClient) CreateDirectory(ctx context.Context, dir string) error {
+	var current string
+	for _, segment := range strings.Split(dir, "/") {
+		current = path.Join(current, segment)
+		_, err := c.client.NewBlockBlobClient(current).Upload(ctx, streaming.NopCloser(bytes.NewReader(nil)), &blockblob.UploadOptions{
+			Metadata: map[string]*string{folderMetadata: to.Ptr(trueValue)},
+			AccessConditions: &blob.AccessConditions{
+				ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)},
+			},
+		})
+		if err != nil && !bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+			return fmt.Errorf("failed to create directory(%s): %w", current, err)
+		}
+	}
+	return nil
+}
+
+func (c *azblobContainerDataClient) DeleteDirectory(ctx context.Context, dir string) error {
+	var names []string
+	prefix := dir + "/"
+	pager := c.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list blobs under %s: %w", dir, err)
+		}
+		for _, item := range resp.Segment.BlobItems {
+			if item.Name != nil {
+				names = append(names, *item.Name)
+			}
+		}
+	}
+	// delete children before parent since directory on HNS enabled account could not be deleted if not empty
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	names = append(names, dir)
+	for _, name := range names {
+		if _, err := c.client.NewBlobClient(name).Delete(ctx, nil); err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return fmt.Errorf("failed to delete blob(%s): %w", name, err)
+		}
+	}
+	klog.V(2).Infof("deleted %d blobs under %s", len(names), dir)
+	return nil
+}
+
+func (c *azblobContainerDataClient) CreateContainer(ctx context.Context, name string, options *blobContainerOptions) error {
+	createOptions := &container.CreateOptions{Metadata: map[string]*string{}}
+	if options != nil {
+		for k, v := range options.metadata {
+			createOptions.Metadata[k] = to.Ptr(v)
+		}
+		if options.encryptionScope != "" {
+			createOptions.CPKScopeInfo = &container.CPKScopeInfo{
+				DefaultEncryptionScope:         to.Ptr(options.encryptionScope),
+				PreventEncryptionScopeOverride: to.Ptr(options.denyEncryptionScopeOverride),
+			}
+		}
+	}
+	_, err := c.service.NewContainerClient(name).Create(ctx, createOptions)
+	if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		return err
+	}
+	return nil
+}
+
+func (c *azblobContainerDataClient) GetEncryptionScope(ctx context.Context) (string, bool, error) {
+	resp, err := c.client.GetProperties(ctx, nil)
+	if err != nil {
+		return "", false, err
+	}
+	return ptr.Deref(resp.DefaultEncryptionScope, ""), ptr.Deref(resp.DenyEncryptionScopeOverride, false), nil
+}
+
+func (c *azblobContainerDataClient) GetMetadata(ctx context.Context) (map[string]string, error) {
+	resp, err := c.client.GetProperties(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string, len(resp.Metadata))
+	for k, v := range resp.Metadata {
+		if v != nil {
+			// metadata keys in response headers are canonicalized
+			metadata[strings.ToLower(k)] = *v
+		}
+	}
+	return metadata, nil
+}
+
+func (c *azblobContainerDataClient) SetMetadata(ctx context.Context, metadata map[string]string) error {
+	m := make(map[string]*string, len(metadata))
+	for k, v := range metadata {
+		m[k] = to.Ptr(v)
+	}
+	_, err := c.client.SetMetadata(ctx, &container.SetMetadataOptions{Metadata: m})
+	return err
+}
+
+func (c *azblobContainerDataClient) ListBlobs(ctx context.Context) ([]string, error) {
+	var names []string
+	pager := c.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Include: container.ListBlobsInclude{Metadata: true}})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			if v, ok := item.Metadata[folderMetadata]; ok && v != nil && strings.EqualFold(*v, trueValue) {
+				continue
+			}
+			names = append(names, *item.Name)
+		}
+	}
+	return names, nil
+}
+
+func (c *azblobContainerDataClient) SetBlobTier(ctx context.Context, name string, tier blob.AccessTier) error {
+	_, err := c.client.NewBlobClient(name).SetTier(ctx, tier, nil)
+	return err
+}
+
+func (c *azblobContainerDataClient) CopyBlob(ctx context.Context, name, dstContainer, dstName string) error {
+	dstClient := c.service.NewContainerClient(dstContainer).NewBlobClient(dstName)
+	// source blob in the same account is authorized by the shared key of the copy request
+	resp, err := dstClient.StartCopyFromURL(ctx, c.client.NewBlobClient(name).URL(), nil)
+	if err != nil {
+		return err
+	}
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(copyBlobPollInterval):
+		}
+		props, err := dstClient.GetProperties(ctx, nil)
+		if err != nil {
+			return err
+		}
+		status = props.CopyStatus
+	}
+	if status != nil && *status != blob.CopyStatusTypeSuccess {
+		return fmt.Errorf("copy blob(%s) to %s/%s finished with status %s", name, dstContainer, dstName, *status)
+	}
+	return nil
+}
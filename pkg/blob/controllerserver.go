@@ -90,267 +90,148 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if parameters == nil {
 		parameters = make(map[string]string)
 	}
-	var storageAccountType, subsID, resourceGroup, location, account, containerName, containerNamePrefix, protocol, customTags, secretName, secretNamespace, pvcNamespace, tagValueDelimiter string
-	var isHnsEnabled, requireInfraEncryption, enableBlobVersioning, createPrivateEndpoint, enableNfsV3, allowSharedKeyAccess *bool
-	var vnetResourceGroup, vnetName, subnetName, accessTier, networkEndpointType, storageEndpointSuffix, fsGroupChangePolicy string
-	var matchTags, useDataPlaneAPI, getLatestAccountKey bool
-	var softDeleteBlobs, softDeleteContainers int32
-	var vnetResourceIDs []string
-	var err error
-	// set allowBlobPublicAccess as false by default
-	allowBlobPublicAccess := ptr.To(false)
-
-	containerNameReplaceMap := map[string]string{}
-
-	// store account key to k8s secret by default
-	storeAccountKey := true
-
-	// Apply ProvisionerParameters (case-insensitive). We leave validation of
-	// the values to the cloud provider.
-	for k, v := range parameters {
-		switch strings.ToLower(k) {
-		case skuNameField:
-			storageAccountType = v
-		case storageAccountTypeField:
-			storageAccountType = v
-		case locationField:
-			location = v
-		case storageAccountField:
-			account = v
-		case subscriptionIDField:
-			subsID = v
-		case resourceGroupField:
-			resourceGroup = v
-		case containerNameField:
-			containerName = v
-		case containerNamePrefixField:
-			containerNamePrefix = v
-		case protocolField:
-			protocol = v
-		case tagsField:
-			customTags = v
-		case matchTagsField:
-			matchTags = strings.EqualFold(v, trueValue)
-		case secretNameField:
-			secretName = v
-		case secretNamespaceField:
-			secretNamespace = v
-		case isHnsEnabledField:
-			if strings.EqualFold(v, trueValue) {
-				isHnsEnabled = ptr.To(true)
-			}
-		case softDeleteBlobsField:
-			days, err := parseDays(v)
-			if err != nil {
-				return nil, err
-			}
-			softDeleteBlobs = days
-		case softDeleteContainersField:
-			days, err := parseDays(v)
-			if err != nil {
-				return nil, err
-			}
-			softDeleteContainers = days
-		case enableBlobVersioningField:
-			enableBlobVersioning = ptr.To(strings.EqualFold(v, trueValue))
-		case storeAccountKeyField:
-			if strings.EqualFold(v, falseValue) {
-				storeAccountKey = false
-			}
-		case getLatestAccountKeyField:
-			if getLatestAccountKey, err = strconv.ParseBool(v); err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", getLatestAccountKeyField, v)
-			}
-		case allowBlobPublicAccessField:
-			if strings.EqualFold(v, trueValue) {
-				allowBlobPublicAccess = ptr.To(true)
-			}
-		case allowSharedKeyAccessField:
-			var boolValue bool
-			if boolValue, err = strconv.ParseBool(v); err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", allowSharedKeyAccessField, v)
-			}
-			allowSharedKeyAccess = ptr.To(boolValue)
-		case requireInfraEncryptionField:
-			if strings.EqualFold(v, trueValue) {
-				requireInfraEncryption = ptr.To(true)
-			}
-		case pvcNamespaceKey:
-			pvcNamespace = v
-			containerNameReplaceMap[pvcNamespaceMetadata] = v
-		case pvcNameKey:
-			containerNameReplaceMap[pvcNameMetadata] = v
-		case pvNameKey:
-			containerNameReplaceMap[pvNameMetadata] = v
-		case serverNameField:
-		case storageAuthTypeField:
-		case storageIdentityClientIDField:
-		case storageIdentityObjectIDField:
-		case storageIdentityResourceIDField:
-		case msiEndpointField:
-		case storageAADEndpointField:
-			// no op, only used in NodeStageVolume
-		case storageEndpointSuffixField:
-			storageEndpointSuffix = v
-		case vnetResourceGroupField:
-			vnetResourceGroup = v
-		case vnetNameField:
-			vnetName = v
-		case subnetNameField:
-			subnetName = v
-		case accessTierField:
-			accessTier = v
-		case networkEndpointTypeField:
-			networkEndpointType = v
-		case mountPermissionsField:
-			// only do validations here, used in NodeStageVolume, NodePublishVolume
-			if v != "" {
-				if _, err := strconv.ParseUint(v, 8, 32); err != nil {
-					return nil, status.Errorf(codes.InvalidArgument, "invalid mountPermissions %s in storage class", v)
-				}
-			}
-		case useDataPlaneAPIField:
-			useDataPlaneAPI = strings.EqualFold(v, trueValue)
-		case fsGroupChangePolicyField:
-			fsGroupChangePolicy = v
-		case tagValueDelimiterField:
-			tagValueDelimiter = v
-		default:
-			return nil, status.Errorf(codes.InvalidArgument, "invalid parameter %q in storage class", k)
-		}
+	p, err := parseStorageClassParameters(parameters)
+	if err != nil {
+		return nil, err
 	}
-
-	if ptr.Deref(enableBlobVersioning, false) {
-		if isNFSProtocol(protocol) || ptr.Deref(isHnsEnabled, false) {
-			return nil, status.Errorf(codes.InvalidArgument, "enableBlobVersioning is not supported for NFS protocol or HNS enabled account")
-		}
+	// PVC namespace is only passed by external-provisioner, so it's checked here instead of the shared parameter parser
+	if p.accountPool.perNamespace && p.pvcNamespace == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s requires PVC namespace in parameters, enable --extra-create-metadata in external-provisioner", accountPerNamespaceField)
 	}
-
-	if !isSupportedFSGroupChangePolicy(fsGroupChangePolicy) {
-		return nil, status.Errorf(codes.InvalidArgument, "fsGroupChangePolicy(%s) is not supported, supported fsGroupChangePolicy list: %v", fsGroupChangePolicy, supportedFSGroupChangePolicyList)
+	if p.accountIsolation == accountIsolationPerNamespace && p.pvcNamespace == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s(%s) requires PVC namespace in parameters, enable --extra-create-metadata in external-provisioner", accountIsolationField, p.accountIsolation)
 	}
-
-	if matchTags && account != "" {
-		return nil, status.Errorf(codes.InvalidArgument, "matchTags must set as false when storageAccount(%s) is provided", account)
+	if field := p.getV2VolumeIDField(); field != "" && d.volumeIDFormatVersion != volumeIDFormatV2 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s requires v2 volume ID, set --volume-id-format-version=2 on controller after all node daemonsets are upgraded", field)
 	}
+	var vnetResourceIDs []string
 
-	if resourceGroup == "" {
-		resourceGroup = d.cloud.ResourceGroup
+	if err := d.authorizeVolumeAccess(&volumeAccessRequest{
+		Namespace:       p.pvcNamespace,
+		StorageAccount:  p.account,
+		Container:       p.containerName,
+		SecretNamespace: p.secretNamespace,
+		ResourceGroup:   p.resourceGroup,
+		SubscriptionID:  p.subsID,
+	}); err != nil {
+		return nil, err
 	}
 
-	if secretNamespace == "" {
-		if pvcNamespace == "" {
-			secretNamespace = defaultNamespace
-		} else {
-			secretNamespace = pvcNamespace
-		}
+	if p.resourceGroup == "" {
+		p.resourceGroup = d.cloud.ResourceGroup
 	}
 
-	if protocol == "" {
-		protocol = Fuse
-	}
-	if !isSupportedProtocol(protocol) {
-		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported protocol list: %v", protocol, supportedProtocolList)
+	capabilities := d.getCloudCapabilities()
+	if d.enableTopology {
+		if err := applyTopologyRequirement(req.GetAccessibilityRequirements(), p, capabilities); err != nil {
+			return nil, err
+		}
 	}
-	if !isSupportedAccessTier(accessTier) {
-		return nil, status.Errorf(codes.InvalidArgument, "accessTier(%s) is not supported, supported AccessTier list: %v", accessTier, armstorage.PossibleAccessTierValues())
+	if err := capabilities.validate(p); err != nil {
+		return nil, err
 	}
 
-	if containerName != "" && containerNamePrefix != "" {
-		return nil, status.Errorf(codes.InvalidArgument, "containerName(%s) and containerNamePrefix(%s) could not be specified together", containerName, containerNamePrefix)
-	}
-	if !isSupportedContainerNamePrefix(containerNamePrefix) {
-		return nil, status.Errorf(codes.InvalidArgument, "containerNamePrefix(%s) can only contain lowercase letters, numbers, hyphens, and length should be less than 21", containerNamePrefix)
+	if p.secretNamespace == "" {
+		if p.pvcNamespace == "" {
+			p.secretNamespace = defaultNamespace
+		} else {
+			p.secretNamespace = p.pvcNamespace
+		}
 	}
 
 	enableHTTPSTrafficOnly := true
-	if strings.EqualFold(networkEndpointType, privateEndpoint) {
-		if strings.Contains(subnetName, ",") {
-			return nil, status.Errorf(codes.InvalidArgument, "subnetName(%s) can only contain one subnet for private endpoint", subnetName)
-		}
-		createPrivateEndpoint = ptr.To(true)
-	}
 	accountKind := string(armstorage.KindStorageV2)
-	if isNFSProtocol(protocol) {
-		isHnsEnabled = ptr.To(true)
-		enableNfsV3 = ptr.To(true)
-		// NFS protocol does not need account key
-		storeAccountKey = false
-		if !ptr.Deref(createPrivateEndpoint, false) {
-			// set VirtualNetworkResourceIDs for storage account firewall setting
-			var err error
-			if vnetResourceIDs, err = d.updateSubnetServiceEndpoints(ctx, vnetResourceGroup, vnetName, subnetName); err != nil {
-				return nil, status.Errorf(codes.Internal, "update service endpoints failed with error: %v", err)
-			}
+	if isNFSProtocol(p.protocol) && !ptr.Deref(p.createPrivateEndpoint, false) {
+		// set VirtualNetworkResourceIDs for storage account firewall setting
+		var err error
+		if vnetResourceIDs, err = d.updateSubnetServiceEndpoints(ctx, p.vnetResourceGroup, p.vnetName, p.subnetName); err != nil {
+			return nil, status.Errorf(codes.Internal, "update service endpoints failed with error: %v", err)
 		}
 	}
 
-	if strings.HasPrefix(strings.ToLower(storageAccountType), "premium") {
+	if strings.HasPrefix(strings.ToLower(p.storageAccountType), "premium") {
 		accountKind = string(armstorage.KindBlockBlobStorage)
 	}
-	if IsAzureStackCloud(d.cloud) {
-		accountKind = string(armstorage.KindStorage)
-		if storageAccountType != "" && storageAccountType != string(armstorage.SKUNameStandardLRS) && storageAccountType != string(armstorage.SKUNamePremiumLRS) {
-			return nil, status.Errorf(codes.InvalidArgument, "Invalid skuName value: %s, as Azure Stack only supports %s and %s Storage Account types.", storageAccountType, armstorage.SKUNamePremiumLRS, armstorage.SKUNameStandardLRS)
-		}
+	if capabilities.AccountKind != "" {
+		accountKind = capabilities.AccountKind
 	}
 
-	tags, err := util.ConvertTagsToMap(customTags, tagValueDelimiter)
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
-	}
-
-	if strings.TrimSpace(storageEndpointSuffix) == "" {
-		storageEndpointSuffix = d.getStorageEndPointSuffix()
-	}
-
-	if storeAccountKey && !ptr.Deref(allowSharedKeyAccess, true) {
-		return nil, status.Errorf(codes.InvalidArgument, "storeAccountKey is not supported for account with shared access key disabled")
+	if strings.TrimSpace(p.storageEndpointSuffix) == "" {
+		p.storageEndpointSuffix = d.getStorageEndPointSuffix()
 	}
 
 	accountOptions := &azure.AccountOptions{
-		Name:                            account,
-		Type:                            storageAccountType,
+		Name:                            p.account,
+		Type:                            p.storageAccountType,
 		Kind:                            accountKind,
-		SubscriptionID:                  subsID,
-		ResourceGroup:                   resourceGroup,
-		Location:                        location,
+		SubscriptionID:                  p.subsID,
+		ResourceGroup:                   p.resourceGroup,
+		Location:                        p.location,
 		EnableHTTPSTrafficOnly:          enableHTTPSTrafficOnly,
 		VirtualNetworkResourceIDs:       vnetResourceIDs,
-		Tags:                            tags,
-		MatchTags:                       matchTags,
-		IsHnsEnabled:                    isHnsEnabled,
-		EnableNfsV3:                     enableNfsV3,
-		AllowBlobPublicAccess:           allowBlobPublicAccess,
-		AllowSharedKeyAccess:            allowSharedKeyAccess,
-		RequireInfrastructureEncryption: requireInfraEncryption,
-		VNetResourceGroup:               vnetResourceGroup,
-		VNetName:                        vnetName,
-		SubnetName:                      subnetName,
-		AccessTier:                      accessTier,
-		CreatePrivateEndpoint:           createPrivateEndpoint,
+		Tags:                            p.tags,
+		MatchTags:                       p.matchTags,
+		IsHnsEnabled:                    p.isHnsEnabled,
+		EnableNfsV3:                     p.enableNfsV3,
+		AllowBlobPublicAccess:           p.allowBlobPublicAccess,
+		AllowSharedKeyAccess:            p.allowSharedKeyAccess,
+		RequireInfrastructureEncryption: p.requireInfraEncryption,
+		VNetResourceGroup:               p.vnetResourceGroup,
+		VNetName:                        p.vnetName,
+		SubnetName:                      p.subnetName,
+		AccessTier:                      p.accessTier,
+		CreatePrivateEndpoint:           p.createPrivateEndpoint,
 		StorageType:                     provider.StorageTypeBlob,
-		StorageEndpointSuffix:           storageEndpointSuffix,
-		EnableBlobVersioning:            enableBlobVersioning,
-		SoftDeleteBlobs:                 softDeleteBlobs,
-		SoftDeleteContainers:            softDeleteContainers,
-		GetLatestAccountKey:             getLatestAccountKey,
+		StorageEndpointSuffix:           p.storageEndpointSuffix,
+		EnableBlobVersioning:            p.enableBlobVersioning,
+		SoftDeleteBlobs:                 p.softDeleteBlobs,
+		SoftDeleteContainers:            p.softDeleteContainers,
+		GetLatestAccountKey:             p.getLatestAccountKey,
 	}
 
-	containerName = replaceWithMap(containerName, containerNameReplaceMap)
-	validContainerName := containerName
+	p.containerName = replaceWithMap(p.containerName, p.containerNameReplaceMap)
+	validContainerName := p.containerName
 	if validContainerName == "" {
 		validContainerName = volName
-		if containerNamePrefix != "" {
-			validContainerName = containerNamePrefix + "-" + volName
+		if p.containerNamePrefix != "" {
+			validContainerName = p.containerNamePrefix + "-" + volName
 		}
-		validContainerName = getValidContainerName(validContainerName, protocol)
+		validContainerName = getValidContainerName(validContainerName, p.protocol)
 		setKeyValueInMap(parameters, containerNameField, validContainerName)
 	}
+	if !p.lifecycleRule.isEmpty() && len(req.GetSecrets()) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "lifecycle management rules are not supported with secrets in CreateVolume request since they are only available in management API")
+	}
+	if p.accountIsolation != "" && len(req.GetSecrets()) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with secrets in CreateVolume request", accountIsolationField)
+	}
+	if p.hasImmutability() && len(req.GetSecrets()) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s and %s are not supported with secrets in CreateVolume request since they are only available in management API", immutabilityPeriodInDaysField, legalHoldTagsField)
+	}
+	if srcVolume := volContentSource.GetVolume(); srcVolume != nil {
+		// only the whole container is copied on cloning, which would expose other volumes sharing the container
+		if info, err := parseVolumeID(srcVolume.GetVolumeId()); err == nil && info.subDir != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "volume cloning from volume(%s) with %s is not supported", srcVolume.GetVolumeId(), subDirField)
+		}
+	}
+	if p.subDir != "" {
+		if volContentSource != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "volume cloning is not supported with %s", subDirField)
+		}
+		// replace pv/pvc name namespace metadata in subDir
+		if p.subDir, err = normalizeSubDir(replaceWithMap(p.subDir, p.containerNameReplaceMap)); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		setKeyValueInMap(parameters, subDirField, p.subDir)
+	}
 
 	if acquired := d.volumeLocks.TryAcquire(volName); !acquired {
 		// logging the job status if it's volume cloning
-		if volContentSource != nil {
+		if volContentSource != nil && !d.useAzcopyForCloning {
+			if progress, ok := d.getNativeCopyProgress(validContainerName); ok {
+				return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsWithCloneFmt, volName, progress)
+			}
+		} else if volContentSource != nil {
 			jobState, percent, err := d.azcopy.GetAzcopyJob(validContainerName, []string{})
 			return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsWithAzcopyFmt, volName, jobState, percent, err)
 		}
@@ -375,13 +256,37 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		mc.ObserveOperationWithResult(isOperationSucceeded, VolumeID, volumeID)
 	}()
 
-	var accountKey string
-	accountName := account
+	var accountKey, isolatedAccountLock string
+	defer func() {
+		if isolatedAccountLock != "" {
+			d.volLockMap.UnlockEntry(isolatedAccountLock)
+		}
+	}()
+	accountName := p.account
 	if len(secrets) == 0 && accountName == "" {
-		if v, ok := d.volMap.Load(volName); ok {
+		lockKey := fmt.Sprintf("%s%s%s%s%s%v", p.storageAccountType, accountKind, p.resourceGroup, p.location, p.protocol, ptr.Deref(p.createPrivateEndpoint, false))
+		if p.accountIsolation != "" {
+			// hold the lock of dedicated account until the container is created, so that the account is not deleted with its last volume in between
+			isolatedAccountName, _ := d.getIsolatedAccountName(p.accountIsolation, accountOptions, p.protocol, lockKey, p.pvcNamespace, volName)
+			isolatedAccountLock = getIsolatedAccountCacheKey(isolatedAccountName)
+			d.volLockMap.LockEntry(isolatedAccountLock)
+			// dedicated account name is deterministic, it's not stored in volMap since the account may be deleted with the last volume
+			if accountName, accountKey, err = d.ensureIsolatedAccount(ctx, p.accountIsolation, accountOptions, p.protocol, lockKey, p.pvcNamespace, volName); err != nil {
+				return nil, err
+			}
+		} else if v, ok := d.volMap.Load(volName); ok {
 			accountName = v.(string)
+		} else if v, ok := d.getState(ctx, stateKindVolumeAccount, volName); ok {
+			klog.V(2).Infof("use storage account(%s) of volume(%s) from state store", v, volName)
+			accountName = v
+			d.volMap.Store(volName, accountName)
+		} else if !p.accountPool.isEmpty() {
+			if accountName, accountKey, err = d.ensurePoolAccount(ctx, &p.accountPool, accountOptions, p.protocol, lockKey, p.pvcNamespace, volName); err != nil {
+				return nil, err
+			}
+			d.volMap.Store(volName, accountName)
+			d.setState(ctx, stateKindVolumeAccount, volName, accountName)
 		} else {
-			lockKey := fmt.Sprintf("%s%s%s%s%s%v", storageAccountType, accountKind, resourceGroup, location, protocol, ptr.Deref(createPrivateEndpoint, false))
 			// search in cache first
 			cache, err := d.accountSearchCache.Get(lockKey, azcache.CacheReadTypeDefault)
 			if err != nil {
@@ -389,110 +294,198 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 			}
 			if cache != nil {
 				accountName = cache.(string)
+			} else if v, ok := d.getState(ctx, stateKindAccountSearch, lockKey); ok {
+				accountName = v
+				d.accountSearchCache.Set(lockKey, accountName)
 			} else {
-				d.volLockMap.LockEntry(lockKey)
-				err = wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
-					var retErr error
-					accountName, accountKey, retErr = d.cloud.EnsureStorageAccount(ctx, accountOptions, protocol)
-					if isRetriableError(retErr) {
-						klog.Warningf("EnsureStorageAccount(%s) failed with error(%v), waiting for retrying", account, retErr)
-						return false, nil
-					}
-					return true, retErr
-				})
-				d.volLockMap.UnlockEntry(lockKey)
-				if err != nil {
-					return nil, status.Errorf(codes.Internal, "ensure storage account failed with %v", err)
+				if accountName, accountKey, err = d.searchStorageAccount(ctx, lockKey, accountOptions, p.protocol); err != nil {
+					return nil, err
 				}
-				d.accountSearchCache.Set(lockKey, accountName)
 				d.volMap.Store(volName, accountName)
+				d.setState(ctx, stateKindVolumeAccount, volName, accountName)
 			}
 		}
 	}
 
-	if ptr.Deref(createPrivateEndpoint, false) && isNFSProtocol(protocol) {
+	if ptr.Deref(p.createPrivateEndpoint, false) {
+		// the private DNS zone link is required by all protocols, blobfuse relies on it to resolve the public endpoint
+		if err := d.ensurePrivateDNSZoneLink(ctx, p.vnetResourceGroup, p.vnetName, p.storageEndpointSuffix, p.privateDNSZoneLink); err != nil {
+			return nil, err
+		}
+	}
+
+	if ptr.Deref(p.createPrivateEndpoint, false) && isNFSProtocol(p.protocol) {
 		// As for blobfuse/blobfuse2, serverName, i.e.,AZURE_STORAGE_BLOB_ENDPOINT env variable can't include
 		// "privatelink", issue: https://github.com/Azure/azure-storage-fuse/issues/1014
 		//
 		// And use public endpoint will be befine to blobfuse/blobfuse2, because it will be resolved to private endpoint
 		// by private dns zone, which includes CNAME record, documented here:
 		// https://learn.microsoft.com/en-us/azure/storage/common/storage-private-endpoints?toc=%2Fazure%2Fstorage%2Fblobs%2Ftoc.json&bc=%2Fazure%2Fstorage%2Fblobs%2Fbreadcrumb%2Ftoc.json#dns-changes-for-private-endpoints
-		setKeyValueInMap(parameters, serverNameField, fmt.Sprintf("%s.privatelink.blob.%s", accountName, storageEndpointSuffix))
+		setKeyValueInMap(parameters, serverNameField, fmt.Sprintf("%s.privatelink.blob.%s", accountName, p.storageEndpointSuffix))
 	}
 
 	accountOptions.Name = accountName
-	if len(secrets) == 0 && useDataPlaneAPI {
+	if len(secrets) == 0 && p.useDataPlaneAPI {
 		if accountKey == "" {
-			if accountName, accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, secrets, secretName, secretNamespace); err != nil {
+			if accountName, accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, secrets, p.secretName, p.secretNamespace); err != nil {
 				return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
 			}
 		}
 		secrets = createStorageAccountSecret(accountName, accountKey)
 	}
 
-	klog.V(2).Infof("begin to create container(%s) on account(%s) type(%s) subsID(%s) rg(%s) location(%s) size(%d)", validContainerName, accountName, storageAccountType, subsID, resourceGroup, location, requestGiB)
-	if err := d.CreateBlobContainer(ctx, subsID, resourceGroup, accountName, validContainerName, secrets); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create container(%s) on account(%s) type(%s) rg(%s) location(%s) size(%d), error: %v", validContainerName, accountName, storageAccountType, resourceGroup, location, requestGiB, err)
+	klog.V(2).Infof("begin to create container(%s) on account(%s) type(%s) subsID(%s) rg(%s) location(%s) size(%d)", validContainerName, accountName, p.storageAccountType, p.subsID, p.resourceGroup, p.location, requestGiB)
+	containerOptions := &blobContainerOptions{
+		encryptionScope:             p.encryptionScope,
+		denyEncryptionScopeOverride: p.denyEncryptionScopeOverride,
+		storageEndpointSuffix:       p.storageEndpointSuffix,
+	}
+	if p.onDelete != onDeleteDelete {
+		// record pv/pvc names so that the container could be traced back after the volume is deleted
+		containerOptions.metadata = getVolumeContainerMetadata(volName, parameters)
+	}
+	if err := d.createBlobContainer(ctx, p.subsID, p.resourceGroup, accountName, validContainerName, secrets, containerOptions); err != nil {
+		if p.encryptionScope != "" && isEncryptionScopeError(err) {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to create container(%s) on account(%s) with %s(%s), error: %v", validContainerName, accountName, encryptionScopeField, p.encryptionScope, err)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to create container(%s) on account(%s) type(%s) rg(%s) location(%s) size(%d), error: %v", validContainerName, accountName, p.storageAccountType, p.resourceGroup, p.location, requestGiB, err)
+	}
+	if isolatedAccountLock != "" {
+		d.volLockMap.UnlockEntry(isolatedAccountLock)
+		isolatedAccountLock = ""
+	}
+	if p.hasImmutability() {
+		if err := d.setContainerImmutability(ctx, p.subsID, p.resourceGroup, accountName, validContainerName, p); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to set WORM policies on container(%s) account(%s) rg(%s), error: %v", validContainerName, accountName, p.resourceGroup, err)
+		}
+	}
+	if p.subDir != "" {
+		if accountKey == "" {
+			if accountName, accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, secrets, p.secretName, p.secretNamespace); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
+			}
+		}
+		client, err := d.containerDataClientFactory.NewContainerDataClient(accountName, accountKey, p.storageEndpointSuffix, validContainerName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+		if err := client.CreateDirectory(ctx, p.subDir); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create %s(%s) in container(%s) on account(%s), error: %v", subDirField, p.subDir, validContainerName, accountName, err)
+		}
+	}
+	var lifecycleRuleName string
+	if !p.lifecycleRule.isEmpty() {
+		prefix := getLifecyclePrefix(validContainerName, p.subDir)
+		lifecycleRuleName = getLifecycleRuleName(prefix)
+		if err := d.updateLifecycleRule(ctx, p.subsID, p.resourceGroup, accountName, lifecycleRuleName, p.lifecycleRule.toManagementPolicyRule(lifecycleRuleName, prefix)); err != nil {
+			if status.Code(err) == codes.ResourceExhausted {
+				return nil, err
+			}
+			return nil, status.Errorf(codes.Internal, "failed to add lifecycle rule(%s) to account(%s) rg(%s), error: %v", lifecycleRuleName, accountName, p.resourceGroup, err)
+		}
 	}
 	if volContentSource != nil {
-		accountSASToken, authAzcopyEnv, err := d.getAzcopyAuth(ctx, accountName, accountKey, storageEndpointSuffix, accountOptions, secrets, secretName, secretNamespace, false)
+		accountSASToken, authAzcopyEnv, err := d.getAzcopyAuth(ctx, accountName, accountKey, p.storageEndpointSuffix, accountOptions, secrets, p.secretName, p.secretNamespace, false)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to getAzcopyAuth on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
 		}
 		var copyErr error
-		copyErr = d.copyVolume(ctx, req, accountName, accountSASToken, authAzcopyEnv, validContainerName, secretNamespace, accountOptions, storageEndpointSuffix)
+		copyErr = d.copyVolume(ctx, req, accountName, accountSASToken, authAzcopyEnv, validContainerName, p.secretNamespace, accountOptions, p.storageEndpointSuffix)
 		if accountSASToken == "" && copyErr != nil && strings.Contains(copyErr.Error(), authorizationPermissionMismatch) {
 			klog.Warningf("azcopy copy failed with AuthorizationPermissionMismatch error, should assign \"Storage Blob Data Contributor\" role to controller identity, fall back to use sas token, original error: %v", copyErr)
-			accountSASToken, authAzcopyEnv, err := d.getAzcopyAuth(ctx, accountName, accountKey, storageEndpointSuffix, accountOptions, secrets, secretName, secretNamespace, true)
+			accountSASToken, authAzcopyEnv, err := d.getAzcopyAuth(ctx, accountName, accountKey, p.storageEndpointSuffix, accountOptions, secrets, p.secretName, p.secretNamespace, true)
 			if err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to getAzcopyAuth on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
+				return nil, status.Errorf(codes.PermissionDenied, "could not write to destination account(%s) rg(%s): assign %q role on the destination account to controller identity or allow listing account key, azcopy error: %v, account key error: %v", accountOptions.Name, accountOptions.ResourceGroup, cloneDestinationRole, copyErr, err)
 			}
-			copyErr = d.copyVolume(ctx, req, accountName, accountSASToken, authAzcopyEnv, validContainerName, secretNamespace, accountOptions, storageEndpointSuffix)
+			copyErr = d.copyVolume(ctx, req, accountName, accountSASToken, authAzcopyEnv, validContainerName, p.secretNamespace, accountOptions, p.storageEndpointSuffix)
 		}
 		if copyErr != nil {
 			return nil, copyErr
 		}
+		if d.enableAsyncClone && !d.useAzcopyForCloning {
+			// clone source is checked in NodeStageVolume to wait for the async copy
+			_, srcAccountName, srcContainerName, _, _, _ := GetContainerInfo(volContentSource.GetVolume().GetVolumeId()) //nolint:dogsled
+			setKeyValueInMap(parameters, cloneSourceField, srcAccountName+"/"+srcContainerName)
+		}
 	}
 
-	if storeAccountKey && len(req.GetSecrets()) == 0 {
+	if p.storeAccountKey && len(req.GetSecrets()) == 0 {
 		if accountKey == "" {
-			if accountName, accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, secrets, secretName, secretNamespace); err != nil {
+			if accountName, accountKey, err = d.GetStorageAccesskey(ctx, accountOptions, secrets, p.secretName, p.secretNamespace); err != nil {
 				return nil, status.Errorf(codes.Internal, "failed to GetStorageAccesskey on account(%s) rg(%s), error: %v", accountOptions.Name, accountOptions.ResourceGroup, err)
 			}
 		}
 
-		secretName, err := setAzureCredentials(ctx, d.KubeClient, accountName, accountKey, secretNamespace)
+		secretName, err := setAzureCredentials(ctx, d.KubeClient, accountName, accountKey, p.secretNamespace)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to store storage account key: %v", err)
 		}
 		if secretName != "" {
-			klog.V(2).Infof("store account key to k8s secret(%v) in %s namespace", secretName, secretNamespace)
+			klog.V(2).Infof("store account key to k8s secret(%v) in %s namespace", secretName, p.secretNamespace)
 		}
 	}
 
 	var uuid string
-	if containerName != "" {
+	if p.containerName != "" {
 		// add volume name as suffix to differentiate volumeID since "containerName" is specified
 		// not necessary for dynamic container name creation since volumeID already contains volume name
 		uuid = volName
 	}
-	volumeID = fmt.Sprintf(volumeIDTemplate, resourceGroup, accountName, validContainerName, uuid, secretNamespace, subsID)
+	volumeIDInfo := &volumeIDInfo{
+		resourceGroup:    p.resourceGroup,
+		accountName:      accountName,
+		containerName:    validContainerName,
+		uuid:             uuid,
+		secretNamespace:  p.secretNamespace,
+		subsID:           p.subsID,
+		protocol:         p.protocol,
+		useDataPlaneAPI:  p.useDataPlaneAPI,
+		subDir:           p.subDir,
+		lifecycleRule:    lifecycleRuleName,
+		accountIsolation: p.accountIsolation,
+	}
+	if p.onDelete != onDeleteDelete {
+		volumeIDInfo.onDelete = p.onDelete
+		volumeIDInfo.backupContainer = p.backupContainerName
+	}
+	if p.storageEndpointSuffix != d.getStorageEndPointSuffix() {
+		volumeIDInfo.storageEndpointSuffix = p.storageEndpointSuffix
+	}
+	volumeID = volumeIDInfo.encode(d.volumeIDFormatVersion)
 	klog.V(2).Infof("create container %s on storage account %s successfully", validContainerName, accountName)
 
-	if useDataPlaneAPI {
+	if p.useDataPlaneAPI {
 		d.dataPlaneAPIVolCache.Set(volumeID, "")
 		d.dataPlaneAPIVolCache.Set(accountName, "")
+		d.setState(ctx, stateKindDataPlaneAPI, volumeID, "")
+		d.setState(ctx, stateKindDataPlaneAPI, accountName, "")
+	}
+
+	var accessibleTopology []*csi.Topology
+	if d.enableTopology {
+		region := p.location
+		if region == "" && p.account == "" {
+			region = d.cloud.Location
+		}
+		if region != "" {
+			// storage account is accessible from all zones of the region, location is checked on the node by cross region mount policy
+			accessibleTopology = []*csi.Topology{{Segments: map[string]string{topologyRegionKey: strings.ToLower(region)}}}
+			setKeyValueInMap(parameters, locationField, region)
+		}
 	}
 
 	isOperationSucceeded = true
+	// storage account of the volume is only needed by CreateVolume retries
+	d.deleteState(ctx, stateKindVolumeAccount, volName)
 	// reset secretNamespace field in VolumeContext
-	setKeyValueInMap(parameters, secretNamespaceField, secretNamespace)
+	setKeyValueInMap(parameters, secretNamespaceField, p.secretNamespace)
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
-			VolumeContext: parameters,
-			ContentSource: volContentSource,
+			VolumeId:           volumeID,
+			CapacityBytes:      req.GetCapacityRange().GetRequiredBytes(),
+			VolumeContext:      parameters,
+			ContentSource:      volContentSource,
+			AccessibleTopology: accessibleTopology,
 		},
 	}, nil
 }
@@ -519,6 +512,8 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 		klog.Errorf("GetContainerInfo(%s) in DeleteVolume failed with error: %v", volumeID, err)
 		return &csi.DeleteVolumeResponse{}, nil
 	}
+	// stop copying into the container being deleted
+	d.cancelCloneJob(ctx, accountName, containerName)
 
 	secrets := req.GetSecrets()
 	if len(secrets) == 0 && d.useDataPlaneAPI(volumeID, accountName) {
@@ -540,11 +535,53 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	if resourceGroupName == "" {
 		resourceGroupName = d.cloud.ResourceGroup
 	}
+	info, _ := parseVolumeID(volumeID)
+	if info.lifecycleRule != "" {
+		// remove lifecycle rule of the volume before deleting data, it's a no-op on DeleteVolume retry
+		if err := d.updateLifecycleRule(ctx, subsID, resourceGroupName, accountName, info.lifecycleRule, nil); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to remove lifecycle rule(%s) from account(%s) rg(%s) volumeID(%s), error: %v", info.lifecycleRule, accountName, resourceGroupName, volumeID, err)
+		}
+	}
+	if info.subDir != "" {
+		// only delete the directory since the container is shared by other volumes
+		if err := d.deleteSubDir(ctx, volumeID, info, secrets); err != nil {
+			return nil, err
+		}
+		d.deleteState(ctx, stateKindDataPlaneAPI, volumeID)
+		isOperationSucceeded = true
+		return &csi.DeleteVolumeResponse{}, nil
+	} else if info.onDelete != "" {
+		deleteContainer, err := d.reclaimVolume(ctx, volumeID, info, secrets)
+		if err != nil {
+			return nil, err
+		}
+		if !deleteContainer {
+			klog.V(2).Infof("container(%s) under rg(%s) account(%s) volumeID(%s) is kept by %s(%s) policy", containerName, resourceGroupName, accountName, volumeID, onDeleteField, info.onDelete)
+			d.deleteState(ctx, stateKindDataPlaneAPI, volumeID)
+			isOperationSucceeded = true
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+	}
 	klog.V(2).Infof("deleting container(%s) rg(%s) account(%s) volumeID(%s)", containerName, resourceGroupName, accountName, volumeID)
 	if err := d.DeleteBlobContainer(ctx, subsID, resourceGroupName, accountName, containerName, secrets); err != nil {
+		if isContainerProtectedError(err) {
+			// retrying would not help until legal hold is cleared or retention period expires
+			protection := d.getContainerProtection(ctx, subsID, resourceGroupName, accountName, containerName)
+			if protection == "" {
+				protection = "legal hold or immutability policy"
+			}
+			return nil, status.Errorf(codes.FailedPrecondition, "container(%s) under rg(%s) account(%s) volumeID(%s) is protected by %s, clear the legal hold or wait until the retention period expires, error: %v", containerName, resourceGroupName, accountName, volumeID, protection, err)
+		}
 		return nil, status.Errorf(codes.Internal, "failed to delete container(%s) under rg(%s) account(%s) volumeID(%s), error: %v", containerName, resourceGroupName, accountName, volumeID, err)
 	}
 
+	if info.accountIsolation != "" {
+		if err := d.deleteIsolatedAccount(ctx, info.accountIsolation, subsID, resourceGroupName, accountName); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to delete dedicated storage account(%s) of volumeID(%s), error: %v", accountName, volumeID, err)
+		}
+	}
+
+	d.deleteState(ctx, stateKindDataPlaneAPI, volumeID)
 	isOperationSucceeded = true
 	klog.V(2).Infof("container(%s) under rg(%s) account(%s) volumeID(%s) is deleted successfully", containerName, resourceGroupName, accountName, volumeID)
 	return &csi.DeleteVolumeResponse{}, nil
@@ -687,31 +724,75 @@ func (d *Driver) ControllerExpandVolume(_ context.Context, req *csi.ControllerEx
 
 // CreateBlobContainer creates a blob container
 func (d *Driver) CreateBlobContainer(ctx context.Context, subsID, resourceGroupName, accountName, containerName string, secrets map[string]string) error {
+	return d.createBlobContainer(ctx, subsID, resourceGroupName, accountName, containerName, secrets, nil)
+}
+
+// createBlobContainer creates a blob container with additional container metadata and encryption scope
+func (d *Driver) createBlobContainer(ctx context.Context, subsID, resourceGroupName, accountName, containerName string, secrets map[string]string, options *blobContainerOptions) error {
 	if containerName == "" {
 		return fmt.Errorf("containerName is empty")
 	}
+	if options == nil {
+		options = &blobContainerOptions{}
+	}
+	containerMetadata := map[string]string{createdByMetadata: d.Name}
+	for k, v := range options.metadata {
+		containerMetadata[k] = v
+	}
 	return wait.ExponentialBackoff(d.cloud.RequestBackoff(), func() (bool, error) {
 		var err error
-		if len(secrets) > 0 {
+		if len(secrets) > 0 && options.encryptionScope != "" {
+			// encryption scope is not supported by the legacy storage SDK
+			secretAccountName, secretAccountKey, getErr := getStorageAccount(secrets)
+			if getErr != nil {
+				return true, getErr
+			}
+			storageEndpointSuffix := options.storageEndpointSuffix
+			if storageEndpointSuffix == "" {
+				storageEndpointSuffix = d.getStorageEndPointSuffix()
+			}
+			client, getErr := d.containerDataClientFactory.NewContainerDataClient(secretAccountName, secretAccountKey, storageEndpointSuffix, containerName)
+			if getErr != nil {
+				return true, getErr
+			}
+			err = client.CreateContainer(ctx, containerName, &blobContainerOptions{
+				metadata:                    containerMetadata,
+				encryptionScope:             options.encryptionScope,
+				denyEncryptionScopeOverride: options.denyEncryptionScopeOverride,
+			})
+		} else if len(secrets) > 0 {
 			container, getErr := getContainerReference(containerName, secrets, d.getCloudEnvironment())
 			if getErr != nil {
 				return true, getErr
 			}
-			container.Metadata = map[string]string{createdByMetadata: d.Name}
-			_, err = container.CreateIfNotExists(&azstorage.CreateContainerOptions{Access: azstorage.ContainerAccessTypePrivate})
+			container.Metadata = containerMetadata
+			err = d.dataPlaneRateLimiter.do(ctx, "CreateContainer", func() error {
+				_, createErr := container.CreateIfNotExists(&azstorage.CreateContainerOptions{Access: azstorage.ContainerAccessTypePrivate})
+				return createErr
+			})
 		} else {
 			blobContainer := armstorage.BlobContainer{
 				ContainerProperties: &armstorage.ContainerProperties{
 					PublicAccess: to.Ptr(armstorage.PublicAccessNone),
-					Metadata:     map[string]*string{createdByMetadata: to.Ptr(d.Name)},
+					Metadata:     map[string]*string{},
 				},
 			}
+			for k, v := range containerMetadata {
+				blobContainer.ContainerProperties.Metadata[k] = to.Ptr(v)
+			}
+			if options.encryptionScope != "" {
+				blobContainer.ContainerProperties.DefaultEncryptionScope = to.Ptr(options.encryptionScope)
+				blobContainer.ContainerProperties.DenyEncryptionScopeOverride = to.Ptr(options.denyEncryptionScopeOverride)
+			}
 			var blobClient blobcontainerclient.Interface
 			blobClient, err = d.clientFactory.GetBlobContainerClientForSub(subsID)
 			if err != nil {
 				return true, err
 			}
-			_, err = blobClient.CreateContainer(ctx, resourceGroupName, accountName, containerName, blobContainer)
+			err = d.armRateLimiter.do(ctx, "CreateContainer", func() error {
+				_, createErr := blobClient.CreateContainer(ctx, resourceGroupName, accountName, containerName, blobContainer)
+				return createErr
+			})
 		}
 		if err != nil {
 			if strings.Contains(err.Error(), containerBeingDeletedDataplaneAPIError) ||
@@ -736,14 +817,19 @@ func (d *Driver) DeleteBlobContainer(ctx context.Context, subsID, resourceGroupN
 			if getErr != nil {
 				return true, getErr
 			}
-			_, err = container.DeleteIfExists(nil)
+			err = d.dataPlaneRateLimiter.do(ctx, "DeleteContainer", func() error {
+				_, deleteErr := container.DeleteIfExists(nil)
+				return deleteErr
+			})
 		} else {
 			var blobClient blobcontainerclient.Interface
 			blobClient, err = d.clientFactory.GetBlobContainerClientForSub(subsID)
 			if err != nil {
 				return true, err
 			}
-			err = blobClient.DeleteContainer(ctx, resourceGroupName, accountName, containerName)
+			err = d.armRateLimiter.do(ctx, "DeleteContainer", func() error {
+				return blobClient.DeleteContainer(ctx, resourceGroupName, accountName, containerName)
+			})
 		}
 		if err != nil {
 			if strings.Contains(err.Error(), containerBeingDeletedDataplaneAPIError) ||
@@ -777,17 +863,39 @@ func (d *Driver) copyBlobContainer(ctx context.Context, req *csi.CreateVolumeReq
 		return fmt.Errorf("srcAccountName(%s) or srcContainerName(%s) or dstContainerName(%s) is empty", srcAccountName, srcContainerName, dstContainerName)
 	}
 	srcAccountSasToken := dstAccountSasToken
-	if srcAccountName != dstAccountName && dstAccountSasToken != "" {
+	if srcAccountName != dstAccountName {
+		// credentials of source and destination are resolved separately since they could be in different subscriptions
 		srcAccountOptions := &azure.AccountOptions{
 			Name:                srcAccountName,
 			ResourceGroup:       srcResourceGroupName,
 			SubscriptionID:      srcSubscriptionID,
 			GetLatestAccountKey: accountOptions.GetLatestAccountKey,
 		}
-		if srcAccountSasToken, _, err = d.getAzcopyAuth(ctx, srcAccountName, "", storageEndpointSuffix, srcAccountOptions, nil, "", secretNamespace, true); err != nil {
+		if srcAccountSasToken, err = d.getCloneSourceSASToken(ctx, srcAccountName, srcContainerName, storageEndpointSuffix, srcAccountOptions, secretNamespace); err != nil {
 			return err
 		}
 	}
+	if !d.useAzcopyForCloning {
+		if d.enableAsyncClone {
+			job := &cloneJob{
+				SrcAccountName:        srcAccountName,
+				SrcContainerName:      srcContainerName,
+				SrcResourceGroup:      srcResourceGroupName,
+				SrcSubscriptionID:     srcSubscriptionID,
+				DstAccountName:        dstAccountName,
+				DstContainerName:      dstContainerName,
+				StorageEndpointSuffix: storageEndpointSuffix,
+				SecretNamespace:       secretNamespace,
+				PVCName:               getValueInMap(req.GetParameters(), pvcNameKey),
+				PVCNamespace:          getValueInMap(req.GetParameters(), pvcNamespaceKey),
+			}
+			if accountOptions != nil {
+				job.DstResourceGroup, job.DstSubscriptionID = accountOptions.ResourceGroup, accountOptions.SubscriptionID
+			}
+			return d.startCloneJob(ctx, job, srcAccountSasToken, dstAccountSasToken)
+		}
+		return d.copyBlobContainerNative(ctx, srcAccountName, srcContainerName, srcAccountSasToken, dstAccountName, dstContainerName, dstAccountSasToken, storageEndpointSuffix)
+	}
 	srcPath := fmt.Sprintf("https://%s.blob.%s/%s%s", srcAccountName, storageEndpointSuffix, srcContainerName, srcAccountSasToken)
 	dstPath := fmt.Sprintf("https://%s.blob.%s/%s%s", dstAccountName, storageEndpointSuffix, dstContainerName, dstAccountSasToken)
 
@@ -182,6 +182,92 @@ func TestCreateVolume(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "accountPerNamespace without PVC namespace",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				req := &csi.CreateVolumeRequest{
+					Name:               "unit-test",
+					VolumeCapabilities: stdVolumeCapabilities,
+					Parameters:         map[string]string{accountPerNamespaceField: "true"},
+				}
+				d.Cap = []*csi.ControllerServiceCapability{
+					controllerServiceCapability,
+				}
+				_, err := d.CreateVolume(context.Background(), req)
+				expectedErr := status.Errorf(codes.InvalidArgument, "%s requires PVC namespace in parameters, enable --extra-create-metadata in external-provisioner", accountPerNamespaceField)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "perNamespace accountIsolation without PVC namespace",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				req := &csi.CreateVolumeRequest{
+					Name:               "unit-test",
+					VolumeCapabilities: stdVolumeCapabilities,
+					Parameters:         map[string]string{accountIsolationField: "perNamespace"},
+				}
+				d.Cap = []*csi.ControllerServiceCapability{
+					controllerServiceCapability,
+				}
+				_, err := d.CreateVolume(context.Background(), req)
+				expectedErr := status.Errorf(codes.InvalidArgument, "%s(%s) requires PVC namespace in parameters, enable --extra-create-metadata in external-provisioner", accountIsolationField, accountIsolationPerNamespace)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "subDir with v1 volume ID format",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				d.volumeIDFormatVersion = volumeIDFormatV1
+				req := &csi.CreateVolumeRequest{
+					Name:               "unit-test",
+					VolumeCapabilities: stdVolumeCapabilities,
+					Parameters:         map[string]string{containerNameField: "container", subDirField: "dir", protocolField: Fuse2},
+				}
+				d.Cap = []*csi.ControllerServiceCapability{
+					controllerServiceCapability,
+				}
+				_, err := d.CreateVolume(context.Background(), req)
+				expectedErr := status.Errorf(codes.InvalidArgument, "%s requires v2 volume ID, set --volume-id-format-version=2 on controller after all node daemonsets are upgraded", subDirField)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
+				}
+			},
+		},
+		{
+			name: "clone from volume with subDir",
+			testFunc: func(t *testing.T) {
+				d := NewFakeDriver()
+				d.cloud = &azure.Cloud{}
+				srcVolumeID := "blob:v2#rg#account#container#uuid###subdir=dir"
+				req := &csi.CreateVolumeRequest{
+					Name:               "unit-test",
+					VolumeCapabilities: stdVolumeCapabilities,
+					VolumeContentSource: &csi.VolumeContentSource{
+						Type: &csi.VolumeContentSource_Volume{
+							Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: srcVolumeID},
+						},
+					},
+				}
+				d.Cap = []*csi.ControllerServiceCapability{
+					controllerServiceCapability,
+				}
+				_, err := d.CreateVolume(context.Background(), req)
+				expectedErr := status.Errorf(codes.InvalidArgument, "volume cloning from volume(%s) with %s is not supported", srcVolumeID, subDirField)
+				if !reflect.DeepEqual(err, expectedErr) {
+					t.Errorf("actualErr: (%v), expectedErr: (%v)", err, expectedErr)
+				}
+			},
+		},
 		{
 			name: "Invalid fsGroupChangePolicy",
 			testFunc: func(t *testing.T) {
@@ -496,7 +582,7 @@ func TestCreateVolume(t *testing.T) {
 					controllerServiceCapability,
 				}
 
-				expectedErr := status.Errorf(codes.InvalidArgument, "Invalid skuName value: %s, as Azure Stack only supports %s and %s Storage Account types.", "unit-test", storage.SkuNamePremiumLRS, storage.SkuNameStandardLRS)
+				expectedErr := status.Errorf(codes.InvalidArgument, "feature skuName(%s) is not supported on this cloud(AZURESTACKCLOUD, storage API version 2019-06-01), supported skuName list: %v", "unit-test", []string{string(storage.SkuNameStandardLRS), string(storage.SkuNamePremiumLRS)})
 				_, err := d.CreateVolume(context.Background(), req)
 				if !reflect.DeepEqual(err, expectedErr) {
 					t.Errorf("Unexpected error: %v", err)
@@ -1724,6 +1810,7 @@ func TestCopyVolume(t *testing.T) {
 				m.EXPECT().RunCommand(gomock.Eq("azcopy jobs list | grep dstContainer -B 3"), gomock.Any()).Return(listStr, nil)
 
 				d.azcopy.ExecCmd = m
+				d.useAzcopyForCloning = true
 
 				var expectedErr error
 				err := d.copyVolume(ctx, req, "", "sastoken", nil, "dstContainer", "", nil, "core.windows.net")
@@ -1765,6 +1852,7 @@ func TestCopyVolume(t *testing.T) {
 				m.EXPECT().RunCommand(gomock.Not("azcopy jobs list | grep dstBlobContainer -B 3"), gomock.Any()).Return("Percent Complete (approx): 50.0", nil)
 
 				d.azcopy.ExecCmd = m
+				d.useAzcopyForCloning = true
 
 				expectedErr := fmt.Errorf("wait for the existing AzCopy job to complete, current copy percentage is 50.0%%")
 				err := d.copyVolume(ctx, req, "", "sastoken", nil, "dstContainer", "", nil, "core.windows.net")
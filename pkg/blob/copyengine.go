@@ -0,0 +1,400 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// clone checkpoint is stored in destination container metadata so that the copy could be resumed after restart
+	cloneSourceMetadata      = "clonesource"
+	cloneStateMetadata       = "clonestate"
+	cloneMarkerMetadata      = "clonemarker"
+	cloneCopiedBlobsMetadata = "clonecopiedblobs"
+	cloneCopiedBytesMetadata = "clonecopiedbytes"
+
+	cloneStateInProgress = "inprogress"
+	cloneStateCompleted  = "completed"
+	cloneStateFailed     = "failed"
+
+	// blobs larger than maxPutBlobFromURLSize are copied block by block with Put Block From URL
+	maxPutBlobFromURLSize = 256 * 1024 * 1024
+	copyBlockSize         = 100 * 1024 * 1024
+
+	defaultCloneCopyParallelism = 16
+)
+
+// copySourceBlob is a blob to be copied from source container
+type copySourceBlob struct {
+	name     string
+	size     int64
+	blobType blob.BlobType
+	metadata map[string]*string
+	headers  *blob.HTTPHeaders
+	// lastModified is only set when the blob is listed
+	lastModified time.Time
+}
+
+// cloneCopyClient copies blobs from source container to destination container with server side copy
+type cloneCopyClient interface {
+	// ListSourceBlobs returns one page of source blobs starting from marker and the marker of next page, next marker is empty on the last page
+	ListSourceBlobs(ctx context.Context, marker string) ([]copySourceBlob, string, error)
+	// CopyBlob copies the source blob to the same name in destination container
+	CopyBlob(ctx context.Context, b copySourceBlob) error
+	GetDestinationMetadata(ctx context.Context) (map[string]string, error)
+	SetDestinationMetadata(ctx context.Context, metadata map[string]string) error
+}
+
+// cloneCopyClientFactory creates copy client, source container URL should contain a SAS token,
+// destination container URL contains a SAS token or dstCredential is used
+type cloneCopyClientFactory interface {
+	NewCloneCopyClient(srcContainerURL, dstContainerURL string, dstCredential azcore.TokenCredential) (cloneCopyClient, error)
+}
+
+// cloneProgress is the structured progress of a native copy job
+type cloneProgress struct {
+	marker      string
+	copiedBlobs int64
+	copiedBytes int64
+	completed   bool
+}
+
+func (p cloneProgress) String() string {
+	state := cloneStateInProgress
+	if p.completed {
+		state = cloneStateCompleted
+	}
+	return fmt.Sprintf("state: %s, copied blobs: %d, copied bytes: %d", state, p.copiedBlobs, p.copiedBytes)
+}
+
+// parseCloneProgress parses the checkpoint in destination container metadata, progress is reset if the checkpoint belongs to another source
+func parseCloneProgress(metadata map[string]string, source string) cloneProgress {
+	var p cloneProgress
+	if metadata[cloneSourceMetadata] != source {
+		return p
+	}
+	p.completed = metadata[cloneStateMetadata] == cloneStateCompleted
+	p.marker = metadata[cloneMarkerMetadata]
+	p.copiedBlobs, _ = strconv.ParseInt(metadata[cloneCopiedBlobsMetadata], 10, 64)
+	p.copiedBytes, _ = strconv.ParseInt(metadata[cloneCopiedBytesMetadata], 10, 64)
+	return p
+}
+
+// setCheckpoint records the progress in metadata, other metadata is kept
+func (p cloneProgress) setCheckpoint(metadata map[string]string, source string) {
+	metadata[cloneSourceMetadata] = source
+	metadata[cloneStateMetadata] = cloneStateInProgress
+	if p.completed {
+		metadata[cloneStateMetadata] = cloneStateCompleted
+	}
+	metadata[cloneMarkerMetadata] = p.marker
+	metadata[cloneCopiedBlobsMetadata] = strconv.FormatInt(p.copiedBlobs, 10)
+	metadata[cloneCopiedBytesMetadata] = strconv.FormatInt(p.copiedBytes, 10)
+}
+
+// nativeCopyJob is a running copy job of a destination container
+type nativeCopyJob struct {
+	copiedBlobs atomic.Int64
+	copiedBytes atomic.Int64
+	done        chan struct{}
+	err         error
+	// cancel stops the background copy of async clone
+	cancel context.CancelFunc
+}
+
+func newNativeCopyJob() *nativeCopyJob {
+	return &nativeCopyJob{done: make(chan struct{})}
+}
+
+func (j *nativeCopyJob) progress() cloneProgress {
+	return cloneProgress{copiedBlobs: j.copiedBlobs.Load(), copiedBytes: j.copiedBytes.Load()}
+}
+
+// copyContainer copies all blobs page by page with bounded parallelism, a checkpoint is saved in destination container metadata after every page
+func copyContainer(ctx context.Context, client cloneCopyClient, source string, parallelism int, job *nativeCopyJob) error {
+	metadata, err := client.GetDestinationMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get destination container metadata: %w", err)
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	if parallelism <= 0 {
+		parallelism = defaultCloneCopyParallelism
+	}
+	progress := parseCloneProgress(metadata, source)
+	job.copiedBlobs.Store(progress.copiedBlobs)
+	job.copiedBytes.Store(progress.copiedBytes)
+	if progress.completed {
+		klog.V(2).Infof("copy from %s is already completed, %s", source, progress)
+		return nil
+	}
+	if progress.marker != "" {
+		klog.V(2).Infof("resume copy from %s at checkpoint, %s", source, progress)
+	}
+
+	for {
+		blobs, nextMarker, err := client.ListSourceBlobs(ctx, progress.marker)
+		if err != nil {
+			return fmt.Errorf("failed to list source blobs: %w", err)
+		}
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(parallelism)
+		for i := range blobs {
+			b := blobs[i]
+			g.Go(func() error {
+				if err := client.CopyBlob(gctx, b); err != nil {
+					return fmt.Errorf("failed to copy blob(%s): %w", b.name, err)
+				}
+				job.copiedBlobs.Add(1)
+				job.copiedBytes.Add(b.size)
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+
+		progress = job.progress()
+		progress.marker = nextMarker
+		progress.completed = nextMarker == ""
+		progress.setCheckpoint(metadata, source)
+		if err := client.SetDestinationMetadata(ctx, metadata); err != nil {
+			return fmt.Errorf("failed to save checkpoint in destination container metadata: %w", err)
+		}
+		klog.V(4).Infof("copy from %s, %s", source, progress)
+		if progress.completed {
+			return nil
+		}
+	}
+}
+
+// markCloneFailed records failed state in destination container metadata so that NodeStageVolume stops waiting for the copy
+func markCloneFailed(ctx context.Context, client cloneCopyClient, source string) error {
+	metadata, err := client.GetDestinationMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[cloneSourceMetadata] = source
+	metadata[cloneStateMetadata] = cloneStateFailed
+	return client.SetDestinationMetadata(ctx, metadata)
+}
+
+// waitForNativeCopy starts copy job of the destination container if it's not running and waits for its completion,
+// the job keeps running in background if timeout
+func (d *Driver) waitForNativeCopy(key, source string, client cloneCopyClient, timeout time.Duration) error {
+	value, loaded := d.nativeCopyJobs.LoadOrStore(key, newNativeCopyJob())
+	job := value.(*nativeCopyJob)
+	if !loaded {
+		go func() {
+			job.err = copyContainer(context.Background(), client, source, d.cloneCopyParallelism, job)
+			close(job.done)
+		}()
+	}
+
+	select {
+	case <-job.done:
+		d.nativeCopyJobs.Delete(key)
+		return job.err
+	case <-time.After(timeout):
+		return fmt.Errorf("timeout waiting for copy from %s to %s complete, %s", source, key, job.progress())
+	}
+}
+
+// copyBlobContainerNative copies the source container to destination container with server side copy and waits for completion
+func (d *Driver) copyBlobContainerNative(ctx context.Context, srcAccountName, srcContainerName, srcSasToken, dstAccountName, dstContainerName, dstSasToken, storageEndpointSuffix string) error {
+	client, err := d.newNativeCopyClient(ctx, srcAccountName, srcContainerName, srcSasToken, dstAccountName, dstContainerName, dstSasToken, storageEndpointSuffix)
+	if err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("copy blob container %s:%s to %s:%s with native copy", srcAccountName, srcContainerName, dstAccountName, dstContainerName)
+	source := srcAccountName + "/" + srcContainerName
+	if err := d.waitForNativeCopy(dstAccountName+"/"+dstContainerName, source, client, time.Duration(d.waitForAzCopyTimeoutMinutes)*time.Minute); err != nil {
+		klog.Warningf("copy blob container %s to %s:%s failed with error: %v", source, dstAccountName, dstContainerName, err)
+		return err
+	}
+	klog.V(2).Infof("copied blob container %s to %s:%s successfully", source, dstAccountName, dstContainerName)
+	return nil
+}
+
+// newNativeCopyClient creates copy client of the source and destination containers, source SAS token is generated
+// with cluster identity if it's empty, and destination is authorized by cluster identity if its SAS token is empty
+func (d *Driver) newNativeCopyClient(ctx context.Context, srcAccountName, srcContainerName, srcSasToken, dstAccountName, dstContainerName, dstSasToken, storageEndpointSuffix string) (cloneCopyClient, error) {
+	if srcSasToken == "" {
+		if d.userDelegationSASFactory == nil {
+			return nil, fmt.Errorf("user delegation sas factory is nil")
+		}
+		var err error
+		if srcSasToken, err = d.userDelegationSASFactory.GetContainerReadSAS(ctx, srcAccountName, storageEndpointSuffix, srcContainerName, time.Duration(d.sasTokenExpirationMinutes)*time.Minute); err != nil {
+			return nil, fmt.Errorf("failed to get user delegation sas token of source container(%s) account(%s): %w", srcContainerName, srcAccountName, err)
+		}
+	}
+	credential, err := d.getCopyDestinationCredential(dstAccountName, dstSasToken)
+	if err != nil {
+		return nil, err
+	}
+	srcURL := fmt.Sprintf("https://%s.blob.%s/%s%s", srcAccountName, storageEndpointSuffix, srcContainerName, srcSasToken)
+	dstURL := fmt.Sprintf("https://%s.blob.%s/%s%s", dstAccountName, storageEndpointSuffix, dstContainerName, dstSasToken)
+	return d.cloneCopyClientFactory.NewCloneCopyClient(srcURL, dstURL, credential)
+}
+
+// getCopyDestinationCredential returns cluster identity credential if there is no SAS token of destination account
+func (d *Driver) getCopyDestinationCredential(dstAccountName, dstSasToken string) (azcore.TokenCredential, error) {
+	if dstSasToken != "" {
+		return nil, nil
+	}
+	if d.cloud == nil || d.cloud.AuthProvider == nil {
+		return nil, fmt.Errorf("cluster identity is not available to access destination account(%s)", dstAccountName)
+	}
+	return d.cloud.AuthProvider.GetAzIdentity(), nil
+}
+
+type azblobCloneCopyClientFactory struct {
+	limiter *adaptiveRateLimiter
+}
+
+type azblobCloneCopyClient struct {
+	src *container.Client
+	dst *container.Client
+}
+
+func (f *azblobCloneCopyClientFactory) NewCloneCopyClient(srcContainerURL, dstContainerURL string, dstCredential azcore.TokenCredential) (cloneCopyClient, error) {
+	options := &container.ClientOptions{ClientOptions: f.limiter.clientOptions()}
+	src, err := container.NewClientWithNoCredential(srcContainerURL, options)
+	if err != nil {
+		return nil, err
+	}
+	var dst *container.Client
+	if dstCredential != nil {
+		dst, err = container.NewClient(dstContainerURL, dstCredential, options)
+	} else {
+		dst, err = container.NewClientWithNoCredential(dstContainerURL, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &azblobCloneCopyClient{src: src, dst: dst}, nil
+}
+
+func (c *azblobCloneCopyClient) ListSourceBlobs(ctx context.Context, marker string) ([]copySourceBlob, string, error) {
+	options := &container.ListBlobsFlatOptions{Include: container.ListBlobsInclude{Metadata: true}}
+	if marker != "" {
+		options.Marker = &marker
+	}
+	resp, err := c.src.NewListBlobsFlatPager(options).NextPage(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	var blobs []copySourceBlob
+	for _, item := range resp.Segment.BlobItems {
+		if item.Name == nil {
+			continue
+		}
+		b := copySourceBlob{name: *item.Name, metadata: item.Metadata}
+		if props := item.Properties; props != nil {
+			b.size = ptr.Deref(props.ContentLength, 0)
+			b.blobType = ptr.Deref(props.BlobType, "")
+			b.lastModified = ptr.Deref(props.LastModified, time.Time{})
+			b.headers = &blob.HTTPHeaders{
+				BlobContentType:        props.ContentType,
+				BlobContentEncoding:    props.ContentEncoding,
+				BlobContentLanguage:    props.ContentLanguage,
+				BlobContentDisposition: props.ContentDisposition,
+				BlobCacheControl:       props.CacheControl,
+				BlobContentMD5:         props.ContentMD5,
+			}
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, ptr.Deref(resp.NextMarker, ""), nil
+}
+
+func (c *azblobCloneCopyClient) CopyBlob(ctx context.Context, b copySourceBlob) error {
+	if b.blobType != "" && b.blobType != blob.BlobTypeBlockBlob {
+		return fmt.Errorf("blob type %s is not supported", b.blobType)
+	}
+	dst := c.dst.NewBlockBlobClient(b.name)
+	srcURL := c.src.NewBlobClient(b.name).URL()
+	switch {
+	case b.size == 0:
+		// e.g. directory marker of blobfuse
+		_, err := dst.Upload(ctx, streaming.NopCloser(bytes.NewReader(nil)), &blockblob.UploadOptions{Metadata: b.metadata, HTTPHeaders: b.headers})
+		return err
+	case b.size <= maxPutBlobFromURLSize:
+		_, err := dst.UploadBlobFromURL(ctx, srcURL, &blockblob.UploadBlobFromURLOptions{Metadata: b.metadata})
+		return err
+	}
+
+	var blockIDs []string
+	for offset := int64(0); offset < b.size; offset += copyBlockSize {
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%016d", offset)))
+		_, err := dst.StageBlockFromURL(ctx, blockID, srcURL, &blockblob.StageBlockFromURLOptions{
+			Range: blob.HTTPRange{Offset: offset, Count: min(copyBlockSize, b.size-offset)},
+		})
+		if err != nil {
+			return err
+		}
+		blockIDs = append(blockIDs, blockID)
+	}
+	_, err := dst.CommitBlockList(ctx, blockIDs, &blockblob.CommitBlockListOptions{Metadata: b.metadata, HTTPHeaders: b.headers})
+	return err
+}
+
+func (c *azblobCloneCopyClient) GetDestinationMetadata(ctx context.Context) (map[string]string, error) {
+	resp, err := c.dst.GetProperties(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]string{}
+	for k, v := range resp.Metadata {
+		if v != nil {
+			// metadata keys in response headers are canonicalized
+			metadata[strings.ToLower(k)] = *v
+		}
+	}
+	return metadata, nil
+}
+
+func (c *azblobCloneCopyClient) SetDestinationMetadata(ctx context.Context, metadata map[string]string) error {
+	m := make(map[string]*string, len(metadata))
+	for k, v := range metadata {
+		m[k] = ptr.To(v)
+	}
+	_, err := c.dst.SetMetadata(ctx, &container.SetMetadataOptions{Metadata: m})
+	return err
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloneCopyClient lists source blobs in pages of pageSize and records copied blobs
+type fakeCloneCopyClient struct {
+	mu       sync.Mutex
+	blobs    []copySourceBlob
+	pageSize int
+	copied   []string
+	metadata map[string]string
+	// failBlob fails copy of the blob once
+	failBlob string
+	// block blocks copy until it's closed
+	block chan struct{}
+
+	srcURL, dstURL string
+	dstCredential  azcore.TokenCredential
+}
+
+func (c *fakeCloneCopyClient) NewCloneCopyClient(srcContainerURL, dstContainerURL string, dstCredential azcore.TokenCredential) (cloneCopyClient, error) {
+	c.srcURL, c.dstURL, c.dstCredential = srcContainerURL, dstContainerURL, dstCredential
+	return c, nil
+}
+
+func (c *fakeCloneCopyClient) ListSourceBlobs(_ context.Context, marker string) ([]copySourceBlob, string, error) {
+	start := 0
+	if marker != "" {
+		start, _ = strconv.Atoi(marker)
+	}
+	end := min(start+c.pageSize, len(c.blobs))
+	next := ""
+	if end < len(c.blobs) {
+		next = strconv.Itoa(end)
+	}
+	return c.blobs[start:end], next, nil
+}
+
+func (c *fakeCloneCopyClient) CopyBlob(_ context.Context, b copySourceBlob) error {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b.name == c.failBlob {
+		c.failBlob = ""
+		return fmt.Errorf("test error")
+	}
+	c.copied = append(c.copied, b.name)
+	return nil
+}
+
+func (c *fakeCloneCopyClient) GetDestinationMetadata(_ context.Context) (map[string]string, error) {
+	metadata := map[string]string{}
+	for k, v := range c.metadata {
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+func (c *fakeCloneCopyClient) SetDestinationMetadata(_ context.Context, metadata map[string]string) error {
+	c.metadata = map[string]string{}
+	for k, v := range metadata {
+		c.metadata[k] = v
+	}
+	return nil
+}
+
+func newFakeCloneCopyClient(n int) *fakeCloneCopyClient {
+	c := &fakeCloneCopyClient{pageSize: 2, metadata: map[string]string{createdByMetadata: "blob.csi.azure.com"}}
+	for i := 0; i < n; i++ {
+		c.blobs = append(c.blobs, copySourceBlob{name: fmt.Sprintf("blob%d", i), size: 10})
+	}
+	return c
+}
+
+func TestCloneProgressCheckpoint(t *testing.T) {
+	metadata := map[string]string{createdByMetadata: "blob.csi.azure.com"}
+	progress := cloneProgress{marker: "next", copiedBlobs: 3, copiedBytes: 30}
+	progress.setCheckpoint(metadata, "account/container")
+	assert.Equal(t, "blob.csi.azure.com", metadata[createdByMetadata])
+	assert.Equal(t, progress, parseCloneProgress(metadata, "account/container"))
+	assert.Equal(t, "state: inprogress, copied blobs: 3, copied bytes: 30", progress.String())
+	// checkpoint of another source is ignored
+	assert.Equal(t, cloneProgress{}, parseCloneProgress(metadata, "account/other"))
+}
+
+func TestCopyContainer(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeCloneCopyClient(5)
+	client.failBlob = "blob3"
+
+	job := newNativeCopyJob()
+	assert.Error(t, copyContainer(ctx, client, "account/src", 2, job))
+	// the first page is saved in checkpoint
+	assert.Equal(t, "2", client.metadata[cloneMarkerMetadata])
+	assert.Equal(t, "2", client.metadata[cloneCopiedBlobsMetadata])
+	assert.Equal(t, cloneStateInProgress, client.metadata[cloneStateMetadata])
+
+	// resume from checkpoint after restart
+	client.copied = nil
+	job = newNativeCopyJob()
+	require.NoError(t, copyContainer(ctx, client, "account/src", 2, job))
+	assert.ElementsMatch(t, []string{"blob2", "blob3", "blob4"}, client.copied)
+	assert.Equal(t, cloneProgress{copiedBlobs: 5, copiedBytes: 50}, job.progress())
+	assert.Equal(t, cloneStateCompleted, client.metadata[cloneStateMetadata])
+	assert.Equal(t, "blob.csi.azure.com", client.metadata[createdByMetadata])
+
+	// completed copy is skipped
+	client.copied = nil
+	require.NoError(t, copyContainer(ctx, client, "account/src", 2, newNativeCopyJob()))
+	assert.Empty(t, client.copied)
+
+	// copy from another source starts over
+	require.NoError(t, copyContainer(ctx, client, "account/other", 0, newNativeCopyJob()))
+	assert.Len(t, client.copied, 5)
+}
+
+func TestWaitForNativeCopy(t *testing.T) {
+	d := NewFakeDriver()
+	client := newFakeCloneCopyClient(3)
+	client.block = make(chan struct{})
+
+	err := d.waitForNativeCopy("account/dst", "account/src", client, 10*time.Millisecond)
+	assert.ErrorContains(t, err, "timeout waiting for copy from account/src to account/dst complete")
+
+	// the job keeps running and is not started again
+	close(client.block)
+	assert.NoError(t, d.waitForNativeCopy("account/dst", "account/src", newFakeCloneCopyClient(1), time.Minute))
+	assert.Len(t, client.copied, 3)
+	_, ok := d.nativeCopyJobs.Load("account/dst")
+	assert.False(t, ok)
+}
+
+func TestCopyBlobContainerNative(t *testing.T) {
+	d := NewFakeDriver()
+	client := newFakeCloneCopyClient(1)
+	d.cloneCopyClientFactory = client
+	sasFactory := &fakeUserDelegationSASFactory{sasToken: "?sig=delegation"}
+	d.userDelegationSASFactory = sasFactory
+
+	err := d.copyBlobContainerNative(context.Background(), "account", "src", "", "account", "dst", "?sig=key", "core.windows.net")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://account.blob.core.windows.net/src?sig=delegation", client.srcURL)
+	assert.Equal(t, "https://account.blob.core.windows.net/dst?sig=key", client.dstURL)
+	assert.Nil(t, client.dstCredential)
+	assert.Equal(t, []string{"blob0"}, client.copied)
+
+	// destination could not be authorized without sas token and cluster identity
+	err = d.copyBlobContainerNative(context.Background(), "account", "src", "?sig=key", "account", "dst", "", "core.windows.net")
+	assert.ErrorContains(t, err, "cluster identity is not available")
+
+	sasFactory.err = fmt.Errorf("AuthorizationPermissionMismatch")
+	err = d.copyBlobContainerNative(context.Background(), "account", "src", "", "account", "dst", "?sig=key", "core.windows.net")
+	assert.ErrorContains(t, err, "AuthorizationPermissionMismatch")
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+const (
+	credentialSourceSecret   = "secret"
+	credentialSourceKeyVault = "keyvault"
+
+	credentialCacheHit  = "hit"
+	credentialCacheMiss = "miss"
+)
+
+var (
+	credentialCacheRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      "blob_csi_driver",
+			Name:           "credential_cache_requests_total",
+			Help:           "Number of credential lookups served by the node credential cache, partitioned by source and result (hit or miss)",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"source", "result"},
+	)
+	registerCredentialCacheMetricsOnce sync.Once
+)
+
+func registerCredentialCacheMetrics() {
+	registerCredentialCacheMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(credentialCacheRequests)
+	})
+}
+
+// credentialCacheKeys records the credential cache entries used by one request, so that they could be evicted if the request fails
+type credentialCacheKeys struct {
+	mu              sync.Mutex
+	secrets         []string
+	keyVaultSecrets []string
+}
+
+type credentialCacheKeysContextKey struct{}
+
+// withCredentialCacheKeys returns a context which records the credential cache entries used with it
+func withCredentialCacheKeys(ctx context.Context) (context.Context, *credentialCacheKeys) {
+	keys := &credentialCacheKeys{}
+	return context.WithValue(ctx, credentialCacheKeysContextKey{}, keys), keys
+}
+
+func recordCredentialCacheKey(ctx context.Context, source, key string) {
+	keys, ok := ctx.Value(credentialCacheKeysContextKey{}).(*credentialCacheKeys)
+	if !ok {
+		return
+	}
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	if source == credentialSourceKeyVault {
+		keys.keyVaultSecrets = append(keys.keyVaultSecrets, key)
+	} else {
+		keys.secrets = append(keys.secrets, key)
+	}
+}
+
+// evictCredentialCache evicts the credential cache entries used by a failed mount, the credential could have been rotated,
+// so it is fetched again on retry instead of failing with the stale credential until the cache entry expires
+func (d *Driver) evictCredentialCache(keys *credentialCacheKeys) {
+	if keys == nil {
+		return
+	}
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	for _, key := range keys.secrets {
+		if d.secretCache != nil {
+			klog.V(2).Infof("evict secret(%s) from credential cache", key)
+			_ = d.secretCache.Delete(key)
+		}
+	}
+	for _, key := range keys.keyVaultSecrets {
+		if d.keyVaultSecretCache != nil {
+			klog.V(2).Infof("evict key vault secret(%s) from credential cache", key)
+			_ = d.keyVaultSecretCache.Delete(key)
+		}
+	}
+}
+
+// setupCredentialCache sets up secret and key vault secret caches, both expire by TTL,
+// secrets are also evicted when they are updated or deleted, see startCredentialCacheInformer
+func (d *Driver) setupCredentialCache(ttl time.Duration) error {
+	registerCredentialCacheMetrics()
+	getter := func(_ string) (interface{}, error) { return nil, nil }
+	var err error
+	if d.secretCache, err = azcache.NewTimedCache(ttl, getter, false); err != nil {
+		return err
+	}
+	d.keyVaultSecretCache, err = azcache.NewTimedCache(ttl, getter, false)
+	return err
+}
+
+// startCredentialCacheInformer watches secrets to evict the cached secret once it is updated or deleted, e.g. account key rotation,
+// secret data is dropped from the informer cache since secrets are still read from the api server on cache miss
+func (d *Driver) startCredentialCacheInformer(ctx context.Context) {
+	if d.secretCache == nil || d.KubeClient == nil {
+		return
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(d.KubeClient, 0, informers.WithTransform(stripSecretData))
+	_, err := factory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, obj interface{}) { d.evictSecretFromCache(obj) },
+		DeleteFunc: d.evictSecretFromCache,
+	})
+	if err != nil {
+		klog.Errorf("failed to add event handler of secret informer: %v", err)
+		return
+	}
+	factory.Start(ctx.Done())
+}
+
+// stripSecretData drops secret data and managed fields before the secret is stored in informer cache
+func stripSecretData(obj interface{}) (interface{}, error) {
+	if secret, ok := obj.(*v1.Secret); ok {
+		secret.Data = nil
+		secret.StringData = nil
+		secret.ManagedFields = nil
+	}
+	return obj, nil
+}
+
+func (d *Driver) evictSecretFromCache(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.Warningf("failed to get key of secret: %v", err)
+		return
+	}
+	if cached, _ := d.secretCache.Get(key, azcache.CacheReadTypeDefault); cached != nil {
+		klog.V(2).Infof("secret(%s) is updated or deleted, evict it from credential cache", key)
+		_ = d.secretCache.Delete(key)
+	}
+}
+
+// getSecret gets secret from the api server, secret is cached with TTL if credential cache is enabled
+func (d *Driver) getSecret(ctx context.Context, secretName, secretNamespace string) (*v1.Secret, error) {
+	if d.secretCache == nil {
+		return d.KubeClient.CoreV1().Secrets(secretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	}
+
+	key := secretNamespace + "/" + secretName
+	recordCredentialCacheKey(ctx, credentialSourceSecret, key)
+	cached, err := d.secretCache.Get(key, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		credentialCacheRequests.WithLabelValues(credentialSourceSecret, credentialCacheHit).Inc()
+		return cached.(*v1.Secret), nil
+	}
+	credentialCacheRequests.WithLabelValues(credentialSourceSecret, credentialCacheMiss).Inc()
+
+	secret, err := d.KubeClient.CoreV1().Secrets(secretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	d.secretCache.Set(key, secret)
+	return secret, nil
+}
+
+// getKeyVaultSecretWithCache gets secret from key vault, secret is cached with TTL if credential cache is enabled
+func (d *Driver) getKeyVaultSecretWithCache(ctx context.Context, vaultURL, secretName, secretVersion string) (*keyVaultSecret, error) {
+	if d.keyVaultSecretCache == nil {
+		return d.getKeyVaultSecret(ctx, vaultURL, secretName, secretVersion)
+	}
+
+	key := strings.Join([]string{strings.TrimSuffix(strings.ToLower(vaultURL), "/"), secretName, secretVersion}, separator)
+	recordCredentialCacheKey(ctx, credentialSourceKeyVault, key)
+	cached, err := d.keyVaultSecretCache.Get(key, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		credentialCacheRequests.WithLabelValues(credentialSourceKeyVault, credentialCacheHit).Inc()
+		return cached.(*keyVaultSecret), nil
+	}
+	credentialCacheRequests.WithLabelValues(credentialSourceKeyVault, credentialCacheMiss).Inc()
+
+	secret, err := d.getKeyVaultSecret(ctx, vaultURL, secretName, secretVersion)
+	if err != nil {
+		return nil, err
+	}
+	d.keyVaultSecretCache.Set(key, secret)
+	return secret, nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/testutil"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+func getCredentialCacheRequests(t *testing.T, source, result string) float64 {
+	value, err := testutil.GetCounterMetricValue(credentialCacheRequests.WithLabelValues(source, result))
+	assert.NoError(t, err)
+	return value
+}
+
+func TestGetSecretWithCredentialCache(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "ns"},
+		Data:       map[string][]byte{defaultSecretAccountName: []byte("account")},
+	}
+
+	d := NewFakeDriver()
+	d.KubeClient = fake.NewSimpleClientset(secret)
+	assert.NoError(t, d.setupCredentialCache(time.Minute))
+
+	tests := []struct {
+		desc            string
+		secretName      string
+		expectedAccount string
+		expectedResult  string
+		expectErr       bool
+	}{
+		{
+			desc:            "secret not in cache, get from api server",
+			secretName:      "secret",
+			expectedAccount: "account",
+			expectedResult:  credentialCacheMiss,
+		},
+		{
+			desc:            "secret found in cache",
+			secretName:      "secret",
+			expectedAccount: "account",
+			expectedResult:  credentialCacheHit,
+		},
+		{
+			desc:           "secret not found",
+			secretName:     "notfound",
+			expectedResult: credentialCacheMiss,
+			expectErr:      true,
+		},
+		{
+			desc:           "secret not found is not cached",
+			secretName:     "notfound",
+			expectedResult: credentialCacheMiss,
+			expectErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		before := getCredentialCacheRequests(t, credentialSourceSecret, test.expectedResult)
+		secret, err := d.getSecret(context.Background(), test.secretName, "ns")
+		assert.Equal(t, test.expectErr, err != nil, test.desc)
+		if err == nil {
+			assert.Equal(t, test.expectedAccount, string(secret.Data[defaultSecretAccountName]), test.desc)
+		}
+		assert.Equal(t, before+1, getCredentialCacheRequests(t, credentialSourceSecret, test.expectedResult), test.desc)
+	}
+
+	// cached secret is returned until it expires even if it's deleted from api server
+	assert.NoError(t, d.KubeClient.CoreV1().Secrets("ns").Delete(context.Background(), "secret", metav1.DeleteOptions{}))
+	_, err := d.getSecret(context.Background(), "secret", "ns")
+	assert.NoError(t, err)
+}
+
+func TestGetSecretWithoutCredentialCache(t *testing.T) {
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "ns"}}
+	d := NewFakeDriver()
+	d.KubeClient = fake.NewSimpleClientset(secret)
+
+	hits := getCredentialCacheRequests(t, credentialSourceSecret, credentialCacheHit)
+	misses := getCredentialCacheRequests(t, credentialSourceSecret, credentialCacheMiss)
+	result, err := d.getSecret(context.Background(), "secret", "ns")
+	assert.NoError(t, err)
+	assert.Equal(t, secret, result)
+	assert.Equal(t, hits, getCredentialCacheRequests(t, credentialSourceSecret, credentialCacheHit))
+	assert.Equal(t, misses, getCredentialCacheRequests(t, credentialSourceSecret, credentialCacheMiss))
+}
+
+func TestGetKeyVaultSecretWithCache(t *testing.T) {
+	d := NewFakeDriver()
+	assert.NoError(t, d.setupCredentialCache(time.Minute))
+
+	vaultURL := "https://vault.vault.azure.net/"
+	key := strings.Join([]string{"https://vault.vault.azure.net", "secret", "version"}, separator)
+	d.keyVaultSecretCache.Set(key, &keyVaultSecret{content: "cachedcontent", contentType: "application/x-pem-file"})
+
+	hits := getCredentialCacheRequests(t, credentialSourceKeyVault, credentialCacheHit)
+	secret, err := d.getKeyVaultSecretWithCache(context.Background(), vaultURL, "secret", "version")
+	assert.NoError(t, err)
+	assert.Equal(t, &keyVaultSecret{content: "cachedcontent", contentType: "application/x-pem-file"}, secret)
+	assert.Equal(t, hits+1, getCredentialCacheRequests(t, credentialSourceKeyVault, credentialCacheHit))
+
+	// cache miss, key vault request fails with canceled context, error should not be cached
+	misses := getCredentialCacheRequests(t, credentialSourceKeyVault, credentialCacheMiss)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 2; i++ {
+		_, err = d.getKeyVaultSecretWithCache(ctx, vaultURL, "othersecret", "")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, misses+2, getCredentialCacheRequests(t, credentialSourceKeyVault, credentialCacheMiss))
+}
+
+func TestEvictCredentialCache(t *testing.T) {
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "ns"}}
+	d := NewFakeDriver()
+	d.KubeClient = fake.NewSimpleClientset(secret)
+	assert.NoError(t, d.setupCredentialCache(time.Minute))
+
+	vaultURL := "https://vault.vault.azure.net/"
+	vaultKey := strings.Join([]string{"https://vault.vault.azure.net", "secret", ""}, separator)
+	d.keyVaultSecretCache.Set(vaultKey, &keyVaultSecret{content: "cachedcontent"})
+
+	ctx, keys := withCredentialCacheKeys(context.Background())
+	_, err := d.getSecret(ctx, "secret", "ns")
+	assert.NoError(t, err)
+	_, err = d.getKeyVaultSecretWithCache(ctx, vaultURL, "secret", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ns/secret"}, keys.secrets)
+	assert.Equal(t, []string{vaultKey}, keys.keyVaultSecrets)
+
+	d.evictCredentialCache(keys)
+	cached, err := d.secretCache.Get("ns/secret", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Nil(t, cached)
+	cached, err = d.keyVaultSecretCache.Get(vaultKey, azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Nil(t, cached)
+}
+
+func TestCredentialCacheInformer(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "ns"},
+		Data:       map[string][]byte{defaultSecretAccountKey: []byte("key")},
+	}
+	d := NewFakeDriver()
+	d.KubeClient = fake.NewSimpleClientset(secret)
+	assert.NoError(t, d.setupCredentialCache(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.startCredentialCacheInformer(ctx)
+
+	_, err := d.getSecret(ctx, "secret", "ns")
+	assert.NoError(t, err)
+
+	// rotated secret is evicted from the cache and read from api server again
+	rotated := secret.DeepCopy()
+	rotated.Data[defaultSecretAccountKey] = []byte("rotatedkey")
+	assert.Eventually(t, func() bool {
+		_, err := d.KubeClient.CoreV1().Secrets("ns").Update(ctx, rotated, metav1.UpdateOptions{})
+		if err != nil {
+			return false
+		}
+		result, err := d.getSecret(ctx, "secret", "ns")
+		return err == nil && string(result.Data[defaultSecretAccountKey]) == "rotatedkey"
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestStripSecretData(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "ns", ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}},
+		Data:       map[string][]byte{defaultSecretAccountKey: []byte("key")},
+		StringData: map[string]string{defaultSecretAccountName: "account"},
+	}
+	obj, err := stripSecretData(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "ns"}}, obj)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronSearchYears bounds the search of next schedule time, e.g. "0 0 30 2 *" never matches
+const maxCronSearchYears = 5
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is a parsed standard 5-field cron expression (minute hour day-of-month month day-of-week) in UTC
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// a day matches either day-of-month or day-of-week if both are restricted
+	domStar, dowStar bool
+}
+
+// parseCronSchedule parses cron expression, numeric values, "*", ranges, steps, lists and macros such as @daily are supported
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron schedule(%s): expected 5 fields, got %d", spec, len(fields))
+	}
+	s := &cronSchedule{}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute in cron schedule(%s): %w", spec, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour in cron schedule(%s): %w", spec, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month in cron schedule(%s): %w", spec, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month in cron schedule(%s): %w", spec, err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week in cron schedule(%s): %w", spec, err)
+	}
+	// 7 is also Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of "*", "n", "a-b" with optional "/step" into a bitset
+func parseCronField(field string, minValue, maxValue int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step(%s)", stepPart)
+			}
+		}
+		start, end := minValue, maxValue
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			lo, hi, _ := strings.Cut(rangePart, "-")
+			var err1, err2 error
+			start, err1 = strconv.Atoi(lo)
+			end, err2 = strconv.Atoi(hi)
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range(%s)", rangePart)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value(%s)", rangePart)
+			}
+			start = value
+			if !hasStep {
+				end = value
+			}
+		}
+		if start < minValue || end > maxValue || start > end {
+			return 0, fmt.Errorf("value(%s) out of range [%d-%d]", rangePart, minValue, maxValue)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first schedule time after t in UTC, zero time is returned if there is no match in maxCronSearchYears
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxCronSearchYears, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
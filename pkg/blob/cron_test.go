@@ -0,0 +1,130 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expectedErr string
+	}{
+		{spec: "*/15 * * * *"},
+		{spec: "0 2 * * 1-5"},
+		{spec: "5-10/2 0,12 1 */3 7"},
+		{spec: "@daily"},
+		{spec: "@Weekly"},
+		{spec: "* * * *", expectedErr: "expected 5 fields"},
+		{spec: "60 * * * *", expectedErr: "invalid minute"},
+		{spec: "* 24 * * *", expectedErr: "invalid hour"},
+		{spec: "* * 0 * *", expectedErr: "invalid day of month"},
+		{spec: "* * * 13 *", expectedErr: "invalid month"},
+		{spec: "* * * * 8", expectedErr: "invalid day of week"},
+		{spec: "*/0 * * * *", expectedErr: "invalid step"},
+		{spec: "10-5 * * * *", expectedErr: "out of range"},
+		{spec: "a * * * *", expectedErr: "invalid value"},
+		{spec: "@every 1h", expectedErr: "expected 5 fields"},
+	}
+
+	for _, test := range tests {
+		_, err := parseCronSchedule(test.spec)
+		if test.expectedErr == "" {
+			assert.NoError(t, err, test.spec)
+		} else {
+			assert.ErrorContains(t, err, test.expectedErr, test.spec)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{
+			spec:     "*/15 * * * *",
+			from:     time.Date(2024, 1, 2, 10, 7, 30, 0, time.UTC),
+			expected: time.Date(2024, 1, 2, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			spec:     "*/15 * * * *",
+			from:     time.Date(2024, 1, 2, 10, 15, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			spec:     "0 2 * * *",
+			from:     time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 3, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			spec:     "5-10/2 * * * *",
+			from:     time.Date(2024, 1, 2, 3, 7, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 2, 3, 9, 0, 0, time.UTC),
+		},
+		{
+			// Wednesday to Sunday
+			spec:     "@weekly",
+			from:     time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// 7 is Sunday
+			spec:     "0 0 * * 7",
+			from:     time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// day matches either day of month or day of week
+			spec:     "0 0 15 * 1",
+			from:     time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			spec:     "0 0 1 * *",
+			from:     time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			spec:     "30 4 29 2 *",
+			from:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2028, 2, 29, 4, 30, 0, 0, time.UTC),
+		},
+		{
+			// local time is converted to UTC
+			spec:     "0 * * * *",
+			from:     time.Date(2024, 1, 2, 10, 30, 0, 0, time.FixedZone("UTC+8", 8*3600)),
+			expected: time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			spec: "0 0 30 2 *",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		s, err := parseCronSchedule(test.spec)
+		require.NoError(t, err, test.spec)
+		assert.Equal(t, test.expected, s.next(test.from), test.spec)
+	}
+}
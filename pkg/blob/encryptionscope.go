@@ -0,0 +1,76 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// encryption scope name should be 3 to 63 alphanumeric characters
+	encryptionScopeRegex = regexp.MustCompile(`^[a-zA-Z0-9]{3,63}$`)
+
+	// container creation errors returned by management and data plane API when the encryption scope is invalid
+	encryptionScopeErrors = []string{
+		"EncryptionScope",
+		"encryption scope",
+	}
+)
+
+func isValidEncryptionScope(scope string) bool {
+	return encryptionScopeRegex.MatchString(scope)
+}
+
+// isEncryptionScopeError returns true if the container could not be created due to invalid encryption scope
+func isEncryptionScopeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, e := range encryptionScopeErrors {
+		if strings.Contains(err.Error(), e) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyEncryptionScope checks that the container is encrypted with the expected default encryption scope before mount,
+// so that blobs written by blobfuse are always encrypted with the scope
+func (d *Driver) verifyEncryptionScope(ctx context.Context, accountName, accountKey, storageEndpointSuffix, containerName, encryptionScope string, denyOverride bool) error {
+	client, err := d.containerDataClientFactory.NewContainerDataClient(accountName, accountKey, storageEndpointSuffix, containerName)
+	if err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	scope, deny, err := client.GetEncryptionScope(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get encryption scope of container(%s) account(%s): %v", containerName, accountName, err)
+	}
+	if !strings.EqualFold(scope, encryptionScope) {
+		return status.Errorf(codes.FailedPrecondition, "default encryption scope(%s) of container(%s) account(%s) does not match %s(%s) in volume context", scope, containerName, accountName, encryptionScopeField, encryptionScope)
+	}
+	if denyOverride && !deny {
+		return status.Errorf(codes.FailedPrecondition, "encryption scope override is allowed on container(%s) account(%s) while %s is set in volume context", containerName, accountName, denyEncryptionScopeOverrideField)
+	}
+	klog.V(2).Infof("container(%s) account(%s) is encrypted with %s(%s)", containerName, accountName, encryptionScopeField, scope)
+	return nil
+}
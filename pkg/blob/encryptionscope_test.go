@@ -0,0 +1,140 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/blobcontainerclient/mock_blobcontainerclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestIsValidEncryptionScope(t *testing.T) {
+	assert.True(t, isValidEncryptionScope("tenant1"))
+	assert.True(t, isValidEncryptionScope("ABC"))
+	assert.False(t, isValidEncryptionScope("ab"))
+	assert.False(t, isValidEncryptionScope("tenant-1"))
+	assert.False(t, isValidEncryptionScope(""))
+}
+
+func TestIsEncryptionScopeError(t *testing.T) {
+	assert.False(t, isEncryptionScopeError(nil))
+	assert.False(t, isEncryptionScopeError(fmt.Errorf("ContainerBeingDeleted")))
+	assert.True(t, isEncryptionScopeError(fmt.Errorf("ERROR CODE: EncryptionScopeNotFound")))
+	assert.True(t, isEncryptionScopeError(fmt.Errorf("the specified encryption scope is disabled")))
+}
+
+func TestVerifyEncryptionScope(t *testing.T) {
+	tests := []struct {
+		desc          string
+		scope         string
+		deny          bool
+		clientErr     error
+		expectedScope string
+		expectedDeny  bool
+		expectedCode  codes.Code
+	}{
+		{
+			desc:          "scope matches",
+			scope:         "tenant1",
+			expectedScope: "Tenant1",
+		},
+		{
+			desc:          "scope and deny override match",
+			scope:         "tenant1",
+			deny:          true,
+			expectedScope: "tenant1",
+			expectedDeny:  true,
+		},
+		{
+			desc:          "scope does not match",
+			scope:         "tenant2",
+			expectedScope: "tenant1",
+			expectedCode:  codes.FailedPrecondition,
+		},
+		{
+			desc:          "override is allowed on container",
+			scope:         "tenant1",
+			expectedScope: "tenant1",
+			expectedDeny:  true,
+			expectedCode:  codes.FailedPrecondition,
+		},
+		{
+			desc:         "client error",
+			scope:        "tenant1",
+			clientErr:    fmt.Errorf("test error"),
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.containerDataClientFactory = &fakeContainerDataClient{encryptionScope: test.scope, denyEncryptionScopeOverride: test.deny, err: test.clientErr}
+		err := d.verifyEncryptionScope(context.Background(), "account", "key", "core.windows.net", "container", test.expectedScope, test.expectedDeny)
+		assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+	}
+}
+
+func TestCreateBlobContainerWithEncryptionScope(t *testing.T) {
+	ctx := context.Background()
+	options := &blobContainerOptions{
+		metadata:                    map[string]string{pvNameContainerMetadata: "pv"},
+		encryptionScope:             "tenant1",
+		denyEncryptionScopeOverride: true,
+		storageEndpointSuffix:       "core.chinacloudapi.cn",
+	}
+
+	// data plane API
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	client := &fakeContainerDataClient{}
+	d.containerDataClientFactory = client
+	secrets := map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "key"}
+	assert.NoError(t, d.createBlobContainer(ctx, "", "rg", "account", "container", secrets, options))
+	assert.Equal(t, []string{"container"}, client.createdContainers)
+	assert.Equal(t, "core.chinacloudapi.cn", client.storageEndpointSuffix)
+	assert.Equal(t, &blobContainerOptions{
+		metadata:                    map[string]string{createdByMetadata: d.Name, pvNameContainerMetadata: "pv"},
+		encryptionScope:             "tenant1",
+		denyEncryptionScopeOverride: true,
+	}, client.createContainerOptions)
+
+	// management API
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	blobClient := mock_blobcontainerclient.NewMockInterface(ctrl)
+	clientFactory := mock_azclient.NewMockClientFactory(ctrl)
+	clientFactory.EXPECT().GetBlobContainerClientForSub(gomock.Any()).Return(blobClient, nil)
+	d.clientFactory = clientFactory
+	blobClient.EXPECT().CreateContainer(gomock.Any(), "rg", "account", "container", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _, _ string, container armstorage.BlobContainer) (*armstorage.BlobContainer, error) {
+			assert.Equal(t, "tenant1", ptr.Deref(container.ContainerProperties.DefaultEncryptionScope, ""))
+			assert.True(t, ptr.Deref(container.ContainerProperties.DenyEncryptionScopeOverride, false))
+			assert.Equal(t, "pv", ptr.Deref(container.ContainerProperties.Metadata[pvNameContainerMetadata], ""))
+			return &container, nil
+		})
+	assert.NoError(t, d.createBlobContainer(ctx, "", "rg", "account", "container", nil, options))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+const (
+	podNameField = "csi.storage.k8s.io/pod.name"
+)
+
+// newEventRecorder returns an event recorder which sends events to the api server, return nil if kubeClient is nil
+func newEventRecorder(kubeClient kubernetes.Interface, driverName, nodeID string) record.EventRecorder {
+	if kubeClient == nil {
+		return nil
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: driverName, Host: nodeID})
+}
+
+// getVolumeEventObject returns the object which events of a volume should be recorded on:
+// PVC if pvc name and namespace are in volume context, otherwise pod for inline volume
+func (d *Driver) getVolumeEventObject(ctx context.Context, attrib map[string]string) runtime.Object {
+	if d.KubeClient == nil {
+		return nil
+	}
+	if pvcName, pvcNamespace := getValueInMap(attrib, pvcNameKey), getValueInMap(attrib, pvcNamespaceKey); pvcName != "" && pvcNamespace != "" {
+		pvc, err := d.KubeClient.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("failed to get pvc(%s/%s) to record event: %v", pvcNamespace, pvcName, err)
+			return nil
+		}
+		return pvc
+	}
+	if podName, podNamespace := getValueInMap(attrib, podNameField), getValueInMap(attrib, podNamespaceField); podName != "" && podNamespace != "" {
+		pod, err := d.KubeClient.CoreV1().Pods(podNamespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("failed to get pod(%s/%s) to record event: %v", podNamespace, podName, err)
+			return nil
+		}
+		return pod
+	}
+	return nil
+}
+
+// recordVolumeEvent records an event on the PVC or pod of the volume, it only logs the event if there is no such object
+func (d *Driver) recordVolumeEvent(ctx context.Context, attrib map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+	if eventType == v1.EventTypeWarning {
+		klog.Warningf(reason+": "+messageFmt, args...)
+	} else {
+		klog.V(2).Infof(reason+": "+messageFmt, args...)
+	}
+	if d.eventRecorder == nil {
+		return
+	}
+	if obj := d.getVolumeEventObject(ctx, attrib); obj != nil {
+		d.eventRecorder.Eventf(obj, eventType, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRecordVolumeEvent(t *testing.T) {
+	pvc := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns"}}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "ns"}}
+
+	tests := []struct {
+		desc          string
+		attrib        map[string]string
+		expectedEvent string
+	}{
+		{
+			desc:          "event recorded on pvc",
+			attrib:        map[string]string{pvcNameKey: "pvc", pvcNamespaceKey: "ns"},
+			expectedEvent: "Warning TestReason test message vol",
+		},
+		{
+			desc:          "event recorded on pod of inline volume",
+			attrib:        map[string]string{podNameField: "pod", podNamespaceField: "ns"},
+			expectedEvent: "Warning TestReason test message vol",
+		},
+		{
+			desc:   "pvc not found",
+			attrib: map[string]string{pvcNameKey: "notfound", pvcNamespaceKey: "ns"},
+		},
+		{
+			desc: "no pvc or pod in volume context",
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.KubeClient = fake.NewSimpleClientset(pvc, pod)
+		recorder := record.NewFakeRecorder(10)
+		d.eventRecorder = recorder
+
+		d.recordVolumeEvent(context.Background(), test.attrib, v1.EventTypeWarning, "TestReason", "test message %s", "vol")
+		if test.expectedEvent == "" {
+			assert.Empty(t, recorder.Events, test.desc)
+		} else {
+			assert.Equal(t, test.expectedEvent, <-recorder.Events, test.desc)
+		}
+	}
+
+	// no event recorder
+	d := NewFakeDriver()
+	d.recordVolumeEvent(context.Background(), map[string]string{pvcNameKey: "pvc", pvcNamespaceKey: "ns"}, v1.EventTypeNormal, "TestReason", "test message")
+}
@@ -68,3 +68,16 @@ func TestGetPluginCapabilities(t *testing.T) {
 	assert.NotNil(t, resp)
 	assert.Equal(t, resp.XXX_sizecache, int32(0))
 }
+
+func TestGetPluginCapabilitiesWithTopology(t *testing.T) {
+	d := NewFakeDriver()
+	resp, err := d.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetCapabilities(), 1)
+
+	d.enableTopology = true
+	resp, err = d.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetCapabilities(), 2)
+	assert.Equal(t, csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS, resp.GetCapabilities()[1].GetService().GetType())
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// maximum immutability period of time-based retention policy
+	maxImmutabilityPeriodInDays = 146000
+)
+
+var (
+	// legal hold tag should be 3 to 23 alphanumeric characters
+	legalHoldTagRegex = regexp.MustCompile(`^[a-zA-Z0-9]{3,23}$`)
+
+	// container deletion errors returned by management and data plane API when the container is protected
+	containerProtectedErrors = []string{
+		"ContainerProtectedFromDeletion",
+		"legal hold",
+		"LegalHold",
+		"immutability policy",
+		"ImmutabilityPolicy",
+	}
+)
+
+// containerImmutabilityClient sets WORM policies on a container, it's implemented by
+// blobcontainerclient.Client through the embedded armstorage.BlobContainersClient
+type containerImmutabilityClient interface {
+	CreateOrUpdateImmutabilityPolicy(ctx context.Context, resourceGroupName string, accountName string, containerName string, options *armstorage.BlobContainersClientCreateOrUpdateImmutabilityPolicyOptions) (armstorage.BlobContainersClientCreateOrUpdateImmutabilityPolicyResponse, error)
+	SetLegalHold(ctx context.Context, resourceGroupName string, accountName string, containerName string, legalHold armstorage.LegalHold, options *armstorage.BlobContainersClientSetLegalHoldOptions) (armstorage.BlobContainersClientSetLegalHoldResponse, error)
+}
+
+// parseLegalHoldTags parses comma separated legal hold tags, tags are normalized to lower case
+func parseLegalHoldTags(tags string) ([]string, error) {
+	var result []string
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if !legalHoldTagRegex.MatchString(tag) {
+			return nil, fmt.Errorf("%s(%s) is invalid, every tag should be 3 to 23 alphanumeric characters", legalHoldTagsField, tag)
+		}
+		result = append(result, strings.ToLower(tag))
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%s is empty", legalHoldTagsField)
+	}
+	return result, nil
+}
+
+// hasImmutability returns true if any WORM policy is specified
+func (p *storageClassParameters) hasImmutability() bool {
+	return p.immutabilityPeriodInDays > 0 || len(p.legalHoldTags) > 0
+}
+
+// setContainerImmutability applies time-based retention policy and legal hold on the container with management API
+func (d *Driver) setContainerImmutability(ctx context.Context, subsID, resourceGroupName, accountName, containerName string, p *storageClassParameters) error {
+	blobClient, err := d.clientFactory.GetBlobContainerClientForSub(subsID)
+	if err != nil {
+		return err
+	}
+	client, ok := blobClient.(containerImmutabilityClient)
+	if !ok {
+		return fmt.Errorf("blob container client(%T) does not support immutability policy", blobClient)
+	}
+
+	if p.immutabilityPeriodInDays > 0 {
+		policy := &armstorage.ImmutabilityPolicy{
+			Properties: &armstorage.ImmutabilityPolicyProperty{
+				ImmutabilityPeriodSinceCreationInDays: to.Ptr(p.immutabilityPeriodInDays),
+				AllowProtectedAppendWrites:            p.allowProtectedAppendWrites,
+			},
+		}
+		klog.V(2).Infof("set immutability policy(%d days, allowProtectedAppendWrites: %v) on container(%s) account(%s) rg(%s)", p.immutabilityPeriodInDays, ptr.Deref(p.allowProtectedAppendWrites, false), containerName, accountName, resourceGroupName)
+		if _, err := client.CreateOrUpdateImmutabilityPolicy(ctx, resourceGroupName, accountName, containerName, &armstorage.BlobContainersClientCreateOrUpdateImmutabilityPolicyOptions{Parameters: policy}); err != nil {
+			return fmt.Errorf("failed to set immutability policy: %w", err)
+		}
+	}
+	if len(p.legalHoldTags) > 0 {
+		legalHold := armstorage.LegalHold{}
+		for _, tag := range p.legalHoldTags {
+			legalHold.Tags = append(legalHold.Tags, to.Ptr(tag))
+		}
+		klog.V(2).Infof("set legal hold(%v) on container(%s) account(%s) rg(%s)", p.legalHoldTags, containerName, accountName, resourceGroupName)
+		if _, err := client.SetLegalHold(ctx, resourceGroupName, accountName, containerName, legalHold, nil); err != nil {
+			return fmt.Errorf("failed to set legal hold: %w", err)
+		}
+	}
+	return nil
+}
+
+// isContainerProtectedError returns true if the container could not be deleted due to legal hold or immutability policy
+func isContainerProtectedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, e := range containerProtectedErrors {
+		if strings.Contains(err.Error(), e) {
+			return true
+		}
+	}
+	return false
+}
+
+// getContainerProtection describes legal hold and immutability policy of the container, returns empty string if unknown
+func (d *Driver) getContainerProtection(ctx context.Context, subsID, resourceGroupName, accountName, containerName string) string {
+	if d.clientFactory == nil {
+		return ""
+	}
+	blobClient, err := d.clientFactory.GetBlobContainerClientForSub(subsID)
+	if err != nil {
+		return ""
+	}
+	container, err := blobClient.Get(ctx, resourceGroupName, accountName, containerName)
+	if err != nil || container == nil || container.ContainerProperties == nil {
+		klog.Warningf("failed to get properties of container(%s) account(%s) rg(%s): %v", containerName, accountName, resourceGroupName, err)
+		return ""
+	}
+	props := container.ContainerProperties
+	var protections []string
+	if ptr.Deref(props.HasLegalHold, false) {
+		var tags []string
+		if props.LegalHold != nil {
+			for _, tag := range props.LegalHold.Tags {
+				if tag != nil && tag.Tag != nil {
+					tags = append(tags, *tag.Tag)
+				}
+			}
+		}
+		protections = append(protections, fmt.Sprintf("legal hold tags %v", tags))
+	}
+	if ptr.Deref(props.HasImmutabilityPolicy, false) && props.ImmutabilityPolicy != nil && props.ImmutabilityPolicy.Properties != nil {
+		policy := props.ImmutabilityPolicy.Properties
+		protections = append(protections, fmt.Sprintf("%s immutability policy of %d days", ptr.Deref(policy.State, ""), ptr.Deref(policy.ImmutabilityPeriodSinceCreationInDays, 0)))
+	}
+	return strings.Join(protections, ", ")
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/blobcontainerclient/mock_blobcontainerclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// fakeImmutabilityBlobContainerClient adds immutability operations to the mock blob container client
+type fakeImmutabilityBlobContainerClient struct {
+	*mock_blobcontainerclient.MockInterface
+	policy    *armstorage.ImmutabilityPolicy
+	legalHold *armstorage.LegalHold
+	err       error
+}
+
+func (c *fakeImmutabilityBlobContainerClient) CreateOrUpdateImmutabilityPolicy(_ context.Context, _, _, _ string, options *armstorage.BlobContainersClientCreateOrUpdateImmutabilityPolicyOptions) (armstorage.BlobContainersClientCreateOrUpdateImmutabilityPolicyResponse, error) {
+	c.policy = options.Parameters
+	return armstorage.BlobContainersClientCreateOrUpdateImmutabilityPolicyResponse{}, c.err
+}
+
+func (c *fakeImmutabilityBlobContainerClient) SetLegalHold(_ context.Context, _, _, _ string, legalHold armstorage.LegalHold, _ *armstorage.BlobContainersClientSetLegalHoldOptions) (armstorage.BlobContainersClientSetLegalHoldResponse, error) {
+	c.legalHold = &legalHold
+	return armstorage.BlobContainersClientSetLegalHoldResponse{}, c.err
+}
+
+func TestParseLegalHoldTags(t *testing.T) {
+	tests := []struct {
+		tags         string
+		expectedTags []string
+		expectErr    bool
+	}{
+		{tags: "audit", expectedTags: []string{"audit"}},
+		{tags: "Audit2024, case123,", expectedTags: []string{"audit2024", "case123"}},
+		{tags: "ab", expectErr: true},
+		{tags: "audit-2024", expectErr: true},
+		{tags: "abcdefghijklmnopqrstuvwx", expectErr: true},
+		{tags: " , ", expectErr: true},
+	}
+
+	for _, test := range tests {
+		tags, err := parseLegalHoldTags(test.tags)
+		assert.Equal(t, test.expectErr, err != nil, test.tags)
+		assert.Equal(t, test.expectedTags, tags, test.tags)
+	}
+}
+
+func TestIsContainerProtectedError(t *testing.T) {
+	assert.False(t, isContainerProtectedError(nil))
+	assert.False(t, isContainerProtectedError(fmt.Errorf("ContainerNotFound")))
+	assert.True(t, isContainerProtectedError(fmt.Errorf("ERROR CODE: ContainerProtectedFromDeletion")))
+	assert.True(t, isContainerProtectedError(fmt.Errorf("This operation is not permitted as the container has a legal hold")))
+	assert.True(t, isContainerProtectedError(fmt.Errorf("This operation is not permitted since the container has an immutability policy")))
+}
+
+func TestSetContainerImmutability(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	client := &fakeImmutabilityBlobContainerClient{MockInterface: mock_blobcontainerclient.NewMockInterface(ctrl)}
+	clientFactory := mock_azclient.NewMockClientFactory(ctrl)
+	clientFactory.EXPECT().GetBlobContainerClientForSub(gomock.Any()).Return(client, nil).AnyTimes()
+	d.clientFactory = clientFactory
+
+	p := &storageClassParameters{immutabilityPeriodInDays: 30, allowProtectedAppendWrites: ptr.To(true), legalHoldTags: []string{"audit", "case123"}}
+	assert.NoError(t, d.setContainerImmutability(context.Background(), "subsID", "rg", "account", "container", p))
+	assert.Equal(t, int32(30), ptr.Deref(client.policy.Properties.ImmutabilityPeriodSinceCreationInDays, 0))
+	assert.True(t, ptr.Deref(client.policy.Properties.AllowProtectedAppendWrites, false))
+	assert.Equal(t, []*string{to.Ptr("audit"), to.Ptr("case123")}, client.legalHold.Tags)
+
+	client.err = fmt.Errorf("test error")
+	assert.Error(t, d.setContainerImmutability(context.Background(), "subsID", "rg", "account", "container", p))
+
+	// mock client does not support immutability operations
+	mockOnlyFactory := mock_azclient.NewMockClientFactory(ctrl)
+	mockOnlyFactory.EXPECT().GetBlobContainerClientForSub(gomock.Any()).Return(mock_blobcontainerclient.NewMockInterface(ctrl), nil)
+	d.clientFactory = mockOnlyFactory
+	assert.Error(t, d.setContainerImmutability(context.Background(), "subsID", "rg", "account", "container", p))
+}
+
+func TestDeleteVolumeWithProtectedContainer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	blobClient := mock_blobcontainerclient.NewMockInterface(ctrl)
+	clientFactory := mock_azclient.NewMockClientFactory(ctrl)
+	clientFactory.EXPECT().GetBlobContainerClientForSub(gomock.Any()).Return(blobClient, nil).AnyTimes()
+	d.clientFactory = clientFactory
+
+	blobClient.EXPECT().DeleteContainer(gomock.Any(), "rg", "account", "container").Return(fmt.Errorf("ERROR CODE: ContainerProtectedFromDeletion")).Times(2)
+	blobClient.EXPECT().Get(gomock.Any(), "rg", "account", "container").Return(&armstorage.BlobContainer{
+		ContainerProperties: &armstorage.ContainerProperties{
+			HasLegalHold: to.Ptr(true),
+			LegalHold: &armstorage.LegalHoldProperties{
+				Tags: []*armstorage.TagProperty{{Tag: to.Ptr("audit")}},
+			},
+			HasImmutabilityPolicy: to.Ptr(true),
+			ImmutabilityPolicy: &armstorage.ImmutabilityPolicyProperties{
+				Properties: &armstorage.ImmutabilityPolicyProperty{
+					ImmutabilityPeriodSinceCreationInDays: to.Ptr(int32(30)),
+					State:                                 to.Ptr(armstorage.ImmutabilityPolicyStateLocked),
+				},
+			},
+		},
+	}, nil)
+	blobClient.EXPECT().Get(gomock.Any(), "rg", "account", "container").Return(nil, fmt.Errorf("test error"))
+
+	_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "rg#account#container"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "legal hold tags [audit], Locked immutability policy of 30 days")
+
+	// protection is unknown if container properties could not be retrieved
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "rg#account#container"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "is protected by legal hold or immutability policy")
+}
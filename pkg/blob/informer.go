@@ -0,0 +1,63 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+)
+
+const controllerInformerResyncPeriod = 10 * time.Minute
+
+// startControllerInformers starts the informers of objects watched by background controllers, so that controllers
+// read from the informer cache instead of listing all objects from api server on each sync,
+// it does not block and controllers wait for the informer cache before their first sync
+func (d *Driver) startControllerInformers(ctx context.Context) {
+	enableVolumePopulator := d.blobDataSourceClient != nil
+	if d.KubeClient == nil || !(d.enableBackupScheduler || enableVolumePopulator || d.enableNetworkRuleReconciler) {
+		return
+	}
+	d.controllerInformerFactory = informers.NewSharedInformerFactory(d.KubeClient, controllerInformerResyncPeriod)
+	if d.enableBackupScheduler || d.enableNetworkRuleReconciler {
+		d.pvLister = d.controllerInformerFactory.Core().V1().PersistentVolumes().Lister()
+	}
+	if d.enableBackupScheduler || enableVolumePopulator {
+		d.pvcLister = d.controllerInformerFactory.Core().V1().PersistentVolumeClaims().Lister()
+	}
+	if d.enableNetworkRuleReconciler {
+		d.nodeLister = d.controllerInformerFactory.Core().V1().Nodes().Lister()
+	}
+	d.controllerInformerFactory.Start(ctx.Done())
+}
+
+// waitForControllerInformers waits for the informer cache of background controllers to be synced,
+// false is returned if informers are not started or ctx is done before the cache is synced
+func (d *Driver) waitForControllerInformers(ctx context.Context) bool {
+	if d.controllerInformerFactory == nil {
+		return false
+	}
+	for informerType, synced := range d.controllerInformerFactory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			klog.Errorf("failed to sync informer cache of %v", informerType)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	leaderElectionLeaseDuration = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 5 * time.Second
+)
+
+// getLeaderElectionLeaseName returns the Lease name of background controllers, e.g. blob-csi-azure-com-controller
+func getLeaderElectionLeaseName(driverName string) string {
+	return strings.ReplaceAll(driverName, ".", "-") + "-controller"
+}
+
+// runBackgroundControllers starts background controllers, controller runs with multiple replicas, so with leader election
+// the controllers only run on the leader, otherwise replicas copy into the same destination or update the same resources concurrently
+func (d *Driver) runBackgroundControllers(ctx context.Context) {
+	if !d.enableLeaderElection || d.KubeClient == nil {
+		d.startBackgroundControllers(ctx)
+		return
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("failed to get hostname as leader election identity: %v", err)
+	}
+	leaseName := getLeaderElectionLeaseName(d.Name)
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: leaseName, Namespace: d.leaderElectionNamespace},
+		Client:     d.KubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	klog.V(2).Infof("start leader election with lease %s/%s, identity: %s", d.leaderElectionNamespace, leaseName, identity)
+	go leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaderElectionLeaseDuration,
+		RenewDeadline:   leaderElectionRenewDeadline,
+		RetryPeriod:     leaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.V(2).Infof("became leader of lease %s/%s, start background controllers", d.leaderElectionNamespace, leaseName)
+				d.startBackgroundControllers(ctx)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					return
+				}
+				// copies started by background controllers are not bound to the leader context,
+				// exit so that they are stopped before another replica takes over
+				klog.Fatalf("lost lease %s/%s, exit to stop background controllers", d.leaderElectionNamespace, leaseName)
+			},
+		},
+	})
+}
+
+// startBackgroundControllers starts the controllers which should only run on one replica, it does not block
+func (d *Driver) startBackgroundControllers(ctx context.Context) {
+	if err := d.resumeCloneJobs(ctx); err != nil {
+		klog.Errorf("failed to resume clone jobs: %v", err)
+	}
+	go d.runVolumePopulator(ctx)
+	go d.runBackupScheduler(ctx)
+	go d.runStateStoreGC(ctx)
+	go d.runNetworkRuleReconciler(ctx)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestGetLeaderElectionLeaseName(t *testing.T) {
+	assert.Equal(t, "blob-csi-azure-com-controller", getLeaderElectionLeaseName(DefaultDriverName))
+}
+
+func TestRunBackgroundControllersWithLeaderElection(t *testing.T) {
+	d := NewFakeDriver()
+	d.KubeClient = fake.NewSimpleClientset()
+	d.enableLeaderElection = true
+	d.leaderElectionNamespace = "kube-system"
+	identity, err := os.Hostname()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.runBackgroundControllers(ctx)
+
+	// lease is acquired by this replica
+	err = wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, 10*time.Second, true, func(ctx context.Context) (bool, error) {
+		lease, err := d.KubeClient.CoordinationV1().Leases("kube-system").Get(ctx, getLeaderElectionLeaseName(d.Name), metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return ptr.Deref(lease.Spec.HolderIdentity, "") == identity, nil
+	})
+	assert.NoError(t, err)
+}
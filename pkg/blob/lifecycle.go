@@ -0,0 +1,270 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// lifecycle management rules created by the driver are named with this prefix
+	lifecycleRuleNamePrefix = "blobcsi"
+	blockBlobType           = "blockBlob"
+	// a management policy could contain at most 100 rules
+	maxLifecycleRules = 100
+)
+
+// lifecycleRule is the tiering and expiry rule of a volume, days are counted since last modification, 0 means not set
+type lifecycleRule struct {
+	tierToCoolAfterDays    int
+	tierToArchiveAfterDays int
+	deleteAfterDays        int
+}
+
+func (r lifecycleRule) isEmpty() bool {
+	return r.tierToCoolAfterDays == 0 && r.tierToArchiveAfterDays == 0 && r.deleteAfterDays == 0
+}
+
+// validate checks that blobs are moved to cooler tier before they are deleted
+func (r lifecycleRule) validate() error {
+	last := 0
+	for _, v := range []struct {
+		field string
+		days  int
+	}{
+		{tierToCoolAfterDaysField, r.tierToCoolAfterDays},
+		{tierToArchiveAfterDaysField, r.tierToArchiveAfterDays},
+		{deleteAfterDaysField, r.deleteAfterDays},
+	} {
+		if v.days == 0 {
+			continue
+		}
+		if v.days <= last {
+			return fmt.Errorf("%s(%d) should be larger than %d", v.field, v.days, last)
+		}
+		last = v.days
+	}
+	return nil
+}
+
+// parseLifecycleDays parses the days of a lifecycle rule action, should be a positive integer
+func parseLifecycleDays(field, value string) (int, error) {
+	days, err := strconv.Atoi(value)
+	if err != nil || days <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s, should be a positive integer", field, value)
+	}
+	return days, nil
+}
+
+// getLifecycleRuleName returns a unique rule name of the prefix, rule name could only contain alphanumeric characters
+func getLifecycleRuleName(prefix string) string {
+	hash := sha256.Sum256([]byte(prefix))
+	name := strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return -1
+	}, prefix)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return lifecycleRuleNamePrefix + name + hex.EncodeToString(hash[:4])
+}
+
+// getLifecyclePrefix returns the prefix filter of the volume, the prefix starts with container name
+func getLifecyclePrefix(containerName, subDir string) string {
+	if subDir != "" {
+		return containerName + "/" + subDir + "/"
+	}
+	return containerName + "/"
+}
+
+// toManagementPolicyRule converts lifecycleRule to management policy rule of the prefix
+func (r lifecycleRule) toManagementPolicyRule(name, prefix string) *armstorage.ManagementPolicyRule {
+	baseBlob := &armstorage.ManagementPolicyBaseBlob{}
+	days := func(d int) *armstorage.DateAfterModification {
+		return &armstorage.DateAfterModification{DaysAfterModificationGreaterThan: to.Ptr(float32(d))}
+	}
+	if r.tierToCoolAfterDays > 0 {
+		baseBlob.TierToCool = days(r.tierToCoolAfterDays)
+	}
+	if r.tierToArchiveAfterDays > 0 {
+		baseBlob.TierToArchive = days(r.tierToArchiveAfterDays)
+	}
+	if r.deleteAfterDays > 0 {
+		baseBlob.Delete = days(r.deleteAfterDays)
+	}
+	return &armstorage.ManagementPolicyRule{
+		Name:    to.Ptr(name),
+		Enabled: to.Ptr(true),
+		Type:    to.Ptr(armstorage.RuleTypeLifecycle),
+		Definition: &armstorage.ManagementPolicyDefinition{
+			Actions: &armstorage.ManagementPolicyAction{BaseBlob: baseBlob},
+			Filters: &armstorage.ManagementPolicyFilter{
+				BlobTypes:   []*string{to.Ptr(blockBlobType)},
+				PrefixMatch: []*string{to.Ptr(prefix)},
+			},
+		},
+	}
+}
+
+// mergeManagementPolicyRule replaces the rule with the same name in policy rules or appends it, rule is removed if newRule is nil
+func mergeManagementPolicyRule(rules []*armstorage.ManagementPolicyRule, name string, newRule *armstorage.ManagementPolicyRule) []*armstorage.ManagementPolicyRule {
+	var result []*armstorage.ManagementPolicyRule
+	replaced := false
+	for _, rule := range rules {
+		if rule != nil && rule.Name != nil && *rule.Name == name {
+			if newRule != nil && !replaced {
+				result = append(result, newRule)
+				replaced = true
+			}
+			continue
+		}
+		result = append(result, rule)
+	}
+	if newRule != nil && !replaced {
+		result = append(result, newRule)
+	}
+	return result
+}
+
+// managementPolicyClient manages the lifecycle management policy of a storage account
+type managementPolicyClient interface {
+	// Get returns the management policy of the account, returns nil if there is no policy
+	Get(ctx context.Context, resourceGroupName, accountName string) (*armstorage.ManagementPolicy, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName, accountName string, policy armstorage.ManagementPolicy) error
+	// Delete deletes the management policy since a policy should contain at least one rule
+	Delete(ctx context.Context, resourceGroupName, accountName string) error
+}
+
+// managementPolicyClientFactory creates management policy client of a subscription
+type managementPolicyClientFactory interface {
+	GetManagementPolicyClientForSub(subscriptionID string) (managementPolicyClient, error)
+}
+
+type armManagementPolicyClientFactory struct {
+	cloud *azure.Cloud
+}
+
+type armManagementPolicyClient struct {
+	client *armstorage.ManagementPoliciesClient
+}
+
+func (f *armManagementPolicyClientFactory) GetManagementPolicyClientForSub(subscriptionID string) (managementPolicyClient, error) {
+	if f.cloud.AuthProvider == nil {
+		return nil, fmt.Errorf("auth provider is not initialized")
+	}
+	if subscriptionID == "" {
+		subscriptionID = f.cloud.SubscriptionID
+	}
+	options, err := azclient.GetAzCoreClientOption(&f.cloud.ARMClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := armstorage.NewManagementPoliciesClient(subscriptionID, f.cloud.AuthProvider.GetAzIdentity(), &arm.ClientOptions{ClientOptions: *options})
+	if err != nil {
+		return nil, err
+	}
+	return &armManagementPolicyClient{client: client}, nil
+}
+
+func (c *armManagementPolicyClient) Get(ctx context.Context, resourceGroupName, accountName string) (*armstorage.ManagementPolicy, error) {
+	resp, err := c.client.Get(ctx, resourceGroupName, accountName, armstorage.ManagementPolicyNameDefault, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &resp.ManagementPolicy, nil
+}
+
+func (c *armManagementPolicyClient) CreateOrUpdate(ctx context.Context, resourceGroupName, accountName string, policy armstorage.ManagementPolicy) error {
+	_, err := c.client.CreateOrUpdate(ctx, resourceGroupName, accountName, armstorage.ManagementPolicyNameDefault, policy, nil)
+	return err
+}
+
+func (c *armManagementPolicyClient) Delete(ctx context.Context, resourceGroupName, accountName string) error {
+	_, err := c.client.Delete(ctx, resourceGroupName, accountName, armstorage.ManagementPolicyNameDefault, nil)
+	return err
+}
+
+// updateLifecycleRule merges the rule into the management policy of the account, the rule is removed if rule is nil,
+// rules of other volumes and rules not created by the driver are kept,
+// ResourceExhausted error is returned if the policy would exceed maxLifecycleRules
+func (d *Driver) updateLifecycleRule(ctx context.Context, subsID, resourceGroupName, accountName, ruleName string, rule *armstorage.ManagementPolicyRule) error {
+	if d.managementPolicyClientFactory == nil {
+		return fmt.Errorf("management policy client factory is nil")
+	}
+	client, err := d.managementPolicyClientFactory.GetManagementPolicyClientForSub(subsID)
+	if err != nil {
+		return err
+	}
+
+	// management policy is a single resource of the account, serialize read-modify-write of the same account
+	lockKey := subsID + resourceGroupName + accountName
+	d.managementPolicyLockMap.LockEntry(lockKey)
+	defer d.managementPolicyLockMap.UnlockEntry(lockKey)
+
+	policy, err := client.Get(ctx, resourceGroupName, accountName)
+	if err != nil {
+		return fmt.Errorf("failed to get management policy: %w", err)
+	}
+	var rules []*armstorage.ManagementPolicyRule
+	if policy != nil && policy.Properties != nil && policy.Properties.Policy != nil {
+		rules = policy.Properties.Policy.Rules
+	}
+	merged := mergeManagementPolicyRule(rules, ruleName, rule)
+	if len(merged) == 0 {
+		if policy == nil {
+			return nil
+		}
+		klog.V(2).Infof("delete management policy of account(%s) rg(%s) since rule(%s) is the last rule", accountName, resourceGroupName, ruleName)
+		return client.Delete(ctx, resourceGroupName, accountName)
+	}
+	if rule == nil && len(merged) == len(rules) {
+		// rule does not exist
+		return nil
+	}
+	if rule != nil && len(merged) > maxLifecycleRules {
+		return status.Errorf(codes.ResourceExhausted, "management policy of account(%s) rg(%s) already has %d rules, could not add rule(%s), use another storage account or subDir volumes in a shared container", accountName, resourceGroupName, len(rules), ruleName)
+	}
+	klog.V(2).Infof("update management policy of account(%s) rg(%s) with rule(%s), total %d rules", accountName, resourceGroupName, ruleName, len(merged))
+	return client.CreateOrUpdate(ctx, resourceGroupName, accountName, armstorage.ManagementPolicy{
+		Properties: &armstorage.ManagementPolicyProperties{
+			Policy: &armstorage.ManagementPolicySchema{Rules: merged},
+		},
+	})
+}
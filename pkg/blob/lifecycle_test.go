@@ -0,0 +1,203 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// fakeManagementPolicyClient keeps management policy of one account in memory
+type fakeManagementPolicyClient struct {
+	policy  *armstorage.ManagementPolicy
+	updates int
+	err     error
+}
+
+func (c *fakeManagementPolicyClient) GetManagementPolicyClientForSub(_ string) (managementPolicyClient, error) {
+	return c, nil
+}
+
+func (c *fakeManagementPolicyClient) Get(_ context.Context, _, _ string) (*armstorage.ManagementPolicy, error) {
+	return c.policy, c.err
+}
+
+func (c *fakeManagementPolicyClient) CreateOrUpdate(_ context.Context, _, _ string, policy armstorage.ManagementPolicy) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.updates++
+	c.policy = &policy
+	return nil
+}
+
+func (c *fakeManagementPolicyClient) Delete(_ context.Context, _, _ string) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.updates++
+	c.policy = nil
+	return nil
+}
+
+func (c *fakeManagementPolicyClient) ruleNames() []string {
+	var names []string
+	if c.policy != nil {
+		for _, rule := range c.policy.Properties.Policy.Rules {
+			names = append(names, *rule.Name)
+		}
+	}
+	return names
+}
+
+func TestLifecycleRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule      lifecycleRule
+		expectErr bool
+	}{
+		{rule: lifecycleRule{tierToCoolAfterDays: 30}},
+		{rule: lifecycleRule{tierToCoolAfterDays: 30, tierToArchiveAfterDays: 90, deleteAfterDays: 365}},
+		{rule: lifecycleRule{tierToArchiveAfterDays: 90, deleteAfterDays: 365}},
+		{rule: lifecycleRule{tierToCoolAfterDays: 90, tierToArchiveAfterDays: 30}, expectErr: true},
+		{rule: lifecycleRule{tierToCoolAfterDays: 30, deleteAfterDays: 30}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectErr, test.rule.validate() != nil, "%+v", test.rule)
+	}
+}
+
+func TestParseLifecycleDays(t *testing.T) {
+	days, err := parseLifecycleDays(deleteAfterDaysField, "30")
+	assert.NoError(t, err)
+	assert.Equal(t, 30, days)
+	for _, v := range []string{"0", "-1", "1.5", "abc"} {
+		_, err := parseLifecycleDays(deleteAfterDaysField, v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestGetLifecycleRuleName(t *testing.T) {
+	name := getLifecycleRuleName(getLifecyclePrefix("pvc-container", ""))
+	assert.Regexp(t, regexp.MustCompile(`^blobcsipvccontainer[0-9a-f]{8}$`), name)
+	assert.Equal(t, name, getLifecycleRuleName("pvc-container/"))
+	// names are unique even if non-alphanumeric characters are removed
+	assert.NotEqual(t, name, getLifecycleRuleName("pvccontainer/"))
+	assert.NotEqual(t, name, getLifecycleRuleName(getLifecyclePrefix("pvc-container", "dir")))
+	assert.Equal(t, "shared/team/pvc/", getLifecyclePrefix("shared", "team/pvc"))
+}
+
+func TestToManagementPolicyRule(t *testing.T) {
+	rule := lifecycleRule{tierToCoolAfterDays: 30, deleteAfterDays: 365}.toManagementPolicyRule("rule", "container/")
+	assert.Equal(t, "rule", *rule.Name)
+	assert.Equal(t, armstorage.RuleTypeLifecycle, *rule.Type)
+	assert.Equal(t, []*string{to.Ptr("container/")}, rule.Definition.Filters.PrefixMatch)
+	assert.Equal(t, float32(30), *rule.Definition.Actions.BaseBlob.TierToCool.DaysAfterModificationGreaterThan)
+	assert.Nil(t, rule.Definition.Actions.BaseBlob.TierToArchive)
+	assert.Equal(t, float32(365), *rule.Definition.Actions.BaseBlob.Delete.DaysAfterModificationGreaterThan)
+}
+
+func TestUpdateLifecycleRule(t *testing.T) {
+	ctx := context.Background()
+	d := NewFakeDriver()
+	client := &fakeManagementPolicyClient{}
+	d.managementPolicyClientFactory = client
+	rule := lifecycleRule{deleteAfterDays: 30}
+
+	// removing a rule from an account without policy is a no-op
+	assert.NoError(t, d.updateLifecycleRule(ctx, "", "rg", "account", "rule1", nil))
+	assert.Equal(t, 0, client.updates)
+
+	// rules of other volumes and user rules are kept
+	client.policy = &armstorage.ManagementPolicy{
+		Properties: &armstorage.ManagementPolicyProperties{
+			Policy: &armstorage.ManagementPolicySchema{Rules: []*armstorage.ManagementPolicyRule{{Name: to.Ptr("user")}}},
+		},
+	}
+	assert.NoError(t, d.updateLifecycleRule(ctx, "", "rg", "account", "rule1", rule.toManagementPolicyRule("rule1", "c1/")))
+	assert.NoError(t, d.updateLifecycleRule(ctx, "", "rg", "account", "rule2", rule.toManagementPolicyRule("rule2", "c2/")))
+	assert.Equal(t, []string{"user", "rule1", "rule2"}, client.ruleNames())
+
+	// existing rule is replaced in place
+	rule.deleteAfterDays = 60
+	assert.NoError(t, d.updateLifecycleRule(ctx, "", "rg", "account", "rule1", rule.toManagementPolicyRule("rule1", "c1/")))
+	assert.Equal(t, []string{"user", "rule1", "rule2"}, client.ruleNames())
+	assert.Equal(t, float32(60), *client.policy.Properties.Policy.Rules[1].Definition.Actions.BaseBlob.Delete.DaysAfterModificationGreaterThan)
+
+	assert.NoError(t, d.updateLifecycleRule(ctx, "", "rg", "account", "rule1", nil))
+	assert.Equal(t, []string{"user", "rule2"}, client.ruleNames())
+	updates := client.updates
+	assert.NoError(t, d.updateLifecycleRule(ctx, "", "rg", "account", "rule1", nil))
+	assert.Equal(t, updates, client.updates)
+
+	// policy is deleted with the last rule
+	client.policy.Properties.Policy.Rules = client.policy.Properties.Policy.Rules[1:]
+	assert.NoError(t, d.updateLifecycleRule(ctx, "", "rg", "account", "rule2", nil))
+	assert.Nil(t, client.policy)
+
+	// rule could not be added to a full policy, while existing rule could still be updated
+	var fullRules []*armstorage.ManagementPolicyRule
+	for i := 0; i < maxLifecycleRules; i++ {
+		fullRules = append(fullRules, &armstorage.ManagementPolicyRule{Name: to.Ptr(fmt.Sprintf("rule%d", i))})
+	}
+	client.policy = &armstorage.ManagementPolicy{
+		Properties: &armstorage.ManagementPolicyProperties{Policy: &armstorage.ManagementPolicySchema{Rules: fullRules}},
+	}
+	err := d.updateLifecycleRule(ctx, "", "rg", "account", "new", rule.toManagementPolicyRule("new", "new/"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.NoError(t, d.updateLifecycleRule(ctx, "", "rg", "account", "rule1", rule.toManagementPolicyRule("rule1", "c1/")))
+
+	client.err = fmt.Errorf("test error")
+	assert.Error(t, d.updateLifecycleRule(ctx, "", "rg", "account", "rule1", rule.toManagementPolicyRule("rule1", "c1/")))
+}
+
+func TestDeleteVolumeWithLifecycleRule(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	d.containerDataClientFactory = &fakeContainerDataClient{}
+	client := &fakeManagementPolicyClient{}
+	d.managementPolicyClientFactory = client
+
+	prefix := getLifecyclePrefix("shared", "dir")
+	ruleName := getLifecycleRuleName(prefix)
+	require.NoError(t, d.updateLifecycleRule(context.Background(), "", "rg", "account", ruleName, lifecycleRule{deleteAfterDays: 30}.toManagementPolicyRule(ruleName, prefix)))
+	require.NoError(t, d.updateLifecycleRule(context.Background(), "", "rg", "account", "other", lifecycleRule{deleteAfterDays: 30}.toManagementPolicyRule("other", "other/")))
+
+	volumeID := (&volumeIDInfo{resourceGroup: "rg", accountName: "account", containerName: "shared", subDir: "dir", lifecycleRule: ruleName}).encode(volumeIDFormatV2)
+	secrets := map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "key"}
+
+	client.err = fmt.Errorf("test error")
+	_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: secrets})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	client.err = nil
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: secrets})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other"}, client.ruleNames())
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/consts"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const (
+	// nodeSubnetIDAnnotation sets the subnet resource ID of the node, e.g. for node pools in another VNet
+	nodeSubnetIDAnnotation = "blob.csi.azure.com/subnet-id"
+	// nodeSubnetLabel is the subnet name of the node pool set by AKS, the subnet is in the VNet of cloud config
+	nodeSubnetLabel = "kubernetes.azure.com/network-subnet"
+
+	subnetNotAllowedReason = "SubnetNotAllowed"
+
+	// networkRulesTag records hashes of subnets whose network rules are added by driver, separated by comma,
+	// only these rules are removed when no node is in the subnet
+	networkRulesTag = "k8s-azure-blob-network-rules"
+	// maxTagValueLength is the max length of tag value of storage account
+	maxTagValueLength = 256
+)
+
+// interval of syncing network rules of NFS storage accounts with subnets of nodes
+var networkRuleSyncInterval = 5 * time.Minute
+
+// subnetRef identifies a subnet, all fields are in lower case so that it can be used as map key
+type subnetRef struct {
+	subsID        string
+	resourceGroup string
+	vnetName      string
+	subnetName    string
+}
+
+func (s subnetRef) vnetKey() string {
+	return s.subsID + "/" + s.resourceGroup + "/" + s.vnetName
+}
+
+// parseSubnetResourceID parses subnet resource ID in the format of subnetTemplate
+func parseSubnetResourceID(id string) (subnetRef, error) {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	if len(parts) != 10 || !strings.EqualFold(parts[0], "subscriptions") || !strings.EqualFold(parts[2], "resourceGroups") ||
+		!strings.EqualFold(parts[6], "virtualNetworks") || !strings.EqualFold(parts[8], "subnets") {
+		return subnetRef{}, fmt.Errorf("invalid subnet resource ID %q", id)
+	}
+	return subnetRef{
+		subsID:        strings.ToLower(parts[1]),
+		resourceGroup: strings.ToLower(parts[3]),
+		vnetName:      strings.ToLower(parts[7]),
+		subnetName:    strings.ToLower(parts[9]),
+	}, nil
+}
+
+// getNodeSubnet returns the subnet of node from nodeSubnetIDAnnotation or nodeSubnetLabel
+func (d *Driver) getNodeSubnet(node *v1.Node) (subnetRef, error) {
+	if id := node.Annotations[nodeSubnetIDAnnotation]; id != "" {
+		return parseSubnetResourceID(id)
+	}
+	if subnetName := node.Labels[nodeSubnetLabel]; subnetName != "" {
+		if d.cloud.VnetName == "" {
+			return subnetRef{}, fmt.Errorf("vnetName is empty in cloud config")
+		}
+		return parseSubnetResourceID(d.getSubnetResourceID("", d.cloud.VnetName, subnetName))
+	}
+	return subnetRef{}, fmt.Errorf("neither annotation %s nor label %s is set", nodeSubnetIDAnnotation, nodeSubnetLabel)
+}
+
+// runNetworkRuleReconciler keeps virtual network rules of NFS storage accounts in sync with subnets of nodes until ctx is done
+func (d *Driver) runNetworkRuleReconciler(ctx context.Context) {
+	if !d.enableNetworkRuleReconciler || d.KubeClient == nil {
+		return
+	}
+	if !d.waitForControllerInformers(ctx) {
+		return
+	}
+	wait.UntilWithContext(ctx, d.syncNetworkRules, networkRuleSyncInterval)
+}
+
+// syncNetworkRules allows subnets of all nodes on storage accounts of NFS volumes which use service endpoint,
+// the Microsoft.Storage service endpoint is added to the subnets first. On storage accounts created by driver,
+// rules added by driver of other subnets in the VNets of nodes are removed, e.g. subnets of deleted node pools,
+// while rules added by users and rules of other VNets are kept.
+func (d *Driver) syncNetworkRules(ctx context.Context) {
+	nodes, err := d.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list nodes: %v", err)
+		return
+	}
+	// nodes in every subnet
+	subnetNodes := map[subnetRef][]*v1.Node{}
+	for _, node := range nodes {
+		subnet, err := d.getNodeSubnet(node)
+		if err != nil {
+			klog.V(4).Infof("skip node(%s) in network rule sync: %v", node.Name, err)
+			continue
+		}
+		subnetNodes[subnet] = append(subnetNodes[subnet], node)
+	}
+	if len(subnetNodes) == 0 {
+		klog.V(2).Infof("no subnet found on nodes, skip network rule sync")
+		return
+	}
+
+	// enable service endpoint on subnets of the same VNet in one call
+	vnetSubnets := map[subnetRef][]string{}
+	for subnet := range subnetNodes {
+		vnet := subnetRef{subsID: subnet.subsID, resourceGroup: subnet.resourceGroup, vnetName: subnet.vnetName}
+		vnetSubnets[vnet] = append(vnetSubnets[vnet], subnet.subnetName)
+	}
+	allowedSubnets := map[subnetRef]bool{}
+	clusterVNets := map[string]bool{}
+	networkSubsID := strings.ToLower(d.getNetworkResourceSubscriptionID())
+	for vnet, subnetNames := range vnetSubnets {
+		clusterVNets[vnet.vnetKey()] = true
+		sort.Strings(subnetNames)
+		if vnet.subsID != networkSubsID {
+			// subnet client only manages subnets in the network resource subscription
+			for _, sn := range subnetNames {
+				subnet := subnetRef{subsID: vnet.subsID, resourceGroup: vnet.resourceGroup, vnetName: vnet.vnetName, subnetName: sn}
+				d.recordNodeEvents(subnetNodes[subnet], "could not add service endpoint %s to subnet %s in subscription %s other than network resource subscription %s, add the service endpoint and network rule manually", storageService, subnetResourceID(subnet), vnet.subsID, networkSubsID)
+			}
+			continue
+		}
+		if _, err := d.updateSubnetServiceEndpoints(ctx, vnet.resourceGroup, vnet.vnetName, strings.Join(subnetNames, ",")); err != nil {
+			for _, sn := range subnetNames {
+				subnet := subnetRef{subsID: vnet.subsID, resourceGroup: vnet.resourceGroup, vnetName: vnet.vnetName, subnetName: sn}
+				d.recordNodeEvents(subnetNodes[subnet], "failed to add service endpoint %s to subnet %s: %v", storageService, sn, err)
+			}
+			continue
+		}
+		for _, sn := range subnetNames {
+			allowedSubnets[subnetRef{subsID: vnet.subsID, resourceGroup: vnet.resourceGroup, vnetName: vnet.vnetName, subnetName: sn}] = true
+		}
+	}
+
+	accounts, err := d.getNFSServiceEndpointAccounts()
+	if err != nil {
+		klog.Errorf("failed to list NFS storage accounts: %v", err)
+		return
+	}
+	for _, account := range accounts {
+		if err := d.syncAccountNetworkRules(ctx, account, allowedSubnets, clusterVNets, subnetNodes); err != nil {
+			klog.Errorf("failed to sync network rules of storage account(%s) rg(%s): %v", account.accountName, account.resourceGroup, err)
+		}
+	}
+}
+
+type nfsAccount struct {
+	subsID        string
+	resourceGroup string
+	accountName   string
+}
+
+// getNFSServiceEndpointAccounts returns storage accounts of NFS volumes of the driver which do not use private endpoint
+func (d *Driver) getNFSServiceEndpointAccounts() ([]nfsAccount, error) {
+	pvs, err := d.pvLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	seen := map[nfsAccount]bool{}
+	var accounts []nfsAccount
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name {
+			continue
+		}
+		attrib := pv.Spec.CSI.VolumeAttributes
+		protocol := getValueInMap(attrib, protocolField)
+		if info, err := parseVolumeID(pv.Spec.CSI.VolumeHandle); err == nil && protocol == "" {
+			protocol = info.protocol
+		}
+		if !isNFSProtocol(protocol) || strings.EqualFold(getValueInMap(attrib, networkEndpointTypeField), privateEndpoint) {
+			continue
+		}
+		resourceGroup, accountName, _, _, subsID, err := GetContainerInfo(pv.Spec.CSI.VolumeHandle)
+		if err != nil {
+			klog.V(4).Infof("skip pv(%s) in network rule sync: %v", pv.Name, err)
+			continue
+		}
+		if resourceGroup == "" {
+			resourceGroup = d.cloud.ResourceGroup
+		}
+		if subsID == "" {
+			subsID = d.cloud.SubscriptionID
+		}
+		account := nfsAccount{subsID: subsID, resourceGroup: resourceGroup, accountName: accountName}
+		if !seen[account] {
+			seen[account] = true
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
+// syncAccountNetworkRules adds rules of allowed subnets to the account and removes rules added by driver of subnets
+// in cluster VNets which are not used by any node, rules are only changed on accounts created by driver
+func (d *Driver) syncAccountNetworkRules(ctx context.Context, account nfsAccount, allowedSubnets map[subnetRef]bool, clusterVNets map[string]bool, subnetNodes map[subnetRef][]*v1.Node) error {
+	var properties storage.Account
+	if err := d.armRateLimiter.do(ctx, "GetStorageAccount", func() error {
+		var rerr *retry.Error
+		properties, rerr = d.cloud.StorageAccountClient.GetProperties(ctx, account.subsID, account.resourceGroup, account.accountName)
+		return rerr.Error()
+	}); err != nil {
+		if isNotFoundError(err) {
+			klog.V(4).Infof("storage account(%s) rg(%s) is not found, skip network rule sync", account.accountName, account.resourceGroup)
+			return nil
+		}
+		return err
+	}
+	if properties.AccountProperties == nil || properties.NetworkRuleSet == nil || properties.NetworkRuleSet.DefaultAction == storage.DefaultActionAllow {
+		// all networks are allowed
+		return nil
+	}
+
+	addedByDriver := parseNetworkRulesTag(ptr.Deref(properties.Tags[networkRulesTag], ""))
+	existing := map[subnetRef]bool{}
+	var rules []storage.VirtualNetworkRule
+	var ruleHashes []string
+	removed := 0
+	for _, rule := range ptr.Deref(properties.NetworkRuleSet.VirtualNetworkRules, nil) {
+		subnet, err := parseSubnetResourceID(ptr.Deref(rule.VirtualNetworkResourceID, ""))
+		if err != nil {
+			rules = append(rules, rule)
+			continue
+		}
+		existing[subnet] = true
+		hash := subnetHash(subnet)
+		if !addedByDriver[hash] {
+			// rule is added by user
+			rules = append(rules, rule)
+			continue
+		}
+		if clusterVNets[subnet.vnetKey()] && len(subnetNodes[subnet]) == 0 {
+			klog.V(2).Infof("remove network rule of subnet %s from storage account(%s) since no node is in the subnet", ptr.Deref(rule.VirtualNetworkResourceID, ""), account.accountName)
+			removed++
+			continue
+		}
+		rules = append(rules, rule)
+		ruleHashes = append(ruleHashes, hash)
+	}
+	var missing []subnetRef
+	for subnet := range subnetNodes {
+		if !existing[subnet] {
+			missing = append(missing, subnet)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return subnetResourceID(missing[i]) < subnetResourceID(missing[j]) })
+
+	if _, ok := properties.Tags[consts.CreatedByTag]; !ok {
+		// do not change network rules of accounts not created by driver
+		for _, subnet := range missing {
+			d.recordNodeEvents(subnetNodes[subnet], "subnet %s is not allowed by network rules of storage account(%s) which is not created by driver, NFS mount would fail", subnetResourceID(subnet), account.accountName)
+		}
+		return nil
+	}
+
+	var added []subnetRef
+	for _, subnet := range missing {
+		if !allowedSubnets[subnet] {
+			// service endpoint could not be added to the subnet, event is already recorded
+			continue
+		}
+		rules = append(rules, storage.VirtualNetworkRule{VirtualNetworkResourceID: ptr.To(subnetResourceID(subnet)), Action: storage.ActionAllow})
+		added = append(added, subnet)
+		hash := subnetHash(subnet)
+		if len(strings.Join(append(ruleHashes, hash), ",")) > maxTagValueLength {
+			klog.Warningf("tag %s of storage account(%s) is full, network rule of subnet %s would not be removed by driver", networkRulesTag, account.accountName, subnetResourceID(subnet))
+			continue
+		}
+		ruleHashes = append(ruleHashes, hash)
+	}
+	if len(added) == 0 && removed == 0 {
+		return nil
+	}
+
+	klog.V(2).Infof("update network rules of storage account(%s) rg(%s), added %d subnets, removed %d subnets", account.accountName, account.resourceGroup, len(added), removed)
+	networkRuleSet := *properties.NetworkRuleSet
+	networkRuleSet.VirtualNetworkRules = &rules
+	// tags are replaced on update, so all existing tags are kept
+	tags := make(map[string]*string, len(properties.Tags)+1)
+	for k, v := range properties.Tags {
+		tags[k] = v
+	}
+	delete(tags, networkRulesTag)
+	if len(ruleHashes) > 0 {
+		sort.Strings(ruleHashes)
+		tags[networkRulesTag] = ptr.To(strings.Join(ruleHashes, ","))
+	}
+	parameters := storage.AccountUpdateParameters{
+		Tags:                              tags,
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{NetworkRuleSet: &networkRuleSet},
+	}
+	if err := d.armRateLimiter.do(ctx, "UpdateStorageAccount", func() error {
+		return d.cloud.StorageAccountClient.Update(ctx, account.subsID, account.resourceGroup, account.accountName, parameters).Error()
+	}); err != nil {
+		for _, subnet := range added {
+			d.recordNodeEvents(subnetNodes[subnet], "failed to allow subnet %s on storage account(%s): %v", subnetResourceID(subnet), account.accountName, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// subnetHash returns a short hash of subnet which is recorded in networkRulesTag
+func subnetHash(subnet subnetRef) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(subnetResourceID(subnet)))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// parseNetworkRulesTag returns the subnet hashes in value of networkRulesTag
+func parseNetworkRulesTag(value string) map[string]bool {
+	hashes := map[string]bool{}
+	for _, hash := range strings.Split(value, ",") {
+		if hash = strings.TrimSpace(hash); hash != "" {
+			hashes[hash] = true
+		}
+	}
+	return hashes
+}
+
+// subnetResourceID returns the resource ID of subnet
+func subnetResourceID(subnet subnetRef) string {
+	return fmt.Sprintf(subnetTemplate, subnet.subsID, subnet.resourceGroup, subnet.vnetName, subnet.subnetName)
+}
+
+// recordNodeEvents records a warning event on nodes whose subnet is not allowed
+func (d *Driver) recordNodeEvents(nodes []*v1.Node, messageFmt string, args ...interface{}) {
+	klog.Warningf(subnetNotAllowedReason+": "+messageFmt, args...)
+	if d.eventRecorder == nil {
+		return
+	}
+	for _, node := range nodes {
+		d.eventRecorder.Eventf(node, v1.EventTypeWarning, subnetNotAllowedReason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	network "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/subnetclient/mock_subnetclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/consts"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestParseSubnetResourceID(t *testing.T) {
+	subnet, err := parseSubnetResourceID("/subscriptions/SUB/resourceGroups/RG/providers/Microsoft.Network/virtualNetworks/VNet/subnets/Subnet1")
+	require.NoError(t, err)
+	assert.Equal(t, subnetRef{subsID: "sub", resourceGroup: "rg", vnetName: "vnet", subnetName: "subnet1"}, subnet)
+	assert.Equal(t, "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet1", subnetResourceID(subnet))
+
+	for _, id := range []string{"", "subnet1", "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet"} {
+		_, err := parseSubnetResourceID(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestSyncNetworkRules(t *testing.T) {
+	subnetID := func(vnet, subnet string) string {
+		return fmt.Sprintf(subnetTemplate, "sub", "rg", vnet, subnet)
+	}
+	newNode := func(name string, labels, annotations map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+	}
+	newPV := func(name, account string, attrib map[string]string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: fakeDriverName, VolumeHandle: "rg#" + account + "#" + name, VolumeAttributes: attrib}},
+			},
+		}
+	}
+	nfs := map[string]string{protocolField: NFS}
+	newAccount := func(tags map[string]*string, defaultAction storage.DefaultAction, subnetIDs ...string) storage.Account {
+		var rules []storage.VirtualNetworkRule
+		for _, id := range subnetIDs {
+			rules = append(rules, storage.VirtualNetworkRule{VirtualNetworkResourceID: ptr.To(id), Action: storage.ActionAllow})
+		}
+		return storage.Account{
+			Tags: tags,
+			AccountProperties: &storage.AccountProperties{
+				NetworkRuleSet: &storage.NetworkRuleSet{
+					DefaultAction:       defaultAction,
+					VirtualNetworkRules: &rules,
+					IPRules:             &[]storage.IPRule{{IPAddressOrRange: ptr.To("1.2.3.4")}},
+				},
+			},
+		}
+	}
+	createdByDriver := map[string]*string{consts.CreatedByTag: ptr.To("azure")}
+	driverSubnetHash := func(subsID, vnet, subnet string) string {
+		return subnetHash(subnetRef{subsID: subsID, resourceGroup: "rg", vnetName: vnet, subnetName: subnet})
+	}
+	managedTags := map[string]*string{
+		consts.CreatedByTag: ptr.To("azure"),
+		networkRulesTag:     ptr.To(driverSubnetHash("sub", "vnet", "deletedpool") + "," + driverSubnetHash("sub", "vnet", "subnet1")),
+	}
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.SubscriptionID = "sub"
+	d.cloud.ResourceGroup = "rg"
+	d.cloud.Location = "eastus"
+	d.cloud.VnetName = "vnet"
+	recorder := record.NewFakeRecorder(100)
+	d.eventRecorder = recorder
+	d.enableNetworkRuleReconciler = true
+	d.KubeClient = fake.NewSimpleClientset(
+		newNode("node1", map[string]string{nodeSubnetLabel: "subnet1"}, nil),
+		newNode("node2", nil, map[string]string{nodeSubnetIDAnnotation: subnetID("vnet2", "subnet2")}),
+		newNode("node3", nil, nil),
+		newNode("node4", nil, map[string]string{nodeSubnetIDAnnotation: fmt.Sprintf(subnetTemplate, "othersub", "rg", "vnet", "subnet4")}),
+		newPV("managed", "managed", nfs),
+		newPV("managed2", "managed", map[string]string{protocolField: NFSv3}),
+		newPV("unmanaged", "unmanaged", nfs),
+		newPV("allowall", "allowall", nfs),
+		newPV("deleted", "deleted", nfs),
+		newPV("fuse", "fuse", nil),
+		newPV("privateendpoint", "privateendpoint", map[string]string{protocolField: NFS, networkEndpointTypeField: privateEndpoint}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.startControllerInformers(ctx)
+	require.True(t, d.waitForControllerInformers(ctx))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	subnetClient := mock_subnetclient.NewMockInterface(ctrl)
+	networkClientFactory := mock_azclient.NewMockClientFactory(ctrl)
+	networkClientFactory.EXPECT().GetSubnetClient().Return(subnetClient).AnyTimes()
+	d.networkClientFactory = networkClientFactory
+	// service endpoint is added to subnet1 which does not have it, subnet4 in other subscription is not updated
+	subnetClient.EXPECT().Get(gomock.Any(), "rg", "vnet", "subnet1", gomock.Any()).Return(&network.Subnet{Name: ptr.To("subnet1")}, nil)
+	subnetClient.EXPECT().CreateOrUpdate(gomock.Any(), "rg", "vnet", "subnet1", gomock.Any()).Return(nil, nil)
+	subnetClient.EXPECT().Get(gomock.Any(), "rg", "vnet2", "subnet2", gomock.Any()).Return(&network.Subnet{
+		Name:       ptr.To("subnet2"),
+		Properties: &network.SubnetPropertiesFormat{ServiceEndpoints: []*network.ServiceEndpointPropertiesFormat{{Service: ptr.To(storageService)}}},
+	}, nil)
+
+	accountClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	d.cloud.StorageAccountClient = accountClient
+	accountClient.EXPECT().GetProperties(gomock.Any(), "sub", "rg", "managed").Return(
+		newAccount(managedTags, storage.DefaultActionDeny, subnetID("vnet", "subnet1"), subnetID("vnet", "deletedpool"), subnetID("vnet", "userpool"), subnetID("othervnet", "subnet1")), nil)
+	accountClient.EXPECT().GetProperties(gomock.Any(), "sub", "rg", "unmanaged").Return(newAccount(nil, storage.DefaultActionDeny), nil)
+	accountClient.EXPECT().GetProperties(gomock.Any(), "sub", "rg", "allowall").Return(newAccount(createdByDriver, storage.DefaultActionAllow), nil)
+	accountClient.EXPECT().GetProperties(gomock.Any(), "sub", "rg", "deleted").Return(storage.Account{}, &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("ResourceNotFound")})
+	accountClient.EXPECT().Update(gomock.Any(), "sub", "rg", "managed", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _, _ string, parameters storage.AccountUpdateParameters) *retry.Error {
+			ruleSet := parameters.NetworkRuleSet
+			var ids []string
+			for _, rule := range *ruleSet.VirtualNetworkRules {
+				ids = append(ids, *rule.VirtualNetworkResourceID)
+			}
+			// rule added by driver of deleted node pool is removed, rules added by user and rule of other vnet are kept
+			assert.Equal(t, []string{subnetID("vnet", "subnet1"), subnetID("vnet", "userpool"), subnetID("othervnet", "subnet1"), subnetID("vnet2", "subnet2")}, ids)
+			assert.Equal(t, storage.DefaultActionDeny, ruleSet.DefaultAction)
+			assert.Len(t, *ruleSet.IPRules, 1)
+			hashes := []string{driverSubnetHash("sub", "vnet", "subnet1"), driverSubnetHash("sub", "vnet2", "subnet2")}
+			sort.Strings(hashes)
+			assert.Equal(t, strings.Join(hashes, ","), ptr.Deref(parameters.Tags[networkRulesTag], ""))
+			assert.Equal(t, "azure", ptr.Deref(parameters.Tags[consts.CreatedByTag], ""))
+			return nil
+		})
+
+	d.syncNetworkRules(ctx)
+
+	// service endpoint could not be added to subnet of node4,
+	// subnets of node1, node2 and node4 are not allowed by the account not created by driver
+	close(recorder.Events)
+	var events []string
+	for e := range recorder.Events {
+		events = append(events, e)
+	}
+	require.Len(t, events, 4)
+	for _, e := range events {
+		assert.Contains(t, e, subnetNotAllowedReason)
+	}
+	assert.Contains(t, events[0], "subscription othersub")
+	for _, e := range events[1:] {
+		assert.Contains(t, e, "storage account(unmanaged)")
+	}
+}
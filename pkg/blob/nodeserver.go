@@ -79,6 +79,10 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	mountPermissions := d.mountPermissions
 	context := req.GetVolumeContext()
 	if context != nil {
+		if err := d.authorizeVolumeAccess(newVolumeAccessRequest(getVolumeNamespace(context), volumeID, context)); err != nil {
+			return nil, err
+		}
+
 		// token request
 		if context[serviceAccountTokenField] != "" && getValueInMap(context, clientIDField) != "" {
 			klog.V(2).Infof("NodePublishVolume: volume(%s) mount on %s with service account token, clientID: %s", volumeID, target, getValueInMap(context, clientIDField))
@@ -92,9 +96,16 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		}
 
 		// ephemeral volume
-		if strings.EqualFold(context[ephemeralField], trueValue) {
+		if isEphemeralVolume(context) {
 			setKeyValueInMap(context, secretNamespaceField, context[podNamespaceField])
-			if !d.allowInlineVolumeKeyAccessWithIdentity {
+			// pvc name and namespace of inline volume are written by pod author, drop them so that
+			// events and container name templates are not applied to PVCs in other namespaces
+			for k := range context {
+				if strings.EqualFold(k, pvcNameKey) || strings.EqualFold(k, pvcNamespaceKey) {
+					delete(context, k)
+				}
+			}
+			if !d.allowKeyAccessWithIdentity(context[podNamespaceField]) {
 				// only get storage account from secret
 				setKeyValueInMap(context, getAccountKeyFromSecretField, trueValue)
 				setKeyValueInMap(context, storageAccountField, "")
@@ -257,14 +268,29 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
+	// volume attributes are used to mount in NodeStageVolume, so namespace policy is also checked here besides NodePublishVolume
+	if err := d.authorizeVolumeAccess(newVolumeAccessRequest(getVolumeNamespace(attrib), volumeID, attrib)); err != nil {
+		return nil, err
+	}
+
 	mc := metrics.NewMetricContext(blobCSIDriverName, "node_stage_volume", d.cloud.ResourceGroup, "", d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, VolumeID, volumeID)
 	}()
 
-	var serverAddress, storageEndpointSuffix, protocol, ephemeralVolMountOptions string
-	var ephemeralVol, isHnsEnabled bool
+	// credential used by a failed mount is evicted from credential cache, it could have been rotated
+	ctx, credentialKeys := withCredentialCacheKeys(ctx)
+	mountSucceeded := false
+	defer func() {
+		if !mountSucceeded {
+			d.evictCredentialCache(credentialKeys)
+		}
+	}()
+
+	var serverAddress, storageEndpointSuffix, protocol, ephemeralVolMountOptions, subDir, encryptionScope, cloneSource, networkEndpointType, location string
+	var ephemeralVol, isHnsEnabled, denyEncryptionScopeOverride bool
+	waitForCloneCompletion := true
 
 	containerNameReplaceMap := map[string]string{}
 
@@ -286,6 +312,20 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			ephemeralVolMountOptions = v
 		case isHnsEnabledField:
 			isHnsEnabled = strings.EqualFold(v, trueValue)
+		case subDirField:
+			subDir = v
+		case encryptionScopeField:
+			encryptionScope = v
+		case denyEncryptionScopeOverrideField:
+			denyEncryptionScopeOverride = strings.EqualFold(v, trueValue)
+		case cloneSourceField:
+			cloneSource = v
+		case networkEndpointTypeField:
+			networkEndpointType = v
+		case locationField:
+			location = v
+		case waitForCloneCompletionField:
+			waitForCloneCompletion = !strings.EqualFold(v, falseValue)
 		case pvcNamespaceKey:
 			containerNameReplaceMap[pvcNamespaceMetadata] = v
 		case pvcNameKey:
@@ -310,6 +350,19 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		}
 	}
 
+	// volume attributes take precedence over the options embedded in volume ID
+	if info, err := parseVolumeID(volumeID); err == nil {
+		if protocol == "" {
+			protocol = info.protocol
+		}
+		if storageEndpointSuffix == "" {
+			storageEndpointSuffix = info.storageEndpointSuffix
+		}
+		if subDir == "" {
+			subDir = info.subDir
+		}
+	}
+
 	if !isSupportedFSGroupChangePolicy(fsGroupChangePolicy) {
 		return nil, status.Errorf(codes.InvalidArgument, "fsGroupChangePolicy(%s) is not supported, supported fsGroupChangePolicy list: %v", fsGroupChangePolicy, supportedFSGroupChangePolicyList)
 	}
@@ -323,23 +376,61 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
-	_, accountName, _, containerName, authEnv, err := d.GetAuthEnv(ctx, volumeID, protocol, attrib, secrets)
+	resourceGroup, accountName, accountKey, containerName, authEnv, err := d.getAuthEnv(ctx, volumeID, protocol, targetPath, attrib, secrets)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "%v", err)
 	}
 
 	// replace pv/pvc name namespace metadata in subDir
 	containerName = replaceWithMap(containerName, containerNameReplaceMap)
+	if subDir != "" {
+		if !isSubDirSupportedProtocol(protocol) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with protocol(%s), supported protocols: %s, %s, %s", subDirField, protocol, Fuse2, NFS, AZNFS)
+		}
+		if subDir, err = normalizeSubDir(replaceWithMap(subDir, containerNameReplaceMap)); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
 
 	if strings.TrimSpace(storageEndpointSuffix) == "" {
 		storageEndpointSuffix = d.getStorageEndPointSuffix()
 	}
 
+	if encryptionScope != "" {
+		if accountKey != "" {
+			if err := d.verifyEncryptionScope(ctx, accountName, accountKey, storageEndpointSuffix, containerName, encryptionScope, denyEncryptionScopeOverride); err != nil {
+				return nil, err
+			}
+		} else {
+			klog.Warningf("skip verifying %s(%s) of container(%s) account(%s) since account key is not available", encryptionScopeField, encryptionScope, containerName, accountName)
+		}
+	}
+
+	if cloneSource != "" && waitForCloneCompletion {
+		subsID := getValueInMap(attrib, subscriptionIDField)
+		if subsID == "" {
+			_, _, _, _, subsID, _ = GetContainerInfo(volumeID) //nolint:dogsled
+		}
+		if err := d.checkCloneCompleted(ctx, subsID, resourceGroup, accountName, accountKey, storageEndpointSuffix, containerName, cloneSource); err != nil {
+			return nil, err
+		}
+	}
+
 	if strings.TrimSpace(serverAddress) == "" {
 		// server address is "accountname.blob.core.windows.net" by default
 		serverAddress = fmt.Sprintf("%s.blob.%s", accountName, storageEndpointSuffix)
 	}
 
+	if strings.EqualFold(networkEndpointType, privateEndpoint) {
+		if err := d.checkPrivateEndpointResolution(ctx, serverAddress, attrib); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.checkCrossRegionMount(ctx, volumeID, location, attrib); err != nil {
+		return nil, err
+	}
+
 	if isReadOnlyFromCapability(volumeCapability) {
 		if isNFSProtocol(protocol) {
 			mountFlags = util.JoinMountOptions(mountFlags, []string{"ro"})
@@ -365,6 +456,9 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		}
 
 		source := fmt.Sprintf("%s:/%s/%s", serverAddress, accountName, containerName)
+		if subDir != "" {
+			source = fmt.Sprintf("%s/%s", source, subDir)
+		}
 		mountOptions := util.JoinMountOptions(mountFlags, []string{"sec=sys,vers=3,nolock"})
 		execFunc := func() error { return d.mounter.MountSensitive(source, targetPath, mountType, mountOptions, []string{}) }
 		timeoutFunc := func() error { return fmt.Errorf("time out") }
@@ -392,6 +486,7 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		}
 
 		isOperationSucceeded = true
+		mountSucceeded = true
 		klog.V(2).Infof("volume(%s) mount %s on %s succeeded", volumeID, source, targetPath)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
@@ -404,6 +499,9 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	if isHnsEnabled {
 		mountOptions = util.JoinMountOptions(mountOptions, []string{"--use-adls=true"})
 	}
+	if subDir != "" {
+		mountOptions = util.JoinMountOptions(mountOptions, []string{"--subdirectory=" + subDir})
+	}
 
 	if !checkGidPresentInMountFlags(mountFlags) && volumeMountGroup != "" {
 		klog.V(2).Infof("append volumeMountGroup %s", volumeMountGroup)
@@ -431,6 +529,7 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 			klog.Errorf("MakeDir failed on target: %s (%v)", targetPath, err)
 			return nil, status.Errorf(codes.Internal, "%v", err)
 		}
+		mountSucceeded = true
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -479,6 +578,7 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		return nil, fmt.Errorf("failed to wait for mount: %w", err)
 	}
 
+	mountSucceeded = true
 	klog.V(2).Infof("volume(%s) mount on %q succeeded", volumeID, targetPath)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
@@ -513,6 +613,7 @@ func (d *Driver) NodeUnstageVolume(_ context.Context, req *csi.NodeUnstageVolume
 		return nil, status.Errorf(codes.Internal, "failed to unmount staging target %q: %v", stagingTargetPath, err)
 	}
 	klog.V(2).Infof("NodeUnstageVolume: volume %s unmount on %s successfully", volumeID, stagingTargetPath)
+	d.removeSPNClientCertificate(volumeID, stagingTargetPath)
 
 	isOperationSucceeded = true
 	return &csi.NodeUnstageVolumeResponse{}, nil
@@ -526,10 +627,18 @@ func (d *Driver) NodeGetCapabilities(_ context.Context, _ *csi.NodeGetCapabiliti
 }
 
 // NodeGetInfo return info of the node on which this plugin is running
-func (d *Driver) NodeGetInfo(_ context.Context, _ *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	return &csi.NodeGetInfoResponse{
+func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	resp := &csi.NodeGetInfoResponse{
 		NodeId: d.NodeID,
-	}, nil
+	}
+	if d.enableTopology {
+		topology, err := d.getNodeTopology(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp.AccessibleTopology = topology.toCSITopology()
+	}
+	return resp, nil
 }
 
 // NodeExpandVolume node expand volume
@@ -0,0 +1,491 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/blob-csi-driver/pkg/util"
+)
+
+// storageClassParameters holds the parsed storage class parameters of CreateVolume
+type storageClassParameters struct {
+	storageAccountType    string
+	subsID                string
+	resourceGroup         string
+	location              string
+	account               string
+	containerName         string
+	containerNamePrefix   string
+	subDir                string
+	onDelete              string
+	backupContainerName   string
+	protocol              string
+	secretName            string
+	secretNamespace       string
+	pvcNamespace          string
+	vnetResourceGroup     string
+	vnetName              string
+	subnetName            string
+	accessTier            string
+	networkEndpointType   string
+	privateDNSZoneLink    string
+	storageEndpointSuffix string
+	fsGroupChangePolicy   string
+
+	isHnsEnabled           *bool
+	requireInfraEncryption *bool
+	enableBlobVersioning   *bool
+	createPrivateEndpoint  *bool
+	enableNfsV3            *bool
+	allowSharedKeyAccess   *bool
+	allowBlobPublicAccess  *bool
+	// WORM policies applied on container creation
+	immutabilityPeriodInDays   int32
+	allowProtectedAppendWrites *bool
+	legalHoldTags              []string
+	lifecycleRule              lifecycleRule
+	// spread volumes across multiple storage accounts
+	accountPool accountPool
+	// accountIsolation creates a dedicated storage account for every volume or PVC namespace
+	accountIsolation string
+	// default encryption scope of the container
+	encryptionScope             string
+	denyEncryptionScopeOverride bool
+
+	matchTags            bool
+	useDataPlaneAPI      bool
+	getLatestAccountKey  bool
+	storeAccountKey      bool
+	softDeleteBlobs      int32
+	softDeleteContainers int32
+
+	tags                    map[string]string
+	containerNameReplaceMap map[string]string
+}
+
+// parseStorageClassParameters parses and validates storage class parameters (case-insensitive),
+// it does not depend on cloud config so it's also used by the admission webhook
+func parseStorageClassParameters(parameters map[string]string) (*storageClassParameters, error) {
+	var customTags, tagValueDelimiter string
+	var err error
+	backupParameters := map[string]string{}
+	p := &storageClassParameters{
+		// set allowBlobPublicAccess as false by default
+		allowBlobPublicAccess: ptr.To(false),
+		// store account key to k8s secret by default
+		storeAccountKey:         true,
+		containerNameReplaceMap: map[string]string{},
+	}
+
+	// We leave validation of the values to the cloud provider.
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case skuNameField:
+			p.storageAccountType = v
+		case storageAccountTypeField:
+			p.storageAccountType = v
+		case locationField:
+			p.location = v
+		case storageAccountField:
+			p.account = v
+		case subscriptionIDField:
+			p.subsID = v
+		case resourceGroupField:
+			p.resourceGroup = v
+		case containerNameField:
+			p.containerName = v
+		case containerNamePrefixField:
+			p.containerNamePrefix = v
+		case subDirField:
+			p.subDir = v
+		case onDeleteField:
+			p.onDelete = v
+		case backupContainerNameField:
+			p.backupContainerName = v
+		case protocolField:
+			p.protocol = v
+		case tagsField:
+			customTags = v
+		case matchTagsField:
+			p.matchTags = strings.EqualFold(v, trueValue)
+		case secretNameField:
+			p.secretName = v
+		case secretNamespaceField:
+			p.secretNamespace = v
+		case isHnsEnabledField:
+			if strings.EqualFold(v, trueValue) {
+				p.isHnsEnabled = ptr.To(true)
+			}
+		case softDeleteBlobsField:
+			days, err := parseDays(v)
+			if err != nil {
+				return nil, err
+			}
+			p.softDeleteBlobs = days
+		case softDeleteContainersField:
+			days, err := parseDays(v)
+			if err != nil {
+				return nil, err
+			}
+			p.softDeleteContainers = days
+		case enableBlobVersioningField:
+			p.enableBlobVersioning = ptr.To(strings.EqualFold(v, trueValue))
+		case storeAccountKeyField:
+			if strings.EqualFold(v, falseValue) {
+				p.storeAccountKey = false
+			}
+		case getLatestAccountKeyField:
+			if p.getLatestAccountKey, err = strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", getLatestAccountKeyField, v)
+			}
+		case allowBlobPublicAccessField:
+			if strings.EqualFold(v, trueValue) {
+				p.allowBlobPublicAccess = ptr.To(true)
+			}
+		case allowSharedKeyAccessField:
+			var boolValue bool
+			if boolValue, err = strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", allowSharedKeyAccessField, v)
+			}
+			p.allowSharedKeyAccess = ptr.To(boolValue)
+		case requireInfraEncryptionField:
+			if strings.EqualFold(v, trueValue) {
+				p.requireInfraEncryption = ptr.To(true)
+			}
+		case immutabilityPeriodInDaysField:
+			days, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || days < 1 || days > maxImmutabilityPeriodInDays {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s, should be an integer between 1 and %d", immutabilityPeriodInDaysField, v, maxImmutabilityPeriodInDays)
+			}
+			p.immutabilityPeriodInDays = int32(days)
+		case allowProtectedAppendWritesField:
+			var boolValue bool
+			if boolValue, err = strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in storage class", allowProtectedAppendWritesField, v)
+			}
+			p.allowProtectedAppendWrites = ptr.To(boolValue)
+		case tierToCoolAfterDaysField:
+			if p.lifecycleRule.tierToCoolAfterDays, err = parseLifecycleDays(tierToCoolAfterDaysField, v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+			}
+		case tierToArchiveAfterDaysField:
+			if p.lifecycleRule.tierToArchiveAfterDays, err = parseLifecycleDays(tierToArchiveAfterDaysField, v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+			}
+		case deleteAfterDaysField:
+			if p.lifecycleRule.deleteAfterDays, err = parseLifecycleDays(deleteAfterDaysField, v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+			}
+		case encryptionScopeField:
+			if !isValidEncryptionScope(v) {
+				return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is invalid, should be 3 to 63 alphanumeric characters", encryptionScopeField, v)
+			}
+			p.encryptionScope = v
+		case denyEncryptionScopeOverrideField:
+			if p.denyEncryptionScopeOverride, err = strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in storage class", denyEncryptionScopeOverrideField, v)
+			}
+		case waitForCloneCompletionField:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in storage class", waitForCloneCompletionField, v)
+			}
+		case accountPoolSizeField:
+			if p.accountPool.size, err = strconv.Atoi(v); err != nil || p.accountPool.size < 1 {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in storage class, should be a positive integer", accountPoolSizeField, v)
+			}
+		case accountPoolStrategyField:
+			p.accountPool.strategy = v
+		case maxVolumesPerAccountField:
+			if p.accountPool.maxVolumes, err = strconv.Atoi(v); err != nil || p.accountPool.maxVolumes < 1 {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in storage class, should be a positive integer", maxVolumesPerAccountField, v)
+			}
+		case accountIsolationField:
+			p.accountIsolation = strings.ToLower(v)
+		case accountPerNamespaceField:
+			if p.accountPool.perNamespace, err = strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s in storage class", accountPerNamespaceField, v)
+			}
+		case backupScheduleField, backupStorageAccountField, backupResourceGroupField, backupSubscriptionIDField, backupRetentionField:
+			backupParameters[strings.ToLower(k)] = v
+		case legalHoldTagsField:
+			if p.legalHoldTags, err = parseLegalHoldTags(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+			}
+		case pvcNamespaceKey:
+			p.pvcNamespace = v
+			p.containerNameReplaceMap[pvcNamespaceMetadata] = v
+		case pvcNameKey:
+			p.containerNameReplaceMap[pvcNameMetadata] = v
+		case pvNameKey:
+			p.containerNameReplaceMap[pvNameMetadata] = v
+		case serverNameField:
+		case storageAuthTypeField:
+		case storageIdentityClientIDField:
+		case storageIdentityObjectIDField:
+		case storageIdentityResourceIDField:
+		case msiEndpointField:
+		case storageAADEndpointField:
+			// no op, only used in NodeStageVolume
+		case storageEndpointSuffixField:
+			p.storageEndpointSuffix = v
+		case vnetResourceGroupField:
+			p.vnetResourceGroup = v
+		case vnetNameField:
+			p.vnetName = v
+		case subnetNameField:
+			p.subnetName = v
+		case accessTierField:
+			p.accessTier = v
+		case networkEndpointTypeField:
+			p.networkEndpointType = v
+		case privateDNSZoneLinkField:
+			p.privateDNSZoneLink = strings.ToLower(v)
+		case mountPermissionsField:
+			// only do validations here, used in NodeStageVolume, NodePublishVolume
+			if v != "" {
+				if _, err := strconv.ParseUint(v, 8, 32); err != nil {
+					return nil, status.Errorf(codes.InvalidArgument, "invalid mountPermissions %s in storage class", v)
+				}
+			}
+		case useDataPlaneAPIField:
+			p.useDataPlaneAPI = strings.EqualFold(v, trueValue)
+		case fsGroupChangePolicyField:
+			p.fsGroupChangePolicy = v
+		case tagValueDelimiterField:
+			tagValueDelimiter = v
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "invalid parameter %q in storage class", k)
+		}
+	}
+
+	if ptr.Deref(p.enableBlobVersioning, false) {
+		if isNFSProtocol(p.protocol) || ptr.Deref(p.isHnsEnabled, false) {
+			return nil, status.Errorf(codes.InvalidArgument, "enableBlobVersioning is not supported for NFS protocol or HNS enabled account")
+		}
+	}
+
+	if !isSupportedFSGroupChangePolicy(p.fsGroupChangePolicy) {
+		return nil, status.Errorf(codes.InvalidArgument, "fsGroupChangePolicy(%s) is not supported, supported fsGroupChangePolicy list: %v", p.fsGroupChangePolicy, supportedFSGroupChangePolicyList)
+	}
+
+	if p.matchTags && p.account != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "matchTags must set as false when storageAccount(%s) is provided", p.account)
+	}
+
+	if !p.accountPool.isEmpty() {
+		if p.account != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "account pool could not be used when storageAccount(%s) is provided", p.account)
+		}
+		if p.matchTags {
+			return nil, status.Errorf(codes.InvalidArgument, "matchTags is not supported with account pool")
+		}
+		if err := p.accountPool.validate(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
+
+	if p.accountIsolation != "" {
+		if !isSupportedAccountIsolation(p.accountIsolation) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is not supported, supported %s list: %v", accountIsolationField, p.accountIsolation, accountIsolationField, supportedAccountIsolationList)
+		}
+		if p.account != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s could not be used when storageAccount(%s) is provided", accountIsolationField, p.account)
+		}
+		if p.matchTags {
+			return nil, status.Errorf(codes.InvalidArgument, "matchTags is not supported with %s", accountIsolationField)
+		}
+		if !p.accountPool.isEmpty() {
+			return nil, status.Errorf(codes.InvalidArgument, "account pool is not supported with %s", accountIsolationField)
+		}
+		if p.subDir != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with %s", subDirField, accountIsolationField)
+		}
+	}
+
+	if p.protocol == "" {
+		p.protocol = Fuse
+	}
+	if !isSupportedProtocol(p.protocol) {
+		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported protocol list: %v", p.protocol, supportedProtocolList)
+	}
+	if !isSupportedAccessTier(p.accessTier) {
+		return nil, status.Errorf(codes.InvalidArgument, "accessTier(%s) is not supported, supported AccessTier list: %v", p.accessTier, armstorage.PossibleAccessTierValues())
+	}
+
+	if p.containerName != "" && p.containerNamePrefix != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "containerName(%s) and containerNamePrefix(%s) could not be specified together", p.containerName, p.containerNamePrefix)
+	}
+	if !isSupportedContainerNamePrefix(p.containerNamePrefix) {
+		return nil, status.Errorf(codes.InvalidArgument, "containerNamePrefix(%s) can only contain lowercase letters, numbers, hyphens, and length should be less than 21", p.containerNamePrefix)
+	}
+
+	if p.subDir != "" {
+		if p.containerName == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "containerName must be specified with %s(%s)", subDirField, p.subDir)
+		}
+		if !isSubDirSupportedProtocol(p.protocol) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not supported with protocol(%s), supported protocols: %s, %s, %s", subDirField, p.protocol, Fuse2, NFS, AZNFS)
+		}
+		if p.subDir, err = normalizeSubDir(p.subDir); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
+
+	if p.denyEncryptionScopeOverride && p.encryptionScope == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with %s", denyEncryptionScopeOverrideField, encryptionScopeField)
+	}
+	if p.allowProtectedAppendWrites != nil && p.immutabilityPeriodInDays == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with %s", allowProtectedAppendWritesField, immutabilityPeriodInDaysField)
+	}
+	if p.hasImmutability() {
+		if p.subDir != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s and %s are not supported with %s since the container is shared", immutabilityPeriodInDaysField, legalHoldTagsField, subDirField)
+		}
+		if p.useDataPlaneAPI {
+			return nil, status.Errorf(codes.InvalidArgument, "%s and %s are not supported with %s since they are only available in management API", immutabilityPeriodInDaysField, legalHoldTagsField, useDataPlaneAPIField)
+		}
+	}
+
+	if !p.lifecycleRule.isEmpty() {
+		if err := p.lifecycleRule.validate(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if p.useDataPlaneAPI {
+			return nil, status.Errorf(codes.InvalidArgument, "lifecycle management rules are not supported with %s since they are only available in management API", useDataPlaneAPIField)
+		}
+	}
+
+	if _, err := parseBackupConfig(backupParameters, nil); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	onDelete, ok := getOnDeletePolicy(p.onDelete)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is not supported, supported %s list: %v", onDeleteField, p.onDelete, onDeleteField, supportedOnDeletePolicyList)
+	}
+	p.onDelete = onDelete
+	if p.onDelete != onDeleteDelete && p.subDir != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is not supported with %s", onDeleteField, p.onDelete, subDirField)
+	}
+	if p.backupContainerName != "" {
+		if p.onDelete != onDeleteCopyToBackupContainer {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with %s(%s)", backupContainerNameField, onDeleteField, onDeleteCopyToBackupContainer)
+		}
+		if getValidContainerName(p.backupContainerName, p.protocol) != p.backupContainerName {
+			return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is not a valid container name", backupContainerNameField, p.backupContainerName)
+		}
+	}
+
+	if strings.EqualFold(p.networkEndpointType, privateEndpoint) {
+		if strings.Contains(p.subnetName, ",") {
+			return nil, status.Errorf(codes.InvalidArgument, "subnetName(%s) can only contain one subnet for private endpoint", p.subnetName)
+		}
+		p.createPrivateEndpoint = ptr.To(true)
+		if p.privateDNSZoneLink == "" {
+			// private DNS zone could be managed out of the cluster VNet resource group, e.g. by custom DNS servers
+			p.privateDNSZoneLink = privateDNSZoneLinkSkip
+		}
+	}
+	if p.privateDNSZoneLink != "" {
+		if !isSupportedPrivateDNSZoneLink(p.privateDNSZoneLink) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s(%s) is not supported, supported %s list: %v", privateDNSZoneLinkField, p.privateDNSZoneLink, privateDNSZoneLinkField, supportedPrivateDNSZoneLinkList)
+		}
+		if !ptr.Deref(p.createPrivateEndpoint, false) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is only supported with %s(%s)", privateDNSZoneLinkField, networkEndpointTypeField, privateEndpoint)
+		}
+	}
+	if isNFSProtocol(p.protocol) {
+		p.isHnsEnabled = ptr.To(true)
+		p.enableNfsV3 = ptr.To(true)
+		// NFS protocol does not need account key
+		p.storeAccountKey = false
+	}
+
+	if p.tags, err = util.ConvertTagsToMap(customTags, tagValueDelimiter); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	if p.storeAccountKey && !ptr.Deref(p.allowSharedKeyAccess, true) {
+		return nil, status.Errorf(codes.InvalidArgument, "storeAccountKey is not supported for account with shared access key disabled")
+	}
+	return p, nil
+}
+
+// getV2VolumeIDField returns the parameter which could only be encoded in v2 volume ID, empty if v1 volume ID is enough
+func (p *storageClassParameters) getV2VolumeIDField() string {
+	switch {
+	case p.subDir != "":
+		return subDirField
+	case p.onDelete != onDeleteDelete:
+		return onDeleteField
+	case p.lifecycleRule.tierToCoolAfterDays > 0:
+		return tierToCoolAfterDaysField
+	case p.lifecycleRule.tierToArchiveAfterDays > 0:
+		return tierToArchiveAfterDaysField
+	case p.lifecycleRule.deleteAfterDays > 0:
+		return deleteAfterDaysField
+	case p.accountIsolation != "":
+		return accountIsolationField
+	}
+	return ""
+}
+
+// validateVolumeAttributes validates volume attributes of PV or inline volume which are used in NodeStageVolume
+func validateVolumeAttributes(attrib map[string]string) error {
+	for k, v := range attrib {
+		switch strings.ToLower(k) {
+		case protocolField:
+			if v != "" && !isSupportedProtocol(v) {
+				return status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported protocol list: %v", v, supportedProtocolList)
+			}
+		case mountPermissionsField:
+			if v != "" {
+				if _, err := strconv.ParseUint(v, 8, 32); err != nil {
+					return status.Errorf(codes.InvalidArgument, "invalid mountPermissions %s", v)
+				}
+			}
+		case fsGroupChangePolicyField:
+			if !isSupportedFSGroupChangePolicy(v) {
+				return status.Errorf(codes.InvalidArgument, "fsGroupChangePolicy(%s) is not supported, supported fsGroupChangePolicy list: %v", v, supportedFSGroupChangePolicyList)
+			}
+		case getLatestAccountKeyField:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", getLatestAccountKeyField, v)
+			}
+		case subDirField:
+			if _, err := normalizeSubDir(v); err != nil {
+				return status.Errorf(codes.InvalidArgument, "%v", err)
+			}
+		case encryptionScopeField:
+			if !isValidEncryptionScope(v) {
+				return status.Errorf(codes.InvalidArgument, "%s(%s) is invalid, should be 3 to 63 alphanumeric characters", encryptionScopeField, v)
+			}
+		case denyEncryptionScopeOverrideField, waitForCloneCompletionField:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return status.Errorf(codes.InvalidArgument, "invalid %s: %s in volume context", k, v)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/ptr"
+)
+
+func TestParseStorageClassParameters(t *testing.T) {
+	tests := []struct {
+		desc         string
+		parameters   map[string]string
+		expectedCode codes.Code
+		verify       func(t *testing.T, p *storageClassParameters)
+	}{
+		{
+			desc:       "default values",
+			parameters: map[string]string{},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, Fuse, p.protocol)
+				assert.True(t, p.storeAccountKey)
+				assert.Equal(t, ptr.To(false), p.allowBlobPublicAccess)
+				assert.Empty(t, p.tags)
+			},
+		},
+		{
+			desc: "nfs protocol",
+			parameters: map[string]string{
+				"Protocol":               NFS,
+				networkEndpointTypeField: privateEndpoint,
+				tagsField:                "a=b",
+			},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, ptr.To(true), p.isHnsEnabled)
+				assert.Equal(t, ptr.To(true), p.enableNfsV3)
+				assert.Equal(t, ptr.To(true), p.createPrivateEndpoint)
+				assert.Equal(t, privateDNSZoneLinkSkip, p.privateDNSZoneLink)
+				assert.False(t, p.storeAccountKey)
+				assert.Equal(t, map[string]string{"a": "b"}, p.tags)
+			},
+		},
+		{
+			desc:         "invalid parameter",
+			parameters:   map[string]string{"invalid": "value"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "unsupported protocol",
+			parameters:   map[string]string{protocolField: "smb"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "unsupported access tier",
+			parameters:   map[string]string{accessTierField: "hot"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "invalid containerNamePrefix",
+			parameters:   map[string]string{containerNamePrefixField: "UPPER"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "containerName and containerNamePrefix",
+			parameters:   map[string]string{containerNameField: "container", containerNamePrefixField: "prefix"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "invalid mountPermissions",
+			parameters:   map[string]string{mountPermissionsField: "0abc"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "unsupported fsGroupChangePolicy",
+			parameters:   map[string]string{fsGroupChangePolicyField: "invalid"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "matchTags with storageAccount",
+			parameters:   map[string]string{matchTagsField: trueValue, storageAccountField: "account"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "enableBlobVersioning with nfs protocol",
+			parameters:   map[string]string{enableBlobVersioningField: trueValue, protocolField: NFS},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "storeAccountKey with shared key access disabled",
+			parameters:   map[string]string{allowSharedKeyAccessField: falseValue},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:       "subDir with shared container",
+			parameters: map[string]string{containerNameField: "shared", subDirField: "/team/${pvc.metadata.name}/", protocolField: Fuse2},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, "team/${pvc.metadata.name}", p.subDir)
+			},
+		},
+		{
+			desc:         "subDir without containerName",
+			parameters:   map[string]string{subDirField: "dir", protocolField: NFS},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "subDir with blobfuse v1",
+			parameters:   map[string]string{containerNameField: "shared", subDirField: "dir"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "subDir with path traversal",
+			parameters:   map[string]string{containerNameField: "shared", subDirField: "../dir", protocolField: NFS},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:       "onDelete policy is case insensitive",
+			parameters: map[string]string{onDeleteField: "Archive"},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, onDeleteArchive, p.onDelete)
+			},
+		},
+		{
+			desc:       "copyToBackupContainer with backupContainerName",
+			parameters: map[string]string{onDeleteField: onDeleteCopyToBackupContainer, backupContainerNameField: "backup"},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, onDeleteCopyToBackupContainer, p.onDelete)
+				assert.Equal(t, "backup", p.backupContainerName)
+			},
+		},
+		{
+			desc:         "unsupported onDelete policy",
+			parameters:   map[string]string{onDeleteField: "recycle"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "onDelete policy with subDir",
+			parameters:   map[string]string{onDeleteField: onDeleteRetain, containerNameField: "shared", subDirField: "dir", protocolField: NFS},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "backupContainerName without copyToBackupContainer",
+			parameters:   map[string]string{onDeleteField: onDeleteRetain, backupContainerNameField: "backup"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "invalid backupContainerName",
+			parameters:   map[string]string{onDeleteField: onDeleteCopyToBackupContainer, backupContainerNameField: "Backup"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:       "immutability policy and legal hold",
+			parameters: map[string]string{immutabilityPeriodInDaysField: "365", allowProtectedAppendWritesField: trueValue, legalHoldTagsField: "Audit,case123"},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, int32(365), p.immutabilityPeriodInDays)
+				assert.Equal(t, ptr.To(true), p.allowProtectedAppendWrites)
+				assert.Equal(t, []string{"audit", "case123"}, p.legalHoldTags)
+				assert.True(t, p.hasImmutability())
+			},
+		},
+		{
+			desc:         "invalid immutabilityPeriodInDays",
+			parameters:   map[string]string{immutabilityPeriodInDaysField: "0"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "invalid legalHoldTags",
+			parameters:   map[string]string{legalHoldTagsField: "a-b"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "allowProtectedAppendWrites without immutabilityPeriodInDays",
+			parameters:   map[string]string{allowProtectedAppendWritesField: trueValue},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "legalHoldTags with subDir",
+			parameters:   map[string]string{legalHoldTagsField: "audit", containerNameField: "shared", subDirField: "dir", protocolField: NFS},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "immutabilityPeriodInDays with useDataPlaneAPI",
+			parameters:   map[string]string{immutabilityPeriodInDaysField: "1", useDataPlaneAPIField: trueValue},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:       "lifecycle management rule",
+			parameters: map[string]string{tierToCoolAfterDaysField: "30", tierToArchiveAfterDaysField: "90", deleteAfterDaysField: "365"},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, lifecycleRule{tierToCoolAfterDays: 30, tierToArchiveAfterDays: 90, deleteAfterDays: 365}, p.lifecycleRule)
+			},
+		},
+		{
+			desc:         "invalid deleteAfterDays",
+			parameters:   map[string]string{deleteAfterDaysField: "0"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "deleteAfterDays before tierToCoolAfterDays",
+			parameters:   map[string]string{tierToCoolAfterDaysField: "30", deleteAfterDaysField: "7"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "lifecycle management rule with useDataPlaneAPI",
+			parameters:   map[string]string{deleteAfterDaysField: "7", useDataPlaneAPIField: trueValue},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:       "encryption scope",
+			parameters: map[string]string{encryptionScopeField: "tenant1", denyEncryptionScopeOverrideField: trueValue},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, "tenant1", p.encryptionScope)
+				assert.True(t, p.denyEncryptionScopeOverride)
+			},
+		},
+		{
+			desc:         "invalid encryption scope",
+			parameters:   map[string]string{encryptionScopeField: "tenant_1"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "denyEncryptionScopeOverride without encryption scope",
+			parameters:   map[string]string{denyEncryptionScopeOverrideField: trueValue},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:       "privateDNSZoneLink",
+			parameters: map[string]string{networkEndpointTypeField: privateEndpoint, "privateDNSZoneLink": "Create"},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, privateDNSZoneLinkCreate, p.privateDNSZoneLink)
+			},
+		},
+		{
+			desc:         "unsupported privateDNSZoneLink",
+			parameters:   map[string]string{networkEndpointTypeField: privateEndpoint, privateDNSZoneLinkField: "delete"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "privateDNSZoneLink without private endpoint",
+			parameters:   map[string]string{privateDNSZoneLinkField: privateDNSZoneLinkCreate},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "multiple subnets with private endpoint",
+			parameters:   map[string]string{networkEndpointTypeField: privateEndpoint, subnetNameField: "subnet1,subnet2"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:       "account pool",
+			parameters: map[string]string{accountPoolSizeField: "3", accountPoolStrategyField: "Hash", maxVolumesPerAccountField: "100"},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, accountPool{size: 3, strategy: accountPoolStrategyHash, maxVolumes: 100}, p.accountPool)
+			},
+		},
+		{
+			desc:         "invalid accountPoolSize",
+			parameters:   map[string]string{accountPoolSizeField: "0"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "unsupported accountPoolStrategy",
+			parameters:   map[string]string{accountPoolStrategyField: "random"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "account pool with storageAccount",
+			parameters:   map[string]string{accountPoolSizeField: "3", storageAccountField: "account"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:       "account isolation",
+			parameters: map[string]string{accountIsolationField: "perNamespace", pvcNamespaceKey: "ns"},
+			verify: func(t *testing.T, p *storageClassParameters) {
+				assert.Equal(t, accountIsolationPerNamespace, p.accountIsolation)
+			},
+		},
+		{
+			desc:         "unsupported accountIsolation",
+			parameters:   map[string]string{accountIsolationField: "perCluster"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "accountIsolation with account pool",
+			parameters:   map[string]string{accountIsolationField: "perVolume", accountPoolSizeField: "3"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "accountIsolation with subDir",
+			parameters:   map[string]string{accountIsolationField: "perVolume", subDirField: "dir"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:       "perNamespace accountIsolation without PVC namespace",
+			parameters: map[string]string{accountIsolationField: "perNamespace"},
+		},
+		{
+			desc:       "accountPerNamespace without PVC namespace",
+			parameters: map[string]string{accountPerNamespaceField: "true"},
+		},
+	}
+
+	for _, test := range tests {
+		p, err := parseStorageClassParameters(test.parameters)
+		assert.Equal(t, test.expectedCode, status.Code(err), test.desc)
+		if test.verify != nil && err == nil {
+			test.verify(t, p)
+		}
+	}
+}
+
+func TestValidateVolumeAttributes(t *testing.T) {
+	tests := []struct {
+		desc      string
+		attrib    map[string]string
+		expectErr bool
+	}{
+		{
+			desc:   "valid attributes",
+			attrib: map[string]string{protocolField: Fuse2, mountPermissionsField: "0755", fsGroupChangePolicyField: "None", getLatestAccountKeyField: "true"},
+		},
+		{
+			desc:      "unsupported protocol",
+			attrib:    map[string]string{"Protocol": "smb"},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid mountPermissions",
+			attrib:    map[string]string{mountPermissionsField: "999"},
+			expectErr: true,
+		},
+		{
+			desc:      "unsupported fsGroupChangePolicy",
+			attrib:    map[string]string{fsGroupChangePolicyField: "invalid"},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid subDir",
+			attrib:    map[string]string{subDirField: "a/../b"},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid encryption scope",
+			attrib:    map[string]string{encryptionScopeField: "a"},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid denyEncryptionScopeOverride",
+			attrib:    map[string]string{denyEncryptionScopeOverrideField: "invalid"},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid getLatestAccountKey",
+			attrib:    map[string]string{getLatestAccountKeyField: "invalid"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		err := validateVolumeAttributes(test.attrib)
+		assert.Equal(t, test.expectErr, err != nil, test.desc)
+	}
+}
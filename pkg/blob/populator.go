@@ -0,0 +1,783 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	blobDataSourceAPIVer   = "v1alpha1"
+	blobDataSourceKind     = "BlobDataSource"
+	blobDataSourceResource = "blobdatasources"
+	// key of SAS token in the secret referenced by BlobDataSource
+	blobDataSourceSASTokenKey = "sasToken"
+
+	blobDataSourceFormatContainer = "container"
+	blobDataSourceFormatBlob      = "blob"
+	blobDataSourceFormatTar       = "tar"
+	blobDataSourceFormatTgz       = "tgz"
+	blobDataSourceFormatZip       = "zip"
+
+	populateStateAnnotation    = "blob.csi.azure.com/populate-state"
+	populateProgressAnnotation = "blob.csi.azure.com/populate-progress"
+
+	populateStartedReason   = "PopulateStarted"
+	populateProgressReason  = "PopulateProgress"
+	populateSucceededReason = "PopulateSucceeded"
+	populateFailedReason    = "PopulateFailed"
+
+	provisionedByAnnotation = "pv.kubernetes.io/provisioned-by"
+	// prefix of storage class parameters reserved by external-provisioner, e.g. provisioner secret
+	csiParameterPrefix                  = "csi.storage.k8s.io/"
+	provisionerSecretNameParameter      = "csi.storage.k8s.io/provisioner-secret-name"
+	provisionerSecretNamespaceParameter = "csi.storage.k8s.io/provisioner-secret-namespace"
+	nodeStageSecretNameParameter        = "csi.storage.k8s.io/node-stage-secret-name"
+	nodeStageSecretNamespaceParameter   = "csi.storage.k8s.io/node-stage-secret-namespace"
+)
+
+var (
+	// interval of checking pending PVCs with BlobDataSource
+	volumePopulatorSyncInterval = 30 * time.Second
+	// interval of publishing populate progress on PVC
+	populateProgressInterval = 30 * time.Second
+	// size of ranges downloaded by zip reader, zip reader requires random access, so zip archive is read by ranges
+	// instead of being buffered on controller, and the latest range is cached since entries are read sequentially
+	zipReadBlockSize int64 = 4 << 20
+
+	blobDataSourceGVR = schema.GroupVersionResource{Group: stateStoreAPIGroup, Version: blobDataSourceAPIVer, Resource: blobDataSourceResource}
+)
+
+// populateClient copies or unpacks content of a BlobDataSource into destination container
+type populateClient interface {
+	cloneCopyClient
+	GetSourceMetadata(ctx context.Context) (map[string]string, error)
+	// GetSourceBlob returns properties of the blob in source container
+	GetSourceBlob(ctx context.Context, name string) (copySourceBlob, error)
+	// DownloadSourceBlob downloads count bytes of the blob from offset, count 0 means to the end of the blob
+	DownloadSourceBlob(ctx context.Context, name string, offset, count int64) (io.ReadCloser, error)
+	// UploadBlob uploads body as a block blob in destination container
+	UploadBlob(ctx context.Context, name string, body io.Reader) error
+}
+
+// populateClientFactory creates populate client, source container URL could contain a SAS token or refer to a public container,
+// destination container URL contains a SAS token or dstCredential is used
+type populateClientFactory interface {
+	NewPopulateClient(srcContainerURL, dstContainerURL string, dstCredential azcore.TokenCredential) (populateClient, error)
+}
+
+// blobDataSource is a parsed BlobDataSource custom resource
+type blobDataSource struct {
+	// url of source container or blob without SAS token, it's recorded in destination container metadata
+	url          string
+	containerURL string
+	blobName     string
+	sasToken     string
+	format       string
+	// restorePoint is the restore point of a volume backup in the format of subscriptionID/resourceGroup/account/container
+	// or account/container, it could only be restored in the namespace of the backed up PVC
+	restorePoint string
+	namespace    string
+	// account and container of the restore point
+	restorePointAccount   *azure.AccountOptions
+	restorePointContainer string
+}
+
+// parseBlobDataSourceURL parses source URL and format, format is detected from blob name if it's empty,
+// host of the URL should be a blob endpoint of the cloud so that controller does not send requests to arbitrary hosts
+func parseBlobDataSourceURL(sourceURL, format, storageEndpointSuffix string) (*blobDataSource, error) {
+	u, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url(%s): %w", sourceURL, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url(%s): only https url of blob or container is supported", u.Redacted())
+	}
+	accountName, found := strings.CutSuffix(strings.ToLower(u.Host), ".blob."+strings.ToLower(storageEndpointSuffix))
+	if !found || accountName == "" || strings.ContainsAny(accountName, ".:") {
+		return nil, fmt.Errorf("invalid url(%s): host should be <account>.blob.%s", u.Redacted(), storageEndpointSuffix)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if parts[0] == "" {
+		return nil, fmt.Errorf("invalid url(%s): container name is empty", u.Redacted())
+	}
+	source := &blobDataSource{containerURL: fmt.Sprintf("%s://%s/%s", u.Scheme, u.Host, parts[0]), format: strings.ToLower(format)}
+	if len(parts) == 2 {
+		source.blobName = strings.TrimSuffix(parts[1], "/")
+	}
+	source.url = source.containerURL
+	if source.blobName != "" {
+		source.url += "/" + source.blobName
+	}
+	if u.RawQuery != "" {
+		source.sasToken = "?" + u.RawQuery
+	}
+
+	if source.format == "" {
+		name := strings.ToLower(source.blobName)
+		switch {
+		case name == "":
+			source.format = blobDataSourceFormatContainer
+		case strings.HasSuffix(name, ".tar"):
+			source.format = blobDataSourceFormatTar
+		case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+			source.format = blobDataSourceFormatTgz
+		case strings.HasSuffix(name, ".zip"):
+			source.format = blobDataSourceFormatZip
+		default:
+			source.format = blobDataSourceFormatBlob
+		}
+	}
+	switch source.format {
+	case blobDataSourceFormatContainer:
+		if source.blobName != "" {
+			return nil, fmt.Errorf("url(%s) should not contain blob name with format %s", source.url, source.format)
+		}
+	case blobDataSourceFormatBlob, blobDataSourceFormatTar, blobDataSourceFormatTgz, blobDataSourceFormatZip:
+		if source.blobName == "" {
+			return nil, fmt.Errorf("url(%s) should contain blob name with format %s", source.url, source.format)
+		}
+	default:
+		return nil, fmt.Errorf("format(%s) is not supported, supported formats: %s, %s, %s, %s, %s", format,
+			blobDataSourceFormatContainer, blobDataSourceFormatBlob, blobDataSourceFormatTar, blobDataSourceFormatTgz, blobDataSourceFormatZip)
+	}
+	return source, nil
+}
+
+// EnableVolumePopulator populates PVCs with BlobDataSource data source in controller
+func (d *Driver) EnableVolumePopulator(dynamicClient dynamic.Interface) {
+	klog.V(2).Infof("volume populator of %s is enabled", blobDataSourceKind)
+	d.blobDataSourceClient = dynamicClient
+}
+
+// runVolumePopulator checks pending PVCs with BlobDataSource periodically until ctx is done
+func (d *Driver) runVolumePopulator(ctx context.Context) {
+	if d.blobDataSourceClient == nil || d.KubeClient == nil {
+		return
+	}
+	if !d.waitForControllerInformers(ctx) {
+		return
+	}
+	wait.UntilWithContext(ctx, d.syncPopulatedPVCs, volumePopulatorSyncInterval)
+}
+
+// isBlobDataSourcePVC returns true if the PVC is pending and its data source is BlobDataSource
+func isBlobDataSourcePVC(pvc *v1.PersistentVolumeClaim) bool {
+	ref := pvc.Spec.DataSourceRef
+	return ref != nil && ptr.Deref(ref.APIGroup, "") == stateStoreAPIGroup && ref.Kind == blobDataSourceKind &&
+		pvc.Spec.VolumeName == "" && pvc.DeletionTimestamp == nil
+}
+
+// syncPopulatedPVCs starts populating pending PVCs with BlobDataSource in background, a PVC is only populated by one goroutine
+func (d *Driver) syncPopulatedPVCs(ctx context.Context) {
+	pvcs, err := d.pvcLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list pvc: %v", err)
+		return
+	}
+	for _, pvc := range pvcs {
+		if !isBlobDataSourcePVC(pvc) {
+			continue
+		}
+		if _, loaded := d.populateJobs.LoadOrStore(pvc.UID, struct{}{}); loaded {
+			continue
+		}
+		go func() {
+			defer d.populateJobs.Delete(pvc.UID)
+			if err := d.populateVolume(ctx, pvc); err != nil {
+				klog.Errorf("failed to populate pvc(%s/%s): %v", pvc.Namespace, pvc.Name, err)
+			}
+		}()
+	}
+}
+
+// populateVolume creates the volume of PVC, populates content of BlobDataSource and creates PV bound to the PVC,
+// every step is idempotent so it's retried on next sync if it fails
+func (d *Driver) populateVolume(ctx context.Context, pvc *v1.PersistentVolumeClaim) error {
+	scName := ptr.Deref(pvc.Spec.StorageClassName, "")
+	if scName == "" {
+		return nil
+	}
+	sc, err := d.KubeClient.StorageV1().StorageClasses().Get(ctx, scName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get storage class(%s): %w", scName, err)
+	}
+	if sc.Provisioner != d.Name {
+		return nil
+	}
+	volName := "pvc-" + string(pvc.UID)
+	if _, err := d.KubeClient.CoreV1().PersistentVolumes().Get(ctx, volName, metav1.GetOptions{}); err == nil {
+		klog.V(4).Infof("pv(%s) of pvc(%s/%s) already exists", volName, pvc.Namespace, pvc.Name)
+		return nil
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	job := newNativeCopyJob()
+	err = d.populateVolumeWithProgress(ctx, pvc, sc, volName, job)
+	if err != nil {
+		d.publishPopulateProgress(ctx, pvc, v1.EventTypeWarning, populateFailedReason, cloneStateFailed, job.progress(), err.Error())
+	}
+	return err
+}
+
+func (d *Driver) populateVolumeWithProgress(ctx context.Context, pvc *v1.PersistentVolumeClaim, sc *storagev1.StorageClass, volName string, job *nativeCopyJob) error {
+	source, err := d.getBlobDataSource(ctx, pvc)
+	if err != nil {
+		return err
+	}
+	req, err := d.newPopulateCreateVolumeRequest(ctx, pvc, sc, volName)
+	if err != nil {
+		return err
+	}
+	resp, err := d.CreateVolume(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to create volume(%s): %w", volName, err)
+	}
+	d.publishPopulateProgress(ctx, pvc, v1.EventTypeNormal, populateStartedReason, cloneStateInProgress, job.progress(), fmt.Sprintf("populate volume(%s) from %s", resp.Volume.VolumeId, source.url))
+
+	stop := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		ticker := time.NewTicker(populateProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				d.publishPopulateProgress(ctx, pvc, v1.EventTypeNormal, populateProgressReason, cloneStateInProgress, job.progress(), "")
+			}
+		}
+	}()
+	err = d.populateContainer(ctx, resp.Volume.VolumeId, source, job)
+	close(stop)
+	<-reported
+	if err != nil {
+		return err
+	}
+
+	pv, err := newPopulatedPV(pvc, sc, volName, d.Name, resp.Volume)
+	if err != nil {
+		return err
+	}
+	if _, err := d.KubeClient.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create pv(%s): %w", volName, err)
+	}
+	progress := job.progress()
+	progress.completed = true
+	d.publishPopulateProgress(ctx, pvc, v1.EventTypeNormal, populateSucceededReason, cloneStateCompleted, progress, fmt.Sprintf("pv(%s) is created", volName))
+	return nil
+}
+
+// getBlobDataSource gets BlobDataSource referenced by the PVC and SAS token in its secret
+func (d *Driver) getBlobDataSource(ctx context.Context, pvc *v1.PersistentVolumeClaim) (*blobDataSource, error) {
+	ref := pvc.Spec.DataSourceRef
+	if ns := ptr.Deref(ref.Namespace, ""); ns != "" && ns != pvc.Namespace {
+		return nil, fmt.Errorf("%s(%s/%s) in another namespace is not supported", blobDataSourceKind, ns, ref.Name)
+	}
+	obj, err := d.blobDataSourceClient.Resource(blobDataSourceGVR).Namespace(pvc.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s(%s/%s): %w", blobDataSourceKind, pvc.Namespace, ref.Name, err)
+	}
+	sourceURL, _, _ := unstructured.NestedString(obj.Object, "spec", "url")
+	format, _, _ := unstructured.NestedString(obj.Object, "spec", "format")
+	secretName, _, _ := unstructured.NestedString(obj.Object, "spec", "secretName")
+	restorePoint, _, _ := unstructured.NestedString(obj.Object, "spec", "restorePoint")
+	if restorePoint != "" {
+		if sourceURL != "" || secretName != "" {
+			return nil, fmt.Errorf("invalid %s(%s/%s): url and secretName could not be specified with restorePoint", blobDataSourceKind, pvc.Namespace, ref.Name)
+		}
+		return d.parseRestorePoint(restorePoint, pvc.Namespace)
+	}
+	source, err := parseBlobDataSourceURL(sourceURL, format, d.getStorageEndPointSuffix())
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s(%s/%s): %w", blobDataSourceKind, pvc.Namespace, ref.Name, err)
+	}
+	if secretName != "" {
+		secret, err := d.KubeClient.CoreV1().Secrets(pvc.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret(%s/%s) of %s(%s): %w", pvc.Namespace, secretName, blobDataSourceKind, ref.Name, err)
+		}
+		sasToken := strings.TrimSpace(string(secret.Data[blobDataSourceSASTokenKey]))
+		if sasToken == "" {
+			return nil, fmt.Errorf("%s is empty in secret(%s/%s)", blobDataSourceSASTokenKey, pvc.Namespace, secretName)
+		}
+		source.sasToken = "?" + strings.TrimPrefix(sasToken, "?")
+	}
+	return source, nil
+}
+
+// parseRestorePoint parses restore point in the format of subscriptionID/resourceGroup/account/container into data source
+// of the namespace, account/container is also supported and the backup account is in the resource group of the cluster
+func (d *Driver) parseRestorePoint(restorePoint, namespace string) (*blobDataSource, error) {
+	account := &azure.AccountOptions{}
+	var containerName string
+	switch parts := strings.Split(restorePoint, "/"); len(parts) {
+	case 2:
+		account.Name, containerName = parts[0], parts[1]
+	case 4:
+		account.SubscriptionID, account.ResourceGroup, account.Name, containerName = parts[0], parts[1], parts[2], parts[3]
+	}
+	if account.Name == "" || !strings.HasPrefix(containerName, restorePointPrefix) {
+		return nil, fmt.Errorf("invalid restorePoint(%s): should be in the format of subscriptionID/resourceGroup/account/%s* or account/%s*", restorePoint, restorePointPrefix, restorePointPrefix)
+	}
+	containerURL := fmt.Sprintf("https://%s.blob.%s/%s", account.Name, d.getStorageEndPointSuffix(), containerName)
+	return &blobDataSource{
+		url:                   containerURL,
+		containerURL:          containerURL,
+		format:                blobDataSourceFormatContainer,
+		restorePoint:          restorePoint,
+		namespace:             namespace,
+		restorePointAccount:   account,
+		restorePointContainer: containerName,
+	}, nil
+}
+
+// newPopulateCreateVolumeRequest builds CreateVolume request from PVC and storage class like external-provisioner
+func (d *Driver) newPopulateCreateVolumeRequest(ctx context.Context, pvc *v1.PersistentVolumeClaim, sc *storagev1.StorageClass, volName string) (*csi.CreateVolumeRequest, error) {
+	parameters := getDriverParameters(sc.Parameters)
+	parameters[pvcNameKey] = pvc.Name
+	parameters[pvcNamespaceKey] = pvc.Namespace
+	parameters[pvNameKey] = volName
+
+	var volCaps []*csi.VolumeCapability
+	for _, mode := range pvc.Spec.AccessModes {
+		volCaps = append(volCaps, &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: sc.MountOptions}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: getCSIAccessMode(mode)},
+		})
+	}
+	req := &csi.CreateVolumeRequest{
+		Name:               volName,
+		Parameters:         parameters,
+		VolumeCapabilities: volCaps,
+	}
+	if capacity, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]; ok {
+		req.CapacityRange = &csi.CapacityRange{RequiredBytes: capacity.Value()}
+	}
+
+	secretRef, err := getStorageClassSecretRef(sc.Parameters, provisionerSecretNameParameter, provisionerSecretNamespaceParameter)
+	if err != nil || secretRef == nil {
+		return req, err
+	}
+	secret, err := d.KubeClient.CoreV1().Secrets(secretRef.Namespace).Get(ctx, secretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provisioner secret(%s/%s): %w", secretRef.Namespace, secretRef.Name, err)
+	}
+	req.Secrets = map[string]string{}
+	for k, v := range secret.Data {
+		req.Secrets[k] = string(v)
+	}
+	return req, nil
+}
+
+// getStorageClassSecretRef returns the secret reference in storage class parameters, templates are not supported
+func getStorageClassSecretRef(parameters map[string]string, nameKey, namespaceKey string) (*v1.SecretReference, error) {
+	name, namespace := parameters[nameKey], parameters[namespaceKey]
+	if name == "" {
+		return nil, nil
+	}
+	if strings.Contains(name, "${") || strings.Contains(namespace, "${") {
+		return nil, fmt.Errorf("template in %s or %s is not supported by volume populator", nameKey, namespaceKey)
+	}
+	return &v1.SecretReference{Name: name, Namespace: namespace}, nil
+}
+
+func getCSIAccessMode(mode v1.PersistentVolumeAccessMode) csi.VolumeCapability_AccessMode_Mode {
+	switch mode {
+	case v1.ReadWriteOnce:
+		return csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+	case v1.ReadOnlyMany:
+		return csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+	case v1.ReadWriteOncePod:
+		return csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER
+	default:
+		return csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+	}
+}
+
+// newPopulatedPV returns PV of the created volume which is pre-bound to the PVC, the PV is deleted by external-provisioner
+func newPopulatedPV(pvc *v1.PersistentVolumeClaim, sc *storagev1.StorageClass, volName, driverName string, vol *csi.Volume) (*v1.PersistentVolume, error) {
+	nodeStageSecretRef, err := getStorageClassSecretRef(sc.Parameters, nodeStageSecretNameParameter, nodeStageSecretNamespaceParameter)
+	if err != nil {
+		return nil, err
+	}
+	reclaimPolicy := v1.PersistentVolumeReclaimDelete
+	if sc.ReclaimPolicy != nil {
+		reclaimPolicy = *sc.ReclaimPolicy
+	}
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        volName,
+			Annotations: map[string]string{provisionedByAnnotation: driverName},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity:                      v1.ResourceList{v1.ResourceStorage: pvc.Spec.Resources.Requests[v1.ResourceStorage]},
+			AccessModes:                   pvc.Spec.AccessModes,
+			PersistentVolumeReclaimPolicy: reclaimPolicy,
+			StorageClassName:              sc.Name,
+			MountOptions:                  sc.MountOptions,
+			VolumeMode:                    pvc.Spec.VolumeMode,
+			ClaimRef: &v1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  pvc.Namespace,
+				Name:       pvc.Name,
+				UID:        pvc.UID,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:             driverName,
+					VolumeHandle:       vol.VolumeId,
+					VolumeAttributes:   vol.VolumeContext,
+					NodeStageSecretRef: nodeStageSecretRef,
+				},
+			},
+		},
+	}, nil
+}
+
+// populateContainer copies or unpacks the data source into container of the volume,
+// the completed state is recorded in container metadata so that it's skipped on retry
+func (d *Driver) populateContainer(ctx context.Context, volumeID string, source *blobDataSource, job *nativeCopyJob) error {
+	resourceGroup, accountName, containerName, secretNamespace, subsID, err := GetContainerInfo(volumeID)
+	if err != nil {
+		return err
+	}
+	storageEndpointSuffix := d.getStorageEndPointSuffix()
+	if info, err := parseVolumeID(volumeID); err == nil && info.storageEndpointSuffix != "" {
+		storageEndpointSuffix = info.storageEndpointSuffix
+	}
+	accountOptions := &azure.AccountOptions{Name: accountName, ResourceGroup: resourceGroup, SubscriptionID: subsID}
+	dstSasToken, _, err := d.getAzcopyAuth(ctx, accountName, "", storageEndpointSuffix, accountOptions, nil, "", secretNamespace, false)
+	if err != nil {
+		return fmt.Errorf("failed to get credential of account(%s): %w", accountName, err)
+	}
+	credential, err := d.getCopyDestinationCredential(accountName, dstSasToken)
+	if err != nil {
+		return err
+	}
+	if source.restorePoint != "" && source.sasToken == "" {
+		account := source.restorePointAccount
+		if source.sasToken, err = d.getCloneSourceSASToken(ctx, account.Name, source.restorePointContainer, storageEndpointSuffix, account, secretNamespace); err != nil {
+			return err
+		}
+	}
+	dstURL := fmt.Sprintf("https://%s.blob.%s/%s%s", accountName, storageEndpointSuffix, containerName, dstSasToken)
+	client, err := d.populateClientFactory.NewPopulateClient(source.containerURL+source.sasToken, dstURL, credential)
+	if err != nil {
+		return err
+	}
+	if source.restorePoint != "" {
+		if err := checkRestorePoint(ctx, client, source); err != nil {
+			return err
+		}
+	}
+
+	klog.V(2).Infof("populate container(%s) account(%s) from %s with format %s", containerName, accountName, source.url, source.format)
+	if source.format == blobDataSourceFormatContainer {
+		return copyContainer(ctx, client, source.url, d.cloneCopyParallelism, job)
+	}
+
+	metadata, err := client.GetDestinationMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get destination container metadata: %w", err)
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	if progress := parseCloneProgress(metadata, source.url); progress.completed {
+		klog.V(2).Infof("populate from %s is already completed, %s", source.url, progress)
+		return nil
+	}
+	switch source.format {
+	case blobDataSourceFormatBlob:
+		b, err := client.GetSourceBlob(ctx, source.blobName)
+		if err != nil {
+			return fmt.Errorf("failed to get source blob(%s): %w", source.blobName, err)
+		}
+		if err := client.CopyBlob(ctx, b); err != nil {
+			return fmt.Errorf("failed to copy blob(%s): %w", source.blobName, err)
+		}
+		job.copiedBlobs.Add(1)
+		job.copiedBytes.Add(b.size)
+	default:
+		if err := unpackArchive(ctx, client, source.blobName, source.format, job); err != nil {
+			return err
+		}
+	}
+	progress := job.progress()
+	progress.completed = true
+	progress.setCheckpoint(metadata, source.url)
+	return client.SetDestinationMetadata(ctx, metadata)
+}
+
+// checkRestorePoint checks that the restore point is completed and belongs to a PVC in the same namespace
+func checkRestorePoint(ctx context.Context, client populateClient, source *blobDataSource) error {
+	metadata, err := client.GetSourceMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata of restore point(%s): %w", source.restorePoint, err)
+	}
+	if metadata[backupPVCNamespaceMetadata] != source.namespace {
+		return fmt.Errorf("restore point(%s) does not belong to namespace(%s)", source.restorePoint, source.namespace)
+	}
+	if !parseCloneProgress(metadata, metadata[cloneSourceMetadata]).completed {
+		return fmt.Errorf("restore point(%s) is not completed", source.restorePoint)
+	}
+	return nil
+}
+
+// unpackArchive downloads the archive blob and uploads its regular files into destination container
+func unpackArchive(ctx context.Context, client populateClient, blobName, format string, job *nativeCopyJob) error {
+	if format == blobDataSourceFormatZip {
+		return unpackZipArchive(ctx, client, blobName, job)
+	}
+	body, err := client.DownloadSourceBlob(ctx, blobName, 0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to download archive(%s): %w", blobName, err)
+	}
+	defer body.Close()
+
+	var r io.Reader = body
+	if format == blobDataSourceFormatTgz {
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("failed to read archive(%s): %w", blobName, err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive(%s): %w", blobName, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := uploadArchiveEntry(ctx, client, hdr.Name, tr, hdr.Size, job); err != nil {
+			return err
+		}
+	}
+}
+
+// unpackZipArchive reads zip archive by ranges of the blob since zip reader requires random access
+func unpackZipArchive(ctx context.Context, client populateClient, blobName string, job *nativeCopyJob) error {
+	b, err := client.GetSourceBlob(ctx, blobName)
+	if err != nil {
+		return fmt.Errorf("failed to get source blob(%s): %w", blobName, err)
+	}
+	zr, err := zip.NewReader(&blobReaderAt{ctx: ctx, client: client, name: blobName, size: b.size}, b.size)
+	if err != nil {
+		return fmt.Errorf("failed to read archive(%s): %w", blobName, err)
+	}
+	for _, file := range zr.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to read %s in archive(%s): %w", file.Name, blobName, err)
+		}
+		err = uploadArchiveEntry(ctx, client, file.Name, r, int64(file.UncompressedSize64), job)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blobReaderAt implements io.ReaderAt of source blob by downloading ranges of zipReadBlockSize, the latest range is cached
+type blobReaderAt struct {
+	ctx    context.Context
+	client populateClient
+	name   string
+	size   int64
+	// offset and content of the cached range
+	offset int64
+	block  []byte
+}
+
+func (r *blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset(%d) of blob(%s)", off, r.name)
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < r.size {
+		pos := off + int64(n)
+		if pos < r.offset || pos >= r.offset+int64(len(r.block)) {
+			if err := r.download(pos); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], r.block[pos-r.offset:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *blobReaderAt) download(offset int64) error {
+	count := min(zipReadBlockSize, r.size-offset)
+	body, err := r.client.DownloadSourceBlob(r.ctx, r.name, offset, count)
+	if err != nil {
+		return fmt.Errorf("failed to download range(%d, %d) of blob(%s): %w", offset, count, r.name, err)
+	}
+	defer body.Close()
+	if int64(cap(r.block)) < count {
+		r.block = make([]byte, count)
+	}
+	block := r.block[:count]
+	if _, err := io.ReadFull(body, block); err != nil {
+		r.block = r.block[:0]
+		return fmt.Errorf("failed to download range(%d, %d) of blob(%s): %w", offset, count, r.name, err)
+	}
+	r.offset, r.block = offset, block
+	return nil
+}
+
+func uploadArchiveEntry(ctx context.Context, client populateClient, name string, r io.Reader, size int64, job *nativeCopyJob) error {
+	blobName, err := getArchiveEntryBlobName(name)
+	if err != nil {
+		return err
+	}
+	if err := client.UploadBlob(ctx, blobName, r); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", blobName, err)
+	}
+	job.copiedBlobs.Add(1)
+	job.copiedBytes.Add(size)
+	return nil
+}
+
+// getArchiveEntryBlobName returns blob name of the file in archive, paths out of the archive root are rejected
+func getArchiveEntryBlobName(name string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid path(%s) in archive", name)
+	}
+	return cleaned, nil
+}
+
+// publishPopulateProgress records an event and updates populate annotations on the PVC
+func (d *Driver) publishPopulateProgress(ctx context.Context, pvc *v1.PersistentVolumeClaim, eventType, reason, state string, progress cloneProgress, detail string) {
+	message := progress.String()
+	if detail != "" {
+		message = detail + ", " + message
+	}
+	d.recordVolumeEvent(ctx, map[string]string{pvcNameKey: pvc.Name, pvcNamespaceKey: pvc.Namespace}, eventType, reason, "%s", message)
+	d.patchPVCAnnotations(ctx, pvc.Namespace, pvc.Name, map[string]string{
+		populateStateAnnotation:    state,
+		populateProgressAnnotation: progress.String(),
+	})
+}
+
+func (f *azblobCloneCopyClientFactory) NewPopulateClient(srcContainerURL, dstContainerURL string, dstCredential azcore.TokenCredential) (populateClient, error) {
+	client, err := f.NewCloneCopyClient(srcContainerURL, dstContainerURL, dstCredential)
+	if err != nil {
+		return nil, err
+	}
+	return client.(*azblobCloneCopyClient), nil
+}
+
+func (c *azblobCloneCopyClient) GetSourceMetadata(ctx context.Context) (map[string]string, error) {
+	resp, err := c.src.GetProperties(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]string{}
+	for k, v := range resp.Metadata {
+		if v != nil {
+			// metadata keys in response headers are canonicalized
+			metadata[strings.ToLower(k)] = *v
+		}
+	}
+	return metadata, nil
+}
+
+func (c *azblobCloneCopyClient) GetSourceBlob(ctx context.Context, name string) (copySourceBlob, error) {
+	resp, err := c.src.NewBlobClient(name).GetProperties(ctx, nil)
+	if err != nil {
+		return copySourceBlob{}, err
+	}
+	b := copySourceBlob{
+		name:     name,
+		size:     ptr.Deref(resp.ContentLength, 0),
+		blobType: ptr.Deref(resp.BlobType, ""),
+		metadata: map[string]*string{},
+		headers: &blob.HTTPHeaders{
+			BlobContentType:        resp.ContentType,
+			BlobContentEncoding:    resp.ContentEncoding,
+			BlobContentLanguage:    resp.ContentLanguage,
+			BlobContentDisposition: resp.ContentDisposition,
+			BlobCacheControl:       resp.CacheControl,
+			BlobContentMD5:         resp.ContentMD5,
+		},
+	}
+	for k, v := range resp.Metadata {
+		// metadata keys in response headers are canonicalized
+		b.metadata[strings.ToLower(k)] = v
+	}
+	return b, nil
+}
+
+func (c *azblobCloneCopyClient) DownloadSourceBlob(ctx context.Context, name string, offset, count int64) (io.ReadCloser, error) {
+	resp, err := c.src.NewBlobClient(name).DownloadStream(ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset, Count: count},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *azblobCloneCopyClient) UploadBlob(ctx context.Context, name string, body io.Reader) error {
+	_, err := c.dst.NewBlockBlobClient(name).UploadStream(ctx, body, nil)
+	return err
+}
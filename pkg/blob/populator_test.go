@@ -0,0 +1,677 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// fakePopulateClient serves source blobs from memory and records uploaded blobs
+type fakePopulateClient struct {
+	*fakeCloneCopyClient
+	sourceBlobs    map[string][]byte
+	sourceMetadata map[string]string
+	uploaded       map[string]string
+	// number of source blob downloads
+	downloads int
+}
+
+func (c *fakePopulateClient) NewPopulateClient(srcContainerURL, dstContainerURL string, dstCredential azcore.TokenCredential) (populateClient, error) {
+	c.srcURL, c.dstURL, c.dstCredential = srcContainerURL, dstContainerURL, dstCredential
+	return c, nil
+}
+
+func (c *fakePopulateClient) GetSourceMetadata(_ context.Context) (map[string]string, error) {
+	return c.sourceMetadata, nil
+}
+
+func (c *fakePopulateClient) GetSourceBlob(_ context.Context, name string) (copySourceBlob, error) {
+	data, ok := c.sourceBlobs[name]
+	if !ok {
+		return copySourceBlob{}, fmt.Errorf("blob %s not found", name)
+	}
+	return copySourceBlob{name: name, size: int64(len(data))}, nil
+}
+
+func (c *fakePopulateClient) DownloadSourceBlob(_ context.Context, name string, offset, count int64) (io.ReadCloser, error) {
+	data, ok := c.sourceBlobs[name]
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found", name)
+	}
+	c.downloads++
+	data = data[offset:]
+	if count > 0 {
+		data = data[:count]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *fakePopulateClient) UploadBlob(_ context.Context, name string, body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	c.uploaded[name] = string(data)
+	return nil
+}
+
+func newFakePopulateClient() *fakePopulateClient {
+	return &fakePopulateClient{fakeCloneCopyClient: newFakeCloneCopyClient(3), sourceBlobs: map[string][]byte{}, uploaded: map[string]string{}}
+}
+
+func newTarArchive(t *testing.T, gzipped bool, files map[string]string) []byte {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gw *gzip.Writer
+	if gzipped {
+		gw = gzip.NewWriter(&buf)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	if gw != nil {
+		require.NoError(t, gw.Close())
+	}
+	return buf.Bytes()
+}
+
+func newZipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	_, err := zw.Create("dir/")
+	require.NoError(t, err)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestParseBlobDataSourceURL(t *testing.T) {
+	tests := []struct {
+		desc           string
+		url            string
+		format         string
+		expectedSource *blobDataSource
+		expectedErr    string
+	}{
+		{
+			desc:   "container with sas token",
+			url:    "https://account.blob.core.windows.net/container?sv=2022&sig=abc",
+			format: "",
+			expectedSource: &blobDataSource{
+				url:          "https://account.blob.core.windows.net/container",
+				containerURL: "https://account.blob.core.windows.net/container",
+				sasToken:     "?sv=2022&sig=abc",
+				format:       blobDataSourceFormatContainer,
+			},
+		},
+		{
+			desc: "public blob",
+			url:  "https://account.blob.core.windows.net/container/models/model.bin",
+			expectedSource: &blobDataSource{
+				url:          "https://account.blob.core.windows.net/container/models/model.bin",
+				containerURL: "https://account.blob.core.windows.net/container",
+				blobName:     "models/model.bin",
+				format:       blobDataSourceFormatBlob,
+			},
+		},
+		{
+			desc: "tar.gz archive",
+			url:  "https://account.blob.core.windows.net/container/dataset.TAR.GZ",
+			expectedSource: &blobDataSource{
+				url:          "https://account.blob.core.windows.net/container/dataset.TAR.GZ",
+				containerURL: "https://account.blob.core.windows.net/container",
+				blobName:     "dataset.TAR.GZ",
+				format:       blobDataSourceFormatTgz,
+			},
+		},
+		{
+			desc:   "archive format of blob without extension",
+			url:    "https://account.blob.core.windows.net/container/dataset",
+			format: "Zip",
+			expectedSource: &blobDataSource{
+				url:          "https://account.blob.core.windows.net/container/dataset",
+				containerURL: "https://account.blob.core.windows.net/container",
+				blobName:     "dataset",
+				format:       blobDataSourceFormatZip,
+			},
+		},
+		{
+			desc:        "http url",
+			url:         "http://account.blob.core.windows.net/container",
+			expectedErr: "only https url of blob or container is supported",
+		},
+		{
+			desc:        "host out of cloud blob endpoint",
+			url:         "https://169.254.169.254/metadata",
+			expectedErr: "host should be <account>.blob.core.windows.net",
+		},
+		{
+			desc:        "host with port",
+			url:         "https://account.blob.core.windows.net:8443/container",
+			expectedErr: "host should be <account>.blob.core.windows.net",
+		},
+		{
+			desc:        "subdomain of blob endpoint",
+			url:         "https://evil.account.blob.core.windows.net/container",
+			expectedErr: "host should be <account>.blob.core.windows.net",
+		},
+		{
+			desc:        "blob endpoint of another cloud",
+			url:         "https://account.blob.core.chinacloudapi.cn/container",
+			expectedErr: "host should be <account>.blob.core.windows.net",
+		},
+		{
+			desc:        "empty container name",
+			url:         "https://account.blob.core.windows.net/",
+			expectedErr: "container name is empty",
+		},
+		{
+			desc:        "blob name with container format",
+			url:         "https://account.blob.core.windows.net/container/blob",
+			format:      blobDataSourceFormatContainer,
+			expectedErr: "should not contain blob name",
+		},
+		{
+			desc:        "archive format without blob name",
+			url:         "https://account.blob.core.windows.net/container",
+			format:      blobDataSourceFormatTar,
+			expectedErr: "should contain blob name",
+		},
+		{
+			desc:        "unsupported format",
+			url:         "https://account.blob.core.windows.net/container/blob.7z",
+			format:      "7z",
+			expectedErr: "format(7z) is not supported",
+		},
+	}
+
+	for _, test := range tests {
+		source, err := parseBlobDataSourceURL(test.url, test.format, "core.windows.net")
+		if test.expectedErr != "" {
+			assert.ErrorContains(t, err, test.expectedErr, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expectedSource, source, test.desc)
+	}
+}
+
+func TestGetArchiveEntryBlobName(t *testing.T) {
+	tests := []struct {
+		name             string
+		expectedBlobName string
+		expectErr        bool
+	}{
+		{name: "data/file.txt", expectedBlobName: "data/file.txt"},
+		{name: "./data//file.txt", expectedBlobName: "data/file.txt"},
+		{name: `data\file.txt`, expectedBlobName: "data/file.txt"},
+		{name: "data/../file.txt", expectedBlobName: "file.txt"},
+		{name: "../file.txt", expectErr: true},
+		{name: "/etc/passwd", expectErr: true},
+		{name: ".", expectErr: true},
+	}
+
+	for _, test := range tests {
+		blobName, err := getArchiveEntryBlobName(test.name)
+		assert.Equal(t, test.expectErr, err != nil, test.name)
+		assert.Equal(t, test.expectedBlobName, blobName, test.name)
+	}
+}
+
+func TestIsBlobDataSourcePVC(t *testing.T) {
+	dataSourceRef := &v1.TypedObjectReference{APIGroup: ptr.To(stateStoreAPIGroup), Kind: blobDataSourceKind, Name: "source"}
+	tests := []struct {
+		desc     string
+		spec     v1.PersistentVolumeClaimSpec
+		expected bool
+	}{
+		{
+			desc:     "pending pvc with BlobDataSource",
+			spec:     v1.PersistentVolumeClaimSpec{DataSourceRef: dataSourceRef},
+			expected: true,
+		},
+		{
+			desc: "bound pvc",
+			spec: v1.PersistentVolumeClaimSpec{DataSourceRef: dataSourceRef, VolumeName: "pv"},
+		},
+		{
+			desc: "pvc cloned from another pvc",
+			spec: v1.PersistentVolumeClaimSpec{DataSourceRef: &v1.TypedObjectReference{Kind: "PersistentVolumeClaim", Name: "source"}},
+		},
+		{
+			desc: "pvc without data source",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isBlobDataSourcePVC(&v1.PersistentVolumeClaim{Spec: test.spec}), test.desc)
+	}
+}
+
+func TestPopulateContainer(t *testing.T) {
+	files := map[string]string{"data/a.txt": "a", "b.txt": "bb"}
+	tests := []struct {
+		desc             string
+		url              string
+		sourceBlobs      map[string][]byte
+		expectedCopied   []string
+		expectedUploaded map[string]string
+		expectedErr      string
+	}{
+		{
+			desc:           "container",
+			url:            "https://source.blob.core.windows.net/container?sig=src",
+			expectedCopied: []string{"blob0", "blob1", "blob2"},
+		},
+		{
+			desc:           "blob",
+			url:            "https://source.blob.core.windows.net/container/model.bin?sig=src",
+			sourceBlobs:    map[string][]byte{"model.bin": []byte("model")},
+			expectedCopied: []string{"model.bin"},
+		},
+		{
+			desc:             "tar archive",
+			url:              "https://source.blob.core.windows.net/container/data.tar",
+			sourceBlobs:      map[string][]byte{"data.tar": newTarArchive(t, false, files)},
+			expectedUploaded: files,
+		},
+		{
+			desc:             "tgz archive",
+			url:              "https://source.blob.core.windows.net/container/data.tgz",
+			sourceBlobs:      map[string][]byte{"data.tgz": newTarArchive(t, true, files)},
+			expectedUploaded: files,
+		},
+		{
+			desc:             "zip archive",
+			url:              "https://source.blob.core.windows.net/container/data.zip",
+			sourceBlobs:      map[string][]byte{"data.zip": newZipArchive(t, files)},
+			expectedUploaded: files,
+		},
+		{
+			desc:        "invalid zip archive",
+			url:         "https://source.blob.core.windows.net/container/invalid.zip",
+			sourceBlobs: map[string][]byte{"invalid.zip": make([]byte, 2048)},
+			expectedErr: "failed to read archive(invalid.zip)",
+		},
+		{
+			desc:        "archive with path out of root",
+			url:         "https://source.blob.core.windows.net/container/data.tar",
+			sourceBlobs: map[string][]byte{"data.tar": newTarArchive(t, false, map[string]string{"../a.txt": "a"})},
+			expectedErr: "invalid path(../a.txt) in archive",
+		},
+		{
+			desc:        "invalid archive",
+			url:         "https://source.blob.core.windows.net/container/data.tgz",
+			sourceBlobs: map[string][]byte{"data.tgz": []byte("invalid")},
+			expectedErr: "failed to read archive(data.tgz)",
+		},
+		{
+			desc:        "blob not found",
+			url:         "https://source.blob.core.windows.net/container/notfound.bin",
+			expectedErr: "blob notfound.bin not found",
+		},
+	}
+
+	// zip archive is read by multiple ranges
+	blockSize := zipReadBlockSize
+	zipReadBlockSize = 64
+	defer func() { zipReadBlockSize = blockSize }()
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		client := newFakePopulateClient()
+		client.sourceBlobs = test.sourceBlobs
+		d.populateClientFactory = client
+		d.azcopySasTokenCache.Set("account", "?sig=dst")
+		source, err := parseBlobDataSourceURL(test.url, "", "core.windows.net")
+		require.NoError(t, err, test.desc)
+
+		job := newNativeCopyJob()
+		err = d.populateContainer(context.Background(), "rg#account#container", source, job)
+		if test.expectedErr != "" {
+			assert.ErrorContains(t, err, test.expectedErr, test.desc)
+			assert.NotEqual(t, cloneStateCompleted, client.metadata[cloneStateMetadata], test.desc)
+			continue
+		}
+		require.NoError(t, err, test.desc)
+		assert.Equal(t, "https://account.blob.core.windows.net/container?sig=dst", client.dstURL, test.desc)
+		assert.ElementsMatch(t, test.expectedCopied, client.copied, test.desc)
+		if test.expectedUploaded != nil {
+			assert.Equal(t, test.expectedUploaded, client.uploaded, test.desc)
+			assert.Equal(t, int64(len(files)), job.progress().copiedBlobs, test.desc)
+		}
+		assert.Equal(t, cloneStateCompleted, client.metadata[cloneStateMetadata], test.desc)
+		assert.Equal(t, source.url, client.metadata[cloneSourceMetadata], test.desc)
+
+		// completed data source is skipped on retry
+		client.copied, client.uploaded = nil, map[string]string{}
+		require.NoError(t, d.populateContainer(context.Background(), "rg#account#container", source, newNativeCopyJob()), test.desc)
+		assert.Empty(t, client.copied, test.desc)
+		assert.Empty(t, client.uploaded, test.desc)
+	}
+}
+
+func TestPopulateContainerFromRestorePoint(t *testing.T) {
+	completed := map[string]string{cloneSourceMetadata: "account/src", cloneStateMetadata: cloneStateCompleted, backupPVCNamespaceMetadata: "ns"}
+	tests := []struct {
+		desc           string
+		restorePoint   string
+		namespace      string
+		metadata       map[string]string
+		expectedCopied []string
+		expectedErr    string
+	}{
+		{
+			desc:           "restore point",
+			restorePoint:   "backup/bkp-0123456789-202401020304",
+			namespace:      "ns",
+			metadata:       completed,
+			expectedCopied: []string{"blob0", "blob1", "blob2"},
+		},
+		{
+			desc:           "restore point with resource group and subscription",
+			restorePoint:   "sub/rg/backup/bkp-0123456789-202401020304",
+			namespace:      "ns",
+			metadata:       completed,
+			expectedCopied: []string{"blob0", "blob1", "blob2"},
+		},
+		{
+			desc:         "restore point of another namespace",
+			restorePoint: "backup/bkp-0123456789-202401020304",
+			namespace:    "other",
+			metadata:     completed,
+			expectedErr:  "does not belong to namespace(other)",
+		},
+		{
+			desc:         "restore point in progress",
+			restorePoint: "backup/bkp-0123456789-202401020304",
+			namespace:    "ns",
+			metadata:     map[string]string{cloneSourceMetadata: "account/src", cloneStateMetadata: cloneStateInProgress, backupPVCNamespaceMetadata: "ns"},
+			expectedErr:  "is not completed",
+		},
+		{
+			desc:         "invalid restore point",
+			restorePoint: "backup/container",
+			expectedErr:  "invalid restorePoint(backup/container)",
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		sasFactory := &fakeUserDelegationSASFactory{sasToken: "?sig=bkp"}
+		d.userDelegationSASFactory = sasFactory
+		client := newFakePopulateClient()
+		client.sourceMetadata = test.metadata
+		d.populateClientFactory = client
+		d.azcopySasTokenCache.Set("account", "?sig=dst")
+
+		source, err := d.parseRestorePoint(test.restorePoint, test.namespace)
+		if err == nil {
+			err = d.populateContainer(context.Background(), "rg#account#container", source, newNativeCopyJob())
+		}
+		if test.expectedErr != "" {
+			assert.ErrorContains(t, err, test.expectedErr, test.desc)
+			assert.Empty(t, client.copied, test.desc)
+			continue
+		}
+		require.NoError(t, err, test.desc)
+		assert.Equal(t, []string{"backup/bkp-0123456789-202401020304"}, sasFactory.requests, test.desc)
+		assert.Equal(t, "https://backup.blob.core.windows.net/bkp-0123456789-202401020304?sig=bkp", client.srcURL, test.desc)
+		assert.ElementsMatch(t, test.expectedCopied, client.copied, test.desc)
+	}
+}
+
+func TestParseRestorePoint(t *testing.T) {
+	tests := []struct {
+		restorePoint      string
+		expectedAccount   *azure.AccountOptions
+		expectedContainer string
+		expectedErr       string
+	}{
+		{
+			restorePoint:      "backup/bkp-0123456789-202401020304",
+			expectedAccount:   &azure.AccountOptions{Name: "backup"},
+			expectedContainer: "bkp-0123456789-202401020304",
+		},
+		{
+			restorePoint:      "sub/rg/backup/bkp-0123456789-202401020304",
+			expectedAccount:   &azure.AccountOptions{Name: "backup", ResourceGroup: "rg", SubscriptionID: "sub"},
+			expectedContainer: "bkp-0123456789-202401020304",
+		},
+		{
+			restorePoint: "rg/backup/bkp-0123456789-202401020304",
+			expectedErr:  "invalid restorePoint(rg/backup/bkp-0123456789-202401020304)",
+		},
+		{
+			restorePoint: "sub/rg//bkp-0123456789-202401020304",
+			expectedErr:  "invalid restorePoint(sub/rg//bkp-0123456789-202401020304)",
+		},
+	}
+
+	d := NewFakeDriver()
+	for _, test := range tests {
+		source, err := d.parseRestorePoint(test.restorePoint, "ns")
+		if test.expectedErr != "" {
+			assert.ErrorContains(t, err, test.expectedErr, test.restorePoint)
+			continue
+		}
+		require.NoError(t, err, test.restorePoint)
+		assert.Equal(t, test.expectedAccount, source.restorePointAccount, test.restorePoint)
+		assert.Equal(t, test.expectedContainer, source.restorePointContainer, test.restorePoint)
+		assert.Equal(t, "https://backup.blob.core.windows.net/bkp-0123456789-202401020304", source.containerURL, test.restorePoint)
+	}
+}
+
+func TestNewPopulateCreateVolumeRequest(t *testing.T) {
+	d := NewFakeDriver()
+	d.KubeClient = fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"},
+		Data:       map[string][]byte{"azurestorageaccountname": []byte("account")},
+	})
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns", UID: "uid"},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			Resources:   v1.VolumeResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")}},
+		},
+	}
+	sc := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "sc"},
+		Parameters: map[string]string{
+			skuNameField:                        "Premium_LRS",
+			provisionerSecretNameParameter:      "secret",
+			provisionerSecretNamespaceParameter: "default",
+			"csi.storage.k8s.io/fstype":         "ext4",
+		},
+		MountOptions: []string{"-o allow_other"},
+	}
+
+	req, err := d.newPopulateCreateVolumeRequest(context.Background(), pvc, sc, "pvc-uid")
+	require.NoError(t, err)
+	assert.Equal(t, "pvc-uid", req.Name)
+	assert.Equal(t, map[string]string{skuNameField: "Premium_LRS", pvcNameKey: "pvc", pvcNamespaceKey: "ns", pvNameKey: "pvc-uid"}, req.Parameters)
+	assert.Equal(t, map[string]string{"azurestorageaccountname": "account"}, req.Secrets)
+	assert.Equal(t, int64(10*1024*1024*1024), req.CapacityRange.RequiredBytes)
+	require.Len(t, req.VolumeCapabilities, 1)
+	assert.Equal(t, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, req.VolumeCapabilities[0].AccessMode.Mode)
+	assert.Equal(t, sc.MountOptions, req.VolumeCapabilities[0].GetMount().MountFlags)
+
+	sc.Parameters[provisionerSecretNameParameter] = "${pvc.name}"
+	_, err = d.newPopulateCreateVolumeRequest(context.Background(), pvc, sc, "pvc-uid")
+	assert.ErrorContains(t, err, "template")
+}
+
+func TestNewPopulatedPV(t *testing.T) {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns", UID: "uid"},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources:   v1.VolumeResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")}},
+		},
+	}
+	sc := &storagev1.StorageClass{
+		ObjectMeta:    metav1.ObjectMeta{Name: "sc"},
+		Parameters:    map[string]string{nodeStageSecretNameParameter: "secret", nodeStageSecretNamespaceParameter: "default"},
+		ReclaimPolicy: ptr.To(v1.PersistentVolumeReclaimRetain),
+	}
+	vol := &csi.Volume{VolumeId: "rg#account#container", VolumeContext: map[string]string{containerNameField: "container"}}
+
+	pv, err := newPopulatedPV(pvc, sc, "pvc-uid", "blob.csi.azure.com", vol)
+	require.NoError(t, err)
+	assert.Equal(t, "pvc-uid", pv.Name)
+	assert.Equal(t, "blob.csi.azure.com", pv.Annotations[provisionedByAnnotation])
+	assert.Equal(t, v1.PersistentVolumeReclaimRetain, pv.Spec.PersistentVolumeReclaimPolicy)
+	assert.Equal(t, resource.MustParse("1Gi"), pv.Spec.Capacity[v1.ResourceStorage])
+	assert.Equal(t, &v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: "ns", Name: "pvc", UID: "uid"}, pv.Spec.ClaimRef)
+	assert.Equal(t, "rg#account#container", pv.Spec.CSI.VolumeHandle)
+	assert.Equal(t, vol.VolumeContext, pv.Spec.CSI.VolumeAttributes)
+	assert.Equal(t, &v1.SecretReference{Name: "secret", Namespace: "default"}, pv.Spec.CSI.NodeStageSecretRef)
+}
+
+func TestPopulateVolume(t *testing.T) {
+	newPVC := func(namespace string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns", UID: "uid"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: ptr.To("sc"),
+				DataSourceRef:    &v1.TypedObjectReference{APIGroup: ptr.To(stateStoreAPIGroup), Kind: blobDataSourceKind, Name: "source", Namespace: ptr.To(namespace)},
+			},
+		}
+	}
+	tests := []struct {
+		desc          string
+		pvc           *v1.PersistentVolumeClaim
+		provisioner   string
+		existingPV    bool
+		expectedErr   string
+		expectedEvent bool
+	}{
+		{
+			desc:        "storage class of another provisioner",
+			pvc:         newPVC(""),
+			provisioner: "other.csi.azure.com",
+		},
+		{
+			desc:        "pv already exists",
+			pvc:         newPVC(""),
+			provisioner: fakeDriverName,
+			existingPV:  true,
+		},
+		{
+			desc:          "data source in another namespace",
+			pvc:           newPVC("other"),
+			provisioner:   fakeDriverName,
+			expectedErr:   "BlobDataSource(other/source) in another namespace is not supported",
+			expectedEvent: true,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		objects := []runtime.Object{test.pvc, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc"}, Provisioner: test.provisioner}}
+		if test.existingPV {
+			objects = append(objects, &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-uid"}})
+		}
+		d.KubeClient = fake.NewSimpleClientset(objects...)
+		recorder := record.NewFakeRecorder(10)
+		d.eventRecorder = recorder
+
+		err := d.populateVolume(context.Background(), test.pvc)
+		if test.expectedErr != "" {
+			assert.ErrorContains(t, err, test.expectedErr, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+		if test.expectedEvent {
+			assert.Contains(t, <-recorder.Events, "Warning PopulateFailed "+test.expectedErr, test.desc)
+			pvc, err := d.KubeClient.CoreV1().PersistentVolumeClaims("ns").Get(context.Background(), "pvc", metav1.GetOptions{})
+			require.NoError(t, err, test.desc)
+			assert.Equal(t, cloneStateFailed, pvc.Annotations[populateStateAnnotation], test.desc)
+		} else {
+			assert.Empty(t, recorder.Events, test.desc)
+		}
+	}
+}
+
+func TestBlobReaderAt(t *testing.T) {
+	blockSize := zipReadBlockSize
+	zipReadBlockSize = 4
+	defer func() { zipReadBlockSize = blockSize }()
+
+	client := newFakePopulateClient()
+	client.sourceBlobs = map[string][]byte{"blob": []byte("0123456789")}
+	r := &blobReaderAt{ctx: context.Background(), client: client, name: "blob", size: 10}
+
+	p := make([]byte, 3)
+	n, err := r.ReadAt(p, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "012", string(p[:n]))
+	// read from cached range
+	n, err = r.ReadAt(p[:1], 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(p[:n]))
+	assert.Equal(t, 1, client.downloads)
+
+	// read across ranges
+	n, err = r.ReadAt(p, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "345", string(p[:n]))
+	assert.Equal(t, 2, client.downloads)
+
+	// read beyond the end
+	n, err = r.ReadAt(p, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "89", string(p[:n]))
+	n, err = r.ReadAt(p, 10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+
+	_, err = r.ReadAt(p, -1)
+	assert.Error(t, err)
+}
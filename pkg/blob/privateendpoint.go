@@ -0,0 +1,191 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/privatedns/armprivatedns"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+const (
+	// privateDNSZoneLinkVerify fails CreateVolume if the private DNS zone or its link to the cluster VNet does not exist
+	privateDNSZoneLinkVerify = "verify"
+	// privateDNSZoneLinkCreate creates the private DNS zone and its link to the cluster VNet if not exist
+	privateDNSZoneLinkCreate = "create"
+	// privateDNSZoneLinkSkip skips the check, e.g. the endpoint is resolved by custom DNS servers, it's the default mode
+	privateDNSZoneLinkSkip = "skip"
+
+	privateEndpointResolutionReason = "PrivateEndpointResolution"
+
+	privateDNSZoneNameFmt = "privatelink.blob.%s"
+	// the same virtual network link name as cloud provider uses when creating the private endpoint
+	vnetLinkNameSuffix = "-vnetlink"
+	vnetIDTemplate     = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s"
+)
+
+var supportedPrivateDNSZoneLinkList = []string{privateDNSZoneLinkVerify, privateDNSZoneLinkCreate, privateDNSZoneLinkSkip}
+
+// ipResolver resolves host name to IP addresses, net.Resolver implements it
+type ipResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func isSupportedPrivateDNSZoneLink(mode string) bool {
+	if mode == "" {
+		return true
+	}
+	for _, v := range supportedPrivateDNSZoneLinkList {
+		if v == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// ensurePrivateDNSZoneLink verifies that the private DNS zone of blob endpoint exists and is linked to the cluster VNet,
+// so that the account name resolves to the private endpoint in the cluster for all protocols. The zone and the link are
+// created if mode is create.
+func (d *Driver) ensurePrivateDNSZoneLink(ctx context.Context, vnetResourceGroup, vnetName, storageEndpointSuffix, mode string) error {
+	if mode == privateDNSZoneLinkSkip {
+		return nil
+	}
+	if d.networkClientFactory == nil {
+		return status.Errorf(codes.Internal, "networkClientFactory is nil")
+	}
+	if vnetResourceGroup == "" {
+		vnetResourceGroup = d.cloud.ResourceGroup
+		if len(d.cloud.VnetResourceGroup) > 0 {
+			vnetResourceGroup = d.cloud.VnetResourceGroup
+		}
+	}
+	if vnetName == "" {
+		vnetName = d.cloud.VnetName
+	}
+	if vnetName == "" {
+		return status.Errorf(codes.InvalidArgument, "vnetName is empty, could not check private DNS zone link")
+	}
+	zoneName := fmt.Sprintf(privateDNSZoneNameFmt, storageEndpointSuffix)
+	linkName := vnetName + vnetLinkNameSuffix
+	vnetID := fmt.Sprintf(vnetIDTemplate, d.cloud.SubscriptionID, vnetResourceGroup, vnetName)
+
+	cacheKey := vnetResourceGroup + "/" + zoneName + "/" + linkName
+	cache, err := d.privateDNSZoneLinkCache.Get(cacheKey, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	if cache != nil {
+		return nil
+	}
+
+	create := mode == privateDNSZoneLinkCreate
+	zoneClient := d.networkClientFactory.GetPrivateZoneClient()
+	err = d.armRateLimiter.do(ctx, "GetPrivateZone", func() error {
+		_, getErr := zoneClient.Get(ctx, vnetResourceGroup, zoneName)
+		return getErr
+	})
+	if err != nil {
+		if !isNotFoundError(err) {
+			return status.Errorf(codes.Internal, "get private DNS zone(%s) in resourceGroup(%s) failed with %v", zoneName, vnetResourceGroup, err)
+		}
+		if !create {
+			return status.Errorf(codes.FailedPrecondition, "private DNS zone(%s) does not exist in resourceGroup(%s), set %s as %s to create it", zoneName, vnetResourceGroup, privateDNSZoneLinkField, privateDNSZoneLinkCreate)
+		}
+		klog.V(2).Infof("creating private DNS zone(%s) in resourceGroup(%s)", zoneName, vnetResourceGroup)
+		if err := d.armRateLimiter.do(ctx, "CreatePrivateZone", func() error {
+			_, createErr := zoneClient.CreateOrUpdate(ctx, vnetResourceGroup, zoneName, armprivatedns.PrivateZone{Location: ptr.To("global")})
+			return createErr
+		}); err != nil {
+			return status.Errorf(codes.Internal, "create private DNS zone(%s) in resourceGroup(%s) failed with %v", zoneName, vnetResourceGroup, err)
+		}
+	}
+
+	linkClient := d.networkClientFactory.GetVirtualNetworkLinkClient()
+	var link *armprivatedns.VirtualNetworkLink
+	err = d.armRateLimiter.do(ctx, "GetVirtualNetworkLink", func() error {
+		var getErr error
+		link, getErr = linkClient.Get(ctx, vnetResourceGroup, zoneName, linkName)
+		return getErr
+	})
+	switch {
+	case err == nil:
+		if link != nil && link.Properties != nil && link.Properties.VirtualNetwork != nil && !strings.EqualFold(ptr.Deref(link.Properties.VirtualNetwork.ID, ""), vnetID) {
+			return status.Errorf(codes.FailedPrecondition, "virtual network link(%s) of private DNS zone(%s) is linked to %s instead of vnet(%s)", linkName, zoneName, ptr.Deref(link.Properties.VirtualNetwork.ID, ""), vnetID)
+		}
+	case !isNotFoundError(err):
+		return status.Errorf(codes.Internal, "get virtual network link(%s) of private DNS zone(%s) in resourceGroup(%s) failed with %v", linkName, zoneName, vnetResourceGroup, err)
+	case !create:
+		return status.Errorf(codes.FailedPrecondition, "private DNS zone(%s) in resourceGroup(%s) is not linked to vnet(%s), set %s as %s to create the link", zoneName, vnetResourceGroup, vnetName, privateDNSZoneLinkField, privateDNSZoneLinkCreate)
+	default:
+		klog.V(2).Infof("creating virtual network link(%s) for vnet(%s) and private DNS zone(%s) in resourceGroup(%s)", linkName, vnetName, zoneName, vnetResourceGroup)
+		parameters := armprivatedns.VirtualNetworkLink{
+			Location: ptr.To("global"),
+			Properties: &armprivatedns.VirtualNetworkLinkProperties{
+				VirtualNetwork:      &armprivatedns.SubResource{ID: ptr.To(vnetID)},
+				RegistrationEnabled: ptr.To(false),
+			},
+		}
+		if err := d.armRateLimiter.do(ctx, "CreateVirtualNetworkLink", func() error {
+			_, createErr := linkClient.CreateOrUpdate(ctx, vnetResourceGroup, zoneName, linkName, parameters)
+			return createErr
+		}); err != nil {
+			return status.Errorf(codes.Internal, "create virtual network link(%s) for vnet(%s) and private DNS zone(%s) failed with %v", linkName, vnetName, zoneName, err)
+		}
+	}
+	d.privateDNSZoneLinkCache.Set(cacheKey, true)
+	return nil
+}
+
+// checkPrivateEndpointResolution checks that the blob endpoint resolves to private IP addresses on the node, a warning event
+// is recorded on the volume if it resolves to a public IP since the mount would bypass the private endpoint, mount is not
+// blocked since the public IP could still be reachable, e.g. public network access of the account is not disabled
+func (d *Driver) checkPrivateEndpointResolution(ctx context.Context, serverAddress string, attrib map[string]string) error {
+	if d.ipResolver == nil {
+		return nil
+	}
+	addrs, err := d.ipResolver.LookupIPAddr(ctx, serverAddress)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to resolve private endpoint %s: %v", serverAddress, err)
+	}
+	for _, addr := range addrs {
+		if !addr.IP.IsPrivate() {
+			klog.Warningf("%s resolves to public IP %s while %s is %s", serverAddress, addr.IP.String(), networkEndpointTypeField, privateEndpoint)
+			d.recordVolumeEvent(ctx, attrib, v1.EventTypeWarning, privateEndpointResolutionReason, "%s resolves to public IP %s while %s is %s, check that the private DNS zone %s is linked to the node VNet or the custom DNS servers forward the zone",
+				serverAddress, addr.IP.String(), networkEndpointTypeField, privateEndpoint, getPrivateDNSZoneName(serverAddress))
+			return nil
+		}
+	}
+	klog.V(2).Infof("private endpoint %s resolves to %v", serverAddress, addrs)
+	return nil
+}
+
+// getPrivateDNSZoneName returns the private DNS zone name of blob endpoint, e.g. privatelink.blob.core.windows.net
+func getPrivateDNSZoneName(serverAddress string) string {
+	if i := strings.Index(serverAddress, ".blob."); i >= 0 {
+		return fmt.Sprintf(privateDNSZoneNameFmt, serverAddress[i+len(".blob."):])
+	}
+	return serverAddress
+}
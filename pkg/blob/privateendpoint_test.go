@@ -0,0 +1,177 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/privatedns/armprivatedns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/privatezoneclient/mock_privatezoneclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/virtualnetworklinkclient/mock_virtualnetworklinkclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestEnsurePrivateDNSZoneLink(t *testing.T) {
+	notFound := fmt.Errorf("ResourceNotFound")
+	vnetID := "/subscriptions/sub/resourceGroups/vnet-rg/providers/Microsoft.Network/virtualNetworks/vnet"
+	tests := []struct {
+		desc         string
+		mode         string
+		zoneErr      error
+		link         *armprivatedns.VirtualNetworkLink
+		linkErr      error
+		expectCreate bool
+		expectedCode codes.Code
+	}{
+		{
+			desc: "zone linked to vnet",
+			mode: privateDNSZoneLinkVerify,
+			link: &armprivatedns.VirtualNetworkLink{Properties: &armprivatedns.VirtualNetworkLinkProperties{VirtualNetwork: &armprivatedns.SubResource{ID: ptr.To(vnetID)}}},
+		},
+		{
+			desc:         "zone linked to another vnet",
+			mode:         privateDNSZoneLinkCreate,
+			link:         &armprivatedns.VirtualNetworkLink{Properties: &armprivatedns.VirtualNetworkLinkProperties{VirtualNetwork: &armprivatedns.SubResource{ID: ptr.To("other")}}},
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:         "zone not found",
+			mode:         privateDNSZoneLinkVerify,
+			zoneErr:      notFound,
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:         "link not found",
+			mode:         privateDNSZoneLinkVerify,
+			linkErr:      notFound,
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:         "get zone failure",
+			mode:         privateDNSZoneLinkCreate,
+			zoneErr:      fmt.Errorf("AuthorizationFailed"),
+			expectedCode: codes.Internal,
+		},
+		{
+			desc:         "create zone and link",
+			mode:         privateDNSZoneLinkCreate,
+			zoneErr:      notFound,
+			linkErr:      notFound,
+			expectCreate: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			d := NewFakeDriver()
+			d.cloud = &azure.Cloud{}
+			d.cloud.SubscriptionID = "sub"
+			d.cloud.VnetResourceGroup = "vnet-rg"
+			d.cloud.VnetName = "vnet"
+			zoneClient := mock_privatezoneclient.NewMockInterface(ctrl)
+			linkClient := mock_virtualnetworklinkclient.NewMockInterface(ctrl)
+			factory := mock_azclient.NewMockClientFactory(ctrl)
+			factory.EXPECT().GetPrivateZoneClient().Return(zoneClient).AnyTimes()
+			factory.EXPECT().GetVirtualNetworkLinkClient().Return(linkClient).AnyTimes()
+			d.networkClientFactory = factory
+
+			zoneClient.EXPECT().Get(gomock.Any(), "vnet-rg", "privatelink.blob.core.windows.net").Return(&armprivatedns.PrivateZone{}, test.zoneErr).Times(1)
+			if test.zoneErr == nil || isNotFoundError(test.zoneErr) && test.mode == privateDNSZoneLinkCreate {
+				linkClient.EXPECT().Get(gomock.Any(), "vnet-rg", "privatelink.blob.core.windows.net", "vnet-vnetlink").Return(test.link, test.linkErr).Times(1)
+			}
+			if test.expectCreate {
+				zoneClient.EXPECT().CreateOrUpdate(gomock.Any(), "vnet-rg", "privatelink.blob.core.windows.net", gomock.Any()).Return(nil, nil).Times(1)
+				linkClient.EXPECT().CreateOrUpdate(gomock.Any(), "vnet-rg", "privatelink.blob.core.windows.net", "vnet-vnetlink", gomock.Any()).
+					DoAndReturn(func(_ context.Context, _, _, _ string, link armprivatedns.VirtualNetworkLink) (*armprivatedns.VirtualNetworkLink, error) {
+						assert.Equal(t, vnetID, *link.Properties.VirtualNetwork.ID)
+						return &link, nil
+					}).Times(1)
+			}
+
+			err := d.ensurePrivateDNSZoneLink(context.Background(), "", "", "core.windows.net", test.mode)
+			assert.Equal(t, test.expectedCode, status.Code(err), "%v", err)
+			if err == nil {
+				// verified link is cached
+				assert.NoError(t, d.ensurePrivateDNSZoneLink(context.Background(), "", "", "core.windows.net", test.mode))
+			}
+		})
+	}
+
+	d := NewFakeDriver()
+	assert.NoError(t, d.ensurePrivateDNSZoneLink(context.Background(), "", "", "core.windows.net", privateDNSZoneLinkSkip))
+}
+
+type fakeIPResolver map[string][]string
+
+func (r fakeIPResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host")
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestCheckPrivateEndpointResolution(t *testing.T) {
+	d := NewFakeDriver()
+	d.ipResolver = fakeIPResolver{
+		"private.blob.core.windows.net":              {"10.0.0.4"},
+		"public.blob.core.windows.net":               {"20.60.1.1"},
+		"nfs.privatelink.blob.core.chinacloudapi.cn": {"52.1.1.1"},
+	}
+	d.KubeClient = fake.NewSimpleClientset(&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "default"}})
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	attrib := map[string]string{pvcNameKey: "pvc", pvcNamespaceKey: "default"}
+
+	assert.NoError(t, d.checkPrivateEndpointResolution(context.Background(), "private.blob.core.windows.net", attrib))
+	assert.Empty(t, recorder.Events)
+
+	// public IP only records a warning event
+	assert.NoError(t, d.checkPrivateEndpointResolution(context.Background(), "public.blob.core.windows.net", attrib))
+	require.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	assert.Contains(t, event, privateEndpointResolutionReason)
+	assert.Contains(t, event, "resolves to public IP 20.60.1.1")
+	assert.Contains(t, event, "privatelink.blob.core.windows.net")
+
+	assert.NoError(t, d.checkPrivateEndpointResolution(context.Background(), "nfs.privatelink.blob.core.chinacloudapi.cn", attrib))
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "private DNS zone privatelink.blob.core.chinacloudapi.cn")
+
+	err := d.checkPrivateEndpointResolution(context.Background(), "unknown.blob.core.windows.net", attrib)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
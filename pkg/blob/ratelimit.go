@@ -0,0 +1,282 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"golang.org/x/time/rate"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	armRateLimiterName       = "arm"
+	dataPlaneRateLimiterName = "dataplane"
+
+	// defaultThrottleRetryAfter is used when a throttled response does not carry Retry-After
+	defaultThrottleRetryAfter = 5 * time.Second
+	// maxThrottleRetryAfter caps Retry-After so that a bogus header does not block all calls
+	maxThrottleRetryAfter = 5 * time.Minute
+	// rate is halved on every throttling down to 1/minRateDivisor of the configured rate,
+	// and increased by 1/rateRecoverySteps of the configured rate on every successful call
+	minRateDivisor    = 10
+	rateRecoverySteps = 20
+)
+
+var (
+	// retry.Error of cloud provider formats Retry-After as "RetryAfter: 10s"
+	retryAfterRegexp = regexp.MustCompile(`(?i)retry-?after: *(\d+)s?`)
+
+	rateLimiterWaitDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      "blob_csi_driver",
+			Name:           "rate_limiter_wait_duration_seconds",
+			Help:           "Time spent waiting for the rate limiter before calling Azure API, partitioned by limiter (arm or dataplane) and operation",
+			Buckets:        []float64{0.001, 0.01, 0.1, 0.5, 1, 2, 5, 10, 30, 60},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"limiter", "operation"},
+	)
+	rateLimiterThrottledTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      "blob_csi_driver",
+			Name:           "rate_limiter_throttled_total",
+			Help:           "Number of throttled Azure API calls, partitioned by limiter (arm or dataplane) and operation",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"limiter", "operation"},
+	)
+	rateLimiterQPS = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      "blob_csi_driver",
+			Name:           "rate_limiter_qps",
+			Help:           "Current rate of the adaptive rate limiter, partitioned by limiter (arm or dataplane)",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"limiter"},
+	)
+	registerRateLimiterMetricsOnce sync.Once
+)
+
+func registerRateLimiterMetrics() {
+	registerRateLimiterMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(rateLimiterWaitDuration, rateLimiterThrottledTotal, rateLimiterQPS)
+	})
+}
+
+// adaptiveRateLimiter is a token bucket shared by all calls of the same API kind, the rate is lowered
+// and calls are held until Retry-After when a call is throttled, and restored gradually on success.
+// A nil limiter does not limit calls.
+type adaptiveRateLimiter struct {
+	name    string
+	limiter *rate.Limiter
+	maxQPS  rate.Limit
+	now     func() time.Time
+
+	mu           sync.Mutex
+	blockedUntil time.Time
+}
+
+// newAdaptiveRateLimiter returns nil if qps is not positive
+func newAdaptiveRateLimiter(name string, qps float64, burst int) *adaptiveRateLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	registerRateLimiterMetrics()
+	rateLimiterQPS.WithLabelValues(name).Set(qps)
+	return &adaptiveRateLimiter{
+		name:    name,
+		limiter: rate.NewLimiter(rate.Limit(qps), burst),
+		maxQPS:  rate.Limit(qps),
+		now:     time.Now,
+	}
+}
+
+// do waits for a token, calls fn and adapts the rate with the error returned by fn
+func (l *adaptiveRateLimiter) do(ctx context.Context, operation string, fn func() error) error {
+	if err := l.wait(ctx, operation); err != nil {
+		return err
+	}
+	err := fn()
+	if isThrottlingError(err) {
+		l.throttled(operation, getRetryAfter(err))
+	} else if err == nil {
+		l.succeeded()
+	}
+	return err
+}
+
+// wait blocks until Retry-After of the last throttled call passes and a token is available
+func (l *adaptiveRateLimiter) wait(ctx context.Context, operation string) error {
+	if l == nil {
+		return nil
+	}
+	start := l.now()
+	l.mu.Lock()
+	blocked := l.blockedUntil.Sub(start)
+	l.mu.Unlock()
+	if blocked > 0 {
+		timer := time.NewTimer(blocked)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err := l.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	rateLimiterWaitDuration.WithLabelValues(l.name, operation).Observe(l.now().Sub(start).Seconds())
+	return nil
+}
+
+// throttled halves the rate and holds following calls until retryAfter passes
+func (l *adaptiveRateLimiter) throttled(operation string, retryAfter time.Duration) {
+	if l == nil {
+		return
+	}
+	if retryAfter <= 0 {
+		retryAfter = defaultThrottleRetryAfter
+	}
+	if retryAfter > maxThrottleRetryAfter {
+		retryAfter = maxThrottleRetryAfter
+	}
+	l.mu.Lock()
+	if until := l.now().Add(retryAfter); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+	limit := l.limiter.Limit() / 2
+	if limit < l.maxQPS/minRateDivisor {
+		limit = l.maxQPS / minRateDivisor
+	}
+	l.limiter.SetLimit(limit)
+	l.mu.Unlock()
+
+	rateLimiterThrottledTotal.WithLabelValues(l.name, operation).Inc()
+	rateLimiterQPS.WithLabelValues(l.name).Set(float64(limit))
+	klog.Warningf("%s call %s is throttled, hold calls for %v and lower rate limit to %.2f qps", l.name, operation, retryAfter, float64(limit))
+}
+
+// succeeded restores the rate step by step after Retry-After passes
+func (l *adaptiveRateLimiter) succeeded() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := l.limiter.Limit()
+	if limit >= l.maxQPS || l.now().Before(l.blockedUntil) {
+		return
+	}
+	limit += l.maxQPS / rateRecoverySteps
+	if limit > l.maxQPS {
+		limit = l.maxQPS
+	}
+	l.limiter.SetLimit(limit)
+	rateLimiterQPS.WithLabelValues(l.name).Set(float64(limit))
+}
+
+// policy returns a per retry pipeline policy of azblob clients, so that every attempt takes a token
+// and throttled responses, i.e. 429 and 503 ServerBusy, adapt the rate
+func (l *adaptiveRateLimiter) policy() policy.Policy {
+	return &rateLimitPolicy{limiter: l}
+}
+
+// clientOptions returns azblob client options with the rate limit policy, empty options are returned for a nil limiter
+func (l *adaptiveRateLimiter) clientOptions() azcore.ClientOptions {
+	if l == nil {
+		return azcore.ClientOptions{}
+	}
+	return azcore.ClientOptions{PerRetryPolicies: []policy.Policy{l.policy()}}
+}
+
+type rateLimitPolicy struct {
+	limiter *adaptiveRateLimiter
+}
+
+func (p *rateLimitPolicy) Do(req *policy.Request) (*http.Response, error) {
+	operation := req.Raw().Method
+	if err := p.limiter.wait(req.Raw().Context(), operation); err != nil {
+		return nil, err
+	}
+	resp, err := req.Next()
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		p.limiter.throttled(operation, parseRetryAfter(resp.Header.Get("Retry-After")))
+	} else if err == nil {
+		p.limiter.succeeded()
+	}
+	return resp, err
+}
+
+func isThrottlingError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	// "client throttled" and "azure cloud provider throttled" are returned by cloud provider clients
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, strings.ToLower(tooManyRequests)) || strings.Contains(msg, "throttled") ||
+		strings.Contains(msg, "httpstatuscode: 429") || strings.Contains(msg, "statuscode=429")
+}
+
+// getRetryAfter returns Retry-After of a throttled call, 0 if not found
+func getRetryAfter(err error) time.Duration {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.RawResponse != nil {
+		if d := parseRetryAfter(respErr.RawResponse.Header.Get("Retry-After")); d > 0 {
+			return d
+		}
+	}
+	if m := retryAfterRegexp.FindStringSubmatch(err.Error()); len(m) == 2 {
+		if seconds, parseErr := strconv.Atoi(m[1]); parseErr == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return 0
+}
+
+// parseRetryAfter parses Retry-After header in seconds or HTTP date, 0 is returned if it's invalid
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+type fakeTransport struct {
+	statusCode int
+	retryAfter string
+	requests   int
+}
+
+func (t *fakeTransport) Do(req *http.Request) (*http.Response, error) {
+	t.requests++
+	resp := &http.Response{StatusCode: t.statusCode, Header: http.Header{}, Body: http.NoBody, Request: req}
+	if t.retryAfter != "" {
+		resp.Header.Set("Retry-After", t.retryAfter)
+	}
+	return resp, nil
+}
+
+func newTestRateLimiter(qps float64, now *time.Time) *adaptiveRateLimiter {
+	l := newAdaptiveRateLimiter(armRateLimiterName, qps, 100)
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestNewAdaptiveRateLimiter(t *testing.T) {
+	assert.Nil(t, newAdaptiveRateLimiter(armRateLimiterName, 0, 10))
+	l := newAdaptiveRateLimiter(armRateLimiterName, 5, 0)
+	require.NotNil(t, l)
+	assert.Equal(t, rate.Limit(5), l.limiter.Limit())
+	assert.Equal(t, 1, l.limiter.Burst())
+
+	// nil limiter does not limit calls
+	var nilLimiter *adaptiveRateLimiter
+	called := false
+	err := nilLimiter.do(context.Background(), "op", func() error {
+		called = true
+		return fmt.Errorf("TooManyRequests")
+	})
+	assert.ErrorContains(t, err, "TooManyRequests")
+	assert.True(t, called)
+	assert.Equal(t, azcore.ClientOptions{}, nilLimiter.clientOptions())
+}
+
+func TestAdaptiveRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newTestRateLimiter(10, &now)
+
+	err := l.do(context.Background(), "op", func() error {
+		return retry.GetThrottlingError("op", "throttled", time.Now().Add(30*time.Second+500*time.Millisecond)).Error()
+	})
+	assert.Error(t, err)
+	assert.Equal(t, rate.Limit(5), l.limiter.Limit())
+	assert.Equal(t, now.Add(30*time.Second), l.blockedUntil)
+
+	// rate is not lower than 1/10 of the configured rate
+	for i := 0; i < 10; i++ {
+		l.throttled("op", time.Second)
+	}
+	assert.Equal(t, rate.Limit(1), l.limiter.Limit())
+	assert.Equal(t, now.Add(30*time.Second), l.blockedUntil)
+
+	// rate is not restored before Retry-After passes
+	l.succeeded()
+	assert.Equal(t, rate.Limit(1), l.limiter.Limit())
+
+	now = now.Add(time.Minute)
+	l.succeeded()
+	assert.InDelta(t, 1.5, float64(l.limiter.Limit()), 0.001)
+	for i := 0; i < 100; i++ {
+		l.succeeded()
+	}
+	assert.Equal(t, rate.Limit(10), l.limiter.Limit())
+
+	// other errors do not change the rate
+	err = l.do(context.Background(), "op", func() error { return fmt.Errorf("not found") })
+	assert.Error(t, err)
+	assert.Equal(t, rate.Limit(10), l.limiter.Limit())
+}
+
+func TestAdaptiveRateLimiterWait(t *testing.T) {
+	l := newAdaptiveRateLimiter(armRateLimiterName, 10, 10)
+	l.throttled("op", time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := l.do(ctx, "op", func() error {
+		t.Fatal("call should be held until Retry-After")
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRateLimitPolicy(t *testing.T) {
+	tests := []struct {
+		desc          string
+		statusCode    int
+		retryAfter    string
+		expectedLimit rate.Limit
+		expectedBlock time.Duration
+	}{
+		{
+			desc:          "success",
+			statusCode:    http.StatusOK,
+			expectedLimit: 10,
+		},
+		{
+			desc:          "throttled with Retry-After",
+			statusCode:    http.StatusTooManyRequests,
+			retryAfter:    "20",
+			expectedLimit: 5,
+			expectedBlock: 20 * time.Second,
+		},
+		{
+			desc:          "server busy",
+			statusCode:    http.StatusServiceUnavailable,
+			expectedLimit: 5,
+			expectedBlock: defaultThrottleRetryAfter,
+		},
+	}
+
+	for _, test := range tests {
+		now := time.Now()
+		l := newTestRateLimiter(10, &now)
+		transport := &fakeTransport{statusCode: test.statusCode, retryAfter: test.retryAfter}
+		options := l.clientOptions()
+		options.Transport = transport
+		options.Retry = policy.RetryOptions{MaxRetries: -1}
+		pipeline := runtime.NewPipeline("test", "v1", runtime.PipelineOptions{}, &options)
+		req, err := runtime.NewRequest(context.Background(), http.MethodGet, "https://account.blob.core.windows.net/container")
+		require.NoError(t, err)
+		_, err = pipeline.Do(req)
+		require.NoError(t, err, test.desc)
+		assert.Equal(t, 1, transport.requests, test.desc)
+		assert.Equal(t, test.expectedLimit, l.limiter.Limit(), test.desc)
+		if test.expectedBlock > 0 {
+			assert.Equal(t, now.Add(test.expectedBlock), l.blockedUntil, test.desc)
+		} else {
+			assert.True(t, l.blockedUntil.IsZero(), test.desc)
+		}
+	}
+}
+
+func TestIsThrottlingError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{err: nil},
+		{err: fmt.Errorf("not found")},
+		{err: fmt.Errorf("Retriable: true, RetryAfter: 5s, HTTPStatusCode: 429, RawError: TooManyRequests"), expected: true},
+		{err: fmt.Errorf("azure cloud provider rate limited(write) for operation CreateOrUpdate, client throttled"), expected: true},
+		{err: &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, expected: true},
+		{err: fmt.Errorf("wrapped: %w", &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}), expected: true},
+		{err: &azcore.ResponseError{StatusCode: http.StatusConflict}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isThrottlingError(test.err), "%v", test.err)
+	}
+}
+
+func TestGetRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "12")
+	tests := []struct {
+		err      error
+		expected time.Duration
+	}{
+		{err: fmt.Errorf("TooManyRequests")},
+		{err: fmt.Errorf("Retriable: true, RetryAfter: 5s, HTTPStatusCode: 429"), expected: 5 * time.Second},
+		{err: fmt.Errorf("throttled, Retry-After: 7"), expected: 7 * time.Second},
+		{err: &azcore.ResponseError{StatusCode: http.StatusTooManyRequests, RawResponse: &http.Response{Header: header}}, expected: 12 * time.Second},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getRetryAfter(test.err), "%v", test.err)
+	}
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("invalid"))
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3"))
+	d := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, d > 50*time.Second && d <= time.Minute, "%v", d)
+}
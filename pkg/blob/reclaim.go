@@ -0,0 +1,178 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	onDeleteDelete                = "delete"
+	onDeleteArchive               = "archive"
+	onDeleteRetain                = "retain"
+	onDeleteCopyToBackupContainer = "copyToBackupContainer"
+
+	defaultBackupContainerName = "blob-csi-backup"
+
+	// container metadata set by onDelete policies
+	pvNameContainerMetadata       = "pvName"
+	pvcNameContainerMetadata      = "pvcName"
+	pvcNamespaceContainerMetadata = "pvcNamespace"
+	orphanedContainerMetadata     = "orphaned"
+	deletedAtContainerMetadata    = "deletedAt"
+	onDeleteContainerMetadata     = "onDelete"
+	volumeIDContainerMetadata     = "volumeId"
+)
+
+var supportedOnDeletePolicyList = []string{onDeleteDelete, onDeleteArchive, onDeleteRetain, onDeleteCopyToBackupContainer}
+
+// getOnDeletePolicy returns the policy in supportedOnDeletePolicyList(case-insensitive), returns false if not supported
+func getOnDeletePolicy(policy string) (string, bool) {
+	if policy == "" {
+		return onDeleteDelete, true
+	}
+	for _, v := range supportedOnDeletePolicyList {
+		if strings.EqualFold(policy, v) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// getVolumeContainerMetadata returns pv/pvc names recorded in container metadata on creation, so that
+// a retained or archived container could be traced back to the volume
+func getVolumeContainerMetadata(volName string, parameters map[string]string) map[string]string {
+	metadata := map[string]string{pvNameContainerMetadata: volName}
+	if v := parameters[pvcNameKey]; v != "" {
+		metadata[pvcNameContainerMetadata] = v
+	}
+	if v := parameters[pvcNamespaceKey]; v != "" {
+		metadata[pvcNamespaceContainerMetadata] = v
+	}
+	return metadata
+}
+
+// reclaimVolume applies onDelete policy of the volume before the container is deleted,
+// returns true if the container should be deleted afterwards
+func (d *Driver) reclaimVolume(ctx context.Context, volumeID string, info *volumeIDInfo, secrets map[string]string) (bool, error) {
+	policy, _ := getOnDeletePolicy(info.onDelete)
+	if policy == onDeleteDelete {
+		return true, nil
+	}
+
+	_, accountName, accountKey, containerName, _, err := d.GetAuthEnv(ctx, volumeID, "", nil, secrets)
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "GetAuthEnv(%s) failed with %v", volumeID, err)
+	}
+	storageEndpointSuffix := info.storageEndpointSuffix
+	if storageEndpointSuffix == "" {
+		storageEndpointSuffix = d.getStorageEndPointSuffix()
+	}
+	client, err := d.containerDataClientFactory.NewContainerDataClient(accountName, accountKey, storageEndpointSuffix, containerName)
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	klog.V(2).Infof("applying onDelete policy(%s) on container(%s) account(%s) volumeID(%s)", policy, containerName, accountName, volumeID)
+	switch policy {
+	case onDeleteArchive:
+		names, err := client.ListBlobs(ctx)
+		if err != nil {
+			return false, status.Errorf(codes.Internal, "failed to list blobs in container(%s) account(%s): %v", containerName, accountName, err)
+		}
+		if err := forEachBlob(ctx, names, d.cloneCopyParallelism, func(ctx context.Context, name string) error {
+			if err := client.SetBlobTier(ctx, name, blob.AccessTierArchive); err != nil {
+				return fmt.Errorf("failed to set blob(%s) to archive tier: %w", name, err)
+			}
+			return nil
+		}); err != nil {
+			return false, status.Errorf(codes.Internal, "failed to archive container(%s) account(%s): %v", containerName, accountName, err)
+		}
+		klog.V(2).Infof("moved %d blobs in container(%s) account(%s) to archive tier", len(names), containerName, accountName)
+		return false, markContainerOrphaned(ctx, client, volumeID, policy)
+	case onDeleteRetain:
+		return false, markContainerOrphaned(ctx, client, volumeID, policy)
+	case onDeleteCopyToBackupContainer:
+		backupContainer := info.backupContainer
+		if backupContainer == "" {
+			backupContainer = defaultBackupContainerName
+		}
+		if err := client.CreateContainer(ctx, backupContainer, &blobContainerOptions{metadata: map[string]string{createdByMetadata: d.Name}}); err != nil {
+			return false, status.Errorf(codes.Internal, "failed to create backup container(%s) on account(%s): %v", backupContainer, accountName, err)
+		}
+		names, err := client.ListBlobs(ctx)
+		if err != nil {
+			return false, status.Errorf(codes.Internal, "failed to list blobs in container(%s) account(%s): %v", containerName, accountName, err)
+		}
+		// blobs are copied under container name in backup container, copy is idempotent on DeleteVolume retry
+		if err := forEachBlob(ctx, names, d.cloneCopyParallelism, func(ctx context.Context, name string) error {
+			if err := client.CopyBlob(ctx, name, backupContainer, fmt.Sprintf("%s/%s", containerName, name)); err != nil {
+				return fmt.Errorf("failed to copy blob(%s): %w", name, err)
+			}
+			return nil
+		}); err != nil {
+			return false, status.Errorf(codes.Internal, "failed to copy container(%s) account(%s) to backup container(%s): %v", containerName, accountName, backupContainer, err)
+		}
+		klog.V(2).Infof("copied %d blobs in container(%s) account(%s) to backup container(%s)", len(names), containerName, accountName, backupContainer)
+		return true, nil
+	}
+	return true, nil
+}
+
+// forEachBlob runs fn on blobs with at most parallelism concurrent calls, returns the first error
+func forEachBlob(ctx context.Context, names []string, parallelism int, fn func(context.Context, string) error) error {
+	if parallelism <= 0 {
+		parallelism = defaultCloneCopyParallelism
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(parallelism)
+	for i := range names {
+		name := names[i]
+		g.Go(func() error {
+			return fn(gctx, name)
+		})
+	}
+	return g.Wait()
+}
+
+// markContainerOrphaned keeps existing container metadata and marks the container as orphaned
+func markContainerOrphaned(ctx context.Context, client containerDataClient, volumeID, policy string) error {
+	metadata, err := client.GetMetadata(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get container metadata of volume(%s): %v", volumeID, err)
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[orphanedContainerMetadata] = trueValue
+	metadata[deletedAtContainerMetadata] = time.Now().UTC().Format(time.RFC3339)
+	metadata[onDeleteContainerMetadata] = policy
+	metadata[volumeIDContainerMetadata] = volumeID
+	if err := client.SetMetadata(ctx, metadata); err != nil {
+		return status.Errorf(codes.Internal, "failed to set container metadata of volume(%s): %v", volumeID, err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestGetOnDeletePolicy(t *testing.T) {
+	tests := []struct {
+		policy         string
+		expectedPolicy string
+		expectedOK     bool
+	}{
+		{policy: "", expectedPolicy: onDeleteDelete, expectedOK: true},
+		{policy: "delete", expectedPolicy: onDeleteDelete, expectedOK: true},
+		{policy: "Archive", expectedPolicy: onDeleteArchive, expectedOK: true},
+		{policy: "RETAIN", expectedPolicy: onDeleteRetain, expectedOK: true},
+		{policy: "copytobackupcontainer", expectedPolicy: onDeleteCopyToBackupContainer, expectedOK: true},
+		{policy: "recycle", expectedOK: false},
+	}
+
+	for _, test := range tests {
+		policy, ok := getOnDeletePolicy(test.policy)
+		assert.Equal(t, test.expectedPolicy, policy, test.policy)
+		assert.Equal(t, test.expectedOK, ok, test.policy)
+	}
+}
+
+func TestGetVolumeContainerMetadata(t *testing.T) {
+	assert.Equal(t, map[string]string{pvNameContainerMetadata: "pv"}, getVolumeContainerMetadata("pv", nil))
+	assert.Equal(t, map[string]string{
+		pvNameContainerMetadata:       "pv",
+		pvcNameContainerMetadata:      "pvc",
+		pvcNamespaceContainerMetadata: "ns",
+	}, getVolumeContainerMetadata("pv", map[string]string{pvcNameKey: "pvc", pvcNamespaceKey: "ns"}))
+}
+
+func TestDeleteVolumeWithOnDeletePolicy(t *testing.T) {
+	secrets := map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "key"}
+
+	tests := []struct {
+		name              string
+		onDelete          string
+		backupContainer   string
+		clientErr         error
+		expectedErrCode   codes.Code
+		expectedTiers     map[string]blob.AccessTier
+		expectedCopies    map[string]string
+		expectedContainer []string
+		expectOrphaned    bool
+	}{
+		{
+			name:           "archive",
+			onDelete:       onDeleteArchive,
+			expectedTiers:  map[string]blob.AccessTier{"a": blob.AccessTierArchive, "dir/b": blob.AccessTierArchive},
+			expectOrphaned: true,
+		},
+		{
+			name:           "retain",
+			onDelete:       onDeleteRetain,
+			expectOrphaned: true,
+		},
+		{
+			name:              "copyToBackupContainer with default backup container",
+			onDelete:          onDeleteCopyToBackupContainer,
+			expectedCopies:    map[string]string{"blob-csi-backup/container/a": "a", "blob-csi-backup/container/dir/b": "dir/b"},
+			expectedContainer: []string{defaultBackupContainerName},
+			// container deletion is not faked, so DeleteVolume fails after backup
+			expectedErrCode: codes.Internal,
+		},
+		{
+			name:              "copyToBackupContainer with backup container",
+			onDelete:          onDeleteCopyToBackupContainer,
+			backupContainer:   "backup",
+			expectedCopies:    map[string]string{"backup/container/a": "a", "backup/container/dir/b": "dir/b"},
+			expectedContainer: []string{"backup"},
+			expectedErrCode:   codes.Internal,
+		},
+		{
+			name:            "archive with client error",
+			onDelete:        onDeleteArchive,
+			clientErr:       fmt.Errorf("test error"),
+			expectedErrCode: codes.Internal,
+		},
+		{
+			name:            "retain with client error",
+			onDelete:        onDeleteRetain,
+			clientErr:       fmt.Errorf("test error"),
+			expectedErrCode: codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			volumeID := (&volumeIDInfo{resourceGroup: "rg", accountName: "account", containerName: "container", onDelete: test.onDelete, backupContainer: test.backupContainer}).encode(volumeIDFormatV2)
+
+			d := NewFakeDriver()
+			d.cloud = &azure.Cloud{}
+			d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+			client := &fakeContainerDataClient{
+				metadata: map[string]string{createdByMetadata: d.Name, pvNameContainerMetadata: "pv"},
+				blobs:    []string{"a", "dir/b"},
+				err:      test.clientErr,
+			}
+			d.containerDataClientFactory = client
+
+			_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: secrets})
+			if test.expectedErrCode == codes.OK {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, test.expectedErrCode, status.Code(err))
+			}
+			assert.Equal(t, "account", client.accountName)
+			assert.Equal(t, "key", client.accountKey)
+			assert.Equal(t, "container", client.containerName)
+			assert.Equal(t, test.expectedTiers, client.tiers)
+			assert.Equal(t, test.expectedCopies, client.copies)
+			assert.Equal(t, test.expectedContainer, client.createdContainers)
+			if test.expectOrphaned {
+				assert.Equal(t, trueValue, client.metadata[orphanedContainerMetadata])
+				assert.Equal(t, test.onDelete, client.metadata[onDeleteContainerMetadata])
+				assert.Equal(t, volumeID, client.metadata[volumeIDContainerMetadata])
+				assert.Equal(t, "pv", client.metadata[pvNameContainerMetadata])
+				_, err := time.Parse(time.RFC3339, client.metadata[deletedAtContainerMetadata])
+				assert.NoError(t, err)
+			} else {
+				assert.Empty(t, client.metadata[orphanedContainerMetadata])
+			}
+		})
+	}
+}
+
+func TestReclaimVolume(t *testing.T) {
+	d := NewFakeDriver()
+	client := &fakeContainerDataClient{}
+	d.containerDataClientFactory = client
+
+	deleteContainer, err := d.reclaimVolume(context.Background(), "rg#account#container", &volumeIDInfo{}, nil)
+	assert.NoError(t, err)
+	assert.True(t, deleteContainer)
+	assert.Empty(t, client.accountName)
+
+	client.blobs = []string{"a"}
+	deleteContainer, err = d.reclaimVolume(context.Background(), "rg#account#container", &volumeIDInfo{onDelete: onDeleteCopyToBackupContainer},
+		map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "key"})
+	assert.NoError(t, err)
+	assert.True(t, deleteContainer)
+	assert.Equal(t, map[string]string{"blob-csi-backup/container/a": "a"}, client.copies)
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	unixSocketPrefix = "unix://"
+	// maxExternalSecretResponseSize limits the size of the response body read from the external secret source
+	maxExternalSecretResponseSize = 1 << 20
+)
+
+// externalSecretRequest is sent to the external secret source to look up credentials of a volume
+type externalSecretRequest struct {
+	VolumeID string `json:"volumeID"`
+	// Namespace is the PVC namespace of the volume, or the pod namespace of inline volume, empty if unknown
+	Namespace string `json:"namespace,omitempty"`
+	// ServiceAccount is the pod service account set by kubelet, only available when pod info is in volume context, e.g. inline volume
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	AccountName    string `json:"accountName,omitempty"`
+	ContainerName  string `json:"containerName,omitempty"`
+}
+
+// externalCredential is the credential material returned by the external secret source,
+// only one of account key, sas token, spn client secret or spn client certificate is expected to be set
+type externalCredential struct {
+	AccountName     string `json:"accountName,omitempty"`
+	AccountKey      string `json:"accountKey,omitempty"`
+	SASToken        string `json:"sasToken,omitempty"`
+	SPNClientID     string `json:"spnClientID,omitempty"`
+	SPNTenantID     string `json:"spnTenantID,omitempty"`
+	SPNClientSecret string `json:"spnClientSecret,omitempty"`
+	// SPNClientCert is PEM or base64 encoded PFX spn client certificate
+	SPNClientCert         string `json:"spnClientCertificate,omitempty"`
+	SPNClientCertPassword string `json:"spnClientCertificatePassword,omitempty"`
+	// TTLSeconds is the time the credential could be cached on the node, 0 means using the driver default
+	TTLSeconds int64 `json:"ttlSeconds,omitempty"`
+}
+
+// externalSecretSource gets storage account credentials from a secret store outside of the cluster,
+// e.g. HashiCorp Vault, it returns nil credential if no credential is found for the volume
+type externalSecretSource interface {
+	GetCredential(ctx context.Context, req *externalSecretRequest) (*externalCredential, error)
+}
+
+// httpSecretSource calls an external secret source plugin endpoint through HTTP,
+// the endpoint could be a http(s) url or a unix domain socket, e.g. unix:///var/run/blob-secret-source.sock
+type httpSecretSource struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSecretSource(endpoint string, timeout time.Duration) *httpSecretSource {
+	client := &http.Client{Timeout: timeout}
+	url := endpoint
+	if strings.HasPrefix(endpoint, unixSocketPrefix) {
+		socketPath := strings.TrimPrefix(endpoint, unixSocketPrefix)
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		}
+		url = "http://localhost/credential"
+	}
+	return &httpSecretSource{url: url, client: client}
+}
+
+// GetCredential posts the request to the plugin endpoint,
+// http.StatusNotFound means there is no credential for the volume in the external secret source
+func (s *httpSecretSource) GetCredential(ctx context.Context, req *externalSecretRequest) (*externalCredential, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call external secret source: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxExternalSecretResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from external secret source: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("external secret source returned status code %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	cred := &externalCredential{}
+	if err := json.Unmarshal(respBody, cred); err != nil {
+		return nil, fmt.Errorf("failed to parse response from external secret source: %w", err)
+	}
+	return cred, nil
+}
+
+type cachedCredential struct {
+	cred     *externalCredential
+	expireAt time.Time
+}
+
+// cachedSecretSource caches credentials returned by the external secret source,
+// each credential expires according to its own TTL, or defaultTTL if TTL is not returned
+type cachedSecretSource struct {
+	source     externalSecretSource
+	defaultTTL time.Duration
+	now        func() time.Time
+
+	lock  sync.Mutex
+	cache map[string]cachedCredential
+}
+
+func newCachedSecretSource(source externalSecretSource, defaultTTL time.Duration) *cachedSecretSource {
+	return &cachedSecretSource{
+		source:     source,
+		defaultTTL: defaultTTL,
+		now:        time.Now,
+		cache:      map[string]cachedCredential{},
+	}
+}
+
+// GetCredential returns credential from cache if it's not expired, otherwise gets it from the external secret source
+func (c *cachedSecretSource) GetCredential(ctx context.Context, req *externalSecretRequest) (*externalCredential, error) {
+	key := strings.Join([]string{req.VolumeID, req.Namespace, req.ServiceAccount, req.AccountName, req.ContainerName}, separator)
+	c.lock.Lock()
+	if v, ok := c.cache[key]; ok {
+		if c.now().Before(v.expireAt) {
+			c.lock.Unlock()
+			return v.cred, nil
+		}
+		delete(c.cache, key)
+	}
+	c.lock.Unlock()
+
+	cred, err := c.source.GetCredential(ctx, req)
+	if err != nil || cred == nil {
+		return cred, err
+	}
+
+	ttl := c.defaultTTL
+	if cred.TTLSeconds > 0 && time.Duration(cred.TTLSeconds)*time.Second < ttl {
+		ttl = time.Duration(cred.TTLSeconds) * time.Second
+	}
+	if ttl > 0 {
+		c.lock.Lock()
+		c.cache[key] = cachedCredential{cred: cred, expireAt: c.now().Add(ttl)}
+		c.lock.Unlock()
+	}
+	return cred, nil
+}
+
+// getExternalCredential gets volume credential from the external secret source if it's configured
+// return nil if external secret source is not configured or there is no credential for the volume
+func (d *Driver) getExternalCredential(ctx context.Context, req *externalSecretRequest) (*externalCredential, error) {
+	if d.externalSecretSource == nil {
+		return nil, nil
+	}
+	cred, err := d.externalSecretSource.GetCredential(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("get credential of volume(%s) from external secret source failed with %w", req.VolumeID, err)
+	}
+	if cred == nil {
+		klog.V(2).Infof("no credential found for volume(%s) namespace(%s) in external secret source", req.VolumeID, req.Namespace)
+	}
+	return cred, nil
+}
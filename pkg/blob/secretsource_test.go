@@ -0,0 +1,247 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSecretSourceServer is a local stand-in of an external secret source plugin,
+// it returns credentials by volumeID
+type fakeSecretSourceServer struct {
+	creds map[string]*externalCredential
+}
+
+func (f *fakeSecretSourceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := &externalSecretRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.VolumeID == "error" {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	cred, ok := f.creds[req.VolumeID]
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(cred)
+}
+
+type fakeExternalSecretSource struct {
+	cred    *externalCredential
+	err     error
+	calls   int
+	lastReq *externalSecretRequest
+}
+
+func (f *fakeExternalSecretSource) GetCredential(_ context.Context, req *externalSecretRequest) (*externalCredential, error) {
+	f.calls++
+	f.lastReq = req
+	return f.cred, f.err
+}
+
+func TestHTTPSecretSource(t *testing.T) {
+	fakeServer := &fakeSecretSourceServer{
+		creds: map[string]*externalCredential{
+			"vol-1": {AccountName: "account", AccountKey: "key"},
+		},
+	}
+	server := httptest.NewServer(fakeServer)
+	defer server.Close()
+
+	tests := []struct {
+		desc         string
+		volumeID     string
+		expectedCred *externalCredential
+		expectErr    bool
+	}{
+		{
+			desc:         "credential found",
+			volumeID:     "vol-1",
+			expectedCred: &externalCredential{AccountName: "account", AccountKey: "key"},
+		},
+		{
+			desc:     "credential not found",
+			volumeID: "vol-2",
+		},
+		{
+			desc:      "external secret source returns error",
+			volumeID:  "error",
+			expectErr: true,
+		},
+	}
+
+	source := newHTTPSecretSource(server.URL, time.Second)
+	for _, test := range tests {
+		cred, err := source.GetCredential(context.Background(), &externalSecretRequest{VolumeID: test.volumeID})
+		assert.Equal(t, test.expectErr, err != nil, test.desc)
+		assert.Equal(t, test.expectedCred, cred, test.desc)
+	}
+}
+
+func TestHTTPSecretSourceWithUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "secret-source.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix socket is not supported: %v", err)
+	}
+	fakeServer := &fakeSecretSourceServer{
+		creds: map[string]*externalCredential{
+			"vol-1": {SASToken: "?sastoken"},
+		},
+	}
+	server := &http.Server{Handler: fakeServer, ReadHeaderTimeout: time.Second}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	source := newHTTPSecretSource(unixSocketPrefix+socket, time.Second)
+	cred, err := source.GetCredential(context.Background(), &externalSecretRequest{VolumeID: "vol-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, &externalCredential{SASToken: "?sastoken"}, cred)
+}
+
+func TestCachedSecretSource(t *testing.T) {
+	now := time.Now()
+	fakeSource := &fakeExternalSecretSource{cred: &externalCredential{AccountKey: "key", TTLSeconds: 60}}
+	cachedSource := newCachedSecretSource(fakeSource, 10*time.Minute)
+	cachedSource.now = func() time.Time { return now }
+	req := &externalSecretRequest{VolumeID: "vol", Namespace: "ns"}
+
+	for i := 0; i < 3; i++ {
+		cred, err := cachedSource.GetCredential(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, "key", cred.AccountKey)
+	}
+	assert.Equal(t, 1, fakeSource.calls)
+
+	// credential TTL(60s) is less than default TTL, so credential should be expired after 61s
+	now = now.Add(61 * time.Second)
+	_, err := cachedSource.GetCredential(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fakeSource.calls)
+
+	// different namespace should not hit the cache
+	_, err = cachedSource.GetCredential(context.Background(), &externalSecretRequest{VolumeID: "vol", Namespace: "ns2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, fakeSource.calls)
+
+	// different service account should not hit the cache
+	_, err = cachedSource.GetCredential(context.Background(), &externalSecretRequest{VolumeID: "vol", Namespace: "ns2", ServiceAccount: "sa"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, fakeSource.calls)
+
+	// error and not found should not be cached
+	fakeSource.cred = nil
+	fakeSource.err = fmt.Errorf("test error")
+	req = &externalSecretRequest{VolumeID: "vol-2"}
+	for i := 0; i < 2; i++ {
+		_, err = cachedSource.GetCredential(context.Background(), req)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 6, fakeSource.calls)
+}
+
+func TestGetAuthEnvWithExternalSecretSource(t *testing.T) {
+	tests := []struct {
+		desc              string
+		source            *fakeExternalSecretSource
+		secrets           map[string]string
+		expectedAccount   string
+		expectedAuthEnv   []string
+		expectedErr       string
+		expectedCallCount int
+	}{
+		{
+			desc:              "account key from external secret source",
+			source:            &fakeExternalSecretSource{cred: &externalCredential{AccountName: "vaultaccount", AccountKey: "vaultkey"}},
+			expectedAccount:   "vaultaccount",
+			expectedAuthEnv:   []string{"AZURE_STORAGE_ACCESS_KEY=vaultkey"},
+			expectedCallCount: 1,
+		},
+		{
+			desc:              "sas token from external secret source",
+			source:            &fakeExternalSecretSource{cred: &externalCredential{SASToken: "?sastoken"}},
+			expectedAccount:   "account",
+			expectedAuthEnv:   []string{"AZURE_STORAGE_SAS_TOKEN=?sastoken"},
+			expectedCallCount: 1,
+		},
+		{
+			desc:              "spn from external secret source",
+			source:            &fakeExternalSecretSource{cred: &externalCredential{SPNClientID: "clientid", SPNTenantID: "tenantid", SPNClientSecret: "secret"}},
+			expectedAccount:   "account",
+			expectedAuthEnv:   []string{"AZURE_STORAGE_SPN_CLIENT_SECRET=secret", "AZURE_STORAGE_SPN_CLIENT_ID=clientid", "AZURE_STORAGE_SPN_TENANT_ID=tenantid"},
+			expectedCallCount: 1,
+		},
+		{
+			desc:              "secrets provided, external secret source should not be called",
+			source:            &fakeExternalSecretSource{cred: &externalCredential{AccountKey: "vaultkey"}},
+			secrets:           map[string]string{defaultSecretAccountName: "secretaccount", defaultSecretAccountKey: "secretkey"},
+			expectedAccount:   "secretaccount",
+			expectedAuthEnv:   []string{"AZURE_STORAGE_ACCESS_KEY=secretkey"},
+			expectedCallCount: 0,
+		},
+		{
+			desc:              "external secret source returns error",
+			source:            &fakeExternalSecretSource{err: fmt.Errorf("test error")},
+			expectedAccount:   "account",
+			expectedErr:       "get credential of volume(rg#account#container) from external secret source failed with test error",
+			expectedCallCount: 1,
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.externalSecretSource = test.source
+		// secretNamespace in volume attributes should not be sent as namespace of the volume
+		attrib := map[string]string{secretNamespaceField: "other", pvcNamespaceKey: "ns", serviceAccountNameField: "sa"}
+		_, accountName, _, _, authEnv, err := d.GetAuthEnv(context.Background(), "rg#account#container", "", attrib, test.secrets)
+		if test.expectedErr == "" {
+			assert.NoError(t, err, test.desc)
+		} else {
+			assert.EqualError(t, err, test.expectedErr, test.desc)
+		}
+		assert.Equal(t, test.expectedAccount, accountName, test.desc)
+		assert.Equal(t, test.expectedAuthEnv, authEnv, test.desc)
+		assert.Equal(t, test.expectedCallCount, test.source.calls, test.desc)
+		if test.source.lastReq != nil {
+			assert.Equal(t, "ns", test.source.lastReq.Namespace, test.desc)
+			assert.Equal(t, "sa", test.source.lastReq.ServiceAccount, test.desc)
+		}
+	}
+
+	// pvc namespace in attributes of inline volume is written by pod author, only pod namespace is sent
+	d := NewFakeDriver()
+	source := &fakeExternalSecretSource{cred: &externalCredential{AccountKey: "vaultkey"}}
+	d.externalSecretSource = source
+	attrib := map[string]string{ephemeralField: trueValue, podNamespaceField: "pod-ns", pvcNamespaceKey: "other", serviceAccountNameField: "sa"}
+	_, _, _, _, _, err := d.GetAuthEnv(context.Background(), "rg#account#container", "", attrib, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &externalSecretRequest{VolumeID: "rg#account#container", Namespace: "pod-ns", ServiceAccount: "sa", AccountName: "account", ContainerName: "container"}, source.lastReq)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// spnCertExpiryWarningPeriod is the period before certificate expiry in which a warning event is recorded
+	spnCertExpiryWarningPeriod = 30 * 24 * time.Hour
+
+	spnCertExpiredReason      = "SPNCertificateExpired"
+	spnCertExpiringSoonReason = "SPNCertificateExpiringSoon"
+)
+
+// spnCertContentTypes are content types of the secret of a key vault certificate
+var spnCertContentTypes = []string{"application/x-pkcs12", "application/x-pem-file"}
+
+// isSPNCertContentType checks the content type of key vault secret which stores spn client certificate,
+// empty content type is allowed for certificate uploaded as a plain secret
+func isSPNCertContentType(contentType string) bool {
+	return contentType == "" || slices.Contains(spnCertContentTypes, strings.ToLower(contentType))
+}
+
+// parseSPNClientCertificate parses a PEM or PFX(PKCS#12) client certificate,
+// certificate content could also be base64 encoded, e.g. certificate stored in Azure Key Vault.
+// return <certificate content, is PEM format, certificate expiry time, error>
+func parseSPNClientCertificate(cert []byte, password string) ([]byte, bool, time.Time, error) {
+	if !bytes.Contains(cert, []byte("-----BEGIN")) {
+		if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(cert))); err == nil {
+			cert = decoded
+		}
+	}
+	certs, _, err := azidentity.ParseCertificates(cert, []byte(password))
+	if err != nil {
+		return nil, false, time.Time{}, fmt.Errorf("failed to parse spn client certificate: %w", err)
+	}
+	var notAfter time.Time
+	for _, c := range certs {
+		if notAfter.IsZero() || c.NotAfter.Before(notAfter) {
+			notAfter = c.NotAfter
+		}
+	}
+	return cert, bytes.Contains(cert, []byte("-----BEGIN")), notAfter, nil
+}
+
+// getSPNCertPath returns the path of spn client certificate file of the volume, the file is in the parent directory of
+// the staging path which is on the host and accessible by blobfuse proxy, it could not be in the staging path since
+// the staging path is hidden by blobfuse mount
+func getSPNCertPath(volumeID, stagingPath string, isPEM bool) string {
+	ext := ".pfx"
+	if isPEM {
+		ext = ".pem"
+	}
+	return filepath.Join(filepath.Dir(filepath.Clean(stagingPath)), fmt.Sprintf("spn-%x%s", sha256.Sum256([]byte(volumeID)), ext))
+}
+
+// setupSPNClientCertificate checks expiry of spn client certificate and writes it to a file which is used by blobfuse
+// a warning event is recorded if certificate is expired or about to expire
+// return auth env of spn client certificate
+func (d *Driver) setupSPNClientCertificate(ctx context.Context, volumeID, stagingPath string, attrib map[string]string, cert []byte, password string) ([]string, error) {
+	content, isPEM, notAfter, err := parseSPNClientCertificate(cert, password)
+	if err != nil {
+		return nil, err
+	}
+	if now := time.Now(); now.After(notAfter) {
+		d.recordVolumeEvent(ctx, attrib, v1.EventTypeWarning, spnCertExpiredReason, "spn client certificate of volume(%s) expired at %s", volumeID, notAfter.Format(time.RFC3339))
+		return nil, fmt.Errorf("spn client certificate of volume(%s) expired at %s", volumeID, notAfter.Format(time.RFC3339))
+	} else if notAfter.Sub(now) < spnCertExpiryWarningPeriod {
+		d.recordVolumeEvent(ctx, attrib, v1.EventTypeWarning, spnCertExpiringSoonReason, "spn client certificate of volume(%s) will expire at %s", volumeID, notAfter.Format(time.RFC3339))
+	}
+
+	certPath := getSPNCertPath(volumeID, stagingPath, isPEM)
+	if err := os.WriteFile(certPath, content, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write spn client certificate of volume(%s): %w", volumeID, err)
+	}
+	klog.V(2).Infof("write spn client certificate of volume(%s) to %s, expiry time: %s", volumeID, certPath, notAfter.Format(time.RFC3339))
+
+	authEnv := []string{"AZURE_STORAGE_SPN_CLIENT_CERT_PATH=" + certPath}
+	if password != "" {
+		authEnv = append(authEnv, "AZURE_STORAGE_SPN_CLIENT_CERT_PASSWORD="+password)
+	}
+	return authEnv, nil
+}
+
+// removeSPNClientCertificate removes spn client certificate files of the volume
+func (d *Driver) removeSPNClientCertificate(volumeID, stagingPath string) {
+	for _, isPEM := range []bool{true, false} {
+		certPath := getSPNCertPath(volumeID, stagingPath, isPEM)
+		if err := os.Remove(certPath); err != nil && !os.IsNotExist(err) {
+			klog.Warningf("failed to remove spn client certificate file(%s): %v", certPath, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// generateTestCertificate returns a self-signed PEM certificate with private key which expires at notAfter
+func generateTestCertificate(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "blob-csi-test"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(cert, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})...)
+}
+
+func TestParseSPNClientCertificate(t *testing.T) {
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second).UTC()
+	cert := generateTestCertificate(t, notAfter)
+
+	tests := []struct {
+		desc      string
+		cert      []byte
+		expectErr bool
+	}{
+		{
+			desc: "PEM certificate",
+			cert: cert,
+		},
+		{
+			desc: "base64 encoded PEM certificate",
+			cert: []byte(base64.StdEncoding.EncodeToString(cert)),
+		},
+		{
+			desc:      "invalid certificate",
+			cert:      []byte("invalid"),
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		content, isPEM, expiry, err := parseSPNClientCertificate(test.cert, "")
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.True(t, isPEM, test.desc)
+		assert.Equal(t, cert, content, test.desc)
+		assert.True(t, notAfter.Equal(expiry), test.desc)
+	}
+}
+
+func TestSetupSPNClientCertificate(t *testing.T) {
+	tests := []struct {
+		desc           string
+		notAfter       time.Time
+		expectedEnv    []string
+		expectedEvent  string
+		expectErr      bool
+		expectCertFile bool
+	}{
+		{
+			desc:           "valid certificate",
+			notAfter:       time.Now().Add(90 * 24 * time.Hour),
+			expectedEnv:    []string{"AZURE_STORAGE_SPN_CLIENT_CERT_PATH="},
+			expectCertFile: true,
+		},
+		{
+			desc:           "certificate expiring soon",
+			notAfter:       time.Now().Add(24 * time.Hour),
+			expectedEnv:    []string{"AZURE_STORAGE_SPN_CLIENT_CERT_PATH="},
+			expectedEvent:  v1.EventTypeWarning + " " + spnCertExpiringSoonReason,
+			expectCertFile: true,
+		},
+		{
+			desc:          "expired certificate",
+			notAfter:      time.Now().Add(-time.Hour),
+			expectedEvent: v1.EventTypeWarning + " " + spnCertExpiredReason,
+			expectErr:     true,
+		},
+	}
+
+	pvc := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns"}}
+	attrib := map[string]string{pvcNameKey: "pvc", pvcNamespaceKey: "ns"}
+	volumeID := "rg#account#container"
+	for _, test := range tests {
+		d := NewFakeDriver()
+		stagingPath := filepath.Join(t.TempDir(), "globalmount")
+		d.KubeClient = fake.NewSimpleClientset(pvc)
+		recorder := record.NewFakeRecorder(10)
+		d.eventRecorder = recorder
+
+		authEnv, err := d.setupSPNClientCertificate(context.Background(), volumeID, stagingPath, attrib, generateTestCertificate(t, test.notAfter), "")
+		assert.Equal(t, test.expectErr, err != nil, test.desc)
+		assert.Equal(t, len(test.expectedEnv), len(authEnv), test.desc)
+		for i := range test.expectedEnv {
+			assert.True(t, strings.HasPrefix(authEnv[i], test.expectedEnv[i]), test.desc)
+		}
+
+		certPath := getSPNCertPath(volumeID, stagingPath, true)
+		if test.expectCertFile {
+			// certificate is next to the staging path which is hidden by blobfuse mount
+			assert.Equal(t, filepath.Dir(stagingPath), filepath.Dir(certPath), test.desc)
+			assert.Equal(t, "AZURE_STORAGE_SPN_CLIENT_CERT_PATH="+certPath, authEnv[0], test.desc)
+			info, err := os.Stat(certPath)
+			assert.NoError(t, err, test.desc)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), test.desc)
+		}
+
+		if test.expectedEvent == "" {
+			assert.Empty(t, recorder.Events, test.desc)
+		} else {
+			event := <-recorder.Events
+			assert.True(t, strings.HasPrefix(event, test.expectedEvent), test.desc)
+		}
+
+		d.removeSPNClientCertificate(volumeID, stagingPath)
+		_, err = os.Stat(certPath)
+		assert.True(t, os.IsNotExist(err), test.desc)
+	}
+}
+
+func TestGetAuthEnvWithSPNClientCertificate(t *testing.T) {
+	d := NewFakeDriver()
+	stagingPath := filepath.Join(t.TempDir(), "globalmount")
+	cert := generateTestCertificate(t, time.Now().Add(90*24*time.Hour))
+	secrets := map[string]string{
+		defaultSecretAccountName:     "account",
+		storageSPNClientCertField:    string(cert),
+		storageSPNClientIDField:      "clientid",
+		storageSPNTenantIDField:      "tenantid",
+		storageSPNClientCertPwdField: "",
+	}
+	_, accountName, _, _, authEnv, err := d.getAuthEnv(context.Background(), "rg#account#container", "", stagingPath, nil, secrets)
+	assert.NoError(t, err)
+	assert.Equal(t, "account", accountName)
+	assert.Equal(t, []string{
+		"AZURE_STORAGE_SPN_CLIENT_CERT_PATH=" + getSPNCertPath("rg#account#container", stagingPath, true),
+		"AZURE_STORAGE_SPN_CLIENT_ID=clientid",
+		"AZURE_STORAGE_SPN_TENANT_ID=tenantid",
+	}, authEnv)
+
+	// certificate is not written without staging path, e.g. in controller
+	_, _, _, _, authEnv, err = d.GetAuthEnv(context.Background(), "rg#account#container", "", nil, secrets)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AZURE_STORAGE_SPN_CLIENT_ID=clientid", "AZURE_STORAGE_SPN_TENANT_ID=tenantid"}, authEnv)
+
+	// invalid certificate
+	secrets[storageSPNClientCertField] = "invalid"
+	_, _, _, _, _, err = d.getAuthEnv(context.Background(), "rg#account#container", "", stagingPath, nil, secrets)
+	assert.Error(t, err)
+}
+
+func TestGetAuthEnvWithSPNClientCertificateInKeyVault(t *testing.T) {
+	d := NewFakeDriver()
+	assert.NoError(t, d.setupCredentialCache(time.Minute))
+	stagingPath := filepath.Join(t.TempDir(), "globalmount")
+	vaultURL := "https://vault.vault.azure.net/"
+	key := strings.Join([]string{"https://vault.vault.azure.net", "secret", ""}, separator)
+	attrib := map[string]string{
+		keyVaultURLField:        vaultURL,
+		keyVaultSecretNameField: "secret",
+		storageAuthTypeField:    SPN,
+		storageSPNClientIDField: "clientid",
+	}
+
+	tests := []struct {
+		contentType string
+		expectErr   bool
+	}{
+		{contentType: "application/x-pem-file"},
+		{contentType: ""},
+		{contentType: "text/plain", expectErr: true},
+	}
+	for _, test := range tests {
+		d.keyVaultSecretCache.Set(key, &keyVaultSecret{content: string(generateTestCertificate(t, time.Now().Add(90*24*time.Hour))), contentType: test.contentType})
+		_, _, _, _, authEnv, err := d.getAuthEnv(context.Background(), "rg#account#container", "", stagingPath, attrib, nil)
+		if test.expectErr {
+			assert.Error(t, err, test.contentType)
+			continue
+		}
+		assert.NoError(t, err, test.contentType)
+		assert.Contains(t, authEnv, "AZURE_STORAGE_SPN_CLIENT_CERT_PATH="+getSPNCertPath("rg#account#container", stagingPath, true), test.contentType)
+	}
+}
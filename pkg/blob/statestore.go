@@ -0,0 +1,354 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// volume name -> storage account picked in CreateVolume
+	stateKindVolumeAccount = "volume-account"
+	// account search key -> storage account picked in CreateVolume
+	stateKindAccountSearch = "account-search"
+	// volume ID or account name -> container created by data plane API
+	stateKindDataPlaneAPI = "data-plane-api"
+
+	stateKindLabel      = "blob.csi.azure.com/state-kind"
+	stateStoreAPIGroup  = "blob.csi.azure.com"
+	stateStoreAPIVer    = "v1alpha1"
+	stateStoreKind      = "BlobDriverState"
+	stateStoreResource  = "blobdriverstates"
+	stateStoreOpTimeout = 10 * time.Second
+)
+
+// interval of removing expired entries from state store
+var stateStoreGCInterval = time.Hour
+
+// stateKindTTLs are the same as TTLs of the corresponding in-memory caches, expired entries are
+// ignored and removed at startup. account search results are short-lived, volume account entries
+// only need to outlive CreateVolume retries.
+var stateKindTTLs = map[string]time.Duration{
+	stateKindVolumeAccount: 24 * time.Hour,
+	stateKindAccountSearch: time.Minute,
+	stateKindDataPlaneAPI:  24 * 30 * time.Hour,
+}
+
+var stateStoreGVR = schema.GroupVersionResource{Group: stateStoreAPIGroup, Version: stateStoreAPIVer, Resource: stateStoreResource}
+
+// stateEntry is a driver decision recorded in state store
+type stateEntry struct {
+	Key        string
+	Value      string
+	UpdateTime time.Time
+}
+
+// stateStore persists driver-managed state so it survives controller restarts and leader failover,
+// sas tokens are credentials and are never persisted
+type stateStore interface {
+	// Get returns nil entry if key is not found
+	Get(ctx context.Context, kind, key string) (*stateEntry, error)
+	Set(ctx context.Context, kind, key, value string) error
+	Delete(ctx context.Context, kind, key string) error
+	List(ctx context.Context, kind string) ([]stateEntry, error)
+}
+
+// memoryStateStore is an in-memory state store, used in tests
+type memoryStateStore struct {
+	mutex   sync.Mutex
+	entries map[string]map[string]stateEntry
+}
+
+func newMemoryStateStore() *memoryStateStore {
+	return &memoryStateStore{entries: map[string]map[string]stateEntry{}}
+}
+
+func (s *memoryStateStore) Get(_ context.Context, kind, key string) (*stateEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if entry, ok := s.entries[kind][key]; ok {
+		return &entry, nil
+	}
+	return nil, nil
+}
+
+func (s *memoryStateStore) Set(_ context.Context, kind, key, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.entries[kind] == nil {
+		s.entries[kind] = map[string]stateEntry{}
+	}
+	s.entries[kind][key] = stateEntry{Key: key, Value: value, UpdateTime: time.Now()}
+	return nil
+}
+
+func (s *memoryStateStore) Delete(_ context.Context, kind, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.entries[kind], key)
+	return nil
+}
+
+func (s *memoryStateStore) List(_ context.Context, kind string) ([]stateEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entries := make([]stateEntry, 0, len(s.entries[kind]))
+	for _, entry := range s.entries[kind] {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// crdStateStore stores every entry as a BlobDriverState custom resource
+type crdStateStore struct {
+	client    dynamic.ResourceInterface
+	namespace string
+}
+
+func newCRDStateStore(dynamicClient dynamic.Interface, namespace string) *crdStateStore {
+	return &crdStateStore{client: dynamicClient.Resource(stateStoreGVR).Namespace(namespace), namespace: namespace}
+}
+
+// getStateObjectName returns a valid object name since keys may contain characters(e.g. #) not allowed in object name
+func getStateObjectName(kind, key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s-%s", kind, hex.EncodeToString(hash[:])[:40])
+}
+
+func newStateObject(namespace, kind, key, value string, updateTime time.Time) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(stateStoreGVR.GroupVersion().String())
+	obj.SetKind(stateStoreKind)
+	obj.SetName(getStateObjectName(kind, key))
+	obj.SetNamespace(namespace)
+	obj.SetLabels(map[string]string{stateKindLabel: kind})
+	setStateObjectSpec(obj, kind, key, value, updateTime)
+	return obj
+}
+
+func setStateObjectSpec(obj *unstructured.Unstructured, kind, key, value string, updateTime time.Time) {
+	obj.Object["spec"] = map[string]interface{}{
+		"kind":       kind,
+		"key":        key,
+		"value":      value,
+		"updateTime": updateTime.UTC().Format(time.RFC3339),
+	}
+}
+
+func getStateEntry(obj *unstructured.Unstructured) (*stateEntry, error) {
+	key, _, err := unstructured.NestedString(obj.Object, "spec", "key")
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := unstructured.NestedString(obj.Object, "spec", "value")
+	if err != nil {
+		return nil, err
+	}
+	entry := &stateEntry{Key: key, Value: value, UpdateTime: obj.GetCreationTimestamp().Time}
+	if updateTime, _, _ := unstructured.NestedString(obj.Object, "spec", "updateTime"); updateTime != "" {
+		if entry.UpdateTime, err = time.Parse(time.RFC3339, updateTime); err != nil {
+			return nil, fmt.Errorf("invalid updateTime(%s) in %s: %w", updateTime, obj.GetName(), err)
+		}
+	}
+	return entry, nil
+}
+
+func (s *crdStateStore) Get(ctx context.Context, kind, key string) (*stateEntry, error) {
+	obj, err := s.client.Get(ctx, getStateObjectName(kind, key), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return getStateEntry(obj)
+}
+
+func (s *crdStateStore) Set(ctx context.Context, kind, key, value string) error {
+	now := time.Now()
+	_, err := s.client.Create(ctx, newStateObject(s.namespace, kind, key, value, now), metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	obj, err := s.client.Get(ctx, getStateObjectName(kind, key), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	setStateObjectSpec(obj, kind, key, value, now)
+	_, err = s.client.Update(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+func (s *crdStateStore) Delete(ctx context.Context, kind, key string) error {
+	if err := s.client.Delete(ctx, getStateObjectName(kind, key), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *crdStateStore) List(ctx context.Context, kind string) ([]stateEntry, error) {
+	list, err := s.client.List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", stateKindLabel, kind)})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]stateEntry, 0, len(list.Items))
+	for i := range list.Items {
+		entry, err := getStateEntry(&list.Items[i])
+		if err != nil {
+			klog.Warningf("skip invalid state object(%s): %v", list.Items[i].GetName(), err)
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// EnableCRDStateStore persists driver-managed state in BlobDriverState custom resources in the state store namespace
+func (d *Driver) EnableCRDStateStore(dynamicClient dynamic.Interface) {
+	klog.V(2).Infof("state store is enabled, namespace: %s", d.stateStoreNamespace)
+	d.stateStore = newCRDStateStore(dynamicClient, d.stateStoreNamespace)
+}
+
+func isStateEntryExpired(kind string, entry *stateEntry) bool {
+	ttl, ok := stateKindTTLs[kind]
+	return ok && time.Since(entry.UpdateTime) > ttl
+}
+
+// getState returns the value of key in state store, returns false if state store is disabled,
+// key is not found or expired
+func (d *Driver) getState(ctx context.Context, kind, key string) (string, bool) {
+	value, ok, err := d.lookupState(ctx, kind, key)
+	if err != nil {
+		klog.Warningf("failed to get %s(%s) from state store: %v", kind, key, err)
+	}
+	return value, ok
+}
+
+// lookupState is the same as getState while the state store error is returned
+func (d *Driver) lookupState(ctx context.Context, kind, key string) (string, bool, error) {
+	if d.stateStore == nil || key == "" {
+		return "", false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, stateStoreOpTimeout)
+	defer cancel()
+	entry, err := d.stateStore.Get(ctx, kind, key)
+	if err != nil {
+		return "", false, err
+	}
+	if entry == nil || isStateEntryExpired(kind, entry) {
+		return "", false, nil
+	}
+	return entry.Value, true, nil
+}
+
+// setState records key in state store, the in-memory cache is still the source of truth
+// if state store is unavailable, so failure is only logged
+func (d *Driver) setState(ctx context.Context, kind, key, value string) {
+	if d.stateStore == nil || key == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, stateStoreOpTimeout)
+	defer cancel()
+	if err := d.stateStore.Set(ctx, kind, key, value); err != nil {
+		klog.Warningf("failed to set %s(%s) in state store: %v", kind, key, err)
+	}
+}
+
+func (d *Driver) deleteState(ctx context.Context, kind, key string) {
+	if d.stateStore == nil || key == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, stateStoreOpTimeout)
+	defer cancel()
+	if err := d.stateStore.Delete(ctx, kind, key); err != nil {
+		klog.Warningf("failed to delete %s(%s) from state store: %v", kind, key, err)
+	}
+}
+
+// loadState loads unexpired entries from state store into in-memory caches, expired entries are removed by runStateStoreGC
+func (d *Driver) loadState(ctx context.Context) error {
+	if d.stateStore == nil {
+		return nil
+	}
+	for _, kind := range []string{stateKindVolumeAccount, stateKindAccountSearch, stateKindDataPlaneAPI} {
+		entries, err := d.stateStore.List(ctx, kind)
+		if err != nil {
+			return fmt.Errorf("failed to list %s from state store: %w", kind, err)
+		}
+		var loaded int
+		for i := range entries {
+			entry := &entries[i]
+			if isStateEntryExpired(kind, entry) {
+				continue
+			}
+			switch kind {
+			case stateKindVolumeAccount:
+				d.volMap.Store(entry.Key, entry.Value)
+			case stateKindAccountSearch:
+				d.accountSearchCache.Set(entry.Key, entry.Value)
+			case stateKindDataPlaneAPI:
+				d.dataPlaneAPIVolCache.Set(entry.Key, entry.Value)
+			}
+			loaded++
+		}
+		klog.V(2).Infof("loaded %d %s entries from state store", loaded, kind)
+	}
+	return nil
+}
+
+// runStateStoreGC removes expired entries from state store periodically until ctx is done,
+// e.g. entries of storage accounts which are not used by new volumes any more
+func (d *Driver) runStateStoreGC(ctx context.Context) {
+	if d.stateStore == nil {
+		return
+	}
+	wait.UntilWithContext(ctx, d.gcState, stateStoreGCInterval)
+}
+
+// gcState removes expired entries of kinds with TTL from state store
+func (d *Driver) gcState(ctx context.Context) {
+	for kind := range stateKindTTLs {
+		entries, err := d.stateStore.List(ctx, kind)
+		if err != nil {
+			klog.Warningf("failed to list %s from state store: %v", kind, err)
+			continue
+		}
+		var removed int
+		for i := range entries {
+			if isStateEntryExpired(kind, &entries[i]) {
+				d.deleteState(ctx, kind, entries[i].Key)
+				removed++
+			}
+		}
+		if removed > 0 {
+			klog.V(2).Infof("removed %d expired %s entries from state store", removed, kind)
+		}
+	}
+}
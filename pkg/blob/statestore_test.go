@@ -0,0 +1,184 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/validation"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+func TestMemoryStateStore(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStateStore()
+
+	entry, err := store.Get(ctx, stateKindVolumeAccount, "pv")
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+
+	assert.NoError(t, store.Set(ctx, stateKindVolumeAccount, "pv", "account"))
+	assert.NoError(t, store.Set(ctx, stateKindDataPlaneAPI, "rg#account#container", ""))
+	entry, err = store.Get(ctx, stateKindVolumeAccount, "pv")
+	assert.NoError(t, err)
+	assert.Equal(t, "account", entry.Value)
+
+	entries, err := store.List(ctx, stateKindVolumeAccount)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.NoError(t, store.Delete(ctx, stateKindVolumeAccount, "pv"))
+	entry, err = store.Get(ctx, stateKindVolumeAccount, "pv")
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+}
+
+func TestStateObject(t *testing.T) {
+	key := "rg#account#container#pv#namespace#subsID"
+	name := getStateObjectName(stateKindDataPlaneAPI, key)
+	assert.Empty(t, validation.NameIsDNSSubdomain(name, false))
+	assert.NotEqual(t, name, getStateObjectName(stateKindVolumeAccount, key))
+
+	updateTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	obj := newStateObject("kube-system", stateKindDataPlaneAPI, key, "value", updateTime)
+	assert.Equal(t, "blob.csi.azure.com/v1alpha1", obj.GetAPIVersion())
+	assert.Equal(t, stateStoreKind, obj.GetKind())
+	assert.Equal(t, name, obj.GetName())
+	assert.Equal(t, "kube-system", obj.GetNamespace())
+	assert.Equal(t, stateKindDataPlaneAPI, obj.GetLabels()[stateKindLabel])
+
+	entry, err := getStateEntry(obj)
+	require.NoError(t, err)
+	assert.Equal(t, &stateEntry{Key: key, Value: "value", UpdateTime: updateTime}, entry)
+
+	obj.Object["spec"].(map[string]interface{})["updateTime"] = "invalid"
+	_, err = getStateEntry(obj)
+	assert.Error(t, err)
+}
+
+func TestDriverState(t *testing.T) {
+	ctx := context.Background()
+	d := NewFakeDriver()
+
+	// state store is disabled
+	d.setState(ctx, stateKindVolumeAccount, "pv", "account")
+	_, ok := d.getState(ctx, stateKindVolumeAccount, "pv")
+	assert.False(t, ok)
+	assert.NoError(t, d.loadState(ctx))
+
+	store := newMemoryStateStore()
+	d.stateStore = store
+	d.setState(ctx, stateKindVolumeAccount, "pv", "account")
+	v, ok := d.getState(ctx, stateKindVolumeAccount, "pv")
+	assert.True(t, ok)
+	assert.Equal(t, "account", v)
+
+	// expired entry is ignored
+	store.entries[stateKindAccountSearch] = map[string]stateEntry{
+		"expired": {Key: "expired", Value: "account1", UpdateTime: time.Now().Add(-2 * time.Minute)},
+		"valid":   {Key: "valid", Value: "account2", UpdateTime: time.Now()},
+	}
+	_, ok = d.getState(ctx, stateKindAccountSearch, "expired")
+	assert.False(t, ok)
+
+	d.deleteState(ctx, stateKindVolumeAccount, "pv")
+	_, ok = d.getState(ctx, stateKindVolumeAccount, "pv")
+	assert.False(t, ok)
+}
+
+func TestLoadState(t *testing.T) {
+	ctx := context.Background()
+	d := NewFakeDriver()
+	store := newMemoryStateStore()
+	d.stateStore = store
+	assert.NoError(t, store.Set(ctx, stateKindVolumeAccount, "pv", "account"))
+	assert.NoError(t, store.Set(ctx, stateKindAccountSearch, "lockKey", "account"))
+	assert.NoError(t, store.Set(ctx, stateKindDataPlaneAPI, "volumeID", ""))
+	store.entries[stateKindAccountSearch]["expired"] = stateEntry{Key: "expired", Value: "account", UpdateTime: time.Now().Add(-time.Hour)}
+
+	assert.NoError(t, d.loadState(ctx))
+
+	v, ok := d.volMap.Load("pv")
+	assert.True(t, ok)
+	assert.Equal(t, "account", v)
+	cache, err := d.accountSearchCache.Get("lockKey", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Equal(t, "account", cache)
+	cache, err = d.accountSearchCache.Get("expired", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.Nil(t, cache)
+	assert.True(t, d.useDataPlaneAPI("volumeID", ""))
+}
+
+func TestGCState(t *testing.T) {
+	ctx := context.Background()
+	d := NewFakeDriver()
+	store := newMemoryStateStore()
+	d.stateStore = store
+	assert.NoError(t, store.Set(ctx, stateKindAccountSearch, "lockKey", "account"))
+	assert.NoError(t, store.Set(ctx, stateKindCloneJob, "job", "{}"))
+	store.entries[stateKindAccountSearch]["expired"] = stateEntry{Key: "expired", Value: "account", UpdateTime: time.Now().Add(-time.Hour)}
+	store.entries[stateKindDataPlaneAPI] = map[string]stateEntry{
+		"account": {Key: "account", UpdateTime: time.Now().Add(-31 * 24 * time.Hour)},
+	}
+
+	d.gcState(ctx)
+
+	// expired entries are removed, clone jobs without TTL are kept
+	for _, e := range []struct {
+		kind, key string
+		exists    bool
+	}{
+		{stateKindAccountSearch, "lockKey", true},
+		{stateKindAccountSearch, "expired", false},
+		{stateKindDataPlaneAPI, "account", false},
+		{stateKindCloneJob, "job", true},
+	} {
+		entry, err := store.Get(ctx, e.kind, e.key)
+		assert.NoError(t, err)
+		assert.Equal(t, e.exists, entry != nil, e.key)
+	}
+}
+
+func TestUseDataPlaneAPIFromStateStore(t *testing.T) {
+	ctx := context.Background()
+	d := NewFakeDriver()
+	d.stateStore = newMemoryStateStore()
+
+	// entry written by another controller replica before failover
+	assert.NoError(t, d.stateStore.Set(ctx, stateKindDataPlaneAPI, "account", ""))
+	assert.True(t, d.useDataPlaneAPI("volumeID", "account"))
+	cache, err := d.dataPlaneAPIVolCache.Get("account", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.NotNil(t, cache)
+
+	// keys not found in state store are cached
+	assert.False(t, d.useDataPlaneAPI("volumeID2", "account2"))
+	assert.NoError(t, d.stateStore.Set(ctx, stateKindDataPlaneAPI, "account2", ""))
+	assert.False(t, d.useDataPlaneAPI("volumeID2", "account2"))
+	cache, err = d.dataPlaneAPIStateMissCache.Get("account2", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+	assert.NotNil(t, cache)
+
+	// entry set by this controller takes precedence over cached miss
+	d.dataPlaneAPIVolCache.Set("volumeID2", "")
+	assert.True(t, d.useDataPlaneAPI("volumeID2", "account2"))
+}
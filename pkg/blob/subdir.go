@@ -0,0 +1,68 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// normalizeSubDir trims leading and trailing slashes in subDir and checks path traversal
+func normalizeSubDir(subDir string) (string, error) {
+	subDir = strings.Trim(strings.TrimSpace(subDir), "/")
+	if subDir == "" {
+		return "", fmt.Errorf("%s is empty", subDirField)
+	}
+	for _, segment := range strings.Split(subDir, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("%s(%s) is invalid, should not contain empty, . or .. path segment", subDirField, subDir)
+		}
+	}
+	return subDir, nil
+}
+
+// isSubDirSupportedProtocol returns true if the protocol could mount a directory of the container
+func isSubDirSupportedProtocol(protocol string) bool {
+	return protocol == Fuse2 || isNFSProtocol(protocol)
+}
+
+// deleteSubDir deletes subDir of the volume with account key
+func (d *Driver) deleteSubDir(ctx context.Context, volumeID string, info *volumeIDInfo, secrets map[string]string) error {
+	_, accountName, accountKey, containerName, _, err := d.GetAuthEnv(ctx, volumeID, "", nil, secrets)
+	if err != nil {
+		return status.Errorf(codes.Internal, "GetAuthEnv(%s) failed with %v", volumeID, err)
+	}
+	storageEndpointSuffix := info.storageEndpointSuffix
+	if storageEndpointSuffix == "" {
+		storageEndpointSuffix = d.getStorageEndPointSuffix()
+	}
+	client, err := d.containerDataClientFactory.NewContainerDataClient(accountName, accountKey, storageEndpointSuffix, containerName)
+	if err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	klog.V(2).Infof("deleting %s(%s) in container(%s) account(%s) volumeID(%s)", subDirField, info.subDir, containerName, accountName, volumeID)
+	if err := client.DeleteDirectory(ctx, info.subDir); err != nil {
+		return status.Errorf(codes.Internal, "failed to delete %s(%s) in container(%s) account(%s) volumeID(%s), error: %v", subDirField, info.subDir, containerName, accountName, volumeID, err)
+	}
+	klog.V(2).Infof("%s(%s) in container(%s) account(%s) volumeID(%s) is deleted successfully", subDirField, info.subDir, containerName, accountName, volumeID)
+	return nil
+}
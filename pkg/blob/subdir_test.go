@@ -0,0 +1,172 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// fakeContainerDataClient records container data operations in memory
+type fakeContainerDataClient struct {
+	accountName, accountKey, storageEndpointSuffix, containerName string
+	createdDirs, deletedDirs, createdContainers                   []string
+	metadata                                                      map[string]string
+	blobs                                                         []string
+	tiers                                                         map[string]blob.AccessTier
+	// copies maps destination "container/name" to source blob name
+	copies                      map[string]string
+	createContainerOptions      *blobContainerOptions
+	encryptionScope             string
+	denyEncryptionScopeOverride bool
+	err                         error
+	// mu protects tiers and copies which are updated concurrently on reclaim
+	mu sync.Mutex
+}
+
+func (c *fakeContainerDataClient) NewContainerDataClient(accountName, accountKey, storageEndpointSuffix, containerName string) (containerDataClient, error) {
+	c.accountName, c.accountKey, c.storageEndpointSuffix, c.containerName = accountName, accountKey, storageEndpointSuffix, containerName
+	return c, nil
+}
+
+func (c *fakeContainerDataClient) CreateDirectory(_ context.Context, dir string) error {
+	c.createdDirs = append(c.createdDirs, dir)
+	return c.err
+}
+
+func (c *fakeContainerDataClient) DeleteDirectory(_ context.Context, dir string) error {
+	c.deletedDirs = append(c.deletedDirs, dir)
+	return c.err
+}
+
+func (c *fakeContainerDataClient) CreateContainer(_ context.Context, name string, options *blobContainerOptions) error {
+	c.createdContainers = append(c.createdContainers, name)
+	c.createContainerOptions = options
+	return c.err
+}
+
+func (c *fakeContainerDataClient) GetEncryptionScope(_ context.Context) (string, bool, error) {
+	return c.encryptionScope, c.denyEncryptionScopeOverride, c.err
+}
+
+func (c *fakeContainerDataClient) GetMetadata(_ context.Context) (map[string]string, error) {
+	metadata := map[string]string{}
+	for k, v := range c.metadata {
+		metadata[k] = v
+	}
+	return metadata, c.err
+}
+
+func (c *fakeContainerDataClient) SetMetadata(_ context.Context, metadata map[string]string) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.metadata = metadata
+	return nil
+}
+
+func (c *fakeContainerDataClient) ListBlobs(_ context.Context) ([]string, error) {
+	return c.blobs, c.err
+}
+
+func (c *fakeContainerDataClient) SetBlobTier(_ context.Context, name string, tier blob.AccessTier) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tiers == nil {
+		c.tiers = map[string]blob.AccessTier{}
+	}
+	c.tiers[name] = tier
+	return nil
+}
+
+func (c *fakeContainerDataClient) CopyBlob(_ context.Context, name, dstContainer, dstName string) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.copies == nil {
+		c.copies = map[string]string{}
+	}
+	c.copies[dstContainer+"/"+dstName] = name
+	return nil
+}
+
+func TestNormalizeSubDir(t *testing.T) {
+	tests := []struct {
+		subDir         string
+		expectedSubDir string
+		expectErr      bool
+	}{
+		{subDir: "dir", expectedSubDir: "dir"},
+		{subDir: " /a/b/ ", expectedSubDir: "a/b"},
+		{subDir: "/", expectErr: true},
+		{subDir: "", expectErr: true},
+		{subDir: "a//b", expectErr: true},
+		{subDir: "a/../b", expectErr: true},
+		{subDir: "./a", expectErr: true},
+	}
+
+	for _, test := range tests {
+		subDir, err := normalizeSubDir(test.subDir)
+		assert.Equal(t, test.expectErr, err != nil, test.subDir)
+		assert.Equal(t, test.expectedSubDir, subDir, test.subDir)
+	}
+}
+
+func TestIsSubDirSupportedProtocol(t *testing.T) {
+	assert.True(t, isSubDirSupportedProtocol(Fuse2))
+	assert.True(t, isSubDirSupportedProtocol(NFS))
+	assert.True(t, isSubDirSupportedProtocol(AZNFS))
+	assert.False(t, isSubDirSupportedProtocol(Fuse))
+	assert.False(t, isSubDirSupportedProtocol(""))
+}
+
+func TestDeleteVolumeWithSubDir(t *testing.T) {
+	volumeID := (&volumeIDInfo{resourceGroup: "rg", accountName: "account", containerName: "shared", uuid: "pv", subDir: "team/pvc-1"}).encode(volumeIDFormatV2)
+	secrets := map[string]string{defaultSecretAccountName: "account", defaultSecretAccountKey: "key"}
+
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	client := &fakeContainerDataClient{}
+	d.containerDataClientFactory = client
+
+	_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: secrets})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"team/pvc-1"}, client.deletedDirs)
+	assert.Equal(t, "account", client.accountName)
+	assert.Equal(t, "key", client.accountKey)
+	assert.Equal(t, "shared", client.containerName)
+	assert.Equal(t, d.getStorageEndPointSuffix(), client.storageEndpointSuffix)
+
+	client.err = fmt.Errorf("test error")
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: secrets})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+const (
+	topologyRegionKey = "topology.blob.csi.azure.com/region"
+	topologyZoneKey   = "topology.blob.csi.azure.com/zone"
+
+	// crossRegionMountAllow mounts volumes in other regions silently
+	crossRegionMountAllow = "allow"
+	// crossRegionMountWarn mounts volumes in other regions with a warning event on the PVC
+	crossRegionMountWarn = "warn"
+	// crossRegionMountDeny refuses to mount volumes in other regions
+	crossRegionMountDeny = "deny"
+
+	crossRegionMountReason = "CrossRegionMount"
+)
+
+var supportedCrossRegionMountPolicyList = []string{crossRegionMountAllow, crossRegionMountWarn, crossRegionMountDeny}
+
+func isSupportedCrossRegionMountPolicy(policy string) bool {
+	for _, v := range supportedCrossRegionMountPolicyList {
+		if v == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeTopology is the region and zone of the node, zone is empty if the node is not in an availability zone
+type nodeTopology struct {
+	region string
+	zone   string
+}
+
+// getNodeTopology returns the region and zone of the node from node labels, or from instance metadata if labels are not set
+func (d *Driver) getNodeTopology(ctx context.Context) (*nodeTopology, error) {
+	d.nodeTopologyLock.Lock()
+	defer d.nodeTopologyLock.Unlock()
+	if d.nodeTopology != nil {
+		return d.nodeTopology, nil
+	}
+
+	var topology *nodeTopology
+	if d.KubeClient != nil && d.NodeID != "" {
+		node, err := d.KubeClient.CoreV1().Nodes().Get(ctx, d.NodeID, metav1.GetOptions{})
+		if err != nil {
+			klog.Warningf("failed to get node(%s) to read topology labels: %v", d.NodeID, err)
+		} else if region := node.Labels[v1.LabelTopologyRegion]; region != "" {
+			topology = &nodeTopology{region: strings.ToLower(region)}
+			topology.setZone(node.Labels[v1.LabelTopologyZone])
+		}
+	}
+	if topology == nil && d.cloud != nil && d.cloud.Metadata != nil {
+		metadata, err := d.cloud.Metadata.GetMetadata(azcache.CacheReadTypeDefault)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get instance metadata: %v", err)
+		}
+		if metadata != nil && metadata.Compute != nil && metadata.Compute.Location != "" {
+			topology = &nodeTopology{region: strings.ToLower(metadata.Compute.Location)}
+			if metadata.Compute.Zone != "" {
+				topology.setZone(fmt.Sprintf("%s-%s", topology.region, metadata.Compute.Zone))
+			}
+		}
+	}
+	if topology == nil {
+		return nil, status.Errorf(codes.Internal, "could not get region of node(%s) from %s label or instance metadata", d.NodeID, v1.LabelTopologyRegion)
+	}
+	klog.V(2).Infof("node(%s) is in region(%s) zone(%s)", d.NodeID, topology.region, topology.zone)
+	d.nodeTopology = topology
+	return topology, nil
+}
+
+// setZone sets zone if it's an availability zone, e.g. eastus-1, a fault domain of non-zonal node, e.g. 0, is ignored
+func (t *nodeTopology) setZone(zone string) {
+	zone = strings.ToLower(zone)
+	if strings.HasPrefix(zone, t.region+"-") {
+		t.zone = zone
+	}
+}
+
+// toCSITopology returns the accessible topology of the node
+func (t *nodeTopology) toCSITopology() *csi.Topology {
+	segments := map[string]string{topologyRegionKey: t.region}
+	if t.zone != "" {
+		segments[topologyZoneKey] = t.zone
+	}
+	return &csi.Topology{Segments: segments}
+}
+
+// getRequestedTopology returns the region of the storage account from the accessibility requirements,
+// preferred topology is picked first since it's the topology of the selected node with WaitForFirstConsumer,
+// zonal is true if the requested topology is in an availability zone
+func getRequestedTopology(requirement *csi.TopologyRequirement) (region string, zonal bool) {
+	if requirement == nil {
+		return "", false
+	}
+	for _, topology := range append(requirement.GetPreferred(), requirement.GetRequisite()...) {
+		segments := topology.GetSegments()
+		if region = segments[topologyRegionKey]; region != "" {
+			return strings.ToLower(region), segments[topologyZoneKey] != ""
+		}
+	}
+	return "", false
+}
+
+// isRegionAccessible checks whether the region satisfies the requisite topology, any region is accessible if there is no requisite topology
+func isRegionAccessible(requirement *csi.TopologyRequirement, region string) bool {
+	requisite := requirement.GetRequisite()
+	if len(requisite) == 0 {
+		return true
+	}
+	for _, topology := range requisite {
+		if r := topology.GetSegments()[topologyRegionKey]; r == "" || strings.EqualFold(r, region) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyTopologyRequirement sets the location of storage account to the region of the requested topology,
+// and prefers zone redundant storage if the topology is zonal and sku is not specified
+func applyTopologyRequirement(requirement *csi.TopologyRequirement, p *storageClassParameters, capabilities *cloudCapabilities) error {
+	if p.account != "" {
+		// location of existing storage account could not be changed
+		return nil
+	}
+	region, zonal := getRequestedTopology(requirement)
+	if p.location != "" {
+		if !isRegionAccessible(requirement, p.location) {
+			return status.Errorf(codes.InvalidArgument, "%s(%s) does not satisfy the requisite topology %v", locationField, p.location, requirement.GetRequisite())
+		}
+	} else {
+		p.location = region
+	}
+	if region == "" || !strings.EqualFold(p.location, region) {
+		return nil
+	}
+	if zonal && p.storageAccountType == "" && capabilities.supportsSKU(string(armstorage.SKUNameStandardZRS)) {
+		klog.V(2).Infof("use %s storage account in region(%s) since the requested topology is zonal", armstorage.SKUNameStandardZRS, region)
+		p.storageAccountType = string(armstorage.SKUNameStandardZRS)
+	}
+	return nil
+}
+
+// checkCrossRegionMount applies the cross region mount policy if the volume is not in the region of the node
+func (d *Driver) checkCrossRegionMount(ctx context.Context, volumeID, location string, attrib map[string]string) error {
+	if location == "" || d.crossRegionMountPolicy == crossRegionMountAllow {
+		return nil
+	}
+	topology, err := d.getNodeTopology(ctx)
+	if err != nil {
+		klog.Warningf("skip checking region of volume(%s): %v", volumeID, err)
+		return nil
+	}
+	if strings.EqualFold(topology.region, location) {
+		return nil
+	}
+	if d.crossRegionMountPolicy == crossRegionMountDeny {
+		return status.Errorf(codes.FailedPrecondition, "volume(%s) in region(%s) could not be mounted on node(%s) in region(%s) since cross region mount policy is %s", volumeID, location, d.NodeID, topology.region, crossRegionMountDeny)
+	}
+	d.recordVolumeEvent(ctx, attrib, v1.EventTypeWarning, crossRegionMountReason, "volume(%s) in region(%s) is mounted on node(%s) in region(%s), which adds latency and cross region data transfer cost", volumeID, location, d.NodeID, topology.region)
+	return nil
+}
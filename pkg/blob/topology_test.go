@@ -0,0 +1,206 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTopologyRequirement(preferred, requisite []map[string]string) *csi.TopologyRequirement {
+	requirement := &csi.TopologyRequirement{}
+	for _, segments := range preferred {
+		requirement.Preferred = append(requirement.Preferred, &csi.Topology{Segments: segments})
+	}
+	for _, segments := range requisite {
+		requirement.Requisite = append(requirement.Requisite, &csi.Topology{Segments: segments})
+	}
+	return requirement
+}
+
+func TestNodeGetInfoWithTopology(t *testing.T) {
+	tests := []struct {
+		desc             string
+		labels           map[string]string
+		expectedSegments map[string]string
+		expectedCode     codes.Code
+	}{
+		{
+			desc:             "zonal node",
+			labels:           map[string]string{v1.LabelTopologyRegion: "EastUS", v1.LabelTopologyZone: "eastus-2"},
+			expectedSegments: map[string]string{topologyRegionKey: "eastus", topologyZoneKey: "eastus-2"},
+		},
+		{
+			desc:             "non-zonal node",
+			labels:           map[string]string{v1.LabelTopologyRegion: "westus", v1.LabelTopologyZone: "0"},
+			expectedSegments: map[string]string{topologyRegionKey: "westus"},
+		},
+		{
+			desc:         "no region label and instance metadata",
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			d.enableTopology = true
+			d.KubeClient = fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: fakeNodeID, Labels: test.labels}})
+			resp, err := d.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+			assert.Equal(t, test.expectedCode, status.Code(err), "%v", err)
+			if err == nil {
+				assert.Equal(t, fakeNodeID, resp.GetNodeId())
+				assert.Equal(t, test.expectedSegments, resp.GetAccessibleTopology().GetSegments())
+			}
+		})
+	}
+}
+
+func TestApplyTopologyRequirement(t *testing.T) {
+	eastus := map[string]string{topologyRegionKey: "eastus"}
+	eastus1 := map[string]string{topologyRegionKey: "eastus", topologyZoneKey: "eastus-1"}
+	westus := map[string]string{topologyRegionKey: "westus"}
+	tests := []struct {
+		desc                string
+		requirement         *csi.TopologyRequirement
+		parameters          storageClassParameters
+		expectedLocation    string
+		expectedAccountType string
+		expectedCode        codes.Code
+	}{
+		{
+			desc: "no requirement",
+		},
+		{
+			desc:             "preferred region is picked first",
+			requirement:      newTopologyRequirement([]map[string]string{westus}, []map[string]string{eastus, westus}),
+			expectedLocation: "westus",
+		},
+		{
+			desc:                "zonal topology prefers zone redundant storage",
+			requirement:         newTopologyRequirement(nil, []map[string]string{eastus1}),
+			expectedLocation:    "eastus",
+			expectedAccountType: "Standard_ZRS",
+		},
+		{
+			desc:                "sku in storage class is kept",
+			requirement:         newTopologyRequirement(nil, []map[string]string{eastus1}),
+			parameters:          storageClassParameters{storageAccountType: "Premium_LRS"},
+			expectedLocation:    "eastus",
+			expectedAccountType: "Premium_LRS",
+		},
+		{
+			desc:             "location satisfies requisite topology",
+			requirement:      newTopologyRequirement([]map[string]string{eastus1}, []map[string]string{eastus1, westus}),
+			parameters:       storageClassParameters{location: "westus"},
+			expectedLocation: "westus",
+		},
+		{
+			desc:         "location does not satisfy requisite topology",
+			requirement:  newTopologyRequirement(nil, []map[string]string{eastus}),
+			parameters:   storageClassParameters{location: "westus"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:        "location of existing account is not changed",
+			requirement: newTopologyRequirement(nil, []map[string]string{eastus1}),
+			parameters:  storageClassParameters{account: "account"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			p := test.parameters
+			err := applyTopologyRequirement(test.requirement, &p, &cloudCapabilities{})
+			assert.Equal(t, test.expectedCode, status.Code(err), "%v", err)
+			if err == nil {
+				assert.Equal(t, test.expectedLocation, p.location)
+				assert.Equal(t, test.expectedAccountType, p.storageAccountType)
+			}
+		})
+	}
+}
+
+func TestCheckCrossRegionMount(t *testing.T) {
+	attrib := map[string]string{pvcNameKey: "pvc", pvcNamespaceKey: "default"}
+	tests := []struct {
+		desc          string
+		policy        string
+		location      string
+		expectedCode  codes.Code
+		expectedEvent bool
+	}{
+		{
+			desc:     "same region",
+			policy:   crossRegionMountDeny,
+			location: "EastUS",
+		},
+		{
+			desc:   "no location",
+			policy: crossRegionMountDeny,
+		},
+		{
+			desc:     "allow",
+			policy:   crossRegionMountAllow,
+			location: "westus",
+		},
+		{
+			desc:          "warn",
+			policy:        crossRegionMountWarn,
+			location:      "westus",
+			expectedEvent: true,
+		},
+		{
+			desc:         "deny",
+			policy:       crossRegionMountDeny,
+			location:     "westus",
+			expectedCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewFakeDriver()
+			d.crossRegionMountPolicy = test.policy
+			d.KubeClient = fake.NewSimpleClientset(
+				&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: fakeNodeID, Labels: map[string]string{v1.LabelTopologyRegion: "eastus"}}},
+				&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "default"}},
+			)
+			recorder := record.NewFakeRecorder(10)
+			d.eventRecorder = recorder
+
+			err := d.checkCrossRegionMount(context.Background(), "rg#account#container", test.location, attrib)
+			assert.Equal(t, test.expectedCode, status.Code(err), "%v", err)
+			if test.expectedEvent {
+				require.Len(t, recorder.Events, 1)
+				assert.Contains(t, <-recorder.Events, crossRegionMountReason)
+			} else {
+				assert.Empty(t, recorder.Events)
+			}
+		})
+	}
+}
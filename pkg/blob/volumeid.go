@@ -0,0 +1,187 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	volumeIDFormatV1 = 1
+	volumeIDFormatV2 = 2
+
+	// v2 volume ID format: blob:v2#rg#account#container#uuid#secretNamespace#subsID#options
+	// every segment is escaped by url.PathEscape, options are encoded as url query, e.g. protocol=nfs&dataplane=true
+	volumeIDV2Prefix = "blob:v2" + separator
+
+	volumeIDProtocolKey        = "protocol"
+	volumeIDEndpointSuffixKey  = "suffix"
+	volumeIDDataPlaneAPIKey    = "dataplane"
+	volumeIDSubDirKey          = "subdir"
+	volumeIDOnDeleteKey        = "ondelete"
+	volumeIDBackupContainerKey = "backupcontainer"
+	volumeIDLifecycleRuleKey   = "lifecyclerule"
+	volumeIDIsolationKey       = "isolation"
+)
+
+// volumeIDInfo is the decoded volume ID
+type volumeIDInfo struct {
+	resourceGroup   string
+	accountName     string
+	containerName   string
+	uuid            string
+	secretNamespace string
+	subsID          string
+
+	// following fields are only available in v2 volume ID
+	protocol              string
+	storageEndpointSuffix string
+	useDataPlaneAPI       bool
+	// subDir is the directory of the volume in a shared container
+	subDir string
+	// onDelete is the reclaim policy of the container, empty means delete
+	onDelete        string
+	backupContainer string
+	// lifecycleRule is the name of lifecycle management rule of the volume in account management policy
+	lifecycleRule string
+	// accountIsolation is the isolation mode of the dedicated account, which is deleted after its last volume
+	accountIsolation string
+	// options keeps unknown options in v2 volume ID
+	options url.Values
+}
+
+// parseVolumeID parses v2 volume ID and all legacy volume ID forms:
+// rg#account#container, rg#account#container#uuid, rg#account#container#uuid#secretNamespace, rg#account#container#uuid#secretNamespace#subsID
+func parseVolumeID(id string) (*volumeIDInfo, error) {
+	if strings.HasPrefix(id, volumeIDV2Prefix) {
+		return parseVolumeIDV2(strings.TrimPrefix(id, volumeIDV2Prefix))
+	}
+
+	segments := strings.Split(id, separator)
+	if len(segments) < 3 {
+		return nil, fmt.Errorf("error parsing volume id: %q, should at least contain two #", id)
+	}
+	info := &volumeIDInfo{
+		resourceGroup: segments[0],
+		accountName:   segments[1],
+		containerName: segments[2],
+	}
+	if len(segments) > 3 {
+		info.uuid = segments[3]
+	}
+	if len(segments) > 4 {
+		info.secretNamespace = segments[4]
+	}
+	if len(segments) > 5 {
+		info.subsID = segments[5]
+	}
+	return info, nil
+}
+
+func parseVolumeIDV2(id string) (*volumeIDInfo, error) {
+	segments := strings.Split(id, separator)
+	if len(segments) < 3 {
+		return nil, fmt.Errorf("error parsing volume id: %q, should at least contain resource group, account and container", volumeIDV2Prefix+id)
+	}
+	values := make([]string, 7)
+	for i := 0; i < len(segments) && i < len(values); i++ {
+		v, err := url.PathUnescape(segments[i])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing volume id: %q, invalid segment %q: %w", volumeIDV2Prefix+id, segments[i], err)
+		}
+		values[i] = v
+	}
+	options, err := url.ParseQuery(values[6])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing volume id: %q, invalid options: %w", volumeIDV2Prefix+id, err)
+	}
+	info := &volumeIDInfo{
+		resourceGroup:         values[0],
+		accountName:           values[1],
+		containerName:         values[2],
+		uuid:                  values[3],
+		secretNamespace:       values[4],
+		subsID:                values[5],
+		protocol:              options.Get(volumeIDProtocolKey),
+		storageEndpointSuffix: options.Get(volumeIDEndpointSuffixKey),
+		subDir:                options.Get(volumeIDSubDirKey),
+		onDelete:              options.Get(volumeIDOnDeleteKey),
+		backupContainer:       options.Get(volumeIDBackupContainerKey),
+		lifecycleRule:         options.Get(volumeIDLifecycleRuleKey),
+		accountIsolation:      options.Get(volumeIDIsolationKey),
+	}
+	if v := options.Get(volumeIDDataPlaneAPIKey); v != "" {
+		if info.useDataPlaneAPI, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("error parsing volume id: %q, invalid %s option: %s", volumeIDV2Prefix+id, volumeIDDataPlaneAPIKey, v)
+		}
+	}
+	for _, key := range []string{volumeIDProtocolKey, volumeIDEndpointSuffixKey, volumeIDDataPlaneAPIKey, volumeIDSubDirKey, volumeIDOnDeleteKey, volumeIDBackupContainerKey, volumeIDLifecycleRuleKey, volumeIDIsolationKey} {
+		options.Del(key)
+	}
+	if len(options) > 0 {
+		info.options = options
+	}
+	return info, nil
+}
+
+// encode returns volume ID in the given format version, options are dropped in v1 format
+func (v *volumeIDInfo) encode(version int) string {
+	if version != volumeIDFormatV2 {
+		return fmt.Sprintf(volumeIDTemplate, v.resourceGroup, v.accountName, v.containerName, v.uuid, v.secretNamespace, v.subsID)
+	}
+
+	options := url.Values{}
+	for k, values := range v.options {
+		options[k] = values
+	}
+	if v.protocol != "" {
+		options.Set(volumeIDProtocolKey, v.protocol)
+	}
+	if v.storageEndpointSuffix != "" {
+		options.Set(volumeIDEndpointSuffixKey, v.storageEndpointSuffix)
+	}
+	if v.useDataPlaneAPI {
+		options.Set(volumeIDDataPlaneAPIKey, trueValue)
+	}
+	if v.subDir != "" {
+		options.Set(volumeIDSubDirKey, v.subDir)
+	}
+	if v.onDelete != "" {
+		options.Set(volumeIDOnDeleteKey, v.onDelete)
+	}
+	if v.backupContainer != "" {
+		options.Set(volumeIDBackupContainerKey, v.backupContainer)
+	}
+	if v.lifecycleRule != "" {
+		options.Set(volumeIDLifecycleRuleKey, v.lifecycleRule)
+	}
+	if v.accountIsolation != "" {
+		options.Set(volumeIDIsolationKey, v.accountIsolation)
+	}
+	segments := []string{v.resourceGroup, v.accountName, v.containerName, v.uuid, v.secretNamespace, v.subsID, options.Encode()}
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return volumeIDV2Prefix + strings.Join(segments, separator)
+}
+
+func isSupportedVolumeIDFormatVersion(version int) bool {
+	return version == volumeIDFormatV1 || version == volumeIDFormatV2
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVolumeID(t *testing.T) {
+	tests := []struct {
+		volumeID     string
+		expectedInfo *volumeIDInfo
+		expectErr    bool
+	}{
+		{
+			volumeID:     "rg#account#container",
+			expectedInfo: &volumeIDInfo{resourceGroup: "rg", accountName: "account", containerName: "container"},
+		},
+		{
+			volumeID:     "rg#account#container#uuid",
+			expectedInfo: &volumeIDInfo{resourceGroup: "rg", accountName: "account", containerName: "container", uuid: "uuid"},
+		},
+		{
+			volumeID:     "rg#account#container#uuid#namespace",
+			expectedInfo: &volumeIDInfo{resourceGroup: "rg", accountName: "account", containerName: "container", uuid: "uuid", secretNamespace: "namespace"},
+		},
+		{
+			volumeID:     "rg#account#container##namespace#subsID",
+			expectedInfo: &volumeIDInfo{resourceGroup: "rg", accountName: "account", containerName: "container", secretNamespace: "namespace", subsID: "subsID"},
+		},
+		{
+			volumeID:  "rg#account",
+			expectErr: true,
+		},
+		{
+			volumeID:     "blob:v2#rg#account#container",
+			expectedInfo: &volumeIDInfo{resourceGroup: "rg", accountName: "account", containerName: "container"},
+		},
+		{
+			volumeID: "blob:v2#rg#account#container#uuid#namespace#subsID#backupcontainer=backup&dataplane=true&foo=a%2Fb&ondelete=copyToBackupContainer&protocol=nfs&subdir=a%2Fb&suffix=core.chinacloudapi.cn",
+			expectedInfo: &volumeIDInfo{
+				resourceGroup:         "rg",
+				accountName:           "account",
+				containerName:         "container",
+				uuid:                  "uuid",
+				secretNamespace:       "namespace",
+				subsID:                "subsID",
+				protocol:              NFS,
+				storageEndpointSuffix: "core.chinacloudapi.cn",
+				useDataPlaneAPI:       true,
+				subDir:                "a/b",
+				onDelete:              onDeleteCopyToBackupContainer,
+				backupContainer:       "backup",
+				options:               url.Values{"foo": []string{"a/b"}},
+			},
+		},
+		{
+			volumeID:     "blob:v2#rg#account#container#pv%23name###",
+			expectedInfo: &volumeIDInfo{resourceGroup: "rg", accountName: "account", containerName: "container", uuid: "pv#name"},
+		},
+		{
+			volumeID:  "blob:v2#rg#account",
+			expectErr: true,
+		},
+		{
+			volumeID:  "blob:v2#rg#account#container%zz",
+			expectErr: true,
+		},
+		{
+			volumeID:  "blob:v2#rg#account#container####dataplane=invalid",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		info, err := parseVolumeID(test.volumeID)
+		assert.Equal(t, test.expectErr, err != nil, test.volumeID)
+		assert.Equal(t, test.expectedInfo, info, test.volumeID)
+	}
+}
+
+func TestVolumeIDInfoEncode(t *testing.T) {
+	info := &volumeIDInfo{
+		resourceGroup:         "rg",
+		accountName:           "account",
+		containerName:         "container",
+		uuid:                  "pv#name",
+		secretNamespace:       "namespace",
+		subsID:                "subsID",
+		protocol:              Fuse2,
+		storageEndpointSuffix: "core.windows.net",
+		useDataPlaneAPI:       true,
+		subDir:                "a/b#c",
+		lifecycleRule:         "rule",
+		accountIsolation:      accountIsolationPerVolume,
+		options:               url.Values{"foo": []string{"bar"}},
+	}
+
+	// options are dropped in v1 format
+	assert.Equal(t, "rg#account#container#pv#name#namespace#subsID", info.encode(volumeIDFormatV1))
+
+	volumeID := info.encode(volumeIDFormatV2)
+	assert.Equal(t, "blob:v2#rg#account#container#pv%23name#namespace#subsID#dataplane=true&foo=bar&isolation=pervolume&lifecyclerule=rule&protocol=fuse2&subdir=a%252Fb%2523c&suffix=core.windows.net", volumeID)
+	decoded, err := parseVolumeID(volumeID)
+	assert.NoError(t, err)
+	assert.Equal(t, info, decoded)
+
+	rg, account, container, secretNamespace, subsID, err := GetContainerInfo(volumeID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rg", "account", "container", "namespace", "subsID"}, []string{rg, account, container, secretNamespace, subsID})
+}
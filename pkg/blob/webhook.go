@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ValidatingWebhookPath is the path of the validating admission webhook
+	ValidatingWebhookPath = "/validate"
+
+	maxAdmissionRequestSize = 3 << 20
+)
+
+// admissionValidator validates StorageClasses, PVs and pod inline volumes of the driver with the driver's own parameter parsing
+type admissionValidator struct {
+	driverName string
+}
+
+// validate returns an error if the object in admission request is not valid
+func (v *admissionValidator) validate(req *admissionv1.AdmissionRequest) error {
+	switch req.Kind.Kind {
+	case "StorageClass":
+		sc := &storagev1.StorageClass{}
+		if err := json.Unmarshal(req.Object.Raw, sc); err != nil {
+			return fmt.Errorf("failed to decode StorageClass: %w", err)
+		}
+		if sc.Provisioner != v.driverName {
+			return nil
+		}
+		if _, err := parseStorageClassParameters(getDriverParameters(sc.Parameters)); err != nil {
+			return fmt.Errorf("StorageClass(%s): %w", sc.Name, err)
+		}
+	case "PersistentVolume":
+		pv := &v1.PersistentVolume{}
+		if err := json.Unmarshal(req.Object.Raw, pv); err != nil {
+			return fmt.Errorf("failed to decode PersistentVolume: %w", err)
+		}
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != v.driverName {
+			return nil
+		}
+		if err := validateVolumeAttributes(pv.Spec.CSI.VolumeAttributes); err != nil {
+			return fmt.Errorf("PersistentVolume(%s): %w", pv.Name, err)
+		}
+	case "Pod":
+		pod := &v1.Pod{}
+		if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+			return fmt.Errorf("failed to decode Pod: %w", err)
+		}
+		for _, vol := range pod.Spec.Volumes {
+			if vol.CSI == nil || vol.CSI.Driver != v.driverName {
+				continue
+			}
+			if err := validateVolumeAttributes(vol.CSI.VolumeAttributes); err != nil {
+				return fmt.Errorf("inline volume(%s): %w", vol.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// getDriverParameters removes the reserved csi.storage.k8s.io/ parameters of StorageClass, e.g. secret names and fstype,
+// which are consumed by external-provisioner and not passed to the driver
+func getDriverParameters(parameters map[string]string) map[string]string {
+	driverParameters := make(map[string]string, len(parameters))
+	for k, v := range parameters {
+		if !strings.HasPrefix(strings.ToLower(k), csiParameterPrefix) {
+			driverParameters[k] = v
+		}
+	}
+	return driverParameters
+}
+
+// review returns the admission response of the admission request
+func (v *admissionValidator) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Operation == admissionv1.Delete {
+		return resp
+	}
+	if err := v.validate(req); err != nil {
+		klog.V(2).Infof("admission request(%s) of %s %s/%s is denied: %v", req.UID, req.Kind.Kind, req.Namespace, req.Name, err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+	return resp
+}
+
+func (v *admissionValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAdmissionRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	review.Response = v.review(review.Request)
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		klog.Errorf("failed to write admission response: %v", err)
+	}
+}
+
+// RunWebhookServer runs the validating admission webhook server with TLS until ctx is done
+func RunWebhookServer(ctx context.Context, driverName, address, certFile, keyFile string) error {
+	mux := http.NewServeMux()
+	mux.Handle(ValidatingWebhookPath, &admissionValidator{driverName: driverName})
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+	klog.V(2).Infof("validating admission webhook server listens on %s", address)
+	if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+func newAdmissionRequest(t *testing.T, kind string, obj interface{}) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(obj)
+	require.NoError(t, err)
+	return &admissionv1.AdmissionRequest{
+		UID:       types.UID("uid"),
+		Kind:      metav1.GroupVersionKind{Kind: kind},
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestAdmissionValidator(t *testing.T) {
+	storageClass := func(provisioner string, parameters map[string]string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc"}, Provisioner: provisioner, Parameters: parameters}
+	}
+	pv := func(driver string, attrib map[string]string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: "rg#account#container", VolumeAttributes: attrib},
+				},
+			},
+		}
+	}
+	pod := func(driver string, attrib map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod"},
+			Spec: v1.PodSpec{
+				Volumes: []v1.Volume{
+					{Name: "emptydir", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+					{Name: "blob", VolumeSource: v1.VolumeSource{CSI: &v1.CSIVolumeSource{Driver: driver, VolumeAttributes: attrib}}},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		desc            string
+		req             *admissionv1.AdmissionRequest
+		expectedAllowed bool
+	}{
+		{
+			desc:            "valid StorageClass",
+			req:             newAdmissionRequest(t, "StorageClass", storageClass(DefaultDriverName, map[string]string{skuNameField: "Standard_LRS", protocolField: NFS})),
+			expectedAllowed: true,
+		},
+		{
+			desc:            "invalid parameter in StorageClass",
+			req:             newAdmissionRequest(t, "StorageClass", storageClass(DefaultDriverName, map[string]string{"invalid": "value"})),
+			expectedAllowed: false,
+		},
+		{
+			desc:            "conflicting options in StorageClass",
+			req:             newAdmissionRequest(t, "StorageClass", storageClass(DefaultDriverName, map[string]string{matchTagsField: trueValue, storageAccountField: "account"})),
+			expectedAllowed: false,
+		},
+		{
+			desc:            "StorageClass of other provisioner",
+			req:             newAdmissionRequest(t, "StorageClass", storageClass("file.csi.azure.com", map[string]string{"invalid": "value"})),
+			expectedAllowed: true,
+		},
+		{
+			desc:            "valid PV",
+			req:             newAdmissionRequest(t, "PersistentVolume", pv(DefaultDriverName, map[string]string{containerNameField: "container"})),
+			expectedAllowed: true,
+		},
+		{
+			desc:            "invalid mountPermissions in PV",
+			req:             newAdmissionRequest(t, "PersistentVolume", pv(DefaultDriverName, map[string]string{mountPermissionsField: "abc"})),
+			expectedAllowed: false,
+		},
+		{
+			desc:            "PV of other driver",
+			req:             newAdmissionRequest(t, "PersistentVolume", pv("file.csi.azure.com", map[string]string{mountPermissionsField: "abc"})),
+			expectedAllowed: true,
+		},
+		{
+			desc:            "invalid protocol in inline volume",
+			req:             newAdmissionRequest(t, "Pod", pod(DefaultDriverName, map[string]string{protocolField: "smb"})),
+			expectedAllowed: false,
+		},
+		{
+			desc:            "valid inline volume",
+			req:             newAdmissionRequest(t, "Pod", pod(DefaultDriverName, map[string]string{containerNameField: "container"})),
+			expectedAllowed: true,
+		},
+	}
+
+	validator := &admissionValidator{driverName: DefaultDriverName}
+	for _, test := range tests {
+		resp := validator.review(test.req)
+		assert.Equal(t, test.req.UID, resp.UID, test.desc)
+		assert.Equal(t, test.expectedAllowed, resp.Allowed, test.desc)
+		if !test.expectedAllowed {
+			assert.NotEmpty(t, resp.Result.Message, test.desc)
+		}
+	}
+
+	// delete operation is always allowed
+	req := newAdmissionRequest(t, "StorageClass", storageClass(DefaultDriverName, map[string]string{"invalid": "value"}))
+	req.Operation = admissionv1.Delete
+	assert.True(t, validator.review(req).Allowed)
+}
+
+func TestAdmissionValidatorExampleStorageClasses(t *testing.T) {
+	files, err := filepath.Glob("../../deploy/example/storageclass-*.yaml")
+	require.NoError(t, err)
+	require.Contains(t, files, "../../deploy/example/storageclass-blob-secret.yaml")
+
+	validator := &admissionValidator{driverName: DefaultDriverName}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		sc := &storagev1.StorageClass{}
+		require.NoError(t, yaml.Unmarshal(data, sc), file)
+		// reserved csi.storage.k8s.io/ parameters, e.g. secret names, are consumed by external-provisioner
+		resp := validator.review(newAdmissionRequest(t, "StorageClass", sc))
+		assert.True(t, resp.Allowed, "%s: %v", file, resp.Result)
+	}
+}
+
+func TestAdmissionValidatorServeHTTP(t *testing.T) {
+	validator := &admissionValidator{driverName: DefaultDriverName}
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc"}, Provisioner: DefaultDriverName, Parameters: map[string]string{accessTierField: "invalid"}}
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  newAdmissionRequest(t, "StorageClass", sc),
+	}
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	validator.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ValidatingWebhookPath, bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	result := &admissionv1.AdmissionReview{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), result))
+	assert.Equal(t, "AdmissionReview", result.Kind)
+	assert.Nil(t, result.Request)
+	assert.False(t, result.Response.Allowed)
+	assert.Contains(t, result.Response.Result.Message, "accessTier(invalid) is not supported")
+
+	// invalid request body
+	recorder = httptest.NewRecorder()
+	validator.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ValidatingWebhookPath, bytes.NewReader([]byte("invalid"))))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -28,6 +28,7 @@ import (
 	"sigs.k8s.io/blob-csi-driver/pkg/blob"
 	"sigs.k8s.io/blob-csi-driver/pkg/util"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 )
@@ -45,6 +46,9 @@ var (
 	allowEmptyCloudConfig      = flag.Bool("allow-empty-cloud-config", true, "allow running driver without cloud config")
 	kubeAPIQPS                 = flag.Float64("kube-api-qps", 25.0, "QPS to use while communicating with the kubernetes apiserver.")
 	kubeAPIBurst               = flag.Int("kube-api-burst", 50, "Burst to use while communicating with the kubernetes apiserver.")
+	webhookAddress             = flag.String("webhook-address", "", "run as validating admission webhook server listening on this address, e.g. :9443, instead of CSI driver")
+	webhookCertFile            = flag.String("webhook-cert-file", "/etc/webhook/certs/tls.crt", "TLS certificate file of validating admission webhook server")
+	webhookKeyFile             = flag.String("webhook-key-file", "/etc/webhook/certs/tls.key", "TLS key file of validating admission webhook server")
 )
 
 func init() {
@@ -65,6 +69,12 @@ func main() {
 	}
 
 	exportMetrics()
+	if *webhookAddress != "" {
+		if err := blob.RunWebhookServer(context.Background(), driverOptions.DriverName, *webhookAddress, *webhookCertFile, *webhookKeyFile); err != nil {
+			klog.Fatalf("Failed to run validating admission webhook server: %v", err)
+		}
+		os.Exit(0)
+	}
 	handle()
 	os.Exit(0)
 }
@@ -88,6 +98,22 @@ func handle() {
 	if driver == nil {
 		klog.Fatalln("Failed to initialize Azure Blob Storage CSI driver")
 	}
+	if driverOptions.EnableStateStore || driverOptions.EnableVolumePopulator {
+		kubeCfg, err := util.GetKubeConfig(*kubeconfig, *kubeAPIQPS, *kubeAPIBurst, userAgent)
+		if err != nil || kubeCfg == nil {
+			klog.Fatalf("failed to get kubeconfig for custom resources, error: %v", err)
+		}
+		dynamicClient, err := dynamic.NewForConfig(kubeCfg)
+		if err != nil {
+			klog.Fatalf("failed to get dynamic client for custom resources, error: %v", err)
+		}
+		if driverOptions.EnableStateStore {
+			driver.EnableCRDStateStore(dynamicClient)
+		}
+		if driverOptions.EnableVolumePopulator {
+			driver.EnableVolumePopulator(dynamicClient)
+		}
+	}
 	if err := driver.Run(context.Background(), *endpoint); err != nil {
 		klog.Fatalf("Failed to run Azure Blob Storage CSI driver: %v", err)
 	}
@@ -329,6 +329,15 @@ func parseAzcopyJobShow(jobshow string) (AzcopyJobState, string, error) {
 }
 
 func GetKubeClient(kubeconfig string, kubeAPIQPS float64, kubeAPIBurst int, userAgent string) (kubernetes.Interface, error) {
+	kubeCfg, err := GetKubeConfig(kubeconfig, kubeAPIQPS, kubeAPIBurst, userAgent)
+	if err != nil || kubeCfg == nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(kubeCfg)
+}
+
+// GetKubeConfig returns nil config if kubeconfig is set as no-need-kubeconfig
+func GetKubeConfig(kubeconfig string, kubeAPIQPS float64, kubeAPIBurst int, userAgent string) (*rest.Config, error) {
 	var err error
 	var kubeCfg *rest.Config
 	if kubeconfig == "no-need-kubeconfig" {
@@ -349,7 +358,7 @@ func GetKubeClient(kubeconfig string, kubeAPIQPS float64, kubeAPIBurst int, user
 	kubeCfg.QPS = float32(kubeAPIQPS)
 	kubeCfg.Burst = kubeAPIBurst
 	kubeCfg.UserAgent = userAgent
-	return kubernetes.NewForConfig(kubeCfg)
+	return kubeCfg, nil
 }
 
 type VolumeMounter struct {